@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+)
+
+// CorporateEvent is a dividend or stock split affecting historical prices
+type CorporateEvent struct {
+	Date   string  `json:"date"`
+	Type   string  `json:"type"`             // "dividend" or "split"
+	Amount float64 `json:"amount,omitempty"` // Dividend per share
+	Ratio  string  `json:"ratio,omitempty"`  // Split ratio, e.g. "4:1"
+	Factor float64 `json:"factor,omitempty"` // Split factor (numerator / denominator)
+}
+
+const (
+	EventDividend = "dividend"
+	EventSplit    = "split"
+)
+
+// splitEvents separates events into dividends and splits, each sorted by date
+func splitEvents(events []CorporateEvent) ([]CorporateEvent, []CorporateEvent) {
+	var dividends, splits []CorporateEvent
+	for _, e := range events {
+		switch e.Type {
+		case EventDividend:
+			dividends = append(dividends, e)
+		case EventSplit:
+			splits = append(splits, e)
+		}
+	}
+	sort.Slice(dividends, func(i, j int) bool { return dividends[i].Date < dividends[j].Date })
+	sort.Slice(splits, func(i, j int) bool { return splits[i].Date < splits[j].Date })
+	return dividends, splits
+}
+
+// hasEventsAfter reports whether any event is dated after the given date
+func hasEventsAfter(events []CorporateEvent, date string) bool {
+	for _, e := range events {
+		if e.Date > date {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAdjClose reports whether every row carries an adjusted close; only then
+// can the data be back-adjusted. Cached rows may come from several
+// providers, and adjusting only some of them would leave a step in the
+// series where the source changes.
+func hasAdjClose(data []StockData) bool {
+	for _, d := range data {
+		if d.AdjClose == "" {
+			return false
+		}
+	}
+	return len(data) > 0
+}
+
+// adjustPrices back-adjusts OHLC for splits and dividends using each day's
+// adjusted close. Input and output are sorted oldest-first; Change and
+// HChange are recomputed from the adjusted closes. Days without an adjusted
+// close (providers that don't supply one) are left unchanged.
+func adjustPrices(data []StockData) []StockData {
+	result := make([]StockData, len(data))
+	for i, d := range data {
+		close := parseFloat(d.Close)
+		adjClose, err := strconv.ParseFloat(d.AdjClose, 64)
+		if err == nil && adjClose > 0 && close > 0 {
+			factor := adjClose / close
+			d.Open = formatFloat(parseFloat(d.Open) * factor)
+			d.High = formatFloat(parseFloat(d.High) * factor)
+			d.Low = formatFloat(parseFloat(d.Low) * factor)
+			d.Close = formatFloat(adjClose)
+		}
+		result[i] = d
+	}
+	computeChanges(result)
+	return result
+}
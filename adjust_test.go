@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAdjustPrices(t *testing.T) {
+	// Oldest-first; a 2:1 split after the first day halves earlier prices
+	data := []StockData{
+		{Date: "2024-01-02", Open: "200.00", High: "210.00", Low: "190.00", Close: "200.00", AdjClose: "100.0000"},
+		{Date: "2024-01-03", Open: "101.00", High: "106.00", Low: "99.00", Close: "105.00", AdjClose: "105.0000"},
+		{Date: "2024-01-04", Open: "105.00", High: "107.00", Low: "104.00", Close: "106.00"},
+	}
+
+	adjusted := adjustPrices(data)
+
+	first := adjusted[0]
+	if first.Open != "100.00" || first.High != "105.00" || first.Low != "95.00" || first.Close != "100.00" {
+		t.Errorf("Unexpected adjusted first day: %+v", first)
+	}
+	if adjusted[1].Change != "5.00%" {
+		t.Errorf("Change across split = %q, want %q", adjusted[1].Change, "5.00%")
+	}
+	// Days without an adjusted close are left as-is
+	if adjusted[2].Close != "106.00" {
+		t.Errorf("Unexpected unadjusted close %q", adjusted[2].Close)
+	}
+	// Input is not modified
+	if data[0].Close != "200.00" {
+		t.Error("adjustPrices modified its input")
+	}
+}
+
+func TestHasAdjClose(t *testing.T) {
+	yahoo := StockData{Date: "2024-01-03", Close: "105.00", AdjClose: "105.0000"}
+	macrotrends := StockData{Date: "2024-01-02", Close: "200.00"}
+	if !hasAdjClose([]StockData{yahoo, yahoo}) {
+		t.Error("Rows that all have an adjusted close can be adjusted")
+	}
+	if hasAdjClose([]StockData{yahoo, macrotrends}) {
+		t.Error("Rows from a mix of sources should not be adjusted")
+	}
+	if hasAdjClose(nil) {
+		t.Error("No rows, nothing to adjust")
+	}
+}
+
+func TestSplitEvents(t *testing.T) {
+	events := []CorporateEvent{
+		{Date: "2024-05-10", Type: EventDividend, Amount: 0.25},
+		{Date: "2020-08-31", Type: EventSplit, Ratio: "4:1", Factor: 4},
+		{Date: "2024-02-09", Type: EventDividend, Amount: 0.24},
+	}
+
+	dividends, splits := splitEvents(events)
+	if len(dividends) != 2 || len(splits) != 1 {
+		t.Fatalf("Got %d dividends and %d splits", len(dividends), len(splits))
+	}
+	if dividends[0].Date != "2024-02-09" {
+		t.Errorf("Expected dividends sorted by date, got %+v", dividends)
+	}
+}
+
+func TestHasEventsAfter(t *testing.T) {
+	events := []CorporateEvent{{Date: "2024-02-09", Type: EventDividend}}
+	if !hasEventsAfter(events, "2024-02-08") {
+		t.Error("Expected event after 2024-02-08")
+	}
+	if hasEventsAfter(events, "2024-02-09") {
+		t.Error("Expected no event after 2024-02-09")
+	}
+}
+
+func TestStockEndpointAdjusted(t *testing.T) {
+	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	today := time.Now().Format("2006-01-02")
+
+	p := &fakeProvider{name: "fake", data: []StockData{
+		{Date: today, Open: "51.00", High: "53.00", Low: "50.00", Close: "52.00", AdjClose: "52.0000"},
+		{Date: yesterday, Open: "100.00", High: "102.00", Low: "98.00", Close: "100.00", AdjClose: "50.0000"},
+	}}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}}, &eventsProvider{p, []CorporateEvent{
+		{Date: today, Type: EventSplit, Ratio: "2:1", Factor: 2},
+	}})
+
+	server := NewServer("0", nil)
+	req := httptest.NewRequest("GET", "/api/stock/TEST?days=10&period=daily&adjusted=true", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Data StockResponse `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	if resp.Data.Adjusted == nil || !*resp.Data.Adjusted || len(resp.Data.Splits) != 1 || len(resp.Data.Dividends) != 0 {
+		t.Errorf("Unexpected events: %+v", resp.Data)
+	}
+	if got := resp.Data.DailyData[1].Close; got != "50.00" {
+		t.Errorf("Adjusted close = %q, want %q", got, "50.00")
+	}
+	if got := resp.Data.DailyData[0].Change; got != "4.00%" {
+		t.Errorf("Adjusted change = %q, want %q", got, "4.00%")
+	}
+}
+
+func TestStockEndpointAdjustedWithoutAdjClose(t *testing.T) {
+	// Like macrotrends: no adjusted closes and no events
+	p := &fakeProvider{name: "fake", data: []StockData{
+		{Date: time.Now().Format("2006-01-02"), Open: "51.00", High: "53.00", Low: "50.00", Close: "52.00"},
+		{Date: time.Now().AddDate(0, 0, -1).Format("2006-01-02"), Open: "100.00", High: "102.00", Low: "98.00", Close: "100.00"},
+	}}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}}, p)
+	server := NewServer("0", nil)
+
+	w := doJSON(t, server, "GET", "/api/stock/TEST?days=10&period=daily&adjusted=true", "")
+	var resp struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if adjusted, ok := resp.Data["adjusted"]; !ok || adjusted != false {
+		t.Errorf("adjusted = %v, want false when the source has no adjusted closes", adjusted)
+	}
+	if rows := resp.Data["daily_data"].([]any); rows[1].(map[string]any)["close"] != "100.00" {
+		t.Errorf("Prices should be unadjusted: %v", rows[1])
+	}
+
+	// Not requested: the field is left out
+	w = doJSON(t, server, "GET", "/api/stock/TEST?days=10&period=daily", "")
+	resp.Data = nil
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if _, ok := resp.Data["adjusted"]; ok {
+		t.Error("adjusted should be omitted unless requested")
+	}
+}
+
+// eventsProvider wraps a fakeProvider and attaches corporate events
+type eventsProvider struct {
+	*fakeProvider
+	events []CorporateEvent
+}
+
+func (p *eventsProvider) Fetch(ctx context.Context, symbol string, days int) (*FetchResult, error) {
+	result, err := p.fakeProvider.Fetch(ctx, symbol, days)
+	if err != nil {
+		return nil, err
+	}
+	result.Events = p.events
+	return result, nil
+}
@@ -0,0 +1,631 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Alert conditions
+const (
+	ConditionCloseBelow = "close_below"
+	ConditionCloseAbove = "close_above"
+	ConditionPEBelow    = "pe_below"
+	ConditionPEAbove    = "pe_above"
+	ConditionDropClose  = "drop_close" // Close-based drop bucket (2-5) vs previous close
+	ConditionDropLow    = "drop_low"   // Low-based drop bucket (2-5) vs previous close
+)
+
+// Alert scopes: which symbols a rule watches
+const (
+	ScopeSymbol    = "symbol"
+	ScopeIndex     = "index"
+	ScopeWatchlist = "watchlist"
+)
+
+// AlertRule is a stored alert condition
+type AlertRule struct {
+	ID         int64   `json:"id"`
+	Name       string  `json:"name"`
+	ScopeType  string  `json:"scope_type"` // symbol, index, watchlist
+	Scope      string  `json:"scope"`      // Symbol, index key or watchlist ID
+	Condition  string  `json:"condition"`
+	Threshold  float64 `json:"threshold"`
+	WebhookURL string  `json:"webhook_url,omitempty"` // Falls back to ALERT_WEBHOOK_URL
+	Enabled    bool    `json:"enabled"`
+	CreatedAt  string  `json:"created_at"`
+}
+
+// AlertEvent is one triggered alert and its delivery status
+type AlertEvent struct {
+	ID          int64   `json:"id"`
+	RuleID      int64   `json:"rule_id"`
+	RuleName    string  `json:"rule_name"`
+	Symbol      string  `json:"symbol"`
+	Date        string  `json:"date"` // Trading day that triggered the alert
+	Condition   string  `json:"condition"`
+	Threshold   float64 `json:"threshold"`
+	Value       float64 `json:"value"` // Close, P/E or drop % that matched
+	Message     string  `json:"message"`
+	TriggeredAt string  `json:"triggered_at"`
+	Delivered   bool    `json:"delivered"`
+	Attempts    int     `json:"attempts"`
+	LastError   string  `json:"last_error,omitempty"`
+}
+
+// Validate checks a rule's scope, condition and threshold
+func (r *AlertRule) Validate() error {
+	r.Scope = strings.TrimSpace(r.Scope)
+	switch r.ScopeType {
+	case ScopeSymbol:
+		r.Scope = strings.ToUpper(r.Scope)
+		if r.Scope == "" {
+			return fmt.Errorf("scope must be a symbol")
+		}
+	case ScopeIndex:
+		r.Scope = strings.ToLower(r.Scope)
+		if _, ok := GetIndices()[r.Scope]; !ok {
+			return fmt.Errorf("unknown index %q", r.Scope)
+		}
+	case ScopeWatchlist:
+		if id, err := strconv.ParseInt(r.Scope, 10, 64); err != nil || id <= 0 {
+			return fmt.Errorf("scope must be a watchlist ID")
+		}
+	default:
+		return fmt.Errorf("scope_type must be symbol, index or watchlist")
+	}
+
+	switch r.Condition {
+	case ConditionCloseBelow, ConditionCloseAbove, ConditionPEBelow, ConditionPEAbove:
+		if r.Threshold <= 0 {
+			return fmt.Errorf("threshold must be positive")
+		}
+	case ConditionDropClose, ConditionDropLow:
+		if r.Threshold < 2 || r.Threshold > 5 || r.Threshold != float64(int(r.Threshold)) {
+			return fmt.Errorf("drop threshold must be a bucket: 2, 3, 4 or 5")
+		}
+	default:
+		return fmt.Errorf("condition must be one of close_below, close_above, pe_below, pe_above, drop_close, drop_low")
+	}
+
+	if r.WebhookURL != "" {
+		if err := validateWebhookURL(r.WebhookURL); err != nil {
+			return err
+		}
+	}
+	if r.Name == "" {
+		r.Name = fmt.Sprintf("%s %s %v", r.Scope, r.Condition, r.Threshold)
+	}
+	return nil
+}
+
+// matches evaluates the rule against the latest bar and the previous close.
+// It returns the value that was compared and a human-readable message.
+func (r *AlertRule) matches(symbol string, latest StockData, prevClose float64) (float64, string, bool) {
+	close := parseFloat(latest.Close)
+	switch r.Condition {
+	case ConditionCloseBelow:
+		return close, fmt.Sprintf("%s closed at %.2f, below %v", symbol, close, r.Threshold), close > 0 && close < r.Threshold
+	case ConditionCloseAbove:
+		return close, fmt.Sprintf("%s closed at %.2f, above %v", symbol, close, r.Threshold), close > r.Threshold
+	case ConditionPEBelow, ConditionPEAbove:
+		if latest.PE == "" {
+			return 0, "", false
+		}
+		pe := parseFloat(latest.PE)
+		if r.Condition == ConditionPEBelow {
+			return pe, fmt.Sprintf("%s P/E %.2f is below %v", symbol, pe, r.Threshold), pe > 0 && pe < r.Threshold
+		}
+		return pe, fmt.Sprintf("%s P/E %.2f is above %v", symbol, pe, r.Threshold), pe > r.Threshold
+	case ConditionDropClose, ConditionDropLow:
+		if prevClose <= 0 {
+			return 0, "", false
+		}
+		closeBucket, lowBucket := calculateDrops(close, parseFloat(latest.Low), prevClose)
+		price, bucket, basis := close, closeBucket, "close"
+		if r.Condition == ConditionDropLow {
+			price, bucket, basis = parseFloat(latest.Low), lowBucket, "low"
+		}
+		pct := roundPct((price - prevClose) / prevClose * 100)
+		return pct, fmt.Sprintf("%s %s dropped %.2f%% vs previous close", symbol, basis, -pct), bucket >= int(r.Threshold)
+	}
+	return 0, "", false
+}
+
+// alertQueueSize bounds the stored batches waiting for evaluation
+const alertQueueSize = 1024
+
+// alertCheck is a stored batch of prices waiting for evaluation
+type alertCheck struct {
+	ctx        context.Context
+	symbol     string
+	latestDate string
+}
+
+// AlertEngine evaluates rules when prices are stored and delivers webhooks
+type AlertEngine struct {
+	cache          *Cache
+	client         *http.Client // For webhook URLs set through the API
+	trustedClient  *http.Client // For ALERT_WEBHOOK_URL and ALERT_WEBHOOK_HOSTS
+	defaultWebhook string
+	maxAttempts    int
+	backoff        time.Duration // Delay before the first retry; doubles each attempt
+
+	start sync.Once
+	queue chan alertCheck
+	wg    sync.WaitGroup // Queued evaluations and in-flight deliveries
+
+	stopOnce sync.Once
+	stopping chan struct{} // Closed by Stop; deliveries stop retrying
+}
+
+// NewAlertEngine creates an engine that reads rules from the cache database
+func NewAlertEngine(cache *Cache) *AlertEngine {
+	noRedirects := func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	return &AlertEngine{
+		cache:          cache,
+		client:         &http.Client{Timeout: 10 * time.Second, Transport: publicOnlyTransport(), CheckRedirect: noRedirects},
+		trustedClient:  &http.Client{Timeout: 10 * time.Second, CheckRedirect: noRedirects},
+		defaultWebhook: os.Getenv("ALERT_WEBHOOK_URL"),
+		maxAttempts:    4,
+		backoff:        2 * time.Second,
+		queue:          make(chan alertCheck, alertQueueSize),
+		stopping:       make(chan struct{}),
+	}
+}
+
+// Evaluate queues the newest day of a stored batch for evaluation against
+// the rules covering symbol. It is registered as the cache's store hook, so
+// it only queues: rule lookups and webhooks stay off the store path. When
+// the queue is full the batch is skipped; the symbol's next store
+// evaluates the same day again.
+func (e *AlertEngine) Evaluate(ctx context.Context, symbol string, data []StockData) {
+	if len(data) == 0 {
+		return
+	}
+	// Only the newest stored day is evaluated
+	var latestDate string
+	for _, d := range data {
+		if d.Date > latestDate {
+			latestDate = d.Date
+		}
+	}
+
+	e.start.Do(func() { go e.work() })
+	e.wg.Add(1)
+	select {
+	case e.queue <- alertCheck{ctx: context.WithoutCancel(ctx), symbol: symbol, latestDate: latestDate}:
+	default:
+		e.wg.Done()
+		slog.WarnContext(ctx, "Alert queue full, skipping evaluation", "symbol", symbol, "date", latestDate)
+	}
+}
+
+// work evaluates queued batches one at a time
+func (e *AlertEngine) work() {
+	for c := range e.queue {
+		e.evaluate(c.ctx, c.symbol, c.latestDate)
+		e.wg.Done()
+	}
+}
+
+// evaluate checks every enabled rule that covers symbol against its bar
+// for latestDate. Each rule fires at most once per symbol and trading day.
+func (e *AlertEngine) evaluate(ctx context.Context, symbol, latestDate string) {
+	rules, err := e.cache.RulesForSymbol(ctx, symbol)
+	if err != nil {
+		slog.ErrorContext(ctx, "Alert rules lookup failed", "symbol", symbol, "error", err)
+		return
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	// Latest bar and previous close from the cache, which holds the full history
+	start, _ := time.Parse("2006-01-02", latestDate)
+	recent, err := e.cache.GetDailyPrices(ctx, symbol, start.AddDate(0, 0, -14).Format("2006-01-02"), latestDate)
+	if err != nil || len(recent) == 0 {
+		return
+	}
+	var prevClose float64
+	if len(recent) > 1 {
+		prevClose = parseFloat(recent[1].Close)
+	}
+
+	for _, rule := range rules {
+		value, message, ok := rule.matches(symbol, recent[0], prevClose)
+		if !ok {
+			continue
+		}
+		event := AlertEvent{
+			RuleID:      rule.ID,
+			RuleName:    rule.Name,
+			Symbol:      symbol,
+			Date:        recent[0].Date,
+			Condition:   rule.Condition,
+			Threshold:   rule.Threshold,
+			Value:       value,
+			Message:     message,
+			TriggeredAt: time.Now().UTC().Format(time.RFC3339),
+		}
+		created, err := e.cache.RecordAlertEvent(ctx, &event)
+		if err != nil {
+			slog.ErrorContext(ctx, "Alert record failed", "symbol", symbol, "rule_id", rule.ID, "error", err)
+			continue
+		}
+		if !created {
+			continue // Already fired for this day
+		}
+
+		webhook := rule.WebhookURL
+		if webhook == "" {
+			webhook = e.defaultWebhook
+		}
+		if webhook == "" {
+			continue
+		}
+		slog.InfoContext(ctx, "Alert triggered", "symbol", symbol, "rule_id", rule.ID, "message", message)
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			e.deliver(ctx, webhook, event)
+		}()
+	}
+}
+
+// deliver POSTs an event to a webhook, retrying with exponential backoff
+func (e *AlertEngine) deliver(ctx context.Context, webhook string, event AlertEvent) {
+	body, _ := json.Marshal(event)
+	delay := e.backoff
+
+	// Rule URLs come from API users: they are checked again in case the
+	// rule predates ALERT_WEBHOOK_HOSTS, and connect only to public
+	// addresses unless the host is allow-listed
+	client := e.trustedClient
+	if webhook != e.defaultWebhook && !webhookHostAllowed(webhook) {
+		if err := validateWebhookURL(webhook); err != nil {
+			_ = e.cache.UpdateAlertDelivery(ctx, event.ID, 0, err)
+			slog.ErrorContext(ctx, "Alert webhook rejected", "alert_id", event.ID, "url", webhook, "error", err)
+			return
+		}
+		client = e.client
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= e.maxAttempts; attempt++ {
+		lastErr = e.post(ctx, client, webhook, body)
+		_ = e.cache.UpdateAlertDelivery(ctx, event.ID, attempt, lastErr)
+		if lastErr == nil {
+			return
+		}
+		if attempt < e.maxAttempts {
+			select {
+			case <-time.After(delay):
+			case <-e.stopping:
+				slog.WarnContext(ctx, "Alert delivery abandoned at shutdown", "alert_id", event.ID, "url", webhook, "attempts", attempt, "error", lastErr)
+				return
+			}
+			delay *= 2
+		}
+	}
+	slog.ErrorContext(ctx, "Alert delivery failed", "alert_id", event.ID, "url", webhook, "attempts", e.maxAttempts, "error", lastErr)
+}
+
+func (e *AlertEngine) post(ctx context.Context, client *http.Client, webhook string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Wait blocks until queued evaluations and in-flight webhook deliveries
+// finish
+func (e *AlertEngine) Wait() {
+	e.wg.Wait()
+}
+
+// Stop makes deliveries give up their remaining retries and waits for
+// queued evaluations and the webhook requests already in flight, so
+// shutdown takes at most one client timeout rather than the whole backoff
+func (e *AlertEngine) Stop() {
+	e.stopOnce.Do(func() { close(e.stopping) })
+	e.wg.Wait()
+}
+
+// webhookHosts is the ALERT_WEBHOOK_HOSTS allow-list: host names, or
+// *.domain for any subdomain. When set, rule webhook URLs must use one of
+// these hosts; otherwise any host with a public address is allowed.
+var webhookHosts = parseWebhookHosts(os.Getenv("ALERT_WEBHOOK_HOSTS"))
+
+// parseWebhookHosts parses a comma-separated host allow-list
+func parseWebhookHosts(v string) []string {
+	var hosts []string
+	for _, h := range strings.Split(v, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// webhookHostAllowed reports whether rawURL's host is in webhookHosts
+func webhookHostAllowed(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, h := range webhookHosts {
+		if host == h {
+			return true
+		}
+		if domain, ok := strings.CutPrefix(h, "*."); ok && strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateWebhookURL checks that a webhook URL set through the API is http(s)
+// and, without an allow-list, doesn't name a loopback, private or
+// link-local host. Host names are checked again when connecting, since they
+// may resolve anywhere.
+func validateWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("webhook_url must be an http(s) URL")
+	}
+	if len(webhookHosts) > 0 {
+		if !webhookHostAllowed(rawURL) {
+			return fmt.Errorf("webhook_url host %q is not in ALERT_WEBHOOK_HOSTS", u.Hostname())
+		}
+		return nil
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("webhook_url must not point to a private or loopback address")
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && !publicAddr(ip) {
+		return fmt.Errorf("webhook_url must not point to a private or loopback address")
+	}
+	return nil
+}
+
+// publicAddr reports whether ip is a globally routable unicast address
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+// sharedAddressSpace is carrier-grade NAT space (RFC 6598), not covered by
+// netip.Addr.IsPrivate
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicOnlyTransport returns a transport that refuses to connect to
+// non-public addresses, whatever the host name resolved to. It doesn't use
+// HTTP(S)_PROXY, so the check applies to the webhook itself.
+func publicOnlyTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil || !publicAddr(ap.Addr()) {
+				return fmt.Errorf("webhook address %s is not public", address)
+			}
+			return nil
+		},
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = dialer.DialContext
+	return t
+}
+
+// ruleColumns is the SELECT list for alert_rules
+const ruleColumns = `id, name, scope_type, scope, condition, threshold, webhook_url, enabled, created_at`
+
+func scanRule(row interface{ Scan(...any) error }) (AlertRule, error) {
+	var r AlertRule
+	var enabled int
+	err := row.Scan(&r.ID, &r.Name, &r.ScopeType, &r.Scope, &r.Condition, &r.Threshold, &r.WebhookURL, &enabled, &r.CreatedAt)
+	r.Enabled = enabled != 0
+	return r, err
+}
+
+// ListAlertRules returns all alert rules
+func (c *Cache) ListAlertRules(ctx context.Context) ([]AlertRule, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT `+ruleColumns+` FROM alert_rules ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	rules := []AlertRule{}
+	for rows.Next() {
+		r, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// GetAlertRule returns a rule by ID, or nil if it does not exist
+func (c *Cache) GetAlertRule(ctx context.Context, id int64) (*AlertRule, error) {
+	r, err := scanRule(c.db.QueryRowContext(ctx, `SELECT `+ruleColumns+` FROM alert_rules WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// CreateAlertRule stores a new rule
+func (c *Cache) CreateAlertRule(ctx context.Context, r AlertRule) (*AlertRule, error) {
+	res, err := c.db.ExecContext(ctx,
+		`INSERT INTO alert_rules (name, scope_type, scope, condition, threshold, webhook_url, enabled, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.Name, r.ScopeType, r.Scope, r.Condition, r.Threshold, r.WebhookURL, r.Enabled,
+		time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return c.GetAlertRule(ctx, id)
+}
+
+// UpdateAlertRule replaces a rule. Returns nil if it does not exist.
+func (c *Cache) UpdateAlertRule(ctx context.Context, id int64, r AlertRule) (*AlertRule, error) {
+	res, err := c.db.ExecContext(ctx,
+		`UPDATE alert_rules SET name = ?, scope_type = ?, scope = ?, condition = ?, threshold = ?,
+		 webhook_url = ?, enabled = ? WHERE id = ?`,
+		r.Name, r.ScopeType, r.Scope, r.Condition, r.Threshold, r.WebhookURL, r.Enabled, id)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, nil
+	}
+	return c.GetAlertRule(ctx, id)
+}
+
+// DeleteAlertRule deletes a rule; its history is kept.
+// Returns false if the rule does not exist.
+func (c *Cache) DeleteAlertRule(ctx context.Context, id int64) (bool, error) {
+	res, err := c.db.ExecContext(ctx, `DELETE FROM alert_rules WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// RulesForSymbol returns enabled rules whose scope includes symbol: the
+// symbol itself, an index it belongs to or a watchlist containing it
+func (c *Cache) RulesForSymbol(ctx context.Context, symbol string) ([]AlertRule, error) {
+	var scopes []any
+	for key, idx := range GetIndices() {
+		for _, s := range idx.Symbols {
+			if s == symbol {
+				scopes = append(scopes, key)
+				break
+			}
+		}
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(scopes)), ",")
+	if placeholders == "" {
+		placeholders = "NULL"
+	}
+
+	query := `SELECT ` + ruleColumns + ` FROM alert_rules WHERE enabled = 1 AND (
+		(scope_type = 'symbol' AND scope = ?)
+		OR (scope_type = 'index' AND scope IN (` + placeholders + `))
+		OR (scope_type = 'watchlist' AND scope IN (
+			SELECT CAST(watchlist_id AS TEXT) FROM watchlist_items WHERE symbol = ?)))
+		ORDER BY id`
+	args := append(append([]any{symbol}, scopes...), symbol)
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var rules []AlertRule
+	for rows.Next() {
+		r, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// RecordAlertEvent stores a triggered alert and sets its ID. Returns false
+// if the rule already fired for this symbol and day.
+func (c *Cache) RecordAlertEvent(ctx context.Context, e *AlertEvent) (bool, error) {
+	res, err := c.db.ExecContext(ctx,
+		`INSERT OR IGNORE INTO alert_events
+		 (rule_id, rule_name, symbol, date, condition, threshold, value, message, triggered_at, delivered, attempts, last_error)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 0, 0, '')`,
+		e.RuleID, e.RuleName, e.Symbol, e.Date, e.Condition, e.Threshold, e.Value, e.Message, e.TriggeredAt)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	e.ID, err = res.LastInsertId()
+	return true, err
+}
+
+// UpdateAlertDelivery records the outcome of a delivery attempt
+func (c *Cache) UpdateAlertDelivery(ctx context.Context, id int64, attempts int, deliveryErr error) error {
+	lastError := ""
+	if deliveryErr != nil {
+		lastError = deliveryErr.Error()
+	}
+	_, err := c.db.ExecContext(ctx,
+		`UPDATE alert_events SET attempts = ?, delivered = ?, last_error = ? WHERE id = ?`,
+		attempts, deliveryErr == nil, lastError, id)
+	return err
+}
+
+// AlertHistory returns triggered alerts newest-first, optionally filtered
+// by rule (0 for all) and symbol ("" for all)
+func (c *Cache) AlertHistory(ctx context.Context, ruleID int64, symbol string, limit int) ([]AlertEvent, error) {
+	rows, err := c.db.QueryContext(ctx,
+		`SELECT id, rule_id, rule_name, symbol, date, condition, threshold, value, message,
+		        triggered_at, delivered, attempts, last_error
+		 FROM alert_events
+		 WHERE (? = 0 OR rule_id = ?) AND (? = '' OR symbol = ?)
+		 ORDER BY id DESC LIMIT ?`,
+		ruleID, ruleID, symbol, symbol, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	events := []AlertEvent{}
+	for rows.Next() {
+		var e AlertEvent
+		var delivered int
+		if err := rows.Scan(&e.ID, &e.RuleID, &e.RuleName, &e.Symbol, &e.Date, &e.Condition, &e.Threshold,
+			&e.Value, &e.Message, &e.TriggeredAt, &delivered, &e.Attempts, &e.LastError); err != nil {
+			return nil, err
+		}
+		e.Delivered = delivered != 0
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver is a local HTTP receiver that fails the first failures requests
+type webhookReceiver struct {
+	mu       sync.Mutex
+	failures int
+	requests int
+	events   []AlertEvent
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.requests++
+	if rcv.requests <= rcv.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	var e AlertEvent
+	_ = json.Unmarshal(body, &e)
+	rcv.events = append(rcv.events, e)
+}
+
+// withWebhookHosts sets the webhook allow-list for the duration of a test
+func withWebhookHosts(t *testing.T, hosts ...string) {
+	t.Helper()
+	saved := webhookHosts
+	webhookHosts = hosts
+	t.Cleanup(func() { webhookHosts = saved })
+}
+
+// newTestAlertEngine wires an engine with fast retries into the cache,
+// allowing webhooks to local test servers
+func newTestAlertEngine(t *testing.T, cache *Cache) *AlertEngine {
+	t.Helper()
+	withWebhookHosts(t, "127.0.0.1")
+	e := NewAlertEngine(cache)
+	e.backoff = 0
+	e.defaultWebhook = ""
+	cache.SetStoreHook(e.Evaluate)
+	return e
+}
+
+func TestAlertRuleValidate(t *testing.T) {
+	valid := []AlertRule{
+		{ScopeType: ScopeSymbol, Scope: "aapl", Condition: ConditionCloseBelow, Threshold: 180},
+		{ScopeType: ScopeIndex, Scope: "DOW", Condition: ConditionDropLow, Threshold: 5},
+		{ScopeType: ScopeWatchlist, Scope: "3", Condition: ConditionPEBelow, Threshold: 15},
+	}
+	for _, r := range valid {
+		if err := r.Validate(); err != nil {
+			t.Errorf("Validate(%+v) error = %v", r, err)
+		}
+	}
+
+	invalid := []AlertRule{
+		{ScopeType: "sector", Scope: "tech", Condition: ConditionCloseBelow, Threshold: 1},
+		{ScopeType: ScopeIndex, Scope: "ftse", Condition: ConditionCloseBelow, Threshold: 1},
+		{ScopeType: ScopeWatchlist, Scope: "abc", Condition: ConditionCloseBelow, Threshold: 1},
+		{ScopeType: ScopeSymbol, Scope: "AAPL", Condition: "volume_above", Threshold: 1},
+		{ScopeType: ScopeSymbol, Scope: "AAPL", Condition: ConditionDropLow, Threshold: 6},
+		{ScopeType: ScopeSymbol, Scope: "AAPL", Condition: ConditionCloseBelow, Threshold: 0},
+		{ScopeType: ScopeSymbol, Scope: "AAPL", Condition: ConditionCloseBelow, Threshold: 1, WebhookURL: "ftp://x"},
+	}
+	for _, webhook := range []string{"http://127.0.0.1:8080/", "http://localhost/hook", "http://[::1]/", "http://10.0.0.5/",
+		"http://169.254.169.254/latest/meta-data", "http://[::ffff:192.168.1.1]/", "https:///path"} {
+		invalid = append(invalid, AlertRule{ScopeType: ScopeSymbol, Scope: "AAPL", Condition: ConditionCloseBelow, Threshold: 1, WebhookURL: webhook})
+	}
+	for _, r := range invalid {
+		if err := r.Validate(); err == nil {
+			t.Errorf("Validate(%+v) expected error", r)
+		}
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	if err := validateWebhookURL("https://hooks.example.com/alerts"); err != nil {
+		t.Errorf("Public host rejected: %v", err)
+	}
+
+	withWebhookHosts(t, "alerts.internal", "*.example.com")
+	for _, webhook := range []string{"http://alerts.internal:9000/hook", "https://hooks.example.com/x"} {
+		if err := validateWebhookURL(webhook); err != nil {
+			t.Errorf("Allow-listed %s rejected: %v", webhook, err)
+		}
+	}
+	for _, webhook := range []string{"https://example.org/x", "https://example.com/x", "http://10.0.0.5/"} {
+		if err := validateWebhookURL(webhook); err == nil {
+			t.Errorf("%s should not be allowed", webhook)
+		}
+	}
+}
+
+func TestAlertRuleMatchesDrops(t *testing.T) {
+	rule := AlertRule{Condition: ConditionDropLow, Threshold: 5}
+	// Low is 6% under the previous close, close only 1%
+	bar := StockData{Close: "99", Low: "94"}
+
+	value, _, ok := rule.matches("AAPL", bar, 100)
+	if !ok || value != -6 {
+		t.Errorf("drop_low = %v, %v; want -6, true", value, ok)
+	}
+
+	rule.Condition = ConditionDropClose
+	if _, _, ok := rule.matches("AAPL", bar, 100); ok {
+		t.Error("drop_close should not match a 1% close drop")
+	}
+}
+
+func TestAlertDeliveryWithRetry(t *testing.T) {
+	cache := newTestCache(t)
+	engine := newTestAlertEngine(t, cache)
+
+	receiver := &webhookReceiver{failures: 1}
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	rule, err := cache.CreateAlertRule(t.Context(), AlertRule{
+		Name: "AAPL under 180", ScopeType: ScopeSymbol, Scope: "AAPL",
+		Condition: ConditionCloseBelow, Threshold: 180, WebhookURL: ts.URL, Enabled: true,
+	})
+	if err != nil {
+		t.Fatalf("CreateAlertRule: %v", err)
+	}
+
+	data := []StockData{
+		{Date: "2024-01-03", Close: "175.00", Low: "174.00"},
+		{Date: "2024-01-02", Close: "185.00", Low: "184.00"},
+	}
+	if err := cache.StoreDailyPrices(t.Context(), "AAPL", data); err != nil {
+		t.Fatalf("StoreDailyPrices: %v", err)
+	}
+	// Storing the same day again must not fire twice
+	_ = cache.StoreDailyPrices(t.Context(), "AAPL", data[:1])
+	engine.Wait()
+
+	if len(receiver.events) != 1 || receiver.requests != 2 {
+		t.Fatalf("Expected 1 delivery after 1 retry, got %d events over %d requests", len(receiver.events), receiver.requests)
+	}
+	if e := receiver.events[0]; e.RuleID != rule.ID || e.Symbol != "AAPL" || e.Date != "2024-01-03" || e.Value != 175 {
+		t.Errorf("Unexpected payload: %+v", e)
+	}
+
+	history, err := cache.AlertHistory(t.Context(), rule.ID, "", 10)
+	if err != nil || len(history) != 1 {
+		t.Fatalf("AlertHistory: %+v, %v", history, err)
+	}
+	if !history[0].Delivered || history[0].Attempts != 2 || history[0].LastError != "" {
+		t.Errorf("Unexpected delivery status: %+v", history[0])
+	}
+}
+
+func TestAlertDeliveryGivesUp(t *testing.T) {
+	cache := newTestCache(t)
+	engine := newTestAlertEngine(t, cache)
+	engine.maxAttempts = 3
+
+	receiver := &webhookReceiver{failures: 100}
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	_, _ = cache.CreateAlertRule(t.Context(), AlertRule{ScopeType: ScopeSymbol, Scope: "AAPL",
+		Condition: ConditionCloseAbove, Threshold: 100, WebhookURL: ts.URL, Enabled: true})
+	_ = cache.StoreDailyPrices(t.Context(), "AAPL", []StockData{{Date: "2024-01-03", Close: "150"}})
+	engine.Wait()
+
+	history, _ := cache.AlertHistory(t.Context(), 0, "AAPL", 10)
+	if len(history) != 1 || history[0].Delivered || history[0].Attempts != 3 || history[0].LastError == "" {
+		t.Errorf("Unexpected history: %+v", history)
+	}
+}
+
+func TestAlertDeliveryStop(t *testing.T) {
+	cache := newTestCache(t)
+	engine := newTestAlertEngine(t, cache)
+	engine.backoff = time.Hour
+
+	receiver := &webhookReceiver{failures: 100}
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	_, _ = cache.CreateAlertRule(t.Context(), AlertRule{ScopeType: ScopeSymbol, Scope: "AAPL",
+		Condition: ConditionCloseAbove, Threshold: 100, WebhookURL: ts.URL, Enabled: true})
+	_ = cache.StoreDailyPrices(t.Context(), "AAPL", []StockData{{Date: "2024-01-03", Close: "150"}})
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		receiver.mu.Lock()
+		requests := receiver.requests
+		receiver.mu.Unlock()
+		if requests > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Stop doesn't wait out the hour before the retry
+	stopped := make(chan struct{})
+	go func() {
+		engine.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop waited for the retry backoff")
+	}
+	history, _ := cache.AlertHistory(t.Context(), 0, "AAPL", 10)
+	if len(history) != 1 || history[0].Delivered || history[0].Attempts != 1 {
+		t.Errorf("Unexpected history: %+v", history)
+	}
+}
+
+func TestAlertWebhookGuard(t *testing.T) {
+	cache := newTestCache(t)
+	engine := newTestAlertEngine(t, cache)
+	withWebhookHosts(t)
+
+	receiver := &webhookReceiver{}
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	// A loopback URL stored before validation existed is never contacted
+	_, _ = cache.CreateAlertRule(t.Context(), AlertRule{ScopeType: ScopeSymbol, Scope: "AAPL",
+		Condition: ConditionCloseAbove, Threshold: 100, WebhookURL: ts.URL, Enabled: true})
+	_ = cache.StoreDailyPrices(t.Context(), "AAPL", []StockData{{Date: "2024-01-03", Close: "150"}})
+	engine.Wait()
+
+	history, _ := cache.AlertHistory(t.Context(), 0, "AAPL", 10)
+	if len(history) != 1 || history[0].Delivered || history[0].LastError == "" || receiver.requests != 0 {
+		t.Errorf("Expected the webhook to be rejected: %+v after %d requests", history, receiver.requests)
+	}
+
+	// Whatever a URL's host resolves to, private addresses are refused when connecting
+	if _, err := engine.client.Post(ts.URL, "application/json", nil); err == nil {
+		t.Error("Expected the public-only client to refuse a loopback address")
+	}
+}
+
+func TestAlertIndexAndWatchlistScopes(t *testing.T) {
+	cache := newTestCache(t)
+	engine := newTestAlertEngine(t, cache)
+
+	wl, _ := cache.CreateWatchlist(t.Context(), "HK", "", []string{"0700.HK"})
+	_, _ = cache.CreateAlertRule(t.Context(), AlertRule{Name: "dow drops", ScopeType: ScopeIndex, Scope: "dow",
+		Condition: ConditionDropLow, Threshold: 5, Enabled: true})
+	_, _ = cache.CreateAlertRule(t.Context(), AlertRule{Name: "cheap", ScopeType: ScopeWatchlist, Scope: jsonID(wl.ID),
+		Condition: ConditionPEBelow, Threshold: 15, Enabled: true})
+	_, _ = cache.CreateAlertRule(t.Context(), AlertRule{Name: "disabled", ScopeType: ScopeSymbol, Scope: "TSLA",
+		Condition: ConditionCloseAbove, Threshold: 1, Enabled: false})
+
+	drop := []StockData{
+		{Date: "2024-01-03", Close: "99", Low: "94"},
+		{Date: "2024-01-02", Close: "100", Low: "99"},
+	}
+	_ = cache.StoreDailyPrices(t.Context(), "AAPL", drop) // Dow member
+	_ = cache.StoreDailyPrices(t.Context(), "TSLA", drop) // Not in the Dow; its rule is disabled
+	_ = cache.StoreDailyPrices(t.Context(), "0700.HK", []StockData{{Date: "2024-01-03", Close: "300", PE: "12.5"}})
+	engine.Wait()
+
+	history, err := cache.AlertHistory(t.Context(), 0, "", 10)
+	if err != nil || len(history) != 2 {
+		t.Fatalf("Expected 2 alerts, got %+v, %v", history, err)
+	}
+	if history[0].Symbol != "0700.HK" || history[0].RuleName != "cheap" || history[0].Value != 12.5 {
+		t.Errorf("Unexpected watchlist alert: %+v", history[0])
+	}
+	if history[1].Symbol != "AAPL" || history[1].RuleName != "dow drops" {
+		t.Errorf("Unexpected index alert: %+v", history[1])
+	}
+}
+
+func jsonID(id int64) string {
+	b, _ := json.Marshal(id)
+	return string(b)
+}
+
+func TestAlertEndpoints(t *testing.T) {
+	server := NewServer("0", newTestCache(t))
+
+	if w := doJSON(t, server, "POST", "/api/alerts", `{"scope_type": "symbol", "scope": "AAPL", "condition": "nope"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Invalid rule: expected 400, got %d", w.Code)
+	}
+	if w := doJSON(t, server, "POST", "/api/alerts", `{"scope_type": "symbol", "scope": "AAPL", "condition": "close_below", "threshold": 1, "webhook_url": "http://169.254.169.254/"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Link-local webhook: expected 400, got %d", w.Code)
+	}
+
+	w := doJSON(t, server, "POST", "/api/alerts", `{"scope_type": "index", "scope": "dow", "condition": "drop_low", "threshold": 5}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Create: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Data AlertRule `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &created)
+	if !created.Data.Enabled || created.Data.Name != "dow drop_low 5" {
+		t.Errorf("Unexpected rule: %+v", created.Data)
+	}
+	path := "/api/alerts/" + jsonID(created.Data.ID)
+
+	if w := doJSON(t, server, "PUT", path, `{"scope_type": "index", "scope": "dow", "condition": "drop_low", "threshold": 4, "enabled": false}`); w.Code != http.StatusOK {
+		t.Errorf("Update: expected 200, got %d", w.Code)
+	}
+	if w := doJSON(t, server, "GET", "/api/alerts/history?limit=5", ""); w.Code != http.StatusOK {
+		t.Errorf("History: expected 200, got %d", w.Code)
+	}
+	if w := doJSON(t, server, "DELETE", path, ""); w.Code != http.StatusOK {
+		t.Errorf("Delete: expected 200, got %d", w.Code)
+	}
+	if w := doJSON(t, server, "GET", path, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Get deleted: expected 404, got %d", w.Code)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"time"
+)
+
+// minTradeValue skips rebalancing trades too small to matter
+const minTradeValue = 0.01
+
+// BacktestConfig describes a fixed-weight portfolio to simulate
+type BacktestConfig struct {
+	Symbols      []string
+	Weights      []float64 // Target weights, normalized to sum to 1
+	Rebalance    string    // none, daily, weekly, monthly, quarterly, yearly
+	StartCapital float64
+	CostBps      float64 // Proportional cost per trade, in basis points of traded value
+	FeePerTrade  float64 // Fixed cost per trade
+	RiskFreePct  float64 // Annual risk-free rate for Sharpe/Sortino
+	StatsPeriod  string  // Return frequency for the risk statistics
+}
+
+// BacktestTrade is a single buy or sell made while rebalancing
+type BacktestTrade struct {
+	Date   string  `json:"date"`
+	Symbol string  `json:"symbol"`
+	Side   string  `json:"side"` // buy or sell
+	Shares float64 `json:"shares"`
+	Price  float64 `json:"price"`
+	Value  float64 `json:"value"`
+	Cost   float64 `json:"cost"`
+}
+
+// EquityPoint is the portfolio value at the close of one day
+type EquityPoint struct {
+	Date   string  `json:"date"`
+	Equity float64 `json:"equity"`
+	Cash   float64 `json:"cash"`
+}
+
+// BacktestHolding is a position at the end of the backtest
+type BacktestHolding struct {
+	Symbol    string  `json:"symbol"`
+	Shares    float64 `json:"shares"`
+	Price     float64 `json:"price"`
+	Value     float64 `json:"value"`
+	WeightPct float64 `json:"weight_pct"`
+}
+
+// BacktestResult is the outcome of a backtest
+type BacktestResult struct {
+	Symbols      []string          `json:"symbols"`
+	Weights      []float64         `json:"weights"`
+	Rebalance    string            `json:"rebalance"`
+	StartDate    string            `json:"start_date"`
+	EndDate      string            `json:"end_date"`
+	Adjusted     map[string]bool   `json:"adjusted,omitempty"` // Per symbol when adjusted=true; false if the source has no adjusted closes
+	StartCapital float64           `json:"start_capital"`
+	EndEquity    float64           `json:"end_equity"`
+	TotalCosts   float64           `json:"total_costs"`
+	Rebalances   int               `json:"rebalances"`
+	Stats        *RiskStats        `json:"stats,omitempty"`
+	Holdings     []BacktestHolding `json:"holdings"`
+	Equity       []EquityPoint     `json:"equity"` // Newest first
+	Trades       []BacktestTrade   `json:"trades"` // Newest first
+}
+
+// portfolio tracks positions during a simulation
+type portfolio struct {
+	cfg    BacktestConfig
+	cash   float64
+	shares []float64
+	trades []BacktestTrade
+	costs  float64
+}
+
+func (p *portfolio) value(prices []float64) float64 {
+	v := p.cash
+	for i, s := range p.shares {
+		v += s * prices[i]
+	}
+	return v
+}
+
+func (p *portfolio) tradeCost(value float64) float64 {
+	return value*p.cfg.CostBps/10000 + p.cfg.FeePerTrade
+}
+
+// rebalance trades every position back to its target weight. Costs are
+// estimated up front so the post-trade portfolio stays fully funded;
+// sells run first so their proceeds can fund the buys.
+func (p *portfolio) rebalance(date string, prices []float64) {
+	equity := p.value(prices)
+	var estCost float64
+	for i, w := range p.cfg.Weights {
+		if diff := equity*w - p.shares[i]*prices[i]; math.Abs(diff) >= minTradeValue {
+			estCost += p.tradeCost(math.Abs(diff))
+		}
+	}
+	investable := equity - estCost
+
+	for i, w := range p.cfg.Weights {
+		diff := investable*w - p.shares[i]*prices[i]
+		if diff > -minTradeValue {
+			continue
+		}
+		p.execute(date, i, -math.Min(-diff, p.shares[i]*prices[i]), prices[i])
+	}
+	for i, w := range p.cfg.Weights {
+		diff := investable*w - p.shares[i]*prices[i]
+		if diff < minTradeValue {
+			continue
+		}
+		// Never spend more cash than is on hand
+		if diff+p.tradeCost(diff) > p.cash {
+			diff = (p.cash - p.cfg.FeePerTrade) / (1 + p.cfg.CostBps/10000)
+		}
+		if diff < minTradeValue {
+			continue
+		}
+		p.execute(date, i, diff, prices[i])
+	}
+}
+
+// execute buys (value > 0) or sells (value < 0) a position at price
+func (p *portfolio) execute(date string, i int, value, price float64) {
+	shares := value / price
+	cost := p.tradeCost(math.Abs(value))
+	p.shares[i] += shares
+	p.cash -= value + cost
+	p.costs += cost
+
+	side := "buy"
+	if value < 0 {
+		side = "sell"
+	}
+	p.trades = append(p.trades, BacktestTrade{
+		Date:   date,
+		Symbol: p.cfg.Symbols[i],
+		Side:   side,
+		Shares: math.Round(math.Abs(shares)*10000) / 10000,
+		Price:  price,
+		Value:  math.Round(math.Abs(value)*100) / 100,
+		Cost:   math.Round(cost*100) / 100,
+	})
+}
+
+// RunBacktest simulates the portfolio over daily series (oldest-first,
+// indexed like cfg.Symbols). Dates are the union of all trading calendars;
+// a symbol that did not trade on a day is valued at its last close. The
+// simulation starts on the first day every symbol has a price.
+func RunBacktest(cfg BacktestConfig, series [][]StockData) BacktestResult {
+	result := BacktestResult{
+		Symbols:      cfg.Symbols,
+		Weights:      make([]float64, len(cfg.Weights)),
+		Rebalance:    cfg.Rebalance,
+		StartCapital: cfg.StartCapital,
+		Holdings:     []BacktestHolding{},
+		Equity:       []EquityPoint{},
+		Trades:       []BacktestTrade{},
+	}
+	for i, w := range cfg.Weights {
+		result.Weights[i] = math.Round(w*10000) / 10000
+	}
+
+	// Close prices by date for each symbol, and the union of dates
+	closesByDate := make([]map[string]float64, len(series))
+	dateSet := map[string]bool{}
+	for i, data := range series {
+		closesByDate[i] = map[string]float64{}
+		for _, d := range data {
+			if c := parseFloat(d.Close); c > 0 {
+				closesByDate[i][d.Date] = c
+				dateSet[d.Date] = true
+			}
+		}
+	}
+	dates := make([]string, 0, len(dateSet))
+	for d := range dateSet {
+		dates = append(dates, d)
+	}
+	sort.Strings(dates)
+
+	p := &portfolio{cfg: cfg, cash: cfg.StartCapital, shares: make([]float64, len(cfg.Symbols))}
+	prices := make([]float64, len(cfg.Symbols))
+	var curveDates []string
+	var curveValues []float64
+	var lastKey string
+
+	for _, date := range dates {
+		ready := true
+		for i := range prices {
+			if c, ok := closesByDate[i][date]; ok {
+				prices[i] = c
+			}
+			if prices[i] == 0 {
+				ready = false
+			}
+		}
+		if !ready {
+			continue
+		}
+
+		key := rebalanceKey(date, cfg.Rebalance)
+		if len(curveDates) == 0 || (cfg.Rebalance != "none" && key != lastKey) {
+			p.rebalance(date, prices)
+			result.Rebalances++
+		}
+		lastKey = key
+
+		equity := p.value(prices)
+		curveDates = append(curveDates, date)
+		curveValues = append(curveValues, equity)
+		result.Equity = append(result.Equity, EquityPoint{
+			Date:   date,
+			Equity: math.Round(equity*100) / 100,
+			Cash:   math.Round(p.cash*100) / 100,
+		})
+	}
+
+	if len(curveDates) == 0 {
+		return result
+	}
+
+	result.StartDate = curveDates[0]
+	result.EndDate = curveDates[len(curveDates)-1]
+	endEquity := curveValues[len(curveValues)-1]
+	result.EndEquity = math.Round(endEquity*100) / 100
+	result.TotalCosts = math.Round(p.costs*100) / 100
+	result.Stats = ComputeRiskStats(curveDates, curveValues, cfg.StatsPeriod, cfg.RiskFreePct)
+
+	for i, sym := range cfg.Symbols {
+		value := p.shares[i] * prices[i]
+		holding := BacktestHolding{
+			Symbol: sym,
+			Shares: math.Round(p.shares[i]*10000) / 10000,
+			Price:  prices[i],
+			Value:  math.Round(value*100) / 100,
+		}
+		if endEquity > 0 {
+			holding.WeightPct = roundPct(value / endEquity * 100)
+		}
+		result.Holdings = append(result.Holdings, holding)
+	}
+
+	// Newest first, consistent with the rest of the API
+	slices.Reverse(result.Equity)
+	result.Trades = append(result.Trades, p.trades...)
+	slices.Reverse(result.Trades)
+	return result
+}
+
+// rebalanceKey groups dates into rebalance periods
+func rebalanceKey(date, rebalance string) string {
+	if rebalance == "none" || rebalance == "daily" {
+		return date
+	}
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return date
+	}
+	return getPeriodKey(t, PeriodType(rebalance))
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestRunBacktestBuyAndHold(t *testing.T) {
+	cfg := BacktestConfig{
+		Symbols:      []string{"AAA"},
+		Weights:      []float64{1},
+		Rebalance:    "none",
+		StartCapital: 1000,
+		StatsPeriod:  "daily",
+	}
+	result := RunBacktest(cfg, [][]StockData{closes("2024-01-01", 100, 110, 121)})
+
+	if result.EndEquity != 1210 || len(result.Trades) != 1 || result.Rebalances != 1 {
+		t.Errorf("Unexpected result: end=%v trades=%d rebalances=%d", result.EndEquity, len(result.Trades), result.Rebalances)
+	}
+	if len(result.Equity) != 3 || result.Equity[0].Date != "2024-01-03" {
+		t.Errorf("Expected newest-first equity curve, got %+v", result.Equity)
+	}
+	if result.Stats == nil || result.Stats.TotalReturnPct != 21 {
+		t.Errorf("Unexpected stats: %+v", result.Stats)
+	}
+	if h := result.Holdings[0]; h.Shares != 10 || h.WeightPct != 100 {
+		t.Errorf("Unexpected holding: %+v", h)
+	}
+}
+
+func TestRunBacktestMonthlyRebalance(t *testing.T) {
+	a := []StockData{
+		{Date: "2024-01-30", Close: "100"},
+		{Date: "2024-01-31", Close: "200"},
+		{Date: "2024-02-01", Close: "200"},
+	}
+	b := []StockData{
+		{Date: "2024-01-30", Close: "50"},
+		{Date: "2024-01-31", Close: "50"},
+		{Date: "2024-02-01", Close: "50"},
+	}
+	cfg := BacktestConfig{
+		Symbols:      []string{"AAA", "BBB"},
+		Weights:      []float64{0.5, 0.5},
+		Rebalance:    "monthly",
+		StartCapital: 1000,
+	}
+	result := RunBacktest(cfg, [][]StockData{a, b})
+
+	// Initial buys, then on Feb 1 sell 250 of AAA and buy 250 of BBB
+	if len(result.Trades) != 4 || result.Rebalances != 2 {
+		t.Fatalf("Expected 4 trades over 2 rebalances, got %+v", result.Trades)
+	}
+	if tr := result.Trades[1]; tr.Symbol != "AAA" || tr.Side != "sell" || tr.Value != 250 || tr.Date != "2024-02-01" {
+		t.Errorf("Unexpected sell: %+v", tr)
+	}
+	if tr := result.Trades[0]; tr.Symbol != "BBB" || tr.Side != "buy" || tr.Value != 250 {
+		t.Errorf("Unexpected buy: %+v", tr)
+	}
+	if result.Holdings[0].WeightPct != 50 || result.Holdings[1].WeightPct != 50 || result.EndEquity != 1500 {
+		t.Errorf("Unexpected holdings: %+v end=%v", result.Holdings, result.EndEquity)
+	}
+}
+
+func TestRunBacktestCosts(t *testing.T) {
+	cfg := BacktestConfig{
+		Symbols:      []string{"AAA", "BBB"},
+		Weights:      []float64{0.5, 0.5},
+		Rebalance:    "none",
+		StartCapital: 1000,
+		CostBps:      10,
+		FeePerTrade:  1,
+	}
+	result := RunBacktest(cfg, [][]StockData{closes("2024-01-01", 100, 100), closes("2024-01-01", 10, 10)})
+
+	// Costs come out of capital up front and cash never goes negative
+	last := result.Equity[0]
+	if last.Cash < 0 || math.Abs(result.TotalCosts-2.998) > 0.01 {
+		t.Errorf("cash=%v costs=%v", last.Cash, result.TotalCosts)
+	}
+	if math.Abs(result.EndEquity+result.TotalCosts-1000) > 0.01 {
+		t.Errorf("Equity plus costs should equal capital: %v + %v", result.EndEquity, result.TotalCosts)
+	}
+}
+
+func TestRunBacktestMixedCalendars(t *testing.T) {
+	us := closes("2024-01-01", 100, 100, 100, 110)
+	hk := []StockData{ // Starts a day later and skips 01-03
+		{Date: "2024-01-02", Close: "10"},
+		{Date: "2024-01-04", Close: "12"},
+	}
+	cfg := BacktestConfig{
+		Symbols:      []string{"AAA", "0001.HK"},
+		Weights:      []float64{0.5, 0.5},
+		Rebalance:    "none",
+		StartCapital: 1000,
+	}
+	result := RunBacktest(cfg, [][]StockData{us, hk})
+
+	if result.StartDate != "2024-01-02" || len(result.Equity) != 3 {
+		t.Fatalf("Expected to start when both have prices, got %+v", result.Equity)
+	}
+	// On the HK holiday the position is valued at its last close
+	if result.Equity[1].Date != "2024-01-03" || result.Equity[1].Equity != 1000 {
+		t.Errorf("Holiday equity = %+v", result.Equity[1])
+	}
+	if result.EndEquity != 1150 {
+		t.Errorf("EndEquity = %v, want 1150", result.EndEquity)
+	}
+}
+
+func TestParseBacktestParams(t *testing.T) {
+	query := httptest.NewRequest("GET", "/?symbols=aapl,msft&weights=60,40&rebalance=quarterly&capital=5000&cost_bps=5", nil).URL.Query()
+	cfg, days, _, err := parseBacktestParams(query)
+	if err != nil {
+		t.Fatalf("parseBacktestParams() error = %v", err)
+	}
+	if cfg.Symbols[0] != "AAPL" || !floatsNear(cfg.Weights[0], 0.6) || cfg.Rebalance != "quarterly" ||
+		cfg.StartCapital != 5000 || cfg.CostBps != 5 || days != 1825 {
+		t.Errorf("Unexpected config: %+v", cfg)
+	}
+
+	for _, q := range []string{
+		"",
+		"symbols=AAPL,AAPL",
+		"symbols=AAPL,MSFT&weights=1",
+		"symbols=AAPL&weights=-1",
+		"symbols=AAPL&rebalance=hourly",
+		"symbols=AAPL&capital=0",
+		"symbols=AAPL&fee=abc",
+	} {
+		query := httptest.NewRequest("GET", "/?"+q, nil).URL.Query()
+		if _, _, _, err := parseBacktestParams(query); err == nil {
+			t.Errorf("parseBacktestParams(%q) expected error", q)
+		}
+	}
+}
+
+func TestBacktestEndpoints(t *testing.T) {
+	start := time.Now().AddDate(0, 0, -3).Format("2006-01-02")
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}},
+		&symbolProvider{name: "fake", data: map[string][]StockData{
+			"AAA": reverseData(closes(start, 100, 105, 110)),
+			"BBB": reverseData(closes(start, 20, 19, 18)),
+		}})
+	server := NewServer("0", nil)
+
+	req := httptest.NewRequest("GET", "/api/backtest?symbols=AAA,BBB&weights=3,1&days=30", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data BacktestResult `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	// 7500 * 1.1 + 2500 * 0.9
+	if resp.Data.EndEquity != 10500 || resp.Data.Stats == nil || resp.Data.Weights[0] != 0.75 {
+		t.Errorf("Unexpected result: %+v", resp.Data)
+	}
+
+	// Neither source has adjusted closes, so neither is adjusted
+	w = doJSON(t, server, "GET", "/api/backtest?symbols=AAA,BBB&weights=3,1&days=30&adjusted=true", "")
+	resp.Data = BacktestResult{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if adj := resp.Data.Adjusted; len(adj) != 2 || adj["AAA"] || adj["BBB"] || resp.Data.EndEquity != 10500 {
+		t.Errorf("adjusted = %v, want false for both", adj)
+	}
+
+	req = httptest.NewRequest("GET", "/api/backtest-excel?symbols=AAA,BBB&days=30", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	f, err := excelize.OpenReader(w.Body)
+	if err != nil {
+		t.Fatalf("Failed to open workbook: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	if sheets := f.GetSheetList(); len(sheets) != 3 || sheets[1] != "Equity" || sheets[2] != "Trades" {
+		t.Errorf("Unexpected sheets: %v", sheets)
+	}
+
+	// A symbol that cannot be fetched fails the whole backtest
+	req = httptest.NewRequest("GET", "/api/backtest?symbols=AAA,MISSING&days=30", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %d", w.Code)
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -13,6 +15,9 @@ import (
 // Cache provides SQLite-backed caching for stock data
 type Cache struct {
 	db *sql.DB
+
+	// storeHook is called after StoreDailyPrices commits (e.g. alert evaluation)
+	storeHook func(ctx context.Context, symbol string, data []StockData)
 }
 
 // FetchMeta holds metadata about a cached symbol
@@ -26,9 +31,23 @@ type FetchMeta struct {
 	EarliestDate string
 }
 
+// cacheDSN adds a busy_timeout to dbPath so that connections wait for locks
+// instead of failing when several fetches write at once. A DSN that sets its
+// own busy_timeout is left alone.
+func cacheDSN(dbPath string) string {
+	switch {
+	case strings.Contains(dbPath, "busy_timeout"):
+		return dbPath
+	case strings.Contains(dbPath, "?"):
+		return dbPath + "&_pragma=busy_timeout(5000)"
+	default:
+		return dbPath + "?_pragma=busy_timeout(5000)"
+	}
+}
+
 // NewCache creates a new SQLite cache
 func NewCache(dbPath string) (*Cache, error) {
-	db, err := sql.Open("sqlite", dbPath)
+	db, err := sql.Open("sqlite", cacheDSN(dbPath))
 	if err != nil {
 		return nil, fmt.Errorf("open cache db: %w", err)
 	}
@@ -76,19 +95,110 @@ func (c *Cache) migrate() error {
 			latest_date   TEXT,
 			earliest_date TEXT
 		);
+
+		CREATE TABLE IF NOT EXISTS corporate_events (
+			symbol TEXT NOT NULL,
+			date   TEXT NOT NULL,
+			type   TEXT NOT NULL,
+			amount REAL,
+			ratio  TEXT,
+			factor REAL,
+			PRIMARY KEY (symbol, date, type)
+		);
+
+		CREATE TABLE IF NOT EXISTS watchlists (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			name        TEXT NOT NULL UNIQUE,
+			description TEXT NOT NULL DEFAULT '',
+			created_at  TEXT NOT NULL,
+			updated_at  TEXT NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS watchlist_items (
+			watchlist_id INTEGER NOT NULL,
+			symbol       TEXT NOT NULL,
+			position     INTEGER NOT NULL,
+			added_at     TEXT NOT NULL,
+			PRIMARY KEY (watchlist_id, symbol)
+		);
+
+		CREATE TABLE IF NOT EXISTS alert_rules (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			name        TEXT NOT NULL,
+			scope_type  TEXT NOT NULL,
+			scope       TEXT NOT NULL,
+			condition   TEXT NOT NULL,
+			threshold   REAL NOT NULL,
+			webhook_url TEXT NOT NULL DEFAULT '',
+			enabled     INTEGER NOT NULL DEFAULT 1,
+			created_at  TEXT NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS alert_events (
+			id           INTEGER PRIMARY KEY AUTOINCREMENT,
+			rule_id      INTEGER NOT NULL,
+			rule_name    TEXT NOT NULL,
+			symbol       TEXT NOT NULL,
+			date         TEXT NOT NULL,
+			condition    TEXT NOT NULL,
+			threshold    REAL NOT NULL,
+			value        REAL NOT NULL,
+			message      TEXT NOT NULL,
+			triggered_at TEXT NOT NULL,
+			delivered    INTEGER NOT NULL DEFAULT 0,
+			attempts     INTEGER NOT NULL DEFAULT 0,
+			last_error   TEXT NOT NULL DEFAULT '',
+			UNIQUE (rule_id, symbol, date)
+		);
 	`)
+	if err != nil {
+		return err
+	}
+
+	// Columns added after the initial schema
+	return c.addColumnIfMissing("daily_prices", "adj_close", "TEXT")
+}
+
+// addColumnIfMissing adds a column to an existing table created by an older version
+func (c *Cache) addColumnIfMissing(table, column, colType string) error {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_ = rows.Close()
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, colType))
 	return err
 }
 
 // GetFetchMeta returns fetch metadata for a symbol, or nil if not cached
-func (c *Cache) GetFetchMeta(symbol string) (*FetchMeta, error) {
-	row := c.db.QueryRow(
+func (c *Cache) GetFetchMeta(ctx context.Context, symbol string) (_ *FetchMeta, err error) {
+	ctx, span := startCacheSpan(ctx, "GetFetchMeta", symbol)
+	defer func() { span.End(err) }()
+
+	row := c.db.QueryRowContext(ctx,
 		`SELECT symbol, source, company_name, ttm_eps, last_fetched, latest_date, earliest_date
 		 FROM fetch_log WHERE symbol = ?`, symbol)
 
 	var m FetchMeta
 	var lastFetched string
-	err := row.Scan(&m.Symbol, &m.Source, &m.CompanyName, &m.TTMEPS,
+	err = row.Scan(&m.Symbol, &m.Source, &m.CompanyName, &m.TTMEPS,
 		&lastFetched, &m.LatestDate, &m.EarliestDate)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -103,9 +213,12 @@ func (c *Cache) GetFetchMeta(symbol string) (*FetchMeta, error) {
 // GetDailyPrices returns cached daily prices for a symbol in a date range.
 // Returns data sorted newest-first (consistent with the app convention).
 // Change and HChange are recomputed from the raw OHLC data.
-func (c *Cache) GetDailyPrices(symbol, startDate, endDate string) ([]StockData, error) {
-	rows, err := c.db.Query(
-		`SELECT date, open, high, low, close, volume, pe
+func (c *Cache) GetDailyPrices(ctx context.Context, symbol, startDate, endDate string) (_ []StockData, err error) {
+	ctx, span := startCacheSpan(ctx, "GetDailyPrices", symbol)
+	defer func() { span.End(err) }()
+
+	rows, err := c.db.QueryContext(ctx,
+		`SELECT date, open, high, low, close, volume, pe, COALESCE(adj_close, '')
 		 FROM daily_prices
 		 WHERE symbol = ? AND date >= ? AND date <= ?
 		 ORDER BY date ASC`, symbol, startDate, endDate)
@@ -119,7 +232,7 @@ func (c *Cache) GetDailyPrices(symbol, startDate, endDate string) ([]StockData,
 
 	for rows.Next() {
 		var d StockData
-		if err := rows.Scan(&d.Date, &d.Open, &d.High, &d.Low, &d.Close, &d.Volume, &d.PE); err != nil {
+		if err := rows.Scan(&d.Date, &d.Open, &d.High, &d.Low, &d.Close, &d.Volume, &d.PE, &d.AdjClose); err != nil {
 			return nil, err
 		}
 
@@ -149,23 +262,98 @@ func (c *Cache) GetDailyPrices(symbol, startDate, endDate string) ([]StockData,
 
 // StoreDailyPrices stores daily price records in the cache.
 // Uses INSERT OR REPLACE so newer data overwrites older cached values.
-func (c *Cache) StoreDailyPrices(symbol string, data []StockData) error {
-	tx, err := c.db.Begin()
+func (c *Cache) StoreDailyPrices(ctx context.Context, symbol string, data []StockData) (err error) {
+	ctx, span := startCacheSpan(ctx, "StoreDailyPrices", symbol, slog.Int("rows", len(data)))
+	defer func() { span.End(err) }()
+
+	tx, err := c.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer func() { _ = tx.Rollback() }()
 
-	stmt, err := tx.Prepare(
-		`INSERT OR REPLACE INTO daily_prices (symbol, date, open, high, low, close, volume, pe)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	stmt, err := tx.PrepareContext(ctx,
+		`INSERT OR REPLACE INTO daily_prices (symbol, date, open, high, low, close, volume, pe, adj_close)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return err
 	}
 	defer func() { _ = stmt.Close() }()
 
 	for _, d := range data {
-		if _, err := stmt.Exec(symbol, d.Date, d.Open, d.High, d.Low, d.Close, d.Volume, d.PE); err != nil {
+		if _, err := stmt.ExecContext(ctx, symbol, d.Date, d.Open, d.High, d.Low, d.Close, d.Volume, d.PE, d.AdjClose); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if c.storeHook != nil {
+		c.storeHook(ctx, symbol, data)
+	}
+	return nil
+}
+
+// startCacheSpan starts a span for a cache operation on a symbol
+func startCacheSpan(ctx context.Context, op, symbol string, attrs ...slog.Attr) (context.Context, *span) {
+	attrs = append([]slog.Attr{slog.String("db.system", "sqlite"), slog.String("symbol", symbol)}, attrs...)
+	return startSpan(ctx, "cache."+op, attrs...)
+}
+
+// SetStoreHook registers a function called with each batch of prices after
+// StoreDailyPrices commits it
+func (c *Cache) SetStoreHook(hook func(ctx context.Context, symbol string, data []StockData)) {
+	c.storeHook = hook
+}
+
+// GetEvents returns cached dividends and splits for a symbol in a date range, oldest first
+func (c *Cache) GetEvents(ctx context.Context, symbol, startDate, endDate string) (_ []CorporateEvent, err error) {
+	ctx, span := startCacheSpan(ctx, "GetEvents", symbol)
+	defer func() { span.End(err) }()
+
+	rows, err := c.db.QueryContext(ctx,
+		`SELECT date, type, COALESCE(amount, 0), COALESCE(ratio, ''), COALESCE(factor, 0)
+		 FROM corporate_events
+		 WHERE symbol = ? AND date >= ? AND date <= ?
+		 ORDER BY date ASC, type ASC`, symbol, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var events []CorporateEvent
+	for rows.Next() {
+		var e CorporateEvent
+		if err := rows.Scan(&e.Date, &e.Type, &e.Amount, &e.Ratio, &e.Factor); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// StoreEvents stores dividend and split events in the cache
+func (c *Cache) StoreEvents(ctx context.Context, symbol string, events []CorporateEvent) (err error) {
+	ctx, span := startCacheSpan(ctx, "StoreEvents", symbol, slog.Int("events", len(events)))
+	defer func() { span.End(err) }()
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stmt, err := tx.PrepareContext(ctx,
+		`INSERT OR REPLACE INTO corporate_events (symbol, date, type, amount, ratio, factor)
+		 VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = stmt.Close() }()
+
+	for _, e := range events {
+		if _, err := stmt.ExecContext(ctx, symbol, e.Date, e.Type, e.Amount, e.Ratio, e.Factor); err != nil {
 			return err
 		}
 	}
@@ -174,8 +362,11 @@ func (c *Cache) StoreDailyPrices(symbol string, data []StockData) error {
 }
 
 // UpdateFetchLog updates the fetch metadata for a symbol
-func (c *Cache) UpdateFetchLog(m FetchMeta) error {
-	_, err := c.db.Exec(
+func (c *Cache) UpdateFetchLog(ctx context.Context, m FetchMeta) (err error) {
+	ctx, span := startCacheSpan(ctx, "UpdateFetchLog", m.Symbol)
+	defer func() { span.End(err) }()
+
+	_, err = c.db.ExecContext(ctx,
 		`INSERT OR REPLACE INTO fetch_log (symbol, source, company_name, ttm_eps, last_fetched, latest_date, earliest_date)
 		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
 		m.Symbol, m.Source, m.CompanyName, m.TTMEPS,
@@ -183,6 +374,127 @@ func (c *Cache) UpdateFetchLog(m FetchMeta) error {
 	return err
 }
 
+// CacheStats summarizes the contents of the cache database
+type CacheStats struct {
+	SizeBytes    int64        `json:"size_bytes"`
+	Symbols      int          `json:"symbols"`
+	Rows         int64        `json:"rows"` // Daily price rows
+	Events       int64        `json:"events"`
+	EarliestDate string       `json:"earliest_date,omitempty"`
+	LatestDate   string       `json:"latest_date,omitempty"`
+	Watchlists   int          `json:"watchlists"`
+	AlertRules   int          `json:"alert_rules"`
+	Entries      []CacheEntry `json:"entries,omitempty"`
+}
+
+// CacheEntry describes the cached history of one symbol
+type CacheEntry struct {
+	Symbol       string `json:"symbol"`
+	Source       string `json:"source"`
+	Rows         int64  `json:"rows"`
+	EarliestDate string `json:"earliest_date"`
+	LatestDate   string `json:"latest_date"`
+	LastFetched  string `json:"last_fetched"`
+}
+
+// SizeBytes returns the size of the database file from SQLite's page count
+func (c *Cache) SizeBytes(ctx context.Context) (int64, error) {
+	var pageCount, pageSize int64
+	if err := c.db.QueryRowContext(ctx, `PRAGMA page_count`).Scan(&pageCount); err != nil {
+		return 0, err
+	}
+	if err := c.db.QueryRowContext(ctx, `PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	return pageCount * pageSize, nil
+}
+
+// Stats returns cache totals, and per-symbol entries if withEntries is set
+func (c *Cache) Stats(ctx context.Context, withEntries bool) (*CacheStats, error) {
+	var st CacheStats
+	size, err := c.SizeBytes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	st.SizeBytes = size
+
+	err = c.db.QueryRowContext(ctx,
+		`SELECT COUNT(DISTINCT symbol), COUNT(*), COALESCE(MIN(date), ''), COALESCE(MAX(date), '') FROM daily_prices`).
+		Scan(&st.Symbols, &st.Rows, &st.EarliestDate, &st.LatestDate)
+	if err != nil {
+		return nil, err
+	}
+	counts := []struct {
+		query string
+		dest  any
+	}{
+		{`SELECT COUNT(*) FROM corporate_events`, &st.Events},
+		{`SELECT COUNT(*) FROM watchlists`, &st.Watchlists},
+		{`SELECT COUNT(*) FROM alert_rules`, &st.AlertRules},
+	}
+	for _, q := range counts {
+		if err := c.db.QueryRowContext(ctx, q.query).Scan(q.dest); err != nil {
+			return nil, err
+		}
+	}
+
+	if !withEntries {
+		return &st, nil
+	}
+	rows, err := c.db.QueryContext(ctx, `
+		SELECT p.symbol, COALESCE(f.source, ''), COUNT(*), MIN(p.date), MAX(p.date), COALESCE(f.last_fetched, '')
+		FROM daily_prices p LEFT JOIN fetch_log f ON f.symbol = p.symbol
+		GROUP BY p.symbol ORDER BY p.symbol`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var e CacheEntry
+		if err := rows.Scan(&e.Symbol, &e.Source, &e.Rows, &e.EarliestDate, &e.LatestDate, &e.LastFetched); err != nil {
+			return nil, err
+		}
+		st.Entries = append(st.Entries, e)
+	}
+	return &st, rows.Err()
+}
+
+// Purge deletes cached prices, events and fetch metadata for the given
+// symbols, or for every symbol if none are given, and reclaims the space.
+// Watchlists and alerts are kept. Returns the number of price rows deleted.
+func (c *Cache) Purge(ctx context.Context, symbols []string) (int64, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	where, args := "", []any{}
+	if len(symbols) > 0 {
+		where = " WHERE symbol IN (?" + strings.Repeat(", ?", len(symbols)-1) + ")"
+		for _, sym := range symbols {
+			args = append(args, strings.ToUpper(sym))
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM daily_prices`+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	deleted, _ := res.RowsAffected()
+	for _, table := range []string{"corporate_events", "fetch_log"} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+where, args...); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	_, err = c.db.ExecContext(ctx, `VACUUM`)
+	return deleted, err
+}
+
 // IsFresh returns true if the symbol was fetched today
 func (m *FetchMeta) IsFresh() bool {
 	now := time.Now()
@@ -224,16 +536,16 @@ func detectDBPath() string {
 func InitCache() *Cache {
 	dbPath := detectDBPath()
 	if dbPath == "none" || dbPath == "" {
-		log.Println("Cache disabled")
+		slog.Info("Cache disabled")
 		return nil
 	}
 
 	cache, err := NewCache(dbPath)
 	if err != nil {
-		log.Printf("Warning: failed to init cache at %s: %v (running without cache)", dbPath, err)
+		slog.Warn("Cache init failed, running without cache", "path", dbPath, "error", err)
 		return nil
 	}
 
-	log.Printf("Cache initialized at %s", dbPath)
+	slog.Info("Cache initialized", "path", dbPath)
 	return cache
 }
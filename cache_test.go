@@ -23,7 +23,7 @@ func TestCacheRoundTrip(t *testing.T) {
 		{Date: "2024-01-03", Open: "145.00", High: "149.00", Low: "144.00", Close: "148.00", Volume: "9M", PE: "28.50"},
 	}
 
-	if err := cache.StoreDailyPrices(symbol, data); err != nil {
+	if err := cache.StoreDailyPrices(t.Context(), symbol, data); err != nil {
 		t.Fatalf("StoreDailyPrices: %v", err)
 	}
 
@@ -37,12 +37,12 @@ func TestCacheRoundTrip(t *testing.T) {
 		LatestDate:   "2024-01-05",
 		EarliestDate: "2024-01-03",
 	}
-	if err := cache.UpdateFetchLog(meta); err != nil {
+	if err := cache.UpdateFetchLog(t.Context(), meta); err != nil {
 		t.Fatalf("UpdateFetchLog: %v", err)
 	}
 
 	// Read back fetch meta
-	gotMeta, err := cache.GetFetchMeta(symbol)
+	gotMeta, err := cache.GetFetchMeta(t.Context(), symbol)
 	if err != nil {
 		t.Fatalf("GetFetchMeta: %v", err)
 	}
@@ -60,7 +60,7 @@ func TestCacheRoundTrip(t *testing.T) {
 	}
 
 	// Read back prices
-	gotData, err := cache.GetDailyPrices(symbol, "2024-01-03", "2024-01-05")
+	gotData, err := cache.GetDailyPrices(t.Context(), symbol, "2024-01-03", "2024-01-05")
 	if err != nil {
 		t.Fatalf("GetDailyPrices: %v", err)
 	}
@@ -106,10 +106,10 @@ func TestCachePartialRange(t *testing.T) {
 		{Date: "2024-01-02", Close: "145.00", High: "146.00"},
 		{Date: "2024-01-01", Close: "142.00", High: "143.00"},
 	}
-	_ = cache.StoreDailyPrices("TEST", data)
+	_ = cache.StoreDailyPrices(t.Context(), "TEST", data)
 
 	// Query partial range
-	result, _ := cache.GetDailyPrices("TEST", "2024-01-03", "2024-01-05")
+	result, _ := cache.GetDailyPrices(t.Context(), "TEST", "2024-01-03", "2024-01-05")
 	if len(result) != 3 {
 		t.Fatalf("Expected 3 records, got %d", len(result))
 	}
@@ -123,7 +123,7 @@ func TestCacheNonExistent(t *testing.T) {
 	}
 	defer cache.Close()
 
-	meta, err := cache.GetFetchMeta("NONEXISTENT")
+	meta, err := cache.GetFetchMeta(t.Context(), "NONEXISTENT")
 	if err != nil {
 		t.Fatalf("GetFetchMeta: %v", err)
 	}
@@ -131,7 +131,7 @@ func TestCacheNonExistent(t *testing.T) {
 		t.Error("Expected nil for non-existent symbol")
 	}
 
-	data, err := cache.GetDailyPrices("NONEXISTENT", "2024-01-01", "2024-12-31")
+	data, err := cache.GetDailyPrices(t.Context(), "NONEXISTENT", "2024-01-01", "2024-12-31")
 	if err != nil {
 		t.Fatalf("GetDailyPrices: %v", err)
 	}
@@ -149,18 +149,18 @@ func TestCacheUpsert(t *testing.T) {
 	defer cache.Close()
 
 	// Store initial data
-	_ = cache.StoreDailyPrices("AAPL", []StockData{
+	_ = cache.StoreDailyPrices(t.Context(), "AAPL", []StockData{
 		{Date: "2024-01-03", Close: "148.00"},
 		{Date: "2024-01-02", Close: "145.00"},
 	})
 
 	// Store overlapping + new data (should upsert)
-	_ = cache.StoreDailyPrices("AAPL", []StockData{
+	_ = cache.StoreDailyPrices(t.Context(), "AAPL", []StockData{
 		{Date: "2024-01-04", Close: "150.00"},
 		{Date: "2024-01-03", Close: "149.00"}, // updated value
 	})
 
-	result, _ := cache.GetDailyPrices("AAPL", "2024-01-02", "2024-01-04")
+	result, _ := cache.GetDailyPrices(t.Context(), "AAPL", "2024-01-02", "2024-01-04")
 	if len(result) != 3 {
 		t.Fatalf("Expected 3 records, got %d", len(result))
 	}
@@ -198,3 +198,93 @@ func TestFetchMetaCoversRange(t *testing.T) {
 		t.Error("Should not cover date before earliest")
 	}
 }
+
+func TestCacheEventsAndAdjClose(t *testing.T) {
+	cache, err := NewCache(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+	defer cache.Close()
+
+	data := []StockData{
+		{Date: "2024-01-03", Open: "10.00", High: "11.00", Low: "9.00", Close: "10.50", Volume: "1M", AdjClose: "10.2500"},
+	}
+	if err := cache.StoreDailyPrices(t.Context(), "AAPL", data); err != nil {
+		t.Fatalf("StoreDailyPrices: %v", err)
+	}
+
+	events := []CorporateEvent{
+		{Date: "2024-01-03", Type: EventDividend, Amount: 0.25},
+		{Date: "2024-01-03", Type: EventSplit, Ratio: "4:1", Factor: 4},
+		{Date: "2025-01-03", Type: EventDividend, Amount: 0.26},
+	}
+	if err := cache.StoreEvents(t.Context(), "AAPL", events); err != nil {
+		t.Fatalf("StoreEvents: %v", err)
+	}
+
+	got, err := cache.GetDailyPrices(t.Context(), "AAPL", "2024-01-01", "2024-12-31")
+	if err != nil || len(got) != 1 {
+		t.Fatalf("GetDailyPrices: %v %v", got, err)
+	}
+	if got[0].AdjClose != "10.2500" {
+		t.Errorf("AdjClose = %q, want %q", got[0].AdjClose, "10.2500")
+	}
+
+	gotEvents, err := cache.GetEvents(t.Context(), "AAPL", "2024-01-01", "2024-12-31")
+	if err != nil {
+		t.Fatalf("GetEvents: %v", err)
+	}
+	if len(gotEvents) != 2 {
+		t.Fatalf("Expected 2 events in range, got %d", len(gotEvents))
+	}
+	if gotEvents[1].Type != EventSplit || gotEvents[1].Factor != 4 {
+		t.Errorf("Unexpected split: %+v", gotEvents[1])
+	}
+}
+
+func TestCacheMigratesOldSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "old.db")
+
+	// Create a database with the original daily_prices schema (no adj_close)
+	cache, err := NewCache(dbPath)
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+	if _, err := cache.db.Exec(`DROP TABLE daily_prices;
+		CREATE TABLE daily_prices (
+			symbol TEXT NOT NULL, date TEXT NOT NULL,
+			open TEXT, high TEXT, low TEXT, close TEXT, volume TEXT, pe TEXT,
+			PRIMARY KEY (symbol, date));
+		INSERT INTO daily_prices VALUES ('AAPL', '2024-01-03', '1', '2', '0.5', '1.5', '1M', '');`); err != nil {
+		t.Fatalf("create old schema: %v", err)
+	}
+	cache.Close()
+
+	cache, err = NewCache(dbPath)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer cache.Close()
+
+	got, err := cache.GetDailyPrices(t.Context(), "AAPL", "2024-01-01", "2024-12-31")
+	if err != nil || len(got) != 1 {
+		t.Fatalf("GetDailyPrices after migration: %v %v", got, err)
+	}
+	if got[0].AdjClose != "" {
+		t.Errorf("Expected empty AdjClose for old rows, got %q", got[0].AdjClose)
+	}
+}
+
+func TestCacheDSN(t *testing.T) {
+	tests := map[string]string{
+		"cache.db":                                  "cache.db?_pragma=busy_timeout(5000)",
+		"file:cache.db?mode=rwc":                    "file:cache.db?mode=rwc&_pragma=busy_timeout(5000)",
+		"cache.db?_pragma=busy_timeout(100)":        "cache.db?_pragma=busy_timeout(100)",
+		"file:cache.db?_pragma=foreign_keys(1)&x=y": "file:cache.db?_pragma=foreign_keys(1)&x=y&_pragma=busy_timeout(5000)",
+	}
+	for in, want := range tests {
+		if got := cacheDSN(in); got != want {
+			t.Errorf("cacheDSN(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
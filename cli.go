@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+)
+
+const cliUsage = `Usage: stock-fetcher [command]
+
+Commands:
+  serve [--port 8080]                   Start the web server (default with no command)
+  fetch SYMBOL [flags]                  Print stock data
+      --days 1825  --period daily|weekly|monthly|quarterly|yearly (default monthly)
+      --format table|csv|json|xlsx (default table)  --adjusted  --indicators sma:50,rsi:14
+      --output FILE (default stdout; xlsx defaults to SYMBOL_PERIOD.xlsx)
+  backfill [SYMBOL...] [flags]          Fill the cache for symbols, an index or a watchlist
+      --index sp500  --watchlist ID  --days 1825  --force (re-fetch data fetched today)
+  cache stats [--symbols] [--json]      Show cache size and contents
+  cache purge [SYMBOL...]               Delete cached prices (all symbols if none given)
+
+Environment variables such as DB_PATH and PROVIDERS_US apply as for the server.
+`
+
+// errUsage reports bad arguments; the usage text has already been printed
+var errUsage = errors.New("invalid usage")
+
+// runCommand runs a subcommand and returns the process exit code. Ctrl-C
+// cancels in-flight fetches; the server handles signals itself.
+func runCommand(args []string, stdout, stderr io.Writer) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	switch args[0] {
+	case "serve":
+		stop()
+		err = cmdServe(args[1:], stderr)
+	case "fetch":
+		err = cmdFetch(ctx, args[1:], stdout, stderr)
+	case "backfill":
+		err = cmdBackfill(ctx, args[1:], stdout, stderr)
+	case "cache":
+		err = cmdCache(ctx, args[1:], stdout, stderr)
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, cliUsage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "Unknown command %q\n\n%s", args[0], cliUsage)
+		return 2
+	}
+
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage), errors.Is(err, flag.ErrHelp):
+		return 2
+	default:
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+}
+
+// newFlagSet creates a flag set that prints the command usage on errors
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, cliUsage) }
+	return fs
+}
+
+// parseArgs parses flags that may appear before or after positional
+// arguments ("fetch AAPL --days 365") and returns the positional ones. The
+// flag set reports its own errors, so they come back as errUsage.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		} else if err != nil {
+			return nil, errUsage
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// openCLICache opens the cache with alert evaluation wired in, as the server
+// does, so prices stored by a command can fire alerts. The returned func
+// waits for alert deliveries and closes the cache.
+func openCLICache() (*Cache, func()) {
+	cache := InitCache()
+	if cache == nil {
+		return nil, func() {}
+	}
+	alerts := NewAlertEngine(cache)
+	cache.SetStoreHook(alerts.Evaluate)
+	return cache, func() {
+		alerts.Wait()
+		_ = cache.Close()
+	}
+}
+
+// cmdServe starts the web server
+func cmdServe(args []string, stderr io.Writer) error {
+	fs := newFlagSet("serve", stderr)
+	port := fs.String("port", os.Getenv("PORT"), "listen port")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if *port == "" {
+		*port = "8080"
+	}
+	return runServer(*port)
+}
+
+// cmdFetch fetches one symbol and writes it as a table, CSV, JSON or Excel
+func cmdFetch(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("fetch", stderr)
+	days := fs.Int("days", 1825, "days of history")
+	period := fs.String("period", "monthly", "daily, weekly, monthly, quarterly or yearly")
+	format := fs.String("format", "table", "table, csv, json or xlsx")
+	output := fs.String("output", "", "output file")
+	adjusted := fs.Bool("adjusted", false, "back-adjust for splits and dividends")
+	indicatorList := fs.String("indicators", "", "indicators, e.g. sma:50,rsi:14")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fmt.Fprintf(stderr, "fetch takes exactly one symbol\n\n%s", cliUsage)
+		return errUsage
+	}
+	symbol := strings.ToUpper(positional[0])
+
+	if *days <= 0 {
+		return fmt.Errorf("--days must be positive")
+	}
+	if *period != "daily" {
+		if _, err := ParsePeriodType(*period); err != nil {
+			return err
+		}
+	}
+	switch *format {
+	case "table", "csv", "json", "xlsx":
+	default:
+		return fmt.Errorf("unknown format %q (use table, csv, json or xlsx)", *format)
+	}
+	indicators, err := ParseIndicators(*indicatorList)
+	if err != nil {
+		return fmt.Errorf("invalid indicators: %w", err)
+	}
+
+	cache, closeCache := openCLICache()
+	defer closeCache()
+	result, err := fetchStockData(ctx, cache, symbol, *days)
+	if err != nil {
+		return err
+	}
+	if len(result.Data) == 0 {
+		return fmt.Errorf("no data found for %s", symbol)
+	}
+
+	// Excel defaults to a file like the web download; "-" forces stdout
+	if *format == "xlsx" && *output == "" {
+		*output = fmt.Sprintf("%s_%s.xlsx", symbol, *period)
+	}
+	out := stdout
+	if *output != "" && *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = file.Close() }()
+		out = file
+	}
+
+	switch *format {
+	case "xlsx":
+		f, err := GenerateExcel(buildExcelParams(symbol, result, *period, *adjusted, indicators))
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		if err := f.Write(out); err != nil {
+			return err
+		}
+		if out != stdout {
+			fmt.Fprintf(stderr, "Wrote %s\n", *output)
+		}
+		return nil
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(buildStockResponse(symbol, result, *period, *adjusted, indicators, defaultRiskFreeRate()))
+	}
+
+	resp := buildStockResponse(symbol, result, *period, *adjusted, indicators, 0)
+	headers, rows := stockRows(resp, result.IncludePE)
+	if *format == "csv" {
+		w := csv.NewWriter(out)
+		_ = w.Write(headers)
+		_ = w.WriteAll(rows)
+		return w.Error()
+	}
+	return writeTable(out, headers, rows)
+}
+
+// stockRows renders daily or period data as text rows with the same columns
+// as the Excel export, indicators last
+func stockRows(resp StockResponse, includePE bool) ([]string, [][]string) {
+	headers, rows := priceRows(resp, includePE)
+	for _, series := range resp.Indicators {
+		headers = append(headers, series.Label)
+		for i, v := range series.Values {
+			cell := ""
+			if v.Value != nil {
+				cell = strconv.FormatFloat(*v.Value, 'f', -1, 64)
+			}
+			rows[i] = append(rows[i], cell)
+		}
+	}
+	return headers, rows
+}
+
+// priceRows renders the price columns of stockRows
+func priceRows(resp StockResponse, includePE bool) ([]string, [][]string) {
+	if resp.PeriodData == nil {
+		rows := make([][]string, 0, len(resp.DailyData))
+		for _, d := range resp.DailyData {
+			row := []string{d.Date, d.Open, d.High, d.Low, d.Close, d.Volume, d.Change, d.HChange}
+			if includePE {
+				row = append(row, d.PE)
+			}
+			rows = append(rows, row)
+		}
+		return dailyHeaders(includePE), rows
+	}
+
+	rows := make([][]string, 0, len(resp.PeriodData))
+	for _, p := range resp.PeriodData {
+		row := []string{p.Period, p.StartDate, p.EndDate, p.Open, p.High, p.Low, p.Close, p.Volume, p.Change, p.HChange}
+		if includePE {
+			row = append(row, p.PE)
+		}
+		for _, n := range []int{p.Days,
+			p.Drop2Pct.Close, p.Drop2Pct.Low, p.Drop3Pct.Close, p.Drop3Pct.Low,
+			p.Drop4Pct.Close, p.Drop4Pct.Low, p.Drop5Pct.Close, p.Drop5Pct.Low} {
+			row = append(row, strconv.Itoa(n))
+		}
+		rows = append(rows, row)
+	}
+	return periodHeaders(includePE), rows
+}
+
+// writeTable writes aligned columns
+func writeTable(out io.Writer, headers []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, strings.Join(headers, "\t")+"\t")
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t")+"\t")
+	}
+	return tw.Flush()
+}
+
+// cmdBackfill fills the cache for a set of symbols. Exits non-zero if any
+// symbol fails, so cron can alert on it.
+func cmdBackfill(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("backfill", stderr)
+	index := fs.String("index", "", "index key, e.g. sp500")
+	watchlist := fs.Int64("watchlist", 0, "watchlist ID")
+	days := fs.Int("days", refreshDays, "days of history")
+	force := fs.Bool("force", false, "re-fetch symbols already fetched today")
+	symbols, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *days <= 0 {
+		return fmt.Errorf("--days must be positive")
+	}
+
+	cache, closeCache := openCLICache()
+	defer closeCache()
+	if cache == nil {
+		return fmt.Errorf("backfill needs the cache (check DB_PATH)")
+	}
+
+	if *index != "" {
+		idx, ok := GetIndices()[strings.ToLower(*index)]
+		if !ok {
+			return fmt.Errorf("unknown index %q", *index)
+		}
+		symbols = append(symbols, idx.Symbols...)
+	}
+	if *watchlist != 0 {
+		wl, err := cache.GetWatchlist(ctx, *watchlist)
+		if err != nil {
+			return err
+		}
+		if wl == nil {
+			return fmt.Errorf("watchlist %d not found", *watchlist)
+		}
+		symbols = append(symbols, wl.Symbols...)
+	}
+	symbols = normalizeSymbols(symbols)
+	if len(symbols) == 0 {
+		fmt.Fprintf(stderr, "backfill needs symbols, --index or --watchlist\n\n%s", cliUsage)
+		return errUsage
+	}
+
+	fetch := fetchStockData
+	if *force {
+		fetch = refreshStockData
+	}
+	results, errs := fetchBatch(symbols, func(symbol string) (*FetchResult, error) {
+		return fetch(ctx, cache, symbol, *days)
+	})
+
+	var failed int
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SYMBOL\tROWS\tFROM\tTO\tSOURCE\tERROR")
+	for i, sym := range symbols {
+		if errs[i] != nil {
+			failed++
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\t%v\n", sym, errs[i])
+			continue
+		}
+		data := results[i].Data
+		from, to := "-", "-"
+		if len(data) > 0 {
+			from, to = data[len(data)-1].Date, data[0].Date
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t\n", sym, len(data), from, to, results[i].Source)
+	}
+	_ = tw.Flush()
+
+	fmt.Fprintf(stderr, "Backfilled %d of %d symbols\n", len(symbols)-failed, len(symbols))
+	if failed > 0 {
+		return fmt.Errorf("%d symbols failed", failed)
+	}
+	return nil
+}
+
+// cmdCache inspects or purges the cache database
+func cmdCache(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 || (args[0] != "stats" && args[0] != "purge") {
+		fmt.Fprintf(stderr, "cache needs stats or purge\n\n%s", cliUsage)
+		return errUsage
+	}
+	action := args[0]
+
+	fs := newFlagSet("cache "+action, stderr)
+	withEntries := fs.Bool("symbols", false, "list every cached symbol")
+	asJSON := fs.Bool("json", false, "print JSON")
+	symbols, err := parseArgs(fs, args[1:])
+	if err != nil {
+		return err
+	}
+
+	cache, closeCache := openCLICache()
+	defer closeCache()
+	if cache == nil {
+		return fmt.Errorf("cache is disabled (check DB_PATH)")
+	}
+
+	if action == "purge" {
+		deleted, err := cache.Purge(ctx, symbols)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Deleted %d price rows\n", deleted)
+		return nil
+	}
+
+	st, err := cache.Stats(ctx, *withEntries)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(st)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Path:\t%s\n", detectDBPath())
+	fmt.Fprintf(tw, "Size:\t%.1f MB\n", float64(st.SizeBytes)/(1<<20))
+	fmt.Fprintf(tw, "Symbols:\t%d\n", st.Symbols)
+	fmt.Fprintf(tw, "Price rows:\t%d\n", st.Rows)
+	fmt.Fprintf(tw, "Events:\t%d\n", st.Events)
+	if st.Rows > 0 {
+		fmt.Fprintf(tw, "Dates:\t%s to %s\n", st.EarliestDate, st.LatestDate)
+	}
+	fmt.Fprintf(tw, "Watchlists:\t%d\n", st.Watchlists)
+	fmt.Fprintf(tw, "Alert rules:\t%d\n", st.AlertRules)
+	if *withEntries {
+		fmt.Fprintln(tw, "\nSYMBOL\tROWS\tFROM\tTO\tSOURCE\tLAST FETCHED")
+		for _, e := range st.Entries {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", e.Symbol, e.Rows, e.EarliestDate, e.LatestDate, e.Source, e.LastFetched)
+		}
+	}
+	return tw.Flush()
+}
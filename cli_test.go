@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cliSeries is ten recent daily bars, newest-first, with Low = Close so no
+// low drops are counted
+func cliSeries() []StockData {
+	data := closes(time.Now().AddDate(0, 0, -10).Format("2006-01-02"), 100, 101, 102, 103, 104, 105, 106, 107, 108, 109)
+	for i := range data {
+		data[i].Open, data[i].High, data[i].Low, data[i].Volume = data[i].Close, data[i].Close, data[i].Close, "1000"
+	}
+	return reverseData(data)
+}
+
+// runCLI runs a command and returns its exit code, stdout and stderr
+func runCLI(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := runCommand(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestParseArgs(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	days := fs.Int("days", 0, "")
+	force := fs.Bool("force", false, "")
+	positional, err := parseArgs(fs, []string{"AAPL", "--days", "30", "MSFT", "--force", "KO"})
+	if err != nil {
+		t.Fatalf("parseArgs: %v", err)
+	}
+	if strings.Join(positional, ",") != "AAPL,MSFT,KO" || *days != 30 || !*force {
+		t.Errorf("Got %v days=%d force=%v", positional, *days, *force)
+	}
+}
+
+func TestRunCommandUsage(t *testing.T) {
+	t.Setenv("DB_PATH", "none")
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{[]string{"help"}, 0},
+		{[]string{"bogus"}, 2},
+		{[]string{"fetch"}, 2},
+		{[]string{"fetch", "AAPL", "MSFT"}, 2},
+		{[]string{"fetch", "AAPL", "--nope"}, 2},
+		{[]string{"fetch", "AAPL", "--format", "xml"}, 1},
+		{[]string{"fetch", "AAPL", "--period", "hourly"}, 1},
+		{[]string{"cache"}, 2},
+		{[]string{"cache", "stats"}, 1}, // Cache disabled
+		{[]string{"backfill", "AAPL"}, 1},
+	}
+	for _, tt := range tests {
+		if code, _, _ := runCLI(tt.args...); code != tt.want {
+			t.Errorf("%v: exit code %d, want %d", tt.args, code, tt.want)
+		}
+	}
+}
+
+func TestFetchCommand(t *testing.T) {
+	t.Setenv("DB_PATH", "none")
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}}, &fakeProvider{name: "fake", data: cliSeries()})
+
+	code, out, errOut := runCLI("fetch", "aapl", "--period", "daily", "--days", "30", "--format", "csv", "--indicators", "sma:2")
+	if code != 0 {
+		t.Fatalf("Exit code %d: %s", code, errOut)
+	}
+	records, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	if err != nil {
+		t.Fatalf("Invalid CSV: %v", err)
+	}
+	if len(records) != 11 || records[0][0] != "Date" || records[0][len(records[0])-1] != "SMA(2)" {
+		t.Fatalf("Unexpected CSV header or length: %v (%d rows)", records[0], len(records))
+	}
+	if newest := records[1]; newest[4] != "109.00" || newest[len(newest)-1] != "108.5" {
+		t.Errorf("Unexpected newest row: %v", newest)
+	}
+
+	code, out, _ = runCLI("fetch", "AAPL", "--days", "30", "--format", "json")
+	var resp StockResponse
+	if err := json.Unmarshal([]byte(out), &resp); code != 0 || err != nil || resp.Symbol != "AAPL" || len(resp.PeriodData) == 0 {
+		t.Errorf("Unexpected JSON output (code %d): %s", code, out)
+	}
+
+	code, out, _ = runCLI("fetch", "AAPL", "--days", "30")
+	if code != 0 || !strings.HasPrefix(strings.TrimSpace(out), "Period") {
+		t.Errorf("Unexpected table output (code %d): %s", code, out)
+	}
+
+	path := filepath.Join(t.TempDir(), "out.xlsx")
+	if code, _, errOut := runCLI("fetch", "AAPL", "--days", "30", "--format", "xlsx", "--output", path); code != 0 {
+		t.Fatalf("xlsx exit code %d: %s", code, errOut)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+		t.Errorf("Excel file not written: %v", err)
+	}
+}
+
+func TestBackfillAndCacheCommands(t *testing.T) {
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "cli.db"))
+	p := &symbolProvider{name: "fake", data: map[string][]StockData{"AAPL": cliSeries(), "MSFT": cliSeries()}}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}}, p)
+
+	code, out, _ := runCLI("backfill", "AAPL", "--days", "30", "msft")
+	if code != 0 || !strings.Contains(out, "AAPL") || !strings.Contains(out, "MSFT") {
+		t.Fatalf("Backfill failed (code %d): %s", code, out)
+	}
+	if code, out, _ := runCLI("backfill", "AAPL", "BAD", "--days", "30"); code != 1 || !strings.Contains(out, "unknown symbol BAD") {
+		t.Errorf("Failed symbol should exit 1 and be listed (code %d): %s", code, out)
+	}
+
+	code, out, _ = runCLI("cache", "stats", "--json", "--symbols")
+	var st CacheStats
+	if err := json.Unmarshal([]byte(out), &st); code != 0 || err != nil {
+		t.Fatalf("Unexpected stats output (code %d): %s", code, out)
+	}
+	if st.Symbols != 2 || st.Rows != 20 || len(st.Entries) != 2 || st.Entries[0].Symbol != "AAPL" || st.Entries[0].Rows != 10 {
+		t.Errorf("Unexpected stats: %+v", st)
+	}
+
+	if code, out, _ := runCLI("cache", "purge", "aapl"); code != 0 || !strings.Contains(out, "Deleted 10") {
+		t.Errorf("Unexpected purge output (code %d): %s", code, out)
+	}
+	if code, out, _ := runCLI("cache", "stats"); code != 0 || !strings.Contains(out, "Symbols:") {
+		t.Errorf("Unexpected stats table (code %d): %s", code, out)
+	}
+	if code, out, _ := runCLI("cache", "purge"); code != 0 || !strings.Contains(out, "Deleted 10") {
+		t.Errorf("Purging everything should delete MSFT (code %d): %s", code, out)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+)
+
+// Concurrent cache misses for the same symbol share one provider fetch, so a
+// popular ticker opened by many users at once costs one set of upstream
+// requests and one cache write rather than one per request.
+
+// flight is one provider fetch in progress
+type flight struct {
+	from    string // Earliest date the cache will cover once the fetch is stored
+	done    chan struct{}
+	result  *FetchResult
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// flightGroup tracks the fetch in progress for each symbol
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// symbolFlights coalesces cache misses in loadStockData
+var symbolFlights = &flightGroup{flights: make(map[string]*flight)}
+
+// do runs fn for key, or waits for the flight already running for key if it
+// covers from. Callers needing an earlier range than the running flight
+// covers run fn on their own.
+//
+// fn runs detached from the cancellation of the caller that started it and
+// is cancelled only once every caller waiting on it has given up, so one
+// client disconnecting doesn't fail the others. It keeps that caller's
+// deadline, so a fetch started within a request budget stays within it.
+// shared reports whether the result came from a flight another caller
+// started. Every caller of a flight gets the same result, which must not be
+// modified.
+func (g *flightGroup) do(ctx context.Context, key, from string, fn func(context.Context) (*FetchResult, error)) (result *FetchResult, shared bool, err error) {
+	g.mu.Lock()
+	f, ok := g.flights[key]
+	switch {
+	case ok && f.from <= from:
+		f.waiters++
+		shared = true
+	case ok:
+		g.mu.Unlock()
+		result, err = fn(ctx)
+		return result, false, err
+	default:
+		var fctx context.Context
+		var cancel context.CancelFunc
+		if deadline, ok := ctx.Deadline(); ok {
+			fctx, cancel = context.WithDeadline(context.WithoutCancel(ctx), deadline)
+		} else {
+			fctx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+		}
+		f = &flight{from: from, done: make(chan struct{}), waiters: 1, cancel: cancel}
+		g.flights[key] = f
+		go func() {
+			defer func() {
+				// Nothing above this goroutine would recover a panic, so
+				// fail the waiters instead of the process
+				if r := recover(); r != nil {
+					slog.ErrorContext(fctx, "Fetch panicked", "symbol", key, "panic", r, "stack", string(debug.Stack()))
+					f.result, f.err = nil, fmt.Errorf("fetch %s panicked: %v", key, r)
+				}
+				g.mu.Lock()
+				if g.flights[key] == f {
+					delete(g.flights, key)
+				}
+				g.mu.Unlock()
+				cancel()
+				close(f.done)
+			}()
+			f.result, f.err = fn(fctx)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.result, shared, f.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		if f.waiters--; f.waiters == 0 {
+			// Nobody is left to serve; later callers start afresh
+			f.cancel()
+			if g.flights[key] == f {
+				delete(g.flights, key)
+			}
+		}
+		g.mu.Unlock()
+		return nil, shared, ctx.Err()
+	}
+}
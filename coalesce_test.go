@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gatedProvider blocks each fetch until released or cancelled
+type gatedProvider struct {
+	name      string
+	data      []StockData
+	release   chan struct{}
+	calls     atomic.Int32
+	cancelled atomic.Int32
+}
+
+func (p *gatedProvider) Name() string { return p.name }
+
+func (p *gatedProvider) QuoteURL(symbol, companyName string) string { return "" }
+
+func (p *gatedProvider) Fetch(ctx context.Context, symbol string, days int) (*FetchResult, error) {
+	p.calls.Add(1)
+	select {
+	case <-p.release:
+		return &FetchResult{Data: append([]StockData(nil), p.data...), CompanyName: symbol}, nil
+	case <-ctx.Done():
+		p.cancelled.Add(1)
+		return nil, ctx.Err()
+	}
+}
+
+// waitForWaiters blocks until n callers are waiting on key's flight
+func waitForWaiters(t *testing.T, key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		symbolFlights.mu.Lock()
+		f := symbolFlights.flights[key]
+		waiting := f != nil && f.waiters == n
+		symbolFlights.mu.Unlock()
+		if waiting {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d callers on %s", n, key)
+}
+
+func TestConcurrentFetchesCoalesced(t *testing.T) {
+	p := &gatedProvider{name: "gated", data: cliSeries(), release: make(chan struct{})}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"gated"}}}, p)
+	cache := newTestCache(t)
+
+	const n = 5
+	coalesced := fetchesCoalesced.Value()
+	results := make([]*FetchResult, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = fetchStockData(t.Context(), cache, "AAPL", 30)
+		}()
+	}
+	waitForWaiters(t, "AAPL", n)
+	close(p.release)
+	wg.Wait()
+
+	if got := p.calls.Load(); got != 1 {
+		t.Errorf("Provider called %d times, want 1", got)
+	}
+	if got := fetchesCoalesced.Value() - coalesced; got != n-1 {
+		t.Errorf("Coalesced fetches = %v, want %d", got, n-1)
+	}
+	for i := range n {
+		if errs[i] != nil || len(results[i].Data) != 10 || results[i].CompanyName != "AAPL" {
+			t.Fatalf("Caller %d: %v %+v", i, errs[i], results[i])
+		}
+	}
+	// Every caller gets its own rows to compute changes on
+	results[0].Data[0].Change = "changed"
+	if results[1].Data[0].Change == "changed" {
+		t.Error("Callers should not share result rows")
+	}
+}
+
+func TestCoalescedFetchOutlivesOneCaller(t *testing.T) {
+	p := &gatedProvider{name: "gated", data: cliSeries(), release: make(chan struct{})}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"gated"}}}, p)
+	cache := newTestCache(t)
+
+	// The caller that started the fetch disconnects
+	ctx, cancel := context.WithCancel(t.Context())
+	first := make(chan error, 1)
+	go func() {
+		_, err := fetchStockData(ctx, cache, "AAPL", 30)
+		first <- err
+	}()
+	waitForWaiters(t, "AAPL", 1)
+	second := make(chan error, 1)
+	go func() {
+		_, err := fetchStockData(t.Context(), cache, "AAPL", 30)
+		second <- err
+	}()
+	waitForWaiters(t, "AAPL", 2)
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("Cancelled caller got %v", err)
+	}
+	close(p.release)
+	if err := <-second; err != nil {
+		t.Errorf("Remaining caller should still be served: %v", err)
+	}
+	if p.cancelled.Load() != 0 {
+		t.Error("Fetch was cancelled while a caller was still waiting")
+	}
+}
+
+func TestCoalescedFetchCancelledWhenAllCallersLeave(t *testing.T) {
+	p := &gatedProvider{name: "gated", data: cliSeries(), release: make(chan struct{})}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"gated"}}}, p)
+	withProviderTimeout(t, time.Minute)
+	cache := newTestCache(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := fetchStockData(ctx, cache, "AAPL", 30); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the caller's deadline, got %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for p.cancelled.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if p.cancelled.Load() != 1 {
+		t.Error("Upstream fetch should be cancelled once nobody is waiting")
+	}
+}
+
+func TestWiderRangeNotCoalesced(t *testing.T) {
+	p := &gatedProvider{name: "gated", data: cliSeries(), release: make(chan struct{})}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"gated"}}}, p)
+	cache := newTestCache(t)
+
+	done := make(chan error, 2)
+	go func() {
+		_, err := fetchStockData(t.Context(), cache, "AAPL", 30)
+		done <- err
+	}()
+	waitForWaiters(t, "AAPL", 1)
+	go func() {
+		// The running fetch won't cover a year, so this one fetches on its own
+		_, err := fetchStockData(t.Context(), cache, "AAPL", 365)
+		done <- err
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for p.calls.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(p.release)
+	for range 2 {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+	if got := p.calls.Load(); got != 2 {
+		t.Errorf("Provider called %d times, want 2", got)
+	}
+}
+
+// panicProvider panics like a parser hitting an unexpected response
+type panicProvider struct{}
+
+func (panicProvider) Name() string { return "panicky" }
+
+func (panicProvider) QuoteURL(symbol, companyName string) string { return "" }
+
+func (panicProvider) Fetch(ctx context.Context, symbol string, days int) (*FetchResult, error) {
+	var rows []StockData
+	return &FetchResult{Data: rows[:1]}, nil
+}
+
+func TestCoalescedFetchPanic(t *testing.T) {
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"panicky"}}}, panicProvider{})
+	logs := captureLogs(t)
+
+	_, err := fetchStockData(t.Context(), newTestCache(t), "AAPL", 30)
+	if err == nil || !strings.Contains(err.Error(), "panicked") {
+		t.Fatalf("Expected the panic as an error, got %v", err)
+	}
+	symbolFlights.mu.Lock()
+	_, running := symbolFlights.flights["AAPL"]
+	symbolFlights.mu.Unlock()
+	if running {
+		t.Error("A panicked flight should be removed")
+	}
+	if len(logRecords(t, logs, "Fetch panicked")) != 1 {
+		t.Error("Expected the panic to be logged")
+	}
+}
+
+func TestCoalescedFetchKeepsDeadline(t *testing.T) {
+	g := &flightGroup{flights: make(map[string]*flight)}
+	ctx, cancel := context.WithTimeout(t.Context(), time.Minute)
+	defer cancel()
+	want, _ := ctx.Deadline()
+
+	_, _, err := g.do(ctx, "AAPL", "", func(fctx context.Context) (*FetchResult, error) {
+		if got, ok := fctx.Deadline(); !ok || !got.Equal(want) {
+			return nil, fmt.Errorf("deadline = %v, %v; want %v", got, ok, want)
+		}
+		return &FetchResult{}, nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCoalescedResultsNotShared(t *testing.T) {
+	p := &gatedProvider{name: "gated", data: cliSeries(), release: make(chan struct{})}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"gated"}}}, p)
+	// A closed cache fails the re-read, so callers are served the fetched rows
+	cache := newTestCache(t)
+	_ = cache.Close()
+
+	results := make(chan *FetchResult, 2)
+	for range 2 {
+		go func() {
+			result, err := fetchStockData(t.Context(), cache, "AAPL", 30)
+			if err != nil {
+				t.Error(err)
+			} else {
+				// Like the handlers, compute changes in place
+				computeChanges(result.Data)
+			}
+			results <- result
+		}()
+	}
+	waitForWaiters(t, "AAPL", 2)
+	close(p.release)
+
+	first, second := <-results, <-results
+	if first == nil || second == nil {
+		t.FailNow()
+	}
+	if &first.Data[0] == &second.Data[0] {
+		t.Error("Callers of one flight should get their own rows")
+	}
+}
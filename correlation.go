@@ -0,0 +1,150 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// minCorrelationReturns is the fewest aligned returns needed for a correlation or beta
+const minCorrelationReturns = 3
+
+// CorrelationReport holds a return-correlation matrix and betas against a benchmark
+type CorrelationReport struct {
+	Symbols      []string          `json:"symbols"` // Row/column order of Matrix
+	Benchmark    string            `json:"benchmark"`
+	PeriodType   string            `json:"period_type"`
+	StartDate    string            `json:"start_date"`
+	EndDate      string            `json:"end_date"`
+	Adjusted     map[string]bool   `json:"adjusted,omitempty"` // Per symbol when adjusted=true; false if the source has no adjusted closes
+	Matrix       [][]*float64      `json:"matrix"`             // Pearson correlation of returns; null if too few aligned returns
+	Observations [][]int           `json:"observations"`       // Aligned returns behind each cell
+	Betas        []BetaStat        `json:"betas,omitempty"`
+	Errors       map[string]string `json:"errors,omitempty"` // Symbols that could not be fetched
+}
+
+// BetaStat is one symbol's beta and correlation against the benchmark
+type BetaStat struct {
+	Symbol       string   `json:"symbol"`
+	Beta         *float64 `json:"beta"`
+	Correlation  *float64 `json:"correlation"`
+	Observations int      `json:"observations"`
+}
+
+// closeSeries is a close-price series keyed by date (daily) or period key
+type closeSeries map[string]float64
+
+// newCloseSeries builds a series from daily data (oldest-first), keeping
+// the last close of each period for aggregated views
+func newCloseSeries(data []StockData, periodType string) closeSeries {
+	series := closeSeries{}
+	for _, d := range data {
+		close := parseFloat(d.Close)
+		if close <= 0 {
+			continue
+		}
+		key := d.Date
+		if periodType != "daily" && periodType != "" {
+			t, err := time.Parse("2006-01-02", d.Date)
+			if err != nil {
+				continue
+			}
+			key = getPeriodKey(t, PeriodType(periodType))
+		}
+		series[key] = close
+	}
+	return series
+}
+
+// alignedReturns returns the returns of two series over the keys they share.
+// Each return spans consecutive shared keys, so a holiday on one exchange
+// stretches both returns over the same interval instead of mismatching them.
+func alignedReturns(a, b closeSeries) ([]float64, []float64) {
+	var keys []string
+	for k := range a {
+		if _, ok := b[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var ra, rb []float64
+	for i := 1; i < len(keys); i++ {
+		prev, cur := keys[i-1], keys[i]
+		ra = append(ra, a[cur]/a[prev]-1)
+		rb = append(rb, b[cur]/b[prev]-1)
+	}
+	return ra, rb
+}
+
+// correlationAndBeta returns the Pearson correlation of x and y and the
+// beta of x against y. ok is false if there are too few returns or y is flat.
+func correlationAndBeta(x, y []float64) (corr, beta float64, ok bool) {
+	n := len(x)
+	if n < minCorrelationReturns || n != len(y) {
+		return 0, 0, false
+	}
+
+	var meanX, meanY float64
+	for i := range x {
+		meanX += x[i]
+		meanY += y[i]
+	}
+	meanX /= float64(n)
+	meanY /= float64(n)
+
+	var cov, varX, varY float64
+	for i := range x {
+		dx, dy := x[i]-meanX, y[i]-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return 0, 0, false
+	}
+	return cov / math.Sqrt(varX*varY), cov / varY, true
+}
+
+// roundRatio rounds a ratio to 4 decimals and returns a pointer for JSON
+func roundRatio(v float64) *float64 {
+	r := math.Round(v*10000) / 10000
+	return &r
+}
+
+// ComputeCorrelation builds the correlation matrix for the given series and
+// betas against the benchmark series (nil benchmark skips betas)
+func ComputeCorrelation(symbols []string, series []closeSeries, benchmark closeSeries) ([][]*float64, [][]int, []BetaStat) {
+	n := len(symbols)
+	matrix := make([][]*float64, n)
+	observations := make([][]int, n)
+	for i := range matrix {
+		matrix[i] = make([]*float64, n)
+		observations[i] = make([]int, n)
+	}
+
+	for i := 0; i < n; i++ {
+		for j := i; j < n; j++ {
+			ri, rj := alignedReturns(series[i], series[j])
+			observations[i][j], observations[j][i] = len(ri), len(ri)
+			if corr, _, ok := correlationAndBeta(ri, rj); ok {
+				matrix[i][j] = roundRatio(corr)
+				matrix[j][i] = matrix[i][j]
+			}
+		}
+	}
+
+	if benchmark == nil {
+		return matrix, observations, nil
+	}
+	betas := make([]BetaStat, n)
+	for i, sym := range symbols {
+		rs, rb := alignedReturns(series[i], benchmark)
+		betas[i] = BetaStat{Symbol: sym, Observations: len(rs)}
+		if corr, beta, ok := correlationAndBeta(rs, rb); ok {
+			betas[i].Correlation = roundRatio(corr)
+			betas[i].Beta = roundRatio(beta)
+		}
+	}
+	return matrix, observations, betas
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAlignedReturnsSkipsMissingDays(t *testing.T) {
+	us := closeSeries{"2024-01-02": 100, "2024-01-03": 110, "2024-01-04": 121, "2024-01-05": 121}
+	hk := closeSeries{"2024-01-02": 50, "2024-01-04": 60, "2024-01-05": 66} // 01-03 is a holiday
+
+	ra, rb := alignedReturns(us, hk)
+	if len(ra) != 2 || len(rb) != 2 {
+		t.Fatalf("Expected 2 aligned returns, got %v / %v", ra, rb)
+	}
+	// The first return spans the holiday on both sides: 100→121 and 50→60
+	if !floatsNear(ra[0], 0.21) || !floatsNear(rb[0], 0.2) {
+		t.Errorf("Holiday-spanning returns = %v / %v", ra[0], rb[0])
+	}
+	if !floatsNear(ra[1], 0) || !floatsNear(rb[1], 0.1) {
+		t.Errorf("Second returns = %v / %v", ra[1], rb[1])
+	}
+}
+
+func TestCorrelationAndBeta(t *testing.T) {
+	x := []float64{0.01, -0.02, 0.03, 0.00}
+	y := []float64{0.02, -0.04, 0.06, 0.00}
+
+	corr, beta, ok := correlationAndBeta(y, x)
+	if !ok || !floatsNear(corr, 1) || !floatsNear(beta, 2) {
+		t.Errorf("corr/beta = %v/%v/%v, want 1/2", corr, beta, ok)
+	}
+
+	inverse := []float64{-0.01, 0.02, -0.03, 0.00}
+	if corr, _, _ := correlationAndBeta(inverse, x); !floatsNear(corr, -1) {
+		t.Errorf("inverse corr = %v, want -1", corr)
+	}
+
+	if _, _, ok := correlationAndBeta([]float64{0.01, 0.02}, []float64{0.01, 0.02}); ok {
+		t.Error("Expected too few returns to be rejected")
+	}
+	if _, _, ok := correlationAndBeta(x, []float64{0, 0, 0, 0}); ok {
+		t.Error("Expected flat series to be rejected")
+	}
+}
+
+func TestNewCloseSeriesPeriods(t *testing.T) {
+	data := []StockData{
+		{Date: "2024-01-30", Close: "10"},
+		{Date: "2024-01-31", Close: "11"},
+		{Date: "2024-02-01", Close: "12"},
+	}
+	series := newCloseSeries(data, "monthly")
+	if len(series) != 2 || series["2024-01"] != 11 || series["2024-02"] != 12 {
+		t.Errorf("Monthly series = %v", series)
+	}
+}
+
+func TestCorrelationEndpoint(t *testing.T) {
+	start := time.Now().AddDate(0, 0, -6).Format("2006-01-02")
+	us := closes(start, 100, 110, 99, 108.9, 100, 105)
+	hk := append([]StockData(nil), us...)
+	hk = append(hk[:2], hk[3:]...) // HK holiday on day 3
+
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}, "HK": {"fake"}}},
+		&symbolProvider{name: "fake", data: map[string][]StockData{
+			"AAA":     reverseData(us),
+			"BBB":     reverseData(closes(start, 50, 45, 50, 45, 50, 45)),
+			"0001.HK": reverseData(hk),
+			"SPY":     reverseData(us),
+		}})
+
+	server := NewServer("0", nil)
+	req := httptest.NewRequest("GET", "/api/correlation?symbols=AAA,bbb,0001.HK,MISSING&days=30", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Data CorrelationReport `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	report := resp.Data
+
+	if len(report.Symbols) != 3 || report.Benchmark != "SPY" || report.Errors["MISSING"] == "" {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	// AAA and 0001.HK match on shared days, so the holiday must not break correlation
+	if c := report.Matrix[0][2]; c == nil || *c != 1 || report.Observations[0][2] != 4 {
+		t.Errorf("AAA/0001.HK correlation = %v over %d returns", c, report.Observations[0][2])
+	}
+	if c := report.Matrix[0][0]; c == nil || *c != 1 {
+		t.Errorf("Diagonal = %v, want 1", c)
+	}
+	if b := report.Betas[0]; b.Symbol != "AAA" || b.Beta == nil || *b.Beta != 1 {
+		t.Errorf("AAA beta = %+v", b)
+	}
+}
+
+func TestCorrelationEndpointAdjusted(t *testing.T) {
+	start := time.Now().AddDate(0, 0, -4).Format("2006-01-02")
+	// AAA halves on a 2:1 split; its adjusted closes carry no step
+	split := closes(start, 100, 102, 51, 52)
+	for i, adj := range []string{"50", "51", "51", "52"} {
+		split[i].AdjClose = adj
+	}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}},
+		&symbolProvider{name: "fake", data: map[string][]StockData{
+			"AAA": reverseData(split),
+			"BBB": reverseData(closes(start, 50, 51, 50, 52)),
+			"SPY": reverseData(closes(start, 50, 51, 50, 52)),
+		}})
+	server := NewServer("0", nil)
+
+	w := doJSON(t, server, "GET", "/api/correlation?symbols=AAA,BBB&days=30&adjusted=true", "")
+	var resp struct {
+		Data CorrelationReport `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	want := map[string]bool{"AAA": true, "BBB": false, "SPY": false}
+	if !maps.Equal(resp.Data.Adjusted, want) {
+		t.Errorf("adjusted = %v, want %v", resp.Data.Adjusted, want)
+	}
+}
+
+func TestCorrelationEndpointValidation(t *testing.T) {
+	server := NewServer("0", nil)
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"symbols=AAPL", http.StatusBadRequest},
+		{"index=unknown", http.StatusNotFound},
+		{"symbols=AAPL,MSFT&period=hourly", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/correlation?"+tt.query, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.query, tt.want, w.Code)
+		}
+	}
+}
+
+func TestCorrelationEndpointFetchErrors(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{fmt.Errorf("%w ZZZ", ErrUnknownSymbol), http.StatusNotFound, "unknown_symbol"},
+		{fmt.Errorf("yahoo: %w", ErrUpstreamThrottled), http.StatusTooManyRequests, "upstream_throttled"},
+		{fmt.Errorf("yahoo: %w", ErrUpstreamUnavailable), http.StatusServiceUnavailable, "upstream_unavailable"},
+	}
+	for _, tt := range tests {
+		withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}}, &fakeProvider{name: "fake", err: tt.err})
+		w := doJSON(t, NewServer("0", nil), "GET", "/api/correlation?symbols=AAA,BBB", "")
+
+		var resp APIResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		if w.Code != tt.status || resp.Code != tt.code {
+			t.Errorf("%v: got %d %q, want %d %q", tt.err, w.Code, resp.Code, tt.status, tt.code)
+		}
+	}
+}
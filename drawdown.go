@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// DrawdownEpisode is a single peak-to-trough decline and its recovery
+type DrawdownEpisode struct {
+	PeakDate      string  `json:"peak_date"`
+	PeakClose     float64 `json:"peak_close"`
+	TroughDate    string  `json:"trough_date"`
+	TroughClose   float64 `json:"trough_close"`
+	DepthPct      float64 `json:"depth_pct"`                 // (trough - peak) / peak * 100, negative
+	DaysToTrough  int     `json:"days_to_trough"`            // Calendar days from peak to trough
+	Recovered     bool    `json:"recovered"`                 // Close got back to the prior peak
+	RecoveryDate  string  `json:"recovery_date,omitempty"`   // First close at or above the peak
+	DaysToRecover int     `json:"days_to_recover,omitempty"` // Calendar days from trough to recovery
+	TotalDays     int     `json:"total_days,omitempty"`      // Calendar days from peak to recovery
+}
+
+// DrawdownReport summarizes the drawdowns of a daily series
+type DrawdownReport struct {
+	Symbol             string            `json:"symbol"`
+	CompanyName        string            `json:"company_name"`
+	StartDate          string            `json:"start_date"`
+	EndDate            string            `json:"end_date"`
+	ThresholdPct       float64           `json:"threshold_pct"`
+	Adjusted           *bool             `json:"adjusted,omitempty"` // As for StockResponse
+	HighClose          float64           `json:"high_close"`         // Highest close in range
+	HighDate           string            `json:"high_date"`
+	LatestClose        float64           `json:"latest_close"`
+	CurrentDrawdownPct float64           `json:"current_drawdown_pct"` // Latest close vs highest close in range
+	MaxDrawdownPct     float64           `json:"max_drawdown_pct"`
+	Episodes           []DrawdownEpisode `json:"episodes"` // Newest first
+}
+
+// daysBetween returns the calendar days between two YYYY-MM-DD dates
+func daysBetween(from, to string) int {
+	a, errA := time.Parse("2006-01-02", from)
+	b, errB := time.Parse("2006-01-02", to)
+	if errA != nil || errB != nil {
+		return 0
+	}
+	return int(b.Sub(a).Hours() / 24)
+}
+
+// roundPct rounds a percentage to 2 decimals
+func roundPct(v float64) float64 {
+	return round2(v)
+}
+
+// round2 rounds a value to 2 decimals
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// AnalyzeDrawdowns walks a daily series (oldest-first) and returns every
+// peak-to-trough episode at least thresholdPct deep (e.g. 10 for -10%).
+// An episode still open at the end of the series is reported unrecovered.
+func AnalyzeDrawdowns(data []StockData, thresholdPct float64) DrawdownReport {
+	report := DrawdownReport{ThresholdPct: thresholdPct, Episodes: []DrawdownEpisode{}}
+	if len(data) == 0 {
+		return report
+	}
+	report.StartDate = data[0].Date
+	report.EndDate = data[len(data)-1].Date
+
+	var episodes []DrawdownEpisode
+	var current *DrawdownEpisode
+	var peak, maxDepth float64
+	var peakDate string
+
+	// closeEpisode records the open episode if it is deep enough
+	closeEpisode := func() {
+		if current != nil && -current.DepthPct >= thresholdPct {
+			episodes = append(episodes, *current)
+		}
+		current = nil
+	}
+
+	for _, d := range data {
+		close := parseFloat(d.Close)
+		if close <= 0 {
+			continue
+		}
+
+		if close >= peak {
+			if current != nil {
+				current.Recovered = true
+				current.RecoveryDate = d.Date
+				current.DaysToRecover = daysBetween(current.TroughDate, d.Date)
+				current.TotalDays = daysBetween(current.PeakDate, d.Date)
+				closeEpisode()
+			}
+			peak = close
+			peakDate = d.Date
+			continue
+		}
+
+		depth := (close - peak) / peak * 100
+		if current == nil {
+			current = &DrawdownEpisode{PeakDate: peakDate, PeakClose: peak}
+		}
+		if current.TroughDate == "" || close < current.TroughClose {
+			current.TroughDate = d.Date
+			current.TroughClose = close
+			current.DepthPct = roundPct(depth)
+			current.DaysToTrough = daysBetween(current.PeakDate, d.Date)
+		}
+		maxDepth = math.Min(maxDepth, depth)
+	}
+	closeEpisode()
+
+	report.MaxDrawdownPct = roundPct(maxDepth)
+	latest := parseFloat(data[len(data)-1].Close)
+	report.HighClose = peak
+	report.HighDate = peakDate
+	report.LatestClose = latest
+	if peak > 0 {
+		report.CurrentDrawdownPct = roundPct((latest - peak) / peak * 100)
+	}
+
+	// Newest first, consistent with the rest of the API
+	for i := len(episodes) - 1; i >= 0; i-- {
+		report.Episodes = append(report.Episodes, episodes[i])
+	}
+	return report
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// closes builds an oldest-first daily series from closing prices
+func closes(start string, values ...float64) []StockData {
+	t, _ := time.Parse("2006-01-02", start)
+	data := make([]StockData, len(values))
+	for i, v := range values {
+		data[i] = StockData{Date: t.AddDate(0, 0, i).Format("2006-01-02"), Close: formatFloat(v)}
+	}
+	return data
+}
+
+func TestAnalyzeDrawdowns(t *testing.T) {
+	// Peak 100 → trough 80 (-20%) → recovery at 101, then peak 110 → 104 (-5.45%),
+	// then 110 → 88 (-20%) still open at the end
+	data := closes("2024-01-01", 100, 90, 80, 95, 101, 110, 104, 110, 99, 88, 92)
+
+	report := AnalyzeDrawdowns(data, 10)
+
+	if len(report.Episodes) != 2 {
+		t.Fatalf("Expected 2 episodes above 10%%, got %d: %+v", len(report.Episodes), report.Episodes)
+	}
+
+	// Newest first: the open episode
+	open := report.Episodes[0]
+	if open.Recovered || open.PeakDate != "2024-01-08" || open.TroughDate != "2024-01-10" || open.DepthPct != -20 {
+		t.Errorf("Unexpected open episode: %+v", open)
+	}
+
+	closed := report.Episodes[1]
+	if !closed.Recovered || closed.PeakDate != "2024-01-01" || closed.TroughDate != "2024-01-03" {
+		t.Errorf("Unexpected closed episode: %+v", closed)
+	}
+	if closed.RecoveryDate != "2024-01-05" || closed.DaysToRecover != 2 || closed.TotalDays != 4 || closed.DaysToTrough != 2 {
+		t.Errorf("Unexpected recovery stats: %+v", closed)
+	}
+
+	if report.MaxDrawdownPct != -20 {
+		t.Errorf("MaxDrawdownPct = %v, want -20", report.MaxDrawdownPct)
+	}
+	if report.HighClose != 110 || report.HighDate != "2024-01-08" {
+		t.Errorf("High = %v on %s", report.HighClose, report.HighDate)
+	}
+	if report.CurrentDrawdownPct != -16.36 {
+		t.Errorf("CurrentDrawdownPct = %v, want -16.36", report.CurrentDrawdownPct)
+	}
+}
+
+func TestAnalyzeDrawdowns_Threshold(t *testing.T) {
+	data := closes("2024-01-01", 100, 96, 101, 90, 102)
+
+	if got := AnalyzeDrawdowns(data, 5).Episodes; len(got) != 1 {
+		t.Errorf("Expected 1 episode at 5%%, got %+v", got)
+	}
+	if got := AnalyzeDrawdowns(data, 0).Episodes; len(got) != 2 {
+		t.Errorf("Expected 2 episodes at 0%%, got %+v", got)
+	}
+}
+
+func TestAnalyzeDrawdowns_Empty(t *testing.T) {
+	report := AnalyzeDrawdowns(nil, 10)
+	if report.Episodes == nil || len(report.Episodes) != 0 {
+		t.Errorf("Expected empty episode list, got %+v", report.Episodes)
+	}
+}
+
+func TestDrawdownsEndpoint(t *testing.T) {
+	// Newest-first, as providers return it
+	data := reverseData(closes(time.Now().AddDate(0, 0, -4).Format("2006-01-02"), 100, 70, 80, 105, 95))
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}},
+		&fakeProvider{name: "fake", data: data})
+
+	server := NewServer("0", nil)
+	req := httptest.NewRequest("GET", "/api/stock/TEST/drawdowns?days=30&threshold=20", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Data DrawdownReport `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if resp.Data.Symbol != "TEST" || len(resp.Data.Episodes) != 1 || resp.Data.Episodes[0].DepthPct != -30 {
+		t.Errorf("Unexpected report: %+v", resp.Data)
+	}
+}
+
+func TestDrawdownsEndpointInvalidThreshold(t *testing.T) {
+	server := NewServer("0", nil)
+	req := httptest.NewRequest("GET", "/api/stock/AAPL/drawdowns?threshold=abc", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/xuri/excelize/v2"
 )
@@ -14,143 +15,460 @@ type ExcelParams struct {
 	Period      string
 	TTMEPS      float64
 	IncludePE   bool
-	Data        []StockData
-	PeriodData  []PeriodData
+	Adjusted    bool
+	Events      []CorporateEvent
+	Data        []StockData       // Daily data, newest-first
+	PeriodData  []PeriodData      // Chosen aggregation, nil for daily
+	Indicators  []IndicatorSeries // Row-aligned with PeriodData, or Data for daily
 }
 
-// GenerateExcel creates an Excel file from stock data
-func GenerateExcel(params ExcelParams) (*excelize.File, error) {
-	f := excelize.NewFile()
+// excelStyles holds the styles shared by workbook sheets
+type excelStyles struct {
+	header  int
+	bold    int
+	number  int // #,##0.00
+	percent int // 0.00%, for changes stored as fractions
+	volume  int // #,##0
 
-	sheetName := "Stock Data"
-	_ = f.SetSheetName("Sheet1", sheetName)
+	// Conditional formats
+	drop     int // Day down 2% or more
+	bigDrop  int // Day down 5% or more
+	negative int // Red font for losses
+}
 
-	// Style for header
-	headerStyle, _ := f.NewStyle(&excelize.Style{
+// newExcelStyles registers the shared styles in f
+func newExcelStyles(f *excelize.File) excelStyles {
+	var st excelStyles
+	st.header, _ = f.NewStyle(&excelize.Style{
 		Font:      &excelize.Font{Bold: true, Color: "FFFFFF"},
 		Fill:      excelize.Fill{Type: "pattern", Color: []string{"4472C4"}, Pattern: 1},
 		Alignment: &excelize.Alignment{Horizontal: "center"},
 	})
-
-	// Style for numbers
-	numberStyle, _ := f.NewStyle(&excelize.Style{
-		NumFmt: 4, // #,##0.00
+	st.bold, _ = f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	st.number, _ = f.NewStyle(&excelize.Style{NumFmt: 4})
+	st.percent, _ = f.NewStyle(&excelize.Style{NumFmt: 10})
+	st.volume, _ = f.NewStyle(&excelize.Style{NumFmt: 3})
+	st.drop, _ = f.NewConditionalStyle(&excelize.Style{
+		Font: &excelize.Font{Color: "9C0006"},
+		Fill: excelize.Fill{Type: "pattern", Color: []string{"FFC7CE"}, Pattern: 1},
+	})
+	st.bigDrop, _ = f.NewConditionalStyle(&excelize.Style{
+		Font: &excelize.Font{Bold: true, Color: "FFFFFF"},
+		Fill: excelize.Fill{Type: "pattern", Color: []string{"C00000"}, Pattern: 1},
+	})
+	st.negative, _ = f.NewConditionalStyle(&excelize.Style{
+		Font: &excelize.Font{Color: "C00000"},
 	})
+	return st
+}
 
-	// Add metadata
-	setCell(f, sheetName, 1, 1, "Symbol:")
-	setCell(f, sheetName, 2, 1, params.Symbol)
-	setCell(f, sheetName, 1, 2, "Company:")
-	setCell(f, sheetName, 2, 2, params.CompanyName)
-	setCell(f, sheetName, 1, 3, "Period:")
-	setCell(f, sheetName, 2, 3, params.Period)
-	if params.IncludePE {
-		setCell(f, sheetName, 1, 4, "TTM EPS:")
-		setCell(f, sheetName, 2, 4, params.TTMEPS)
-	}
+// GenerateExcel creates a workbook with Summary, Daily, the chosen period
+// (e.g. Monthly), Drops and, when P/E is available, EPS & PE sheets. Adjusted
+// exports add an Events sheet. The chosen period's sheet is active.
+func GenerateExcel(params ExcelParams) (*excelize.File, error) {
+	f := excelize.NewFile()
+	st := newExcelStyles(f)
 
-	row := 6
+	_ = f.SetSheetName("Sheet1", "Summary")
+	writeSummary(f, "Summary", params, st)
+
+	dailyCols := len(dailyHeaders(params.IncludePE))
+	active, _ := f.NewSheet("Daily")
+	lastRow := writeDailyData(f, "Daily", 1, params.Data, params.IncludePE, st) - 1
+	if params.PeriodData == nil && len(params.Indicators) > 0 {
+		writeIndicatorColumns(f, "Daily", 1, dailyCols+1, params.Indicators, st.header)
+		dailyCols += len(params.Indicators)
+	}
+	addPriceChart(f, "Daily", 1, lastRow, 1, 5, dailyCols+2, params.Symbol+" daily close")
 
 	if params.PeriodData != nil {
-		row = writePeriodData(f, sheetName, row, params.PeriodData, params.IncludePE, headerStyle)
-	} else {
-		row = writeDailyData(f, sheetName, row, params.Data, params.IncludePE, headerStyle, numberStyle)
+		sheet := periodSheetName(params.Period)
+		active, _ = f.NewSheet(sheet)
+		numCols := len(periodHeaders(params.IncludePE))
+		lastRow := writePeriodData(f, sheet, 1, params.PeriodData, params.IncludePE, st) - 1
+		if len(params.Indicators) > 0 {
+			writeIndicatorColumns(f, sheet, 1, numCols+1, params.Indicators, st.header)
+			numCols += len(params.Indicators)
+		}
+		addPriceChart(f, sheet, 1, lastRow, 1, 7, numCols+2, fmt.Sprintf("%s %s close", params.Symbol, params.Period))
 	}
 
-	// Auto-fit columns
-	_ = row // silence unused warning
-	for col := 1; col <= 16; col++ {
-		colName, _ := excelize.ColumnNumberToName(col)
-		_ = f.SetColWidth(sheetName, colName, colName, 12)
+	writeDropSummary(f, params.Data, st)
+	if params.IncludePE {
+		writeValuation(f, params.Data, st)
+	}
+	if params.Adjusted && len(params.Events) > 0 {
+		writeEvents(f, params.Events, st.header)
 	}
 
+	f.SetActiveSheet(active)
 	return f, nil
 }
 
-// writeDailyData writes daily stock data to Excel
-func writeDailyData(f *excelize.File, sheet string, startRow int, data []StockData, includePE bool, headerStyle, numberStyle int) int {
-	headers := []string{"Date", "Open", "High", "Low", "Close", "Volume", "Change", "HChange"}
-	if includePE {
-		headers = append(headers, "PE")
+// periodSheetName names a period sheet, e.g. "monthly" → "Monthly"
+func periodSheetName(period string) string {
+	if period == "" {
+		return "Period"
 	}
+	return strings.ToUpper(period[:1]) + period[1:]
+}
 
-	// Write headers
-	for col, h := range headers {
-		setCellWithStyle(f, sheet, col+1, startRow, h, headerStyle)
+// writeSummary writes the export metadata
+func writeSummary(f *excelize.File, sheet string, params ExcelParams, st excelStyles) {
+	rows := [][2]interface{}{
+		{"Symbol:", params.Symbol},
+		{"Company:", params.CompanyName},
+		{"Period:", params.Period},
+	}
+	if n := len(params.Data); n > 0 {
+		rows = append(rows,
+			[2]interface{}{"From:", params.Data[n-1].Date},
+			[2]interface{}{"To:", params.Data[0].Date},
+			[2]interface{}{"Trading Days:", n},
+			[2]interface{}{"Latest Close:", parseFloatStr(params.Data[0].Close)},
+		)
+	}
+	if params.IncludePE {
+		rows = append(rows, [2]interface{}{"TTM EPS:", params.TTMEPS})
 	}
-	startRow++
+	if params.Adjusted {
+		rows = append(rows, [2]interface{}{"Adjusted:", "split/dividend back-adjusted"})
+	}
+	for i, r := range rows {
+		setCellWithStyle(f, sheet, 1, i+1, r[0], st.bold)
+		setCell(f, sheet, 2, i+1, r[1])
+	}
+	_ = f.SetColWidth(sheet, "A", "A", 16)
+	_ = f.SetColWidth(sheet, "B", "B", 30)
+}
 
-	// Write data rows
-	for _, d := range data {
-		setCell(f, sheet, 1, startRow, d.Date)
-		setCellNum(f, sheet, 2, startRow, d.Open, numberStyle)
-		setCellNum(f, sheet, 3, startRow, d.High, numberStyle)
-		setCellNum(f, sheet, 4, startRow, d.Low, numberStyle)
-		setCellNum(f, sheet, 5, startRow, d.Close, numberStyle)
-		setCell(f, sheet, 6, startRow, d.Volume)
-		setCell(f, sheet, 7, startRow, d.Change)
-		setCell(f, sheet, 8, startRow, d.HChange)
-		if includePE {
-			setCell(f, sheet, 9, startRow, d.PE)
+// writeEvents writes dividends and splits to a separate "Events" sheet
+func writeEvents(f *excelize.File, events []CorporateEvent, headerStyle int) {
+	sheet := "Events"
+	_, _ = f.NewSheet(sheet)
+
+	for col, h := range []string{"Date", "Type", "Dividend", "Split Ratio"} {
+		setCellWithStyle(f, sheet, col+1, 1, h, headerStyle)
+	}
+
+	row := 2
+	for _, e := range events {
+		setCell(f, sheet, 1, row, e.Date)
+		setCell(f, sheet, 2, row, e.Type)
+		if e.Type == EventDividend {
+			setCell(f, sheet, 3, row, e.Amount)
+		} else {
+			setCell(f, sheet, 4, row, e.Ratio)
 		}
-		startRow++
+		row++
+	}
+	_ = f.SetColWidth(sheet, "A", "D", 12)
+	freezeHeader(f, sheet, 1)
+}
+
+// dailyHeaders returns the column headers for daily data
+func dailyHeaders(includePE bool) []string {
+	headers := []string{"Date", "Open", "High", "Low", "Close", "Volume", "Change", "HChange"}
+	if includePE {
+		headers = append(headers, "PE")
 	}
-	return startRow
+	return headers
 }
 
-// writePeriodData writes period aggregated data to Excel
-func writePeriodData(f *excelize.File, sheet string, startRow int, data []PeriodData, includePE bool, headerStyle int) int {
+// periodHeaders returns the column headers for period aggregated data; drop
+// counts are split into close-based (C) and low-based (L) columns
+func periodHeaders(includePE bool) []string {
 	headers := []string{"Period", "Start", "End", "Open", "High", "Low", "Close", "Volume", "Change", "HChange"}
 	if includePE {
 		headers = append(headers, "PE")
 	}
-	headers = append(headers, "Days", "C/L-2%", "C/L-3%", "C/L-4%", "C/L-5%")
+	return append(headers, "Days", "C-2%", "L-2%", "C-3%", "L-3%", "C-4%", "L-4%", "C-5%", "L-5%")
+}
 
-	// Write headers
+// writeHeaders writes a styled header row
+func writeHeaders(f *excelize.File, sheet string, row int, headers []string, headerStyle int) {
 	for col, h := range headers {
-		setCellWithStyle(f, sheet, col+1, startRow, h, headerStyle)
+		setCellWithStyle(f, sheet, col+1, row, h, headerStyle)
 	}
-	startRow++
+}
+
+// writeDailyData writes daily stock data with its header at startRow, with
+// typed cells, a frozen header and highlighted drop days. Returns the row
+// after the last one written.
+func writeDailyData(f *excelize.File, sheet string, startRow int, data []StockData, includePE bool, st excelStyles) int {
+	headers := dailyHeaders(includePE)
+	writeHeaders(f, sheet, startRow, headers, st.header)
+
+	row := startRow + 1
+	for _, d := range data {
+		setCell(f, sheet, 1, row, d.Date)
+		setCellNum(f, sheet, 2, row, d.Open, st.number)
+		setCellNum(f, sheet, 3, row, d.High, st.number)
+		setCellNum(f, sheet, 4, row, d.Low, st.number)
+		setCellNum(f, sheet, 5, row, d.Close, st.number)
+		setCellVolume(f, sheet, 6, row, d.Volume, st.volume)
+		setCellPct(f, sheet, 7, row, d.Change, st.percent)
+		setCellPct(f, sheet, 8, row, d.HChange, st.percent)
+		if includePE {
+			setCellNum(f, sheet, 9, row, d.PE, st.number)
+		}
+		row++
+	}
+
+	if row > startRow+1 {
+		// Close-to-close drops in the Change column, as counted by the drop buckets
+		changes := fmt.Sprintf("G%d:G%d", startRow+1, row-1)
+		bigDrop, drop := st.bigDrop, st.drop
+		_ = f.SetConditionalFormat(sheet, changes, []excelize.ConditionalFormatOptions{
+			{Type: "cell", Criteria: "<=", Value: "-0.05", Format: &bigDrop, StopIfTrue: true},
+			{Type: "cell", Criteria: "<=", Value: "-0.02", Format: &drop},
+		})
+	}
+	_ = f.SetColWidth(sheet, "A", "A", 12)
+	_ = f.SetColWidth(sheet, "B", "I", 12)
+	freezeHeader(f, sheet, startRow)
+	return row
+}
+
+// writePeriodData writes period aggregated data with its header at startRow,
+// with typed cells, a frozen header and color-scaled drop counts. Returns the
+// row after the last one written.
+func writePeriodData(f *excelize.File, sheet string, startRow int, data []PeriodData, includePE bool, st excelStyles) int {
+	headers := periodHeaders(includePE)
+	writeHeaders(f, sheet, startRow, headers, st.header)
 
-	// Write data rows
+	row := startRow + 1
 	for _, p := range data {
-		col := 1
-		setCell(f, sheet, col, startRow, p.Period)
-		col++
-		setCell(f, sheet, col, startRow, p.StartDate)
-		col++
-		setCell(f, sheet, col, startRow, p.EndDate)
-		col++
-		setCell(f, sheet, col, startRow, parseFloatStr(p.Open))
-		col++
-		setCell(f, sheet, col, startRow, parseFloatStr(p.High))
-		col++
-		setCell(f, sheet, col, startRow, parseFloatStr(p.Low))
-		col++
-		setCell(f, sheet, col, startRow, parseFloatStr(p.Close))
-		col++
-		setCell(f, sheet, col, startRow, p.Volume)
-		col++
-		setCell(f, sheet, col, startRow, p.Change)
-		col++
-		setCell(f, sheet, col, startRow, p.HChange)
-		col++
+		setCell(f, sheet, 1, row, p.Period)
+		setCell(f, sheet, 2, row, p.StartDate)
+		setCell(f, sheet, 3, row, p.EndDate)
+		setCellNum(f, sheet, 4, row, p.Open, st.number)
+		setCellNum(f, sheet, 5, row, p.High, st.number)
+		setCellNum(f, sheet, 6, row, p.Low, st.number)
+		setCellNum(f, sheet, 7, row, p.Close, st.number)
+		setCellVolume(f, sheet, 8, row, p.Volume, st.volume)
+		setCellPct(f, sheet, 9, row, p.Change, st.percent)
+		setCellPct(f, sheet, 10, row, p.HChange, st.percent)
+		col := 11
 		if includePE {
-			setCell(f, sheet, col, startRow, p.PE)
+			setCellNum(f, sheet, col, row, p.PE, st.number)
 			col++
 		}
-		setCell(f, sheet, col, startRow, p.Days)
-		col++
-		setCell(f, sheet, col, startRow, fmt.Sprintf("%d/%d", p.Drop2Pct.Close, p.Drop2Pct.Low))
-		col++
-		setCell(f, sheet, col, startRow, fmt.Sprintf("%d/%d", p.Drop3Pct.Close, p.Drop3Pct.Low))
-		col++
-		setCell(f, sheet, col, startRow, fmt.Sprintf("%d/%d", p.Drop4Pct.Close, p.Drop4Pct.Low))
-		col++
-		setCell(f, sheet, col, startRow, fmt.Sprintf("%d/%d", p.Drop5Pct.Close, p.Drop5Pct.Low))
-		startRow++
+		for i, v := range []int{p.Days,
+			p.Drop2Pct.Close, p.Drop2Pct.Low, p.Drop3Pct.Close, p.Drop3Pct.Low,
+			p.Drop4Pct.Close, p.Drop4Pct.Low, p.Drop5Pct.Close, p.Drop5Pct.Low} {
+			setCell(f, sheet, col+i, row, v)
+		}
+		row++
+	}
+
+	if row > startRow+1 {
+		negative := st.negative
+		_ = f.SetConditionalFormat(sheet, fmt.Sprintf("I%d:J%d", startRow+1, row-1), []excelize.ConditionalFormatOptions{
+			{Type: "cell", Criteria: "<", Value: "0", Format: &negative},
+		})
+		firstDrop := len(headers) - 7
+		colorScaleColumns(f, sheet, firstDrop, len(headers), startRow+1, row-1)
+	}
+	_ = f.SetColWidth(sheet, "A", "C", 12)
+	end, _ := excelize.ColumnNumberToName(len(headers))
+	_ = f.SetColWidth(sheet, "D", end, 10)
+	freezeHeader(f, sheet, startRow)
+	return row
+}
+
+// writeIndicatorColumns writes indicator series as extra columns starting at
+// startCol; values are row-aligned with the price data below headerRow
+func writeIndicatorColumns(f *excelize.File, sheet string, headerRow, startCol int, series []IndicatorSeries, headerStyle int) {
+	for i, s := range series {
+		col := startCol + i
+		setCellWithStyle(f, sheet, col, headerRow, s.Label, headerStyle)
+		for j, v := range s.Values {
+			if v.Value != nil {
+				setCell(f, sheet, col, headerRow+1+j, *v.Value)
+			}
+		}
+	}
+}
+
+// dropBuckets labels the drop summary buckets, matching classifyDropPct
+var dropBuckets = []string{"2-3%", "3-4%", "4-5%", "5%+"}
+
+// writeDropSummary writes a "Drops" sheet counting drop days per year and
+// bucket, with a column chart of close-based drops. Counts come from the
+// yearly aggregation, so they match the Yearly period view.
+func writeDropSummary(f *excelize.File, data []StockData, st excelStyles) {
+	sheet := "Drops"
+	_, _ = f.NewSheet(sheet)
+
+	headers := []string{"Year", "Days"}
+	for _, b := range dropBuckets {
+		headers = append(headers, b+" Close", b+" Low")
+	}
+	writeHeaders(f, sheet, 1, headers, st.header)
+
+	years := AggregateToPeriods(reverseData(data), PeriodYearly)
+	totals := make([]int, len(headers)-1)
+	row := 2
+	for _, y := range years {
+		counts := []int{y.Days,
+			y.Drop2Pct.Close, y.Drop2Pct.Low, y.Drop3Pct.Close, y.Drop3Pct.Low,
+			y.Drop4Pct.Close, y.Drop4Pct.Low, y.Drop5Pct.Close, y.Drop5Pct.Low}
+		setCell(f, sheet, 1, row, y.Period)
+		for i, v := range counts {
+			setCell(f, sheet, i+2, row, v)
+			totals[i] += v
+		}
+		row++
+	}
+	lastYear := row - 1
+
+	setCellWithStyle(f, sheet, 1, row, "Total", st.bold)
+	for i, v := range totals {
+		setCellWithStyle(f, sheet, i+2, row, v, st.bold)
+	}
+	setCell(f, sheet, 1, row+2, "Close: close vs previous close. Low: intraday low vs previous close. The first trading day of each year is not counted.")
+
+	if lastYear >= 2 {
+		colorScaleColumns(f, sheet, 3, len(headers), 2, lastYear)
+
+		chart := &excelize.Chart{
+			Type:      excelize.Col,
+			Title:     []excelize.RichTextRun{{Text: "Close-based drop days per year"}},
+			XAxis:     excelize.ChartAxis{ReverseOrder: true},
+			YAxis:     excelize.ChartAxis{MajorGridLines: true},
+			Legend:    excelize.ChartLegend{Position: "bottom"},
+			Dimension: excelize.ChartDimension{Width: 640, Height: 320},
+		}
+		for i := range dropBuckets {
+			col := 3 + 2*i
+			chart.Series = append(chart.Series, excelize.ChartSeries{
+				Name:       cellRef(sheet, col, 1),
+				Categories: columnRef(sheet, 1, 2, lastYear),
+				Values:     columnRef(sheet, col, 2, lastYear),
+			})
+		}
+		anchor, _ := excelize.CoordinatesToCellName(len(headers)+2, 2)
+		_ = f.AddChart(sheet, anchor, chart)
+	}
+	_ = f.SetColWidth(sheet, "A", "A", 10)
+	_ = f.SetColWidth(sheet, "B", "J", 11)
+	freezeHeader(f, sheet, 1)
+}
+
+// writeValuation writes an "EPS & PE" sheet with month-end close, P/E and the
+// TTM EPS implied by them, with a P/E line chart and an EPS column chart
+func writeValuation(f *excelize.File, data []StockData, st excelStyles) {
+	sheet := "EPS & PE"
+	_, _ = f.NewSheet(sheet)
+	writeHeaders(f, sheet, 1, []string{"Month", "Close", "PE", "EPS (TTM)"}, st.header)
+
+	row := 2
+	for _, m := range AggregateToPeriods(reverseData(data), PeriodMonthly) {
+		setCell(f, sheet, 1, row, m.Period)
+		setCellNum(f, sheet, 2, row, m.Close, st.number)
+		setCellNum(f, sheet, 3, row, m.PE, st.number)
+		if pe := parseFloatStr(m.PE); pe > 0 {
+			setCellWithStyle(f, sheet, 4, row, round2(parseFloatStr(m.Close)/pe), st.number)
+		}
+		row++
+	}
+	last := row - 1
+	_ = f.SetColWidth(sheet, "A", "D", 12)
+	freezeHeader(f, sheet, 1)
+	if last < 2 {
+		return
+	}
+
+	for i, c := range []struct {
+		typ   excelize.ChartType
+		col   int
+		title string
+	}{
+		{excelize.Line, 3, "P/E ratio"},
+		{excelize.Col, 4, "TTM EPS"},
+	} {
+		series := excelize.ChartSeries{
+			Name:       cellRef(sheet, c.col, 1),
+			Categories: columnRef(sheet, 1, 2, last),
+			Values:     columnRef(sheet, c.col, 2, last),
+		}
+		if c.typ == excelize.Line {
+			series.Marker = excelize.ChartMarker{Symbol: "none"}
+		}
+		anchor, _ := excelize.CoordinatesToCellName(6, 2+i*18)
+		_ = f.AddChart(sheet, anchor, &excelize.Chart{
+			Type:      c.typ,
+			Series:    []excelize.ChartSeries{series},
+			Title:     []excelize.RichTextRun{{Text: c.title}},
+			XAxis:     excelize.ChartAxis{ReverseOrder: true},
+			YAxis:     excelize.ChartAxis{MajorGridLines: true},
+			Legend:    excelize.ChartLegend{Position: "none"},
+			Dimension: excelize.ChartDimension{Width: 640, Height: 320},
+		})
 	}
-	return startRow
+}
+
+// addPriceChart adds a line chart of the close column (rows below headerRow
+// through lastRow, newest first) at anchorCol. The category axis is reversed
+// so time runs left to right.
+func addPriceChart(f *excelize.File, sheet string, headerRow, lastRow, labelCol, closeCol, anchorCol int, title string) {
+	if lastRow <= headerRow {
+		return
+	}
+	anchor, _ := excelize.CoordinatesToCellName(anchorCol, headerRow+1)
+	_ = f.AddChart(sheet, anchor, &excelize.Chart{
+		Type: excelize.Line,
+		Series: []excelize.ChartSeries{{
+			Name:       cellRef(sheet, closeCol, headerRow),
+			Categories: columnRef(sheet, labelCol, headerRow+1, lastRow),
+			Values:     columnRef(sheet, closeCol, headerRow+1, lastRow),
+			Marker:     excelize.ChartMarker{Symbol: "none"},
+		}},
+		Title:     []excelize.RichTextRun{{Text: title}},
+		XAxis:     excelize.ChartAxis{ReverseOrder: true},
+		YAxis:     excelize.ChartAxis{MajorGridLines: true},
+		Legend:    excelize.ChartLegend{Position: "none"},
+		Dimension: excelize.ChartDimension{Width: 720, Height: 360},
+	})
+}
+
+// colorScaleColumns shades each column from firstCol to lastCol from white
+// (fewest) to red (most), scaled per column
+func colorScaleColumns(f *excelize.File, sheet string, firstCol, lastCol, firstRow, lastRow int) {
+	for col := firstCol; col <= lastCol; col++ {
+		name, _ := excelize.ColumnNumberToName(col)
+		_ = f.SetConditionalFormat(sheet, fmt.Sprintf("%s%d:%s%d", name, firstRow, name, lastRow), []excelize.ConditionalFormatOptions{{
+			Type: "2_color_scale", Criteria: "=",
+			MinType: "min", MaxType: "max",
+			MinColor: "#FFFFFF", MaxColor: "#F8696B",
+		}})
+	}
+}
+
+// freezeHeader keeps rows up to headerRow and the first column in view
+func freezeHeader(f *excelize.File, sheet string, headerRow int) {
+	topLeft, _ := excelize.CoordinatesToCellName(2, headerRow+1)
+	_ = f.SetPanes(sheet, &excelize.Panes{
+		Freeze:      true,
+		XSplit:      1,
+		YSplit:      headerRow,
+		TopLeftCell: topLeft,
+		ActivePane:  "bottomRight",
+		Selection:   []excelize.Selection{{SQRef: topLeft, ActiveCell: topLeft, Pane: "bottomRight"}},
+	})
+}
+
+// cellRef returns an absolute reference to one cell, for chart series names
+func cellRef(sheet string, col, row int) string {
+	name, _ := excelize.ColumnNumberToName(col)
+	return fmt.Sprintf("'%s'!$%s$%d", sheet, name, row)
+}
+
+// columnRef returns an absolute reference to rows first..last of a column
+func columnRef(sheet string, col, first, last int) string {
+	name, _ := excelize.ColumnNumberToName(col)
+	return fmt.Sprintf("'%s'!$%s$%d:$%s$%d", sheet, name, first, name, last)
 }
 
 // Helper functions
@@ -165,14 +483,286 @@ func setCellWithStyle(f *excelize.File, sheet string, col, row int, value interf
 	_ = f.SetCellStyle(sheet, cell, cell, style)
 }
 
+// setCellNum writes a numeric string as a number; empty or invalid values
+// leave the cell blank
 func setCellNum(f *excelize.File, sheet string, col, row int, value string, style int) {
-	cell, _ := excelize.CoordinatesToCellName(col, row)
-	v, _ := strconv.ParseFloat(value, 64)
-	_ = f.SetCellValue(sheet, cell, v)
-	_ = f.SetCellStyle(sheet, cell, cell, style)
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return
+	}
+	setCellWithStyle(f, sheet, col, row, v, style)
+}
+
+// setCellPct writes a change like "-1.23%" as the fraction -0.0123
+func setCellPct(f *excelize.File, sheet string, col, row int, value string, style int) {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil {
+		return
+	}
+	setCellWithStyle(f, sheet, col, row, v/100, style)
+}
+
+// setCellVolume writes a volume like "45.20M" as a share count
+func setCellVolume(f *excelize.File, sheet string, col, row int, value string, style int) {
+	if value == "" {
+		return
+	}
+	setCellWithStyle(f, sheet, col, row, parseVolume(value), style)
 }
 
 func parseFloatStr(s string) float64 {
 	v, _ := strconv.ParseFloat(s, 64)
 	return v
 }
+
+// GroupExcelParams contains parameters for an index or watchlist workbook
+type GroupExcelParams struct {
+	Name    string // Index or watchlist name
+	Period  string
+	Symbols []string
+	Results []*FetchResult // Indexed like Symbols, newest-first data
+	Errors  []error        // Indexed like Symbols
+}
+
+// groupSummaryHeaders are the Summary sheet columns of a group workbook
+var groupSummaryHeaders = []string{"Symbol", "Company", "From", "To", "Close", "Day Change", "Change", "PE", "C-5%", "L-5%", "Error"}
+
+// GenerateGroupExcel creates a workbook with a Summary sheet and one sheet
+// per constituent holding its daily or period data. Company names come from
+// CompanyNames, falling back to the provider's; symbols that failed are
+// listed in the summary without a sheet.
+func GenerateGroupExcel(params GroupExcelParams) (*excelize.File, error) {
+	f := excelize.NewFile()
+	st := newExcelStyles(f)
+
+	summary := "Summary"
+	_ = f.SetSheetName("Sheet1", summary)
+	setCellWithStyle(f, summary, 1, 1, params.Name, st.bold)
+	setCell(f, summary, 1, 2, fmt.Sprintf("%d symbols, %s", len(params.Symbols), params.Period))
+
+	headerRow := 4
+	writeHeaders(f, summary, headerRow, groupSummaryHeaders, st.header)
+	names := GetCompanyNamesForSymbols(params.Symbols)
+	sheets := map[string]bool{strings.ToLower(summary): true}
+	row := headerRow + 1
+	for i, sym := range params.Symbols {
+		symbol := strings.ToUpper(sym)
+		result := params.Results[i]
+		company := names[sym]
+		if company == "" && result != nil {
+			company = formatCompanyName(result.CompanyName)
+		}
+		setCell(f, summary, 1, row, symbol)
+		setCell(f, summary, 2, row, company)
+
+		switch {
+		case params.Errors[i] != nil:
+			setCell(f, summary, len(groupSummaryHeaders), row, params.Errors[i].Error())
+		case result == nil || len(result.Data) == 0:
+			setCell(f, summary, len(groupSummaryHeaders), row, "no data found")
+		default:
+			writeGroupSummaryRow(f, summary, row, result.Data, st)
+			sheet := groupSheetName(symbol, sheets)
+			if _, err := f.NewSheet(sheet); err != nil {
+				return nil, err
+			}
+			writeConstituent(f, sheet, symbol, company, result, params.Period, st)
+			link, _ := excelize.CoordinatesToCellName(1, row)
+			_ = f.SetCellHyperLink(summary, link, fmt.Sprintf("'%s'!A1", sheet), "Location")
+		}
+		row++
+	}
+
+	if row > headerRow+1 {
+		negative := st.negative
+		_ = f.SetConditionalFormat(summary, fmt.Sprintf("F%d:G%d", headerRow+1, row-1), []excelize.ConditionalFormatOptions{
+			{Type: "cell", Criteria: "<", Value: "0", Format: &negative},
+		})
+		colorScaleColumns(f, summary, 9, 10, headerRow+1, row-1)
+	}
+	_ = f.SetColWidth(summary, "A", "A", 10)
+	_ = f.SetColWidth(summary, "B", "B", 28)
+	_ = f.SetColWidth(summary, "C", "J", 11)
+	_ = f.SetColWidth(summary, "K", "K", 40)
+	freezeHeader(f, summary, headerRow)
+	return f, nil
+}
+
+// groupSheetName makes a symbol a valid sheet name not already in used.
+// Excel compares sheet names case-insensitively, so symbols that clean to
+// the same name (BRK/B and BRK:B) get a ~2, ~3... suffix within the
+// 31-character limit. The name is added to used.
+func groupSheetName(symbol string, used map[string]bool) string {
+	base := []rune(strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`:\/?*[]'`, r) {
+			return '_'
+		}
+		return r
+	}, symbol))
+	name := string(base[:min(len(base), 31)])
+	for n := 2; used[strings.ToLower(name)]; n++ {
+		suffix := fmt.Sprintf("~%d", n)
+		name = string(base[:min(len(base), 31-len(suffix))]) + suffix
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}
+
+// writeGroupSummaryRow writes one constituent's range, latest close, day
+// and whole-range change, P/E and 5%+ drop days over the range
+func writeGroupSummaryRow(f *excelize.File, sheet string, row int, data []StockData, st excelStyles) {
+	latest, oldest := data[0], data[len(data)-1]
+	setCell(f, sheet, 3, row, oldest.Date)
+	setCell(f, sheet, 4, row, latest.Date)
+	setCellNum(f, sheet, 5, row, latest.Close, st.number)
+	setCellPct(f, sheet, 6, row, latest.Change, st.percent)
+	if first := parseFloat(oldest.Close); first > 0 {
+		setCellWithStyle(f, sheet, 7, row, parseFloat(latest.Close)/first-1, st.percent)
+	}
+	setCellNum(f, sheet, 8, row, latest.PE, st.number)
+
+	var closeDrops, lowDrops int
+	for i := 0; i < len(data)-1; i++ {
+		c, l := calculateDrops(parseFloat(data[i].Close), parseFloat(data[i].Low), parseFloat(data[i+1].Close))
+		if c == 5 {
+			closeDrops++
+		}
+		if l == 5 {
+			lowDrops++
+		}
+	}
+	setCell(f, sheet, 9, row, closeDrops)
+	setCell(f, sheet, 10, row, lowDrops)
+}
+
+// writeConstituent writes one symbol's sheet: a title row, then its daily or
+// period data and a price chart
+func writeConstituent(f *excelize.File, sheet, symbol, company string, result *FetchResult, period string, st excelStyles) {
+	title := symbol
+	if company != "" {
+		title += " - " + company
+	}
+	setCellWithStyle(f, sheet, 1, 1, title, st.bold)
+
+	headerRow := 3
+	var lastRow, numCols, closeCol int
+	if period == "daily" {
+		lastRow = writeDailyData(f, sheet, headerRow, result.Data, result.IncludePE, st) - 1
+		numCols, closeCol = len(dailyHeaders(result.IncludePE)), 5
+	} else {
+		periodType, _ := ParsePeriodType(period)
+		periods := AggregateToPeriods(reverseData(result.Data), periodType)
+		lastRow = writePeriodData(f, sheet, headerRow, periods, result.IncludePE, st) - 1
+		numCols, closeCol = len(periodHeaders(result.IncludePE)), 7
+	}
+	addPriceChart(f, sheet, headerRow, lastRow, 1, closeCol, numCols+2, fmt.Sprintf("%s %s close", symbol, period))
+}
+
+// GenerateBacktestExcel creates an Excel file from a backtest result with
+// Summary, Equity and Trades sheets
+func GenerateBacktestExcel(result BacktestResult) (*excelize.File, error) {
+	f := excelize.NewFile()
+
+	sheet := "Summary"
+	_ = f.SetSheetName("Sheet1", sheet)
+
+	headerStyle, _ := f.NewStyle(&excelize.Style{
+		Font:      &excelize.Font{Bold: true, Color: "FFFFFF"},
+		Fill:      excelize.Fill{Type: "pattern", Color: []string{"4472C4"}, Pattern: 1},
+		Alignment: &excelize.Alignment{Horizontal: "center"},
+	})
+	numberStyle, _ := f.NewStyle(&excelize.Style{
+		NumFmt: 4, // #,##0.00
+	})
+
+	// Parameters and results
+	rows := [][2]interface{}{
+		{"Rebalance:", result.Rebalance},
+		{"Start Date:", result.StartDate},
+		{"End Date:", result.EndDate},
+		{"Start Capital:", result.StartCapital},
+		{"End Equity:", result.EndEquity},
+		{"Total Costs:", result.TotalCosts},
+		{"Rebalances:", result.Rebalances},
+	}
+	if result.Adjusted != nil {
+		var adjusted []string
+		for _, sym := range result.Symbols {
+			if result.Adjusted[sym] {
+				adjusted = append(adjusted, sym)
+			}
+		}
+		note := "none (no adjusted closes)"
+		if len(adjusted) > 0 {
+			note = "split/dividend back-adjusted: " + strings.Join(adjusted, ", ")
+		}
+		rows = append(rows, [2]interface{}{"Adjusted:", note})
+	}
+	if st := result.Stats; st != nil {
+		rows = append(rows,
+			[2]interface{}{"Total Return %:", st.TotalReturnPct},
+			[2]interface{}{"CAGR %:", st.CAGRPct},
+			[2]interface{}{"Volatility %:", st.VolatilityPct},
+			[2]interface{}{"Sharpe:", st.Sharpe},
+			[2]interface{}{"Sortino:", st.Sortino},
+			[2]interface{}{"Max Drawdown %:", st.MaxDrawdownPct},
+			[2]interface{}{"Positive Periods %:", st.PositivePeriodsPct},
+		)
+	}
+	row := 1
+	for _, r := range rows {
+		setCell(f, sheet, 1, row, r[0])
+		setCell(f, sheet, 2, row, r[1])
+		row++
+	}
+
+	// Target weights and final holdings
+	row++
+	for col, h := range []string{"Symbol", "Target %", "Shares", "Price", "Value", "Weight %"} {
+		setCellWithStyle(f, sheet, col+1, row, h, headerStyle)
+	}
+	row++
+	for i, h := range result.Holdings {
+		setCell(f, sheet, 1, row, h.Symbol)
+		setCell(f, sheet, 2, row, roundPct(result.Weights[i]*100))
+		setCell(f, sheet, 3, row, h.Shares)
+		setCellWithStyle(f, sheet, 4, row, h.Price, numberStyle)
+		setCellWithStyle(f, sheet, 5, row, h.Value, numberStyle)
+		setCell(f, sheet, 6, row, h.WeightPct)
+		row++
+	}
+	_ = f.SetColWidth(sheet, "A", "A", 18)
+	_ = f.SetColWidth(sheet, "B", "F", 14)
+
+	// Equity curve
+	sheet = "Equity"
+	_, _ = f.NewSheet(sheet)
+	for col, h := range []string{"Date", "Equity", "Cash"} {
+		setCellWithStyle(f, sheet, col+1, 1, h, headerStyle)
+	}
+	for i, p := range result.Equity {
+		setCell(f, sheet, 1, i+2, p.Date)
+		setCellWithStyle(f, sheet, 2, i+2, p.Equity, numberStyle)
+		setCellWithStyle(f, sheet, 3, i+2, p.Cash, numberStyle)
+	}
+	_ = f.SetColWidth(sheet, "A", "C", 14)
+
+	// Trade list
+	sheet = "Trades"
+	_, _ = f.NewSheet(sheet)
+	for col, h := range []string{"Date", "Symbol", "Side", "Shares", "Price", "Value", "Cost"} {
+		setCellWithStyle(f, sheet, col+1, 1, h, headerStyle)
+	}
+	for i, t := range result.Trades {
+		setCell(f, sheet, 1, i+2, t.Date)
+		setCell(f, sheet, 2, i+2, t.Symbol)
+		setCell(f, sheet, 3, i+2, t.Side)
+		setCell(f, sheet, 4, i+2, t.Shares)
+		setCellWithStyle(f, sheet, 5, i+2, t.Price, numberStyle)
+		setCellWithStyle(f, sheet, 6, i+2, t.Value, numberStyle)
+		setCellWithStyle(f, sheet, 7, i+2, t.Cost, numberStyle)
+	}
+	_ = f.SetColWidth(sheet, "A", "G", 12)
+
+	return f, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// excelSeries is a newest-first daily series over two years with a 6% drop
+// on 2024-01-02 and P/E from an EPS of 5
+func excelSeries() []StockData {
+	data := closes("2023-12-29", 100, 101, 102, 103, 96.82, 97)
+	for i := range data {
+		data[i].Open, data[i].High, data[i].Low = data[i].Close, data[i].Close, data[i].Close
+		data[i].Volume = "1.50M"
+		data[i].PE = formatFloat(parseFloat(data[i].Close) / 5)
+	}
+	computeChanges(data)
+	return reverseData(data)
+}
+
+func TestGenerateExcelWorkbook(t *testing.T) {
+	data := excelSeries()
+	f, err := GenerateExcel(ExcelParams{
+		Symbol:     "TEST",
+		Period:     "monthly",
+		IncludePE:  true,
+		Data:       data,
+		PeriodData: AggregateToPeriods(reverseData(data), PeriodMonthly),
+	})
+	if err != nil {
+		t.Fatalf("GenerateExcel() error = %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	want := []string{"Summary", "Daily", "Monthly", "Drops", "EPS & PE"}
+	if got := f.GetSheetList(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("Sheets = %v, want %v", got, want)
+	}
+	if f.GetSheetName(f.GetActiveSheetIndex()) != "Monthly" {
+		t.Errorf("Active sheet = %q, want Monthly", f.GetSheetName(f.GetActiveSheetIndex()))
+	}
+
+	// Daily row 3 is 2024-01-02 (-6%): typed volume and percent change
+	for cell, want := range map[string]string{"A3": "2024-01-02", "F3": "1500000", "G3": "-0.06"} {
+		if got, _ := f.GetCellValue("Daily", cell, excelize.Options{RawCellValue: true}); got != want {
+			t.Errorf("Daily %s = %q, want %q", cell, got, want)
+		}
+	}
+	if typ, _ := f.GetCellType("Daily", "G3"); typ != excelize.CellTypeNumber && typ != excelize.CellTypeUnset {
+		t.Errorf("Change cell type = %v, want number", typ)
+	}
+	if got, _ := f.GetCellValue("Daily", "G3"); got != "-6.00%" {
+		t.Errorf("Formatted change = %q, want -6.00%%", got)
+	}
+
+	// Monthly drop counts are numbers in split C/L columns
+	headers := periodHeaders(true)
+	if headers[len(headers)-1] != "L-5%" {
+		t.Fatalf("Unexpected period headers %v", headers)
+	}
+	c5, _ := excelize.CoordinatesToCellName(len(headers)-1, 2)
+	if got, _ := f.GetCellValue("Monthly", c5); got != "1" {
+		t.Errorf("January C-5%% = %q, want 1", got)
+	}
+
+	// Yearly drop summary with totals, and implied EPS
+	if got, _ := f.GetCellValue("Drops", "I4"); got != "1" {
+		t.Errorf("Drops total 5%%+ close = %q, want 1", got)
+	}
+	if got, _ := f.GetCellValue("EPS & PE", "D2", excelize.Options{RawCellValue: true}); got != "5" {
+		t.Errorf("EPS = %q, want 5", got)
+	}
+
+	for _, sheet := range []string{"Daily", "Monthly", "Drops", "EPS & PE"} {
+		if panes, _ := f.GetPanes(sheet); !panes.Freeze || panes.YSplit != 1 {
+			t.Errorf("%s: header not frozen: %+v", sheet, panes)
+		}
+	}
+	if formats, _ := f.GetConditionalFormats("Daily"); len(formats["G2:G7"]) != 2 {
+		t.Errorf("Daily drop formats = %+v", formats)
+	}
+	if formats, _ := f.GetConditionalFormats("Drops"); len(formats) == 0 {
+		t.Error("Drops sheet has no color scales")
+	}
+
+	// Daily, Monthly, Drops and two valuation charts
+	charts := 0
+	f.Pkg.Range(func(k, _ any) bool {
+		if strings.HasPrefix(k.(string), "xl/charts/chart") {
+			charts++
+		}
+		return true
+	})
+	if charts != 5 {
+		t.Errorf("Got %d charts, want 5", charts)
+	}
+}
+
+func TestGenerateExcelDailyWithoutPE(t *testing.T) {
+	data := excelSeries()
+	for i := range data {
+		data[i].PE = ""
+	}
+	f, err := GenerateExcel(ExcelParams{Symbol: "TEST", Period: "daily", Data: data})
+	if err != nil {
+		t.Fatalf("GenerateExcel() error = %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	want := []string{"Summary", "Daily", "Drops"}
+	if got := f.GetSheetList(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Sheets = %v, want %v", got, want)
+	}
+	if f.GetSheetName(f.GetActiveSheetIndex()) != "Daily" {
+		t.Errorf("Active sheet = %q, want Daily", f.GetSheetName(f.GetActiveSheetIndex()))
+	}
+	// The oldest day has no change; the cell stays empty rather than 0
+	if got, _ := f.GetCellValue("Daily", "G7"); got != "" {
+		t.Errorf("First change = %q, want empty", got)
+	}
+}
+
+func TestGenerateGroupExcel(t *testing.T) {
+	data := excelSeries()
+	f, err := GenerateGroupExcel(GroupExcelParams{
+		Name:    "Mine",
+		Period:  "monthly",
+		Symbols: []string{"AAPL", "NEW", "BAD"},
+		Results: []*FetchResult{{Data: data, IncludePE: true}, {Data: data, CompanyName: "new-co"}, nil},
+		Errors:  []error{nil, nil, errors.New("upstream failed")},
+	})
+	if err != nil {
+		t.Fatalf("GenerateGroupExcel() error = %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	want := []string{"Summary", "AAPL", "NEW"}
+	if got := f.GetSheetList(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("Sheets = %v, want %v", got, want)
+	}
+
+	rows, _ := f.GetRows("Summary", excelize.Options{RawCellValue: true})
+	if len(rows) != 7 || strings.Join(rows[3], ",") != strings.Join(groupSummaryHeaders, ",") {
+		t.Fatalf("Unexpected summary: %v", rows)
+	}
+	// Names from CompanyNames, then the provider; change 97 vs 100 over the range
+	if r := rows[4]; r[1] != "Apple" || r[3] != "2024-01-03" || r[4] != "97" || !strings.HasPrefix(r[6], "-0.03") || r[8] != "1" {
+		t.Errorf("Unexpected AAPL row: %v", r)
+	}
+	if r := rows[5]; r[1] != "New Co" {
+		t.Errorf("Unexpected NEW row: %v", r)
+	}
+	if r := rows[6]; r[len(r)-1] != "upstream failed" {
+		t.Errorf("Unexpected BAD row: %v", r)
+	}
+	if ok, target, _ := f.GetCellHyperLink("Summary", "A5"); !ok || target != "'AAPL'!A1" {
+		t.Errorf("AAPL link = %v %q", ok, target)
+	}
+
+	// Each constituent sheet reuses the period layout below a title row
+	if got, _ := f.GetCellValue("AAPL", "A1"); got != "AAPL - Apple" {
+		t.Errorf("Title = %q", got)
+	}
+	if got, _ := f.GetCellValue("AAPL", "A3"); got != "Period" {
+		t.Errorf("Header = %q, want Period", got)
+	}
+	if panes, _ := f.GetPanes("AAPL"); panes.YSplit != 3 {
+		t.Errorf("Constituent header not frozen: %+v", panes)
+	}
+}
+
+func TestGroupSheetName(t *testing.T) {
+	used := map[string]bool{"summary": true}
+	long := strings.Repeat("X", 40)
+	tests := []struct{ symbol, want string }{
+		{"BRK/B:X", "BRK_B_X"},
+		{"BRK/B", "BRK_B"},
+		{"BRK:B", "BRK_B~2"},
+		{"brk_b", "brk_b~3"},
+		{"SUMMARY", "SUMMARY~2"},
+		{long, long[:31]},
+		{long, long[:29] + "~2"},
+	}
+	for _, tt := range tests {
+		if got := groupSheetName(tt.symbol, used); got != tt.want {
+			t.Errorf("groupSheetName(%q) = %q, want %q", tt.symbol, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateGroupExcelClashingSheetNames(t *testing.T) {
+	data := excelSeries()
+	f, err := GenerateGroupExcel(GroupExcelParams{
+		Name:    "Mine",
+		Period:  "monthly",
+		Symbols: []string{"BRK/B", "BRK:B"},
+		Results: []*FetchResult{{Data: data}, {Data: data}},
+		Errors:  []error{nil, nil},
+	})
+	if err != nil {
+		t.Fatalf("GenerateGroupExcel() error = %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	want := []string{"Summary", "BRK_B", "BRK_B~2"}
+	if got := f.GetSheetList(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("Sheets = %v, want %v", got, want)
+	}
+	if got, _ := f.GetCellValue("BRK_B~2", "A1"); !strings.HasPrefix(got, "BRK:B") {
+		t.Errorf("Second sheet title = %q", got)
+	}
+	if _, target, _ := f.GetCellHyperLink("Summary", "A6"); target != "'BRK_B~2'!A1" {
+		t.Errorf("BRK:B link = %q", target)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ndjsonFlushEvery is how many NDJSON lines are written between flushes
+const ndjsonFlushEvery = 100
+
+// exportTable holds stock data as machine-readable columns: prices, volume
+// and percentages are numbers, and a missing value is nil
+type exportTable struct {
+	Columns []string
+	Rows    [][]any
+}
+
+// newExportTable converts a stock response (newest-first) into an export
+// table. Change columns are percent values without the "%" sign, volumes are
+// share counts and each indicator adds a column named by its key.
+func newExportTable(resp StockResponse, includePE bool) exportTable {
+	var t exportTable
+	if resp.PeriodData == nil {
+		t.Columns = []string{"date", "open", "high", "low", "close", "volume", "change_pct", "hchange_pct"}
+		if includePE {
+			t.Columns = append(t.Columns, "pe")
+		}
+		for _, d := range resp.DailyData {
+			row := []any{d.Date, exportNum(d.Open), exportNum(d.High), exportNum(d.Low), exportNum(d.Close),
+				exportVolume(d.Volume), exportPct(d.Change), exportPct(d.HChange)}
+			if includePE {
+				row = append(row, exportNum(d.PE))
+			}
+			t.Rows = append(t.Rows, row)
+		}
+	} else {
+		t.Columns = []string{"period", "start_date", "end_date", "open", "high", "low", "close", "volume", "change_pct", "hchange_pct"}
+		if includePE {
+			t.Columns = append(t.Columns, "pe")
+		}
+		t.Columns = append(t.Columns, "days",
+			"drop_2pct_close", "drop_2pct_low", "drop_3pct_close", "drop_3pct_low",
+			"drop_4pct_close", "drop_4pct_low", "drop_5pct_close", "drop_5pct_low")
+		for _, p := range resp.PeriodData {
+			row := []any{p.Period, p.StartDate, p.EndDate, exportNum(p.Open), exportNum(p.High), exportNum(p.Low), exportNum(p.Close),
+				exportVolume(p.Volume), exportPct(p.Change), exportPct(p.HChange)}
+			if includePE {
+				row = append(row, exportNum(p.PE))
+			}
+			row = append(row, p.Days,
+				p.Drop2Pct.Close, p.Drop2Pct.Low, p.Drop3Pct.Close, p.Drop3Pct.Low,
+				p.Drop4Pct.Close, p.Drop4Pct.Low, p.Drop5Pct.Close, p.Drop5Pct.Low)
+			t.Rows = append(t.Rows, row)
+		}
+	}
+
+	for _, series := range resp.Indicators {
+		t.Columns = append(t.Columns, series.Key)
+		for i, v := range series.Values {
+			var cell any
+			if v.Value != nil {
+				cell = *v.Value
+			}
+			t.Rows[i] = append(t.Rows[i], cell)
+		}
+	}
+	return t
+}
+
+// exportNum parses a formatted price or ratio; empty or invalid is nil
+func exportNum(s string) any {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return v
+}
+
+// exportPct parses a change like "-1.23%" into -1.23
+func exportPct(s string) any {
+	return exportNum(strings.TrimSuffix(strings.TrimSpace(s), "%"))
+}
+
+// exportVolume parses a volume like "45.20M" into a share count
+func exportVolume(s string) any {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	return int64(math.Round(parseVolume(s)))
+}
+
+// formatExportValue renders a cell for CSV; nil is an empty field
+func formatExportValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// WriteCSV writes the table with a header row
+func (t exportTable) WriteCSV(out io.Writer) error {
+	w := csv.NewWriter(out)
+	_ = w.Write(t.Columns)
+	record := make([]string, len(t.Columns))
+	for _, row := range t.Rows {
+		for i, v := range row {
+			record[i] = formatExportValue(v)
+		}
+		_ = w.Write(record)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// WriteNDJSON writes one JSON object per row, keys in column order, calling
+// flush every ndjsonFlushEvery rows so clients can consume it as it arrives
+func (t exportTable) WriteNDJSON(out io.Writer, flush func()) error {
+	w := bufio.NewWriter(out)
+	for n, row := range t.Rows {
+		_ = w.WriteByte('{')
+		for i, v := range row {
+			if i > 0 {
+				_ = w.WriteByte(',')
+			}
+			key, _ := json.Marshal(t.Columns[i])
+			val, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			_, _ = w.Write(key)
+			_ = w.WriteByte(':')
+			_, _ = w.Write(val)
+		}
+		if _, err := w.WriteString("}\n"); err != nil {
+			return err
+		}
+		if (n+1)%ndjsonFlushEvery == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			flush()
+		}
+	}
+	return w.Flush()
+}
+
+// handleStockCSV serves stock data as CSV
+// GET /api/stock-csv/{symbol}?days=1825&period=monthly&adjusted=true&indicators=sma:50
+func (s *Server) handleStockCSV(w http.ResponseWriter, r *http.Request) {
+	symbol, t, ok := s.stockExport(w, r, "/api/stock-csv/")
+	if !ok {
+		return
+	}
+	filename := fmt.Sprintf("%s_%s.csv", symbol, periodOrDefault(r))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	if err := t.WriteCSV(w); err != nil {
+		slog.ErrorContext(r.Context(), "CSV write failed", "symbol", symbol, "error", err)
+	}
+}
+
+// handleStockNDJSON streams stock data as newline-delimited JSON, one row
+// per line, newest first
+// GET /api/stock-ndjson/{symbol}?days=1825&period=monthly&adjusted=true&indicators=sma:50
+func (s *Server) handleStockNDJSON(w http.ResponseWriter, r *http.Request) {
+	symbol, t, ok := s.stockExport(w, r, "/api/stock-ndjson/")
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flush := func() {}
+	if f, ok := w.(http.Flusher); ok {
+		flush = f.Flush
+	}
+	if err := t.WriteNDJSON(w, flush); err != nil {
+		slog.ErrorContext(r.Context(), "NDJSON stream failed", "symbol", symbol, "error", err)
+	}
+}
+
+// periodOrDefault returns the period query parameter, monthly if unset
+func periodOrDefault(r *http.Request) string {
+	if period := r.URL.Query().Get("period"); period != "" {
+		return period
+	}
+	return "monthly"
+}
+
+// stockExport parses an export request, fetches the data and builds the
+// export table. On failure it writes a JSON error and returns false.
+func (s *Server) stockExport(w http.ResponseWriter, r *http.Request, prefix string) (string, exportTable, bool) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return "", exportTable{}, false
+	}
+
+	symbol := strings.ToUpper(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, prefix), "/"))
+	if symbol == "" {
+		writeError(w, http.StatusBadRequest, "Symbol is required")
+		return "", exportTable{}, false
+	}
+
+	query := r.URL.Query()
+	days := 1825
+	if d := query.Get("days"); d != "" {
+		if parsed, err := strconv.Atoi(d); err == nil && parsed > 0 {
+			days = parsed
+		}
+	}
+	period := periodOrDefault(r)
+	if _, err := ParsePeriodType(period); err != nil && period != "daily" {
+		writeError(w, http.StatusBadRequest, "Invalid period. Use: daily, weekly, monthly, quarterly, yearly")
+		return "", exportTable{}, false
+	}
+	adjusted, _ := strconv.ParseBool(query.Get("adjusted"))
+	indicators, err := ParseIndicators(query.Get("indicators"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid indicators: %v", err))
+		return "", exportTable{}, false
+	}
+
+	result, err := fetchStockData(r.Context(), s.cache, symbol, days)
+	if err != nil {
+		writeFetchError(w, fmt.Sprintf("Failed to fetch data: %v", err), err)
+		return "", exportTable{}, false
+	}
+	if len(result.Data) == 0 {
+		writeErrorCode(w, http.StatusNotFound, "no_data", "No data found for symbol")
+		return "", exportTable{}, false
+	}
+
+	resp := buildStockResponse(symbol, result, period, adjusted, indicators, 0)
+	return symbol, newExportTable(resp, result.IncludePE), true
+}
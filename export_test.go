@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestExportTableParsesFormattedValues(t *testing.T) {
+	resp := StockResponse{DailyData: []StockData{
+		{Date: "2024-01-03", Open: "10.00", High: "11.50", Low: "9.75", Close: "11.00", Volume: "45.20M", Change: "-1.23%", HChange: "0.50%", PE: "25.10"},
+		{Date: "2024-01-02", Open: "10.00", High: "10.00", Low: "10.00", Close: "10.00", Volume: "950"},
+	}}
+	tbl := newExportTable(resp, true)
+
+	want := []string{"date", "open", "high", "low", "close", "volume", "change_pct", "hchange_pct", "pe"}
+	if strings.Join(tbl.Columns, ",") != strings.Join(want, ",") {
+		t.Fatalf("Columns = %v, want %v", tbl.Columns, want)
+	}
+	newest := tbl.Rows[0]
+	if newest[4] != 11.0 || newest[5] != int64(45200000) || newest[6] != -1.23 || newest[7] != 0.5 || newest[8] != 25.1 {
+		t.Errorf("Unexpected newest row: %#v", newest)
+	}
+	if oldest := tbl.Rows[1]; oldest[5] != int64(950) || oldest[6] != nil || oldest[8] != nil {
+		t.Errorf("Missing values should be nil: %#v", oldest)
+	}
+
+	var sb strings.Builder
+	if err := tbl.WriteCSV(&sb); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(sb.String()), "\n")
+	if lines[1] != "2024-01-03,10,11.5,9.75,11,45200000,-1.23,0.5,25.1" || lines[2] != "2024-01-02,10,10,10,10,950,,," {
+		t.Errorf("Unexpected CSV:\n%s", sb.String())
+	}
+}
+
+func TestStockCSVEndpoint(t *testing.T) {
+	p := &fakeProvider{name: "fake", data: cliSeries()}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}}, p)
+	server := NewServer("0", nil)
+
+	w := doJSON(t, server, "GET", "/api/stock-csv/aapl?days=30&period=weekly", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, "AAPL_weekly.csv") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || len(records) < 2 || records[0][0] != "period" || records[0][len(records[0])-1] != "drop_5pct_low" {
+		t.Fatalf("Unexpected CSV (%v): %v", err, records)
+	}
+	if records[1][6] != "109" {
+		t.Errorf("Latest weekly close = %q, want 109", records[1][6])
+	}
+
+	if w := doJSON(t, server, "GET", "/api/stock-csv/AAPL?period=hourly", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Invalid period: expected 400, got %d", w.Code)
+	}
+	if w := doJSON(t, server, "GET", "/api/stock-csv/", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Missing symbol: expected 400, got %d", w.Code)
+	}
+}
+
+func TestStockNDJSONEndpoint(t *testing.T) {
+	p := &fakeProvider{name: "fake", data: cliSeries()}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}}, p)
+
+	w := doJSON(t, NewServer("0", nil), "GET", "/api/stock-ndjson/AAPL?days=30&period=daily&indicators=sma:2", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("Expected NDJSON 200, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	body := w.Body.String()
+	if !strings.HasPrefix(body, `{"date":`) {
+		t.Errorf("Keys should follow column order: %s", body)
+	}
+
+	var rows []map[string]any
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		var row map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("Invalid line %q: %v", scanner.Text(), err)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) != 10 {
+		t.Fatalf("Got %d rows, want 10", len(rows))
+	}
+	if newest := rows[0]; newest["close"] != 109.0 || newest["volume"] != 1000.0 || newest["sma_2"] != 108.5 {
+		t.Errorf("Unexpected newest row: %v", newest)
+	}
+	if oldest := rows[9]; oldest["change_pct"] != nil || oldest["sma_2"] != nil {
+		t.Errorf("Warm-up values should be null: %v", oldest)
+	}
+}
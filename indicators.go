@@ -0,0 +1,349 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// IndicatorSpec is a parsed indicator request such as "sma:50" or "macd:12:26:9"
+type IndicatorSpec struct {
+	Name   string    // sma, ema, rsi, macd, bb, atr
+	Params []float64 // Periods (and multiplier for bb)
+}
+
+// IndicatorSeries is one computed line, aligned with the price rows it was
+// computed from (newest-first, same order as DailyData/PeriodData)
+type IndicatorSeries struct {
+	Key    string           `json:"key"`   // Machine-friendly key, e.g. "sma_50", "macd_signal"
+	Label  string           `json:"label"` // Display label, e.g. "SMA(50)"
+	Values []IndicatorValue `json:"values"`
+}
+
+// IndicatorValue is an indicator reading for one row; Value is null during warm-up
+type IndicatorValue struct {
+	Date  string   `json:"date"`
+	Value *float64 `json:"value"`
+}
+
+// maxIndicators and maxIndicatorPeriod bound the work a single request can ask for
+const (
+	maxIndicators      = 12
+	maxIndicatorPeriod = 500
+)
+
+// indicatorDefaults holds the default parameters for each indicator
+var indicatorDefaults = map[string][]float64{
+	"sma":  {20},
+	"ema":  {20},
+	"rsi":  {14},
+	"macd": {12, 26, 9},
+	"bb":   {20, 2},
+	"atr":  {14},
+}
+
+// ParseIndicators parses a comma-separated list like "sma:50,sma:200,rsi:14"
+func ParseIndicators(s string) ([]IndicatorSpec, error) {
+	var specs []IndicatorSpec
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(strings.ToLower(item))
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		name := parts[0]
+		if name == "bollinger" {
+			name = "bb"
+		}
+		defaults, ok := indicatorDefaults[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown indicator %q (use sma, ema, rsi, macd, bb, atr)", parts[0])
+		}
+		if len(parts)-1 > len(defaults) {
+			return nil, fmt.Errorf("too many parameters for %s", name)
+		}
+
+		params := append([]float64(nil), defaults...)
+		for i, p := range parts[1:] {
+			v, err := strconv.ParseFloat(p, 64)
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("invalid parameter %q for %s", p, name)
+			}
+			params[i] = v
+		}
+
+		// All parameters except the Bollinger multiplier are whole periods
+		for i, v := range params {
+			if name == "bb" && i == 1 {
+				continue
+			}
+			if v != math.Trunc(v) || v > maxIndicatorPeriod {
+				return nil, fmt.Errorf("invalid period %v for %s (whole number up to %d)", v, name, maxIndicatorPeriod)
+			}
+		}
+		if name == "macd" && params[0] >= params[1] {
+			return nil, fmt.Errorf("macd fast period must be less than slow period")
+		}
+
+		specs = append(specs, IndicatorSpec{Name: name, Params: params})
+	}
+
+	if len(specs) > maxIndicators {
+		return nil, fmt.Errorf("too many indicators (max %d)", maxIndicators)
+	}
+	return specs, nil
+}
+
+// ComputeDailyIndicators computes indicators over daily data (newest-first)
+func ComputeDailyIndicators(data []StockData, specs []IndicatorSpec) []IndicatorSeries {
+	n := len(data)
+	dates := make([]string, n)
+	high := make([]float64, n)
+	low := make([]float64, n)
+	close := make([]float64, n)
+	for i, d := range data {
+		j := n - 1 - i // oldest-first
+		dates[j] = d.Date
+		high[j] = parseFloat(d.High)
+		low[j] = parseFloat(d.Low)
+		close[j] = parseFloat(d.Close)
+	}
+	return computeIndicators(specs, dates, high, low, close)
+}
+
+// ComputePeriodIndicators computes indicators over period aggregates (newest-first)
+func ComputePeriodIndicators(data []PeriodData, specs []IndicatorSpec) []IndicatorSeries {
+	n := len(data)
+	dates := make([]string, n)
+	high := make([]float64, n)
+	low := make([]float64, n)
+	close := make([]float64, n)
+	for i, p := range data {
+		j := n - 1 - i // oldest-first
+		dates[j] = p.Period
+		high[j] = parseFloat(p.High)
+		low[j] = parseFloat(p.Low)
+		close[j] = parseFloat(p.Close)
+	}
+	return computeIndicators(specs, dates, high, low, close)
+}
+
+// computeIndicators evaluates each spec over oldest-first series
+func computeIndicators(specs []IndicatorSpec, dates []string, high, low, close []float64) []IndicatorSeries {
+	var result []IndicatorSeries
+	add := func(key, label string, values []float64) {
+		result = append(result, newIndicatorSeries(key, label, dates, values))
+	}
+
+	for _, spec := range specs {
+		p := spec.Params
+		switch spec.Name {
+		case "sma":
+			add(fmt.Sprintf("sma_%d", int(p[0])), fmt.Sprintf("SMA(%d)", int(p[0])), sma(close, int(p[0])))
+		case "ema":
+			add(fmt.Sprintf("ema_%d", int(p[0])), fmt.Sprintf("EMA(%d)", int(p[0])), ema(close, int(p[0])))
+		case "rsi":
+			add(fmt.Sprintf("rsi_%d", int(p[0])), fmt.Sprintf("RSI(%d)", int(p[0])), rsi(close, int(p[0])))
+		case "atr":
+			add(fmt.Sprintf("atr_%d", int(p[0])), fmt.Sprintf("ATR(%d)", int(p[0])), atr(high, low, close, int(p[0])))
+		case "macd":
+			line, signal, hist := macd(close, int(p[0]), int(p[1]), int(p[2]))
+			suffix := fmt.Sprintf("%d_%d_%d", int(p[0]), int(p[1]), int(p[2]))
+			add("macd_"+suffix, "MACD", line)
+			add("macd_signal_"+suffix, "MACD Signal", signal)
+			add("macd_hist_"+suffix, "MACD Hist", hist)
+		case "bb":
+			upper, middle, lower := bollinger(close, int(p[0]), p[1])
+			suffix := fmt.Sprintf("%d_%s", int(p[0]), strconv.FormatFloat(p[1], 'f', -1, 64))
+			label := fmt.Sprintf("BB(%d,%s)", int(p[0]), strconv.FormatFloat(p[1], 'f', -1, 64))
+			add("bb_upper_"+suffix, label+" Upper", upper)
+			add("bb_middle_"+suffix, label+" Middle", middle)
+			add("bb_lower_"+suffix, label+" Lower", lower)
+		}
+	}
+	return result
+}
+
+// newIndicatorSeries converts oldest-first values (NaN for warm-up) into a
+// newest-first series rounded to 4 decimals
+func newIndicatorSeries(key, label string, dates []string, values []float64) IndicatorSeries {
+	n := len(dates)
+	series := IndicatorSeries{Key: key, Label: label, Values: make([]IndicatorValue, n)}
+	for i := range dates {
+		v := IndicatorValue{Date: dates[i]}
+		if !math.IsNaN(values[i]) {
+			rounded := math.Round(values[i]*10000) / 10000
+			v.Value = &rounded
+		}
+		series.Values[n-1-i] = v
+	}
+	return series
+}
+
+// nanSlice returns a slice of n NaNs
+func nanSlice(n int) []float64 {
+	s := make([]float64, n)
+	for i := range s {
+		s[i] = math.NaN()
+	}
+	return s
+}
+
+// sma computes the simple moving average
+func sma(values []float64, period int) []float64 {
+	out := nanSlice(len(values))
+	var sum float64
+	for i, v := range values {
+		sum += v
+		if i >= period {
+			sum -= values[i-period]
+		}
+		if i >= period-1 {
+			out[i] = sum / float64(period)
+		}
+	}
+	return out
+}
+
+// ema computes the exponential moving average, seeded with the SMA of the
+// first period values. NaN inputs (warm-up of an upstream series) are skipped.
+func ema(values []float64, period int) []float64 {
+	out := nanSlice(len(values))
+	k := 2.0 / float64(period+1)
+
+	start := 0
+	for start < len(values) && math.IsNaN(values[start]) {
+		start++
+	}
+	if len(values)-start < period {
+		return out
+	}
+
+	var sum float64
+	for i := start; i < start+period; i++ {
+		sum += values[i]
+	}
+	prev := sum / float64(period)
+	out[start+period-1] = prev
+
+	for i := start + period; i < len(values); i++ {
+		prev = values[i]*k + prev*(1-k)
+		out[i] = prev
+	}
+	return out
+}
+
+// rsi computes the Relative Strength Index using Wilder's smoothing
+func rsi(close []float64, period int) []float64 {
+	out := nanSlice(len(close))
+	if len(close) <= period {
+		return out
+	}
+
+	var gain, loss float64
+	for i := 1; i <= period; i++ {
+		change := close[i] - close[i-1]
+		if change > 0 {
+			gain += change
+		} else {
+			loss -= change
+		}
+	}
+	avgGain := gain / float64(period)
+	avgLoss := loss / float64(period)
+	out[period] = rsiValue(avgGain, avgLoss)
+
+	for i := period + 1; i < len(close); i++ {
+		change := close[i] - close[i-1]
+		g, l := 0.0, 0.0
+		if change > 0 {
+			g = change
+		} else {
+			l = -change
+		}
+		avgGain = (avgGain*float64(period-1) + g) / float64(period)
+		avgLoss = (avgLoss*float64(period-1) + l) / float64(period)
+		out[i] = rsiValue(avgGain, avgLoss)
+	}
+	return out
+}
+
+func rsiValue(avgGain, avgLoss float64) float64 {
+	if avgLoss == 0 {
+		return 100
+	}
+	rs := avgGain / avgLoss
+	return 100 - 100/(1+rs)
+}
+
+// macd computes the MACD line (fast EMA - slow EMA), its signal EMA and the histogram
+func macd(close []float64, fast, slow, signalPeriod int) ([]float64, []float64, []float64) {
+	fastEMA := ema(close, fast)
+	slowEMA := ema(close, slow)
+
+	line := nanSlice(len(close))
+	for i := range close {
+		if !math.IsNaN(fastEMA[i]) && !math.IsNaN(slowEMA[i]) {
+			line[i] = fastEMA[i] - slowEMA[i]
+		}
+	}
+
+	signal := ema(line, signalPeriod)
+	hist := nanSlice(len(close))
+	for i := range close {
+		if !math.IsNaN(line[i]) && !math.IsNaN(signal[i]) {
+			hist[i] = line[i] - signal[i]
+		}
+	}
+	return line, signal, hist
+}
+
+// bollinger computes Bollinger Bands: SMA ± k population standard deviations
+func bollinger(close []float64, period int, k float64) ([]float64, []float64, []float64) {
+	middle := sma(close, period)
+	upper := nanSlice(len(close))
+	lower := nanSlice(len(close))
+
+	for i := period - 1; i < len(close); i++ {
+		var variance float64
+		for j := i - period + 1; j <= i; j++ {
+			d := close[j] - middle[i]
+			variance += d * d
+		}
+		sd := math.Sqrt(variance / float64(period))
+		upper[i] = middle[i] + k*sd
+		lower[i] = middle[i] - k*sd
+	}
+	return upper, middle, lower
+}
+
+// atr computes the Average True Range using Wilder's smoothing
+func atr(high, low, close []float64, period int) []float64 {
+	out := nanSlice(len(close))
+	if len(close) < period {
+		return out
+	}
+
+	tr := make([]float64, len(close))
+	for i := range close {
+		tr[i] = high[i] - low[i]
+		if i > 0 {
+			tr[i] = math.Max(tr[i], math.Max(math.Abs(high[i]-close[i-1]), math.Abs(low[i]-close[i-1])))
+		}
+	}
+
+	var sum float64
+	for i := 0; i < period; i++ {
+		sum += tr[i]
+	}
+	prev := sum / float64(period)
+	out[period-1] = prev
+
+	for i := period; i < len(close); i++ {
+		prev = (prev*float64(period-1) + tr[i]) / float64(period)
+		out[i] = prev
+	}
+	return out
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func floatsNear(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestParseIndicators(t *testing.T) {
+	specs, err := ParseIndicators("sma:50, SMA:200,rsi,macd,bollinger:20:2.5,atr:10")
+	if err != nil {
+		t.Fatalf("ParseIndicators() error = %v", err)
+	}
+	if len(specs) != 6 {
+		t.Fatalf("Expected 6 specs, got %d", len(specs))
+	}
+	if specs[1].Name != "sma" || specs[1].Params[0] != 200 {
+		t.Errorf("Unexpected sma spec: %+v", specs[1])
+	}
+	if specs[2].Params[0] != 14 {
+		t.Errorf("Expected default RSI period 14, got %v", specs[2].Params)
+	}
+	if len(specs[3].Params) != 3 || specs[3].Params[1] != 26 {
+		t.Errorf("Expected default MACD params, got %v", specs[3].Params)
+	}
+	if specs[4].Name != "bb" || specs[4].Params[1] != 2.5 {
+		t.Errorf("Unexpected bb spec: %+v", specs[4])
+	}
+
+	if specs, err := ParseIndicators(""); err != nil || len(specs) != 0 {
+		t.Errorf("Empty input: %v, %v", specs, err)
+	}
+}
+
+func TestParseIndicators_Invalid(t *testing.T) {
+	for _, s := range []string{"foo:10", "sma:abc", "sma:0", "sma:1.5", "sma:10:20", "sma:100000", "macd:26:12:9"} {
+		if _, err := ParseIndicators(s); err == nil {
+			t.Errorf("ParseIndicators(%q) expected error", s)
+		}
+	}
+}
+
+func TestSMAandEMA(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5}
+
+	s := sma(values, 3)
+	if !math.IsNaN(s[1]) || !floatsNear(s[2], 2) || !floatsNear(s[4], 4) {
+		t.Errorf("sma = %v", s)
+	}
+
+	e := ema(values, 3)
+	// Seeded with SMA(3)=2, then k=0.5: 4*0.5+2*0.5=3, 5*0.5+3*0.5=4
+	if !math.IsNaN(e[1]) || !floatsNear(e[2], 2) || !floatsNear(e[3], 3) || !floatsNear(e[4], 4) {
+		t.Errorf("ema = %v", e)
+	}
+}
+
+func TestRSI(t *testing.T) {
+	// Strictly rising prices have no losses
+	up := rsi([]float64{1, 2, 3, 4, 5}, 3)
+	if !math.IsNaN(up[2]) || up[3] != 100 {
+		t.Errorf("rsi rising = %v", up)
+	}
+
+	// Equal gains and losses give RSI 50
+	flat := rsi([]float64{10, 11, 10, 11, 10}, 4)
+	if !floatsNear(flat[4], 50) {
+		t.Errorf("rsi flat = %v", flat)
+	}
+}
+
+func TestBollinger(t *testing.T) {
+	upper, middle, lower := bollinger([]float64{2, 4, 4, 4, 5, 5, 7, 9}, 8, 2)
+	// Mean 5, population standard deviation 2
+	if !floatsNear(middle[7], 5) || !floatsNear(upper[7], 9) || !floatsNear(lower[7], 1) {
+		t.Errorf("bollinger = %v/%v/%v", upper[7], middle[7], lower[7])
+	}
+}
+
+func TestATR(t *testing.T) {
+	high := []float64{10, 12, 11}
+	low := []float64{8, 9, 9}
+	close := []float64{9, 11, 10}
+	a := atr(high, low, close, 2)
+	// TR = [2, 3, 2]; ATR seed = 2.5, then (2.5*1 + 2)/2 = 2.25
+	if !math.IsNaN(a[0]) || !floatsNear(a[1], 2.5) || !floatsNear(a[2], 2.25) {
+		t.Errorf("atr = %v", a)
+	}
+}
+
+func TestMACD(t *testing.T) {
+	close := make([]float64, 40)
+	for i := range close {
+		close[i] = float64(i + 1)
+	}
+	line, signal, hist := macd(close, 3, 6, 4)
+
+	if !math.IsNaN(line[4]) || math.IsNaN(line[5]) {
+		t.Errorf("MACD line warm-up wrong: %v", line[:6])
+	}
+	if math.IsNaN(signal[8]) || !math.IsNaN(signal[7]) {
+		t.Errorf("MACD signal warm-up wrong: %v", signal[:9])
+	}
+	// On a linear trend the line converges to a positive constant
+	if line[39] <= 0 || !floatsNear(hist[39], line[39]-signal[39]) {
+		t.Errorf("MACD = %v %v %v", line[39], signal[39], hist[39])
+	}
+}
+
+func TestComputeDailyIndicatorsAlignment(t *testing.T) {
+	// Newest-first, as served by fetchStockData
+	data := []StockData{
+		{Date: "2024-01-05", High: "5", Low: "5", Close: "5"},
+		{Date: "2024-01-04", High: "4", Low: "4", Close: "4"},
+		{Date: "2024-01-03", High: "3", Low: "3", Close: "3"},
+	}
+	specs, _ := ParseIndicators("sma:2")
+
+	series := ComputeDailyIndicators(data, specs)
+	if len(series) != 1 || series[0].Key != "sma_2" || series[0].Label != "SMA(2)" {
+		t.Fatalf("Unexpected series: %+v", series)
+	}
+
+	values := series[0].Values
+	if values[0].Date != "2024-01-05" || values[0].Value == nil || *values[0].Value != 4.5 {
+		t.Errorf("Unexpected newest value: %+v", values[0])
+	}
+	if values[2].Value != nil {
+		t.Errorf("Expected warm-up value to be null, got %v", *values[2].Value)
+	}
+}
+
+func TestGenerateExcelWithIndicators(t *testing.T) {
+	data := []StockData{
+		{Date: "2024-01-04", Open: "4", High: "4", Low: "4", Close: "4"},
+		{Date: "2024-01-03", Open: "3", High: "3", Low: "3", Close: "3"},
+	}
+	specs, _ := ParseIndicators("sma:2")
+
+	f, err := GenerateExcel(ExcelParams{
+		Symbol:     "TEST",
+		Period:     "daily",
+		Data:       data,
+		Indicators: ComputeDailyIndicators(data, specs),
+	})
+	if err != nil {
+		t.Fatalf("GenerateExcel() error = %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	// Daily headers are columns A-H, so the indicator lands in column I
+	header, _ := f.GetCellValue("Daily", "I1")
+	value, _ := f.GetCellValue("Daily", "I2")
+	if header != "SMA(2)" || value != "3.5" {
+		t.Errorf("Indicator column = %q / %q", header, value)
+	}
+}
+
+func TestStockEndpointInvalidIndicators(t *testing.T) {
+	server := NewServer("0", nil)
+
+	req := httptest.NewRequest("GET", "/api/stock/AAPL?indicators=bogus:5", nil)
+	w := httptest.NewRecorder()
+
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
@@ -4,7 +4,7 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -14,14 +14,21 @@ import (
 var httpAdapter *httpadapter.HandlerAdapter
 
 func init() {
-	log.Println("Lambda cold start")
+	setupLogging()
+	setupTracing()
+	slog.Info("Lambda cold start")
 	cache := InitCache()
 	server := NewServer("0", cache)
 	httpAdapter = httpadapter.New(server.Handler())
 }
 
+// Handler serves one API Gateway event. The invocation context, which ends
+// at the function timeout, becomes the request's context.
 func Handler(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	return httpAdapter.ProxyWithContext(ctx, req)
+	resp, err := httpAdapter.ProxyWithContext(ctx, req)
+	// The sandbox may freeze between invocations, so export spans now
+	flushTraces(ctx)
+	return resp, err
 }
 
 func main() {
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalFileFetcher reads price histories from a directory of CSV or JSON
+// files named after the symbol (e.g. AAPL.csv, 0700.HK.json)
+type LocalFileFetcher struct {
+	dir string
+}
+
+// NewLocalFileFetcher creates a fetcher that reads files from dir
+func NewLocalFileFetcher(dir string) *LocalFileFetcher {
+	return &LocalFileFetcher{dir: dir}
+}
+
+// localBar is a single OHLCV row as stored in a local JSON file
+type localBar struct {
+	Date   string   `json:"date"`
+	Open   float64  `json:"open"`
+	High   float64  `json:"high"`
+	Low    float64  `json:"low"`
+	Close  float64  `json:"close"`
+	Volume float64  `json:"volume"`
+	EPS    *float64 `json:"eps,omitempty"` // Reported on this day; nil carries the last report forward
+
+	date time.Time // Parsed Date
+}
+
+// localFile is the JSON file layout: either a bare array of bars or an
+// object with an optional company name
+type localFile struct {
+	CompanyName string     `json:"company_name"`
+	Prices      []localBar `json:"prices"`
+}
+
+// findFile returns the path of the data file for a symbol
+func (f *LocalFileFetcher) findFile(symbol string) (string, error) {
+	if f.dir == "" {
+		return "", fmt.Errorf("local data directory not configured (set LOCAL_DATA_DIR)")
+	}
+	if strings.ContainsAny(symbol, `/\`) || strings.Contains(symbol, "..") {
+		return "", fmt.Errorf("%w %q", ErrUnknownSymbol, symbol)
+	}
+
+	for _, name := range []string{strings.ToUpper(symbol), strings.ToLower(symbol)} {
+		for _, ext := range []string{".csv", ".json"} {
+			path := filepath.Join(f.dir, name+ext)
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w %s: no local data file in %s", ErrUnknownSymbol, symbol, f.dir)
+}
+
+// loadBars reads all bars for a symbol, sorted oldest-first
+func (f *LocalFileFetcher) loadBars(symbol string) ([]localBar, string, error) {
+	path, err := f.findFile(symbol)
+	if err != nil {
+		return nil, "", err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var bars []localBar
+	var companyName string
+	if strings.HasSuffix(path, ".json") {
+		bars, companyName, err = parseLocalJSON(content)
+	} else {
+		bars, err = parseLocalCSV(string(content))
+	}
+	if err == nil {
+		err = parseBarDates(bars)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+
+	sort.Slice(bars, func(i, j int) bool {
+		return bars[i].date.Before(bars[j].date)
+	})
+	return bars, companyName, nil
+}
+
+// parseBarDates parses every bar's YYYY-MM-DD date. A file with dates in any
+// other format is rejected rather than filtered or sorted wrongly.
+func parseBarDates(bars []localBar) error {
+	for i := range bars {
+		date, err := time.Parse("2006-01-02", bars[i].Date)
+		if err != nil {
+			return fmt.Errorf("%w: invalid date %q, want YYYY-MM-DD", ErrParse, bars[i].Date)
+		}
+		bars[i].date = date
+	}
+	return nil
+}
+
+// parseLocalJSON parses either a bare array of bars or a localFile object
+func parseLocalJSON(content []byte) ([]localBar, string, error) {
+	var bars []localBar
+	if err := json.Unmarshal(content, &bars); err == nil {
+		return bars, "", nil
+	}
+
+	var file localFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, "", fmt.Errorf("%w: JSON: %w", ErrParse, err)
+	}
+	return file.Prices, file.CompanyName, nil
+}
+
+// parseLocalCSV parses a CSV with a Date,Open,High,Low,Close,Volume header
+// and an optional EPS column. Volume may be raw or abbreviated ("1.5M").
+func parseLocalCSV(content string) ([]localBar, error) {
+	reader := csv.NewReader(strings.NewReader(content))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%w: CSV: %w", ErrParse, err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("%w: no rows in CSV", ErrNoData)
+	}
+
+	cols := make(map[string]int)
+	for i, h := range records[0] {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, required := range []string{"date", "close"} {
+		if _, ok := cols[required]; !ok {
+			return nil, fmt.Errorf("%w: CSV missing %q column", ErrParse, required)
+		}
+	}
+
+	field := func(rec []string, name string) string {
+		i, ok := cols[name]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+
+	var bars []localBar
+	for _, rec := range records[1:] {
+		closeVal, err := strconv.ParseFloat(field(rec, "close"), 64)
+		if err != nil || closeVal == 0 {
+			continue
+		}
+		bar := localBar{
+			Date:   field(rec, "date"),
+			Open:   parseFloat(field(rec, "open")),
+			High:   parseFloat(field(rec, "high")),
+			Low:    parseFloat(field(rec, "low")),
+			Close:  closeVal,
+			Volume: parseVolume(field(rec, "volume")),
+		}
+		// An empty EPS cell means no report that day
+		if eps, err := strconv.ParseFloat(field(rec, "eps"), 64); err == nil {
+			bar.EPS = &eps
+		}
+		bars = append(bars, bar)
+	}
+	return bars, nil
+}
+
+// Name implements Provider
+func (f *LocalFileFetcher) Name() string {
+	return "local"
+}
+
+// QuoteURL implements Provider; local files have no public page
+func (f *LocalFileFetcher) QuoteURL(symbol, companyName string) string {
+	return ""
+}
+
+// Fetch implements Provider, returning the last N days from the symbol's
+// file with P/E computed from the EPS column when present
+func (f *LocalFileFetcher) Fetch(_ context.Context, symbol string, days int) (*FetchResult, error) {
+	bars, companyName, err := f.loadBars(symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bar dates parse as midnight UTC, so the start date is taken the same way
+	startDate, _ := time.Parse("2006-01-02", time.Now().AddDate(0, 0, -days).Format("2006-01-02"))
+
+	var data []StockData
+	var eps float64 // last reported EPS, carried forward between reports
+	includePE := false
+
+	for _, b := range bars {
+		// Like macrotrends, a loss leaves P/E blank until earnings turn
+		// positive again
+		if b.EPS != nil {
+			eps = *b.EPS
+			includePE = true
+		}
+		if b.date.Before(startDate) {
+			continue
+		}
+
+		pe := ""
+		if eps > 0 {
+			pe = fmt.Sprintf("%.2f", b.Close/eps)
+		}
+
+		data = append(data, StockData{
+			Date:   b.Date,
+			Open:   formatFloat(b.Open),
+			High:   formatFloat(b.High),
+			Low:    formatFloat(b.Low),
+			Close:  formatFloat(b.Close),
+			Volume: formatVolumeFloat(b.Volume),
+			PE:     pe,
+		})
+	}
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w for %s in the last %d days", ErrNoData, symbol, days)
+	}
+
+	computeChanges(data)
+
+	if companyName == "" {
+		companyName = GetCompanyName(strings.ToUpper(symbol))
+	}
+
+	return &FetchResult{
+		Data:        reverseData(data),
+		TTMEPS:      eps,
+		CompanyName: companyName,
+		IncludePE:   includePE,
+	}, nil
+}
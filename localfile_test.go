@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeLocalCSV writes a CSV history of n trading days ending today
+func writeLocalCSV(t *testing.T, dir, symbol string, n int, withEPS bool) {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("Date,Open,High,Low,Close,Volume")
+	if withEPS {
+		b.WriteString(",EPS")
+	}
+	b.WriteString("\n")
+
+	start := time.Now().AddDate(0, 0, -n+1)
+	for i := 0; i < n; i++ {
+		date := start.AddDate(0, 0, i).Format("2006-01-02")
+		price := 100.0 + float64(i)
+		fmt.Fprintf(&b, "%s,%.2f,%.2f,%.2f,%.2f,%d", date, price, price+1, price-1, price+0.5, 1000000+i)
+		if withEPS {
+			if i == 0 {
+				b.WriteString(",5.00")
+			} else {
+				b.WriteString(",")
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, symbol+".csv"), []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseLocalCSV(t *testing.T) {
+	bars, err := parseLocalCSV("Date, Open, High, Low, Close, Volume, EPS\n2024-01-02,10,11,9,10.5,1.5M,2.1\n2024-01-03,10.5,12,10,11.5,2000000,\n")
+	if err != nil {
+		t.Fatalf("parseLocalCSV() error = %v", err)
+	}
+	if len(bars) != 2 {
+		t.Fatalf("Expected 2 bars, got %d", len(bars))
+	}
+	if bars[0].Volume != 1.5e6 || bars[0].EPS == nil || *bars[0].EPS != 2.1 {
+		t.Errorf("Unexpected first bar: %+v", bars[0])
+	}
+	if bars[1].Volume != 2e6 || bars[1].EPS != nil {
+		t.Errorf("Unexpected second bar: %+v", bars[1])
+	}
+}
+
+func TestParseLocalCSV_MissingColumns(t *testing.T) {
+	if _, err := parseLocalCSV("Date,Open\n2024-01-02,10\n"); err == nil {
+		t.Error("Expected error for missing close column")
+	}
+}
+
+func TestParseLocalJSON(t *testing.T) {
+	bars, name, err := parseLocalJSON([]byte(`{"company_name":"Demo Corp","prices":[{"date":"2024-01-02","open":1,"high":2,"low":0.5,"close":1.5,"volume":100,"eps":0.1}]}`))
+	if err != nil {
+		t.Fatalf("parseLocalJSON() error = %v", err)
+	}
+	if name != "Demo Corp" || len(bars) != 1 || bars[0].EPS == nil || *bars[0].EPS != 0.1 {
+		t.Errorf("Unexpected result: %q %+v", name, bars)
+	}
+
+	bars, _, err = parseLocalJSON([]byte(`[{"date":"2024-01-02","close":1.5}]`))
+	if err != nil || len(bars) != 1 {
+		t.Errorf("Bare array: %+v, %v", bars, err)
+	}
+}
+
+func TestLocalFileFetch(t *testing.T) {
+	dir := t.TempDir()
+	writeLocalCSV(t, dir, "DEMO", 10, true)
+
+	fetcher := NewLocalFileFetcher(dir)
+	result, err := fetcher.Fetch(t.Context(), "demo", 5)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+
+	// 5 calendar days back plus today
+	if len(result.Data) != 6 {
+		t.Errorf("Expected 6 records, got %d", len(result.Data))
+	}
+	if !result.IncludePE || result.TTMEPS != 5 {
+		t.Errorf("Expected EPS carried forward, got IncludePE=%v TTMEPS=%v", result.IncludePE, result.TTMEPS)
+	}
+	if result.Data[0].PE == "" {
+		t.Error("Expected P/E on latest record")
+	}
+	if result.Data[0].Date <= result.Data[1].Date {
+		t.Error("Expected newest-first order")
+	}
+}
+
+func TestLocalFileFetchLoss(t *testing.T) {
+	dir := t.TempDir()
+	day := func(n int) string { return time.Now().AddDate(0, 0, n).Format("2006-01-02") }
+	content := "Date,Close,EPS\n" +
+		day(-3) + ",100,5\n" +
+		day(-2) + ",90,\n" +
+		day(-1) + ",80,-1.5\n" +
+		day(0) + ",85,\n"
+	if err := os.WriteFile(filepath.Join(dir, "LOSS.csv"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := NewLocalFileFetcher(dir).Fetch(t.Context(), "LOSS", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Newest first: the loss blanks P/E from the day it's reported
+	var pe []string
+	for _, d := range result.Data {
+		pe = append(pe, d.PE)
+	}
+	if strings.Join(pe, ",") != ",,18.00,20.00" || !result.IncludePE || result.TTMEPS != -1.5 {
+		t.Errorf("P/E = %q, IncludePE=%v TTMEPS=%v", pe, result.IncludePE, result.TTMEPS)
+	}
+}
+
+func TestLocalFileFetchRejectsBadDates(t *testing.T) {
+	dir := t.TempDir()
+	for symbol, content := range map[string]string{
+		"SHORT.csv": "Date,Close\n2024-1-5,10\n",
+		"US.csv":    "Date,Close\n01/05/2024,10\n",
+		"JS.json":   `[{"date":"2024-01-05","close":10},{"date":"5 Jan 2024","close":11}]`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, symbol), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, symbol := range []string{"SHORT", "US", "JS"} {
+		if _, err := NewLocalFileFetcher(dir).Fetch(t.Context(), symbol, 100000); !errors.Is(err, ErrParse) {
+			t.Errorf("Fetch(%s) = %v, want a parse error", symbol, err)
+		}
+	}
+}
+
+func TestLocalFileFetchRejectsPaths(t *testing.T) {
+	fetcher := NewLocalFileFetcher(t.TempDir())
+	for _, symbol := range []string{"../etc/passwd", "a/b", `a\b`} {
+		if _, err := fetcher.Fetch(t.Context(), symbol, 30); err == nil {
+			t.Errorf("Fetch(%q) expected error", symbol)
+		}
+	}
+}
+
+func TestStockEndpointOffline(t *testing.T) {
+	dir := t.TempDir()
+	writeLocalCSV(t, dir, "DEMO", 60, false)
+	withProviders(t, ProviderChains{
+		Markets: map[string][]string{"US": {"local"}},
+	}, NewLocalFileFetcher(dir))
+
+	server := NewServer("0", nil)
+
+	req := httptest.NewRequest("GET", "/api/stock/DEMO?days=90&period=weekly", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Success bool          `json:"success"`
+		Data    StockResponse `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if resp.Data.DataSource != "local" {
+		t.Errorf("DataSource = %q, want %q", resp.Data.DataSource, "local")
+	}
+	if len(resp.Data.PeriodData) == 0 {
+		t.Error("Expected weekly period data")
+	}
+
+	req = httptest.NewRequest("GET", "/api/stock-excel/DEMO?days=90&period=daily", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Excel: expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.Contains(ct, "spreadsheetml") {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+}
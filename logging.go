@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"strings"
+)
+
+// Logs are structured with log/slog. Records logged with a request context
+// carry its request ID and trace/span IDs, so the lines of one slow request
+// can be picked out of interleaved batch fetches.
+
+// contextKey keys values stored in a context.Context by this package
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	spanKey
+	priorityKey
+)
+
+// maxRequestIDLen bounds client-supplied X-Request-ID values
+const maxRequestIDLen = 64
+
+// newRequestID returns a random 16-character hex ID
+func newRequestID() string {
+	return fmt.Sprintf("%016x", rand.Uint64())
+}
+
+// validRequestID reports whether a client-supplied request ID is safe to log
+// and echo back: short, and only letters, digits, '-', '_' and '.'
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestID returns a context carrying a request ID
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// requestIDFrom returns the context's request ID, or "" if it has none
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// contextHandler adds the request ID and current span from the record's
+// context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle implements slog.Handler
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if id := requestIDFrom(ctx); id != "" {
+			r.AddAttrs(slog.String("request_id", id))
+		}
+		if s := spanFrom(ctx); s != nil {
+			r.AddAttrs(slog.String("trace_id", s.TraceID()), slog.String("span_id", s.SpanID()))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup implements slog.Handler
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// newLogHandler builds the log handler from LOG_FORMAT (text or json,
+// default text) and LOG_LEVEL (debug, info, warn or error, default info)
+func newLogHandler(w io.Writer, format, level string) slog.Handler {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		lvl = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	if strings.EqualFold(format, "json") {
+		return contextHandler{slog.NewJSONHandler(w, opts)}
+	}
+	return contextHandler{slog.NewTextHandler(w, opts)}
+}
+
+// setupLogging installs the default logger. Output from the standard log
+// package is routed through it too.
+func setupLogging() {
+	slog.SetDefault(slog.New(newLogHandler(os.Stderr, os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"))))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureLogs sends debug-level JSON logs to a buffer for the duration of a test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	saved := slog.Default()
+	slog.SetDefault(slog.New(newLogHandler(&buf, "json", "debug")))
+	t.Cleanup(func() { slog.SetDefault(saved) })
+	return &buf
+}
+
+// logRecords parses captured JSON log lines with the given message
+func logRecords(t *testing.T, buf *bytes.Buffer, msg string) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	scanner := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	for scanner.Scan() {
+		var rec map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("Invalid log line %q: %v", scanner.Text(), err)
+		}
+		if rec["msg"] == msg {
+			records = append(records, rec)
+		}
+	}
+	return records
+}
+
+func TestValidRequestID(t *testing.T) {
+	for id, want := range map[string]bool{
+		"abc-123_x.y":           true,
+		"":                      false,
+		"has space":             false,
+		"new\nline":             false,
+		strings.Repeat("a", 65): false,
+	} {
+		if got := validRequestID(id); got != want {
+			t.Errorf("validRequestID(%q) = %v, want %v", id, got, want)
+		}
+	}
+	if id := newRequestID(); len(id) != 16 || !validRequestID(id) {
+		t.Errorf("newRequestID() = %q", id)
+	}
+}
+
+func TestRequestIDInLogs(t *testing.T) {
+	logs := captureLogs(t)
+	p := &fakeProvider{name: "fake", data: cliSeries()}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}}, p)
+	server := NewServer("0", nil)
+
+	req := httptest.NewRequest("GET", "/api/stock/AAPL?days=30", nil)
+	req.Header.Set("X-Request-ID", "client-42")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if got := w.Header().Get("X-Request-ID"); got != "client-42" {
+		t.Errorf("X-Request-ID = %q, want the client's ID", got)
+	}
+
+	access := logRecords(t, logs, "HTTP request")
+	if len(access) != 1 || access[0]["request_id"] != "client-42" || access[0]["status"] != 200.0 || access[0]["trace_id"] == nil {
+		t.Fatalf("Unexpected access log: %v", access)
+	}
+	fetches := logRecords(t, logs, "Provider fetch")
+	if len(fetches) != 1 || fetches[0]["request_id"] != "client-42" || fetches[0]["provider"] != "fake" {
+		t.Errorf("Provider log should carry the request ID: %v", fetches)
+	}
+
+	// Unsafe IDs are replaced rather than echoed
+	req = httptest.NewRequest("GET", "/api/health", nil)
+	req.Header.Set("X-Request-ID", "bad id")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if got := w.Header().Get("X-Request-ID"); got == "bad id" || len(got) != 16 {
+		t.Errorf("X-Request-ID = %q, want a generated ID", got)
+	}
+}
+
+func TestCacheWriteErrorsLogged(t *testing.T) {
+	logs := captureLogs(t)
+	p := &fakeProvider{name: "fake", data: cliSeries()}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}}, p)
+	cache := newTestCache(t)
+	_ = cache.db.Close()
+
+	ctx := withRequestID(t.Context(), "req-1")
+	result, err := fetchStockData(ctx, cache, "AAPL", 30)
+	if err != nil || len(result.Data) != 10 {
+		t.Fatalf("Provider data should be served despite cache errors: %v", err)
+	}
+
+	failed := logRecords(t, logs, "Cache store failed")
+	if len(failed) != 1 || failed[0]["request_id"] != "req-1" || failed[0]["symbol"] != "AAPL" || failed[0]["error"] == nil {
+		t.Errorf("Unexpected store failure logs: %v", failed)
+	}
+	if len(logRecords(t, logs, "Fetch log update failed")) != 1 {
+		t.Error("Fetch log failure not logged")
+	}
+}
@@ -1,12 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
-	"time"
 )
 
 // MacrotrendsFetcher fetches fundamental data from macrotrends.net
@@ -17,9 +18,7 @@ type MacrotrendsFetcher struct {
 // NewMacrotrendsFetcher creates a new Macrotrends fetcher
 func NewMacrotrendsFetcher() *MacrotrendsFetcher {
 	return &MacrotrendsFetcher{
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		client: newUpstreamClient(),
 	}
 }
 
@@ -52,11 +51,11 @@ type FundamentalData struct {
 }
 
 // getCompanySlug tries to find the macrotrends URL slug for a symbol
-func (f *MacrotrendsFetcher) getCompanySlug(symbol string) (string, error) {
+func (f *MacrotrendsFetcher) getCompanySlug(ctx context.Context, symbol string) (string, error) {
 	// Search for the company
 	searchURL := fmt.Sprintf("https://www.macrotrends.net/production/stocks/desktop/ticker_search_list.php?q=%s", symbol)
 
-	req, err := http.NewRequest("GET", searchURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", searchURL, nil)
 	if err != nil {
 		return "", err
 	}
@@ -71,7 +70,7 @@ func (f *MacrotrendsFetcher) getCompanySlug(symbol string) (string, error) {
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("search returned status %d", resp.StatusCode)
+		return "", newStatusError("search", resp, nil)
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -86,11 +85,11 @@ func (f *MacrotrendsFetcher) getCompanySlug(symbol string) (string, error) {
 	}
 
 	if err := json.Unmarshal(body, &results); err != nil {
-		return "", fmt.Errorf("failed to parse search results: %w", err)
+		return "", fmt.Errorf("%w: search results: %w", ErrParse, err)
 	}
 
 	if len(results) == 0 {
-		return "", fmt.Errorf("no results found for symbol %s", symbol)
+		return "", fmt.Errorf("%w %s: no search results", ErrUnknownSymbol, symbol)
 	}
 
 	// Find exact match only - don't fall back to first result
@@ -102,20 +101,20 @@ func (f *MacrotrendsFetcher) getCompanySlug(symbol string) (string, error) {
 	}
 
 	// No exact match found
-	return "", fmt.Errorf("symbol %s not found on macrotrends (may be an ETF or unsupported stock)", symbol)
+	return "", fmt.Errorf("%w %s: not on macrotrends (may be an ETF or unsupported stock)", ErrUnknownSymbol, symbol)
 }
 
 // FetchPERatio fetches P/E ratio data for a symbol
-func (f *MacrotrendsFetcher) FetchPERatio(symbol string) (*FundamentalData, error) {
+func (f *MacrotrendsFetcher) FetchPERatio(ctx context.Context, symbol string) (*FundamentalData, error) {
 	// Get company slug
-	slug, err := f.getCompanySlug(symbol)
+	slug, err := f.getCompanySlug(ctx, symbol)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find company: %w", err)
 	}
 
 	parts := strings.Split(slug, "/")
 	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid slug format: %s", slug)
+		return nil, fmt.Errorf("%w: invalid slug %s", ErrParse, slug)
 	}
 	ticker := parts[0]
 	companySlug := parts[1]
@@ -123,7 +122,7 @@ func (f *MacrotrendsFetcher) FetchPERatio(symbol string) (*FundamentalData, erro
 	// Fetch the iframe with chart data
 	iframeURL := fmt.Sprintf("https://www.macrotrends.net/production/stocks/desktop/fundamental_iframe.php?t=%s&type=pe-ratio&statement=price-ratios&freq=Q&sub=", ticker)
 
-	req, err := http.NewRequest("GET", iframeURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", iframeURL, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -138,7 +137,7 @@ func (f *MacrotrendsFetcher) FetchPERatio(symbol string) (*FundamentalData, erro
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("iframe returned status %d", resp.StatusCode)
+		return nil, newStatusError("iframe", resp, nil)
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -151,7 +150,7 @@ func (f *MacrotrendsFetcher) FetchPERatio(symbol string) (*FundamentalData, erro
 	startMarker := "var chartData = "
 	startIdx := strings.Index(bodyStr, startMarker)
 	if startIdx == -1 {
-		return nil, fmt.Errorf("could not find chart data in response")
+		return nil, fmt.Errorf("%w: could not find chart data", ErrParse)
 	}
 	startIdx += len(startMarker)
 
@@ -171,18 +170,18 @@ func (f *MacrotrendsFetcher) FetchPERatio(symbol string) (*FundamentalData, erro
 		}
 	}
 	if endIdx == -1 {
-		return nil, fmt.Errorf("could not find end of chart data")
+		return nil, fmt.Errorf("%w: could not find end of chart data", ErrParse)
 	}
 
 	jsonData := subStr[:endIdx]
 
 	var peData []PERatioData
 	if err := json.Unmarshal([]byte(jsonData), &peData); err != nil {
-		return nil, fmt.Errorf("failed to parse P/E data: %w", err)
+		return nil, fmt.Errorf("%w: P/E data: %w", ErrParse, err)
 	}
 
 	if len(peData) == 0 {
-		return nil, fmt.Errorf("no P/E data found")
+		return nil, fmt.Errorf("%w: empty P/E chart", ErrNoData)
 	}
 
 	// Get latest data point
@@ -199,16 +198,16 @@ func (f *MacrotrendsFetcher) FetchPERatio(symbol string) (*FundamentalData, erro
 }
 
 // FetchDailyPrices fetches daily stock prices from macrotrends
-func (f *MacrotrendsFetcher) FetchDailyPrices(symbol string, days int) ([]DailyPriceData, error) {
+func (f *MacrotrendsFetcher) FetchDailyPrices(ctx context.Context, symbol string, days int) ([]DailyPriceData, error) {
 	// Get company slug
-	slug, err := f.getCompanySlug(symbol)
+	slug, err := f.getCompanySlug(ctx, symbol)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find company: %w", err)
 	}
 
 	parts := strings.Split(slug, "/")
 	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid slug format: %s", slug)
+		return nil, fmt.Errorf("%w: invalid slug %s", ErrParse, slug)
 	}
 	ticker := parts[0]
 	companySlug := parts[1]
@@ -216,7 +215,7 @@ func (f *MacrotrendsFetcher) FetchDailyPrices(symbol string, days int) ([]DailyP
 	// Fetch the stock price history iframe
 	iframeURL := fmt.Sprintf("https://www.macrotrends.net/production/stocks/desktop/stock_price_history.php?t=%s", ticker)
 
-	req, err := http.NewRequest("GET", iframeURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", iframeURL, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -231,7 +230,7 @@ func (f *MacrotrendsFetcher) FetchDailyPrices(symbol string, days int) ([]DailyP
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("price history returned status %d", resp.StatusCode)
+		return nil, newStatusError("price history", resp, nil)
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -244,7 +243,7 @@ func (f *MacrotrendsFetcher) FetchDailyPrices(symbol string, days int) ([]DailyP
 	startMarker := "var dataDaily = "
 	startIdx := strings.Index(bodyStr, startMarker)
 	if startIdx == -1 {
-		return nil, fmt.Errorf("could not find daily price data in response")
+		return nil, fmt.Errorf("%w: could not find daily price data", ErrParse)
 	}
 	startIdx += len(startMarker)
 
@@ -264,18 +263,18 @@ func (f *MacrotrendsFetcher) FetchDailyPrices(symbol string, days int) ([]DailyP
 		}
 	}
 	if endIdx == -1 {
-		return nil, fmt.Errorf("could not find end of daily price data")
+		return nil, fmt.Errorf("%w: could not find end of daily price data", ErrParse)
 	}
 
 	jsonData := subStr[:endIdx]
 
 	var allData []DailyPriceData
 	if err := json.Unmarshal([]byte(jsonData), &allData); err != nil {
-		return nil, fmt.Errorf("failed to parse daily price data: %w", err)
+		return nil, fmt.Errorf("%w: daily price data: %w", ErrParse, err)
 	}
 
 	if len(allData) == 0 {
-		return nil, fmt.Errorf("no daily price data found")
+		return nil, fmt.Errorf("%w: empty daily price chart", ErrNoData)
 	}
 
 	// Return only the last N days
@@ -321,3 +320,80 @@ func (data *FundamentalData) GetEPSForDate(date string) float64 {
 	}
 	return eps
 }
+
+// Name implements Provider
+func (f *MacrotrendsFetcher) Name() string {
+	return "macrotrends"
+}
+
+// QuoteURL implements Provider
+func (f *MacrotrendsFetcher) QuoteURL(symbol, companyName string) string {
+	slug := companyName
+	if slug == "" {
+		slug = strings.ToLower(symbol)
+	}
+	return fmt.Sprintf("https://www.macrotrends.net/stocks/charts/%s/%s/stock-price-history", strings.ToUpper(symbol), slug)
+}
+
+// Fetch implements Provider, returning daily prices with historical P/E
+func (f *MacrotrendsFetcher) Fetch(ctx context.Context, symbol string, days int) (*FetchResult, error) {
+	peData, err := f.FetchPERatio(ctx, symbol)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch P/E data: %w", err)
+	}
+
+	prices, err := f.FetchDailyPrices(ctx, symbol, days)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch price data: %w", err)
+	}
+
+	var data []StockData
+	var prevClose, prevHigh float64
+
+	for _, p := range prices {
+		close, _ := strconv.ParseFloat(p.Close, 64)
+		open, _ := strconv.ParseFloat(p.Open, 64)
+		high, _ := strconv.ParseFloat(p.High, 64)
+		low, _ := strconv.ParseFloat(p.Low, 64)
+
+		change := ""
+		if prevClose > 0 {
+			pctChange := ((close - prevClose) / prevClose) * 100
+			change = fmt.Sprintf("%.2f%%", pctChange)
+		}
+
+		hchange := ""
+		if prevHigh > 0 {
+			pctHChange := ((close - prevHigh) / prevHigh) * 100
+			hchange = fmt.Sprintf("%.2f%%", pctHChange)
+		}
+
+		pe := ""
+		historicalEPS := peData.GetEPSForDate(p.Date)
+		if historicalEPS > 0 {
+			pe = fmt.Sprintf("%.2f", close/historicalEPS)
+		}
+
+		data = append(data, StockData{
+			Date:    p.Date,
+			Open:    fmt.Sprintf("%.2f", open),
+			High:    fmt.Sprintf("%.2f", high),
+			Low:     fmt.Sprintf("%.2f", low),
+			Close:   fmt.Sprintf("%.2f", close),
+			Volume:  p.Volume + "M",
+			Change:  change,
+			HChange: hchange,
+			PE:      pe,
+		})
+
+		prevClose = close
+		prevHigh = high
+	}
+
+	return &FetchResult{
+		Data:        reverseData(data),
+		TTMEPS:      peData.GetLatestTTM_EPS(),
+		CompanyName: peData.CompanyName,
+		IncludePE:   true,
+	}, nil
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
-	"strconv"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -19,6 +22,8 @@ type StockData struct {
 	Change  string `json:"change"`
 	HChange string `json:"hchange"`
 	PE      string `json:"pe,omitempty"`
+
+	AdjClose string `json:"adj_close,omitempty"` // Split/dividend-adjusted close, when the provider supplies it
 }
 
 // isHKStock checks if the symbol is a Hong Kong stock
@@ -35,80 +40,25 @@ func reverseData(data []StockData) []StockData {
 	return result
 }
 
-// fetchUSStock fetches US stock data from macrotrends (with P/E)
-func fetchUSStock(symbol string, days int) ([]StockData, float64, string, error) {
-	fetcher := NewMacrotrendsFetcher()
-
-	peData, err := fetcher.FetchPERatio(symbol)
-	if err != nil {
-		return nil, 0, "", fmt.Errorf("failed to fetch P/E data: %w", err)
-	}
-	latestEPS := peData.GetLatestTTM_EPS()
-	companyName := peData.CompanyName
-
-	prices, err := fetcher.FetchDailyPrices(symbol, days)
-	if err != nil {
-		return nil, 0, "", fmt.Errorf("failed to fetch price data: %w", err)
-	}
-
-	var data []StockData
+// computeChanges fills Change and HChange for data sorted oldest-first
+func computeChanges(data []StockData) {
 	var prevClose, prevHigh float64
+	for i := range data {
+		close := parseFloat(data[i].Close)
+		high := parseFloat(data[i].High)
 
-	for _, p := range prices {
-		close, _ := strconv.ParseFloat(p.Close, 64)
-		open, _ := strconv.ParseFloat(p.Open, 64)
-		high, _ := strconv.ParseFloat(p.High, 64)
-		low, _ := strconv.ParseFloat(p.Low, 64)
-
-		change := ""
+		data[i].Change = ""
 		if prevClose > 0 {
-			pctChange := ((close - prevClose) / prevClose) * 100
-			change = fmt.Sprintf("%.2f%%", pctChange)
+			data[i].Change = fmt.Sprintf("%.2f%%", ((close-prevClose)/prevClose)*100)
 		}
-
-		hchange := ""
+		data[i].HChange = ""
 		if prevHigh > 0 {
-			pctHChange := ((close - prevHigh) / prevHigh) * 100
-			hchange = fmt.Sprintf("%.2f%%", pctHChange)
-		}
-
-		pe := ""
-		historicalEPS := peData.GetEPSForDate(p.Date)
-		if historicalEPS > 0 {
-			pe = fmt.Sprintf("%.2f", close/historicalEPS)
-		}
-
-		data = append(data, StockData{
-			Date:    p.Date,
-			Open:    fmt.Sprintf("%.2f", open),
-			High:    fmt.Sprintf("%.2f", high),
-			Low:     fmt.Sprintf("%.2f", low),
-			Close:   fmt.Sprintf("%.2f", close),
-			Volume:  p.Volume + "M",
-			Change:  change,
-			HChange: hchange,
-			PE:      pe,
-		})
+			data[i].HChange = fmt.Sprintf("%.2f%%", ((close-prevHigh)/prevHigh)*100)
+		}
 
 		prevClose = close
 		prevHigh = high
 	}
-
-	return reverseData(data), latestEPS, companyName, nil
-}
-
-// fetchHKStock fetches HK stock data from Yahoo (no P/E)
-func fetchHKStock(symbol string, days int) ([]StockData, string, error) {
-	fetcher := NewYahooFetcher()
-	endDate := time.Now()
-	startDate := endDate.AddDate(0, 0, -days)
-
-	yahooData, companyName, err := fetcher.FetchHistoricalData(symbol, startDate, endDate)
-	if err != nil {
-		return nil, "", err
-	}
-
-	return reverseData(yahooData), companyName, nil
 }
 
 // formatCompanyName formats the company slug for display
@@ -126,52 +76,82 @@ func formatCompanyName(slug string) string {
 	return strings.Join(words, " ")
 }
 
-// fetchFromProvider fetches stock data directly from the upstream provider
-func fetchFromProvider(symbol string, days int, useYahoo bool) ([]StockData, float64, string, bool, error) {
-	var data []StockData
-	var companyName string
-	var ttmEPS float64
-	var err error
-	includePE := false
-
-	if useYahoo {
-		data, companyName, err = fetchHKStock(symbol, days)
-	} else {
-		data, ttmEPS, companyName, err = fetchUSStock(symbol, days)
-		if err != nil {
-			// Fallback to Yahoo Finance for ETFs or unsupported stocks
-			data, companyName, err = fetchHKStock(symbol, days)
-		} else {
-			includePE = true
+// hasPE reports whether any row carries a P/E value
+func hasPE(data []StockData) bool {
+	for _, d := range data {
+		if d.PE != "" {
+			return true
 		}
 	}
+	return false
+}
 
-	return data, ttmEPS, companyName, includePE, err
+// cachedResult builds a FetchResult from cached rows and their fetch metadata.
+// Macrotrends always has a P/E column, blank while earnings are negative;
+// other sources have one when their rows carry P/E.
+func cachedResult(meta *FetchMeta, data []StockData, events []CorporateEvent) *FetchResult {
+	return &FetchResult{
+		Data:        data,
+		TTMEPS:      meta.TTMEPS,
+		CompanyName: meta.CompanyName,
+		IncludePE:   meta.Source == "macrotrends" || hasPE(data),
+		Source:      meta.Source,
+		Events:      events,
+	}
 }
 
 // fetchStockData fetches stock data, using cache when available.
 // The cache stores raw OHLCV+PE; Change/HChange are recomputed on read.
-func fetchStockData(cache *Cache, symbol string, days int, useYahoo bool) ([]StockData, float64, string, bool, error) {
+func fetchStockData(ctx context.Context, cache *Cache, symbol string, days int) (*FetchResult, error) {
+	return loadStockData(ctx, cache, symbol, days, false)
+}
+
+// refreshStockData is fetchStockData without the same-day cache hit, so data
+// first fetched before the market closed picks up the closing prices
+func refreshStockData(ctx context.Context, cache *Cache, symbol string, days int) (*FetchResult, error) {
+	return loadStockData(ctx, cache, symbol, days, true)
+}
+
+// loadStockData implements fetchStockData; force skips the fresh-cache shortcut
+func loadStockData(ctx context.Context, cache *Cache, symbol string, days int, force bool) (result *FetchResult, err error) {
 	symbolUpper := strings.ToUpper(symbol)
 	startDate := time.Now().AddDate(0, 0, -days).Format("2006-01-02")
 	today := time.Now().Format("2006-01-02")
 
+	ctx, span := startSpan(ctx, "fetchStockData",
+		slog.String("symbol", symbolUpper), slog.Int("days", days), slog.Bool("force", force))
+	defer func() { span.End(err) }()
+	// record counts the cache outcome and tags the span with it
+	record := func(lookup string) {
+		cacheLookups.Inc(lookup)
+		span.SetAttr("cache.result", lookup)
+	}
+
 	if cache != nil {
-		meta, _ := cache.GetFetchMeta(symbolUpper)
+		// Local reads are cheap, so they ignore cancellation: a request
+		// that has run out of time can still be answered from the cache
+		readCtx := context.WithoutCancel(ctx)
+		meta, err := cache.GetFetchMeta(readCtx, symbolUpper)
+		if err != nil {
+			slog.WarnContext(ctx, "Cache lookup failed", "symbol", symbolUpper, "error", err)
+		}
 
 		// Cache hit: fresh today and covers the requested range
-		if meta != nil && meta.IsFresh() && meta.CoversRange(startDate) {
-			data, err := cache.GetDailyPrices(symbolUpper, startDate, today)
+		if !force && meta != nil && meta.IsFresh() && meta.CoversRange(startDate) {
+			data, err := cache.GetDailyPrices(readCtx, symbolUpper, startDate, today)
 			if err == nil && len(data) > 0 {
-				includePE := meta.Source == "macrotrends"
-				return data, meta.TTMEPS, meta.CompanyName, includePE, nil
+				events, _ := cache.GetEvents(readCtx, symbolUpper, startDate, today)
+				record("hit")
+				return cachedResult(meta, data, events), nil
 			}
 		}
 
 		// Cache stale or doesn't cover range — fetch from provider
 		// If we have some cached data, fetch only the delta
 		fetchDays := days
+		lookup := "miss"
 		if meta != nil && meta.CoversRange(startDate) {
+			lookup = "refresh"
 			// We have the range but it's stale — just fetch recent delta
 			daysSinceLatest := int(time.Since(meta.LastFetched).Hours()/24) + 5
 			if daysSinceLatest < fetchDays {
@@ -179,61 +159,186 @@ func fetchStockData(cache *Cache, symbol string, days int, useYahoo bool) ([]Sto
 			}
 		}
 
-		data, ttmEPS, companyName, includePE, err := fetchFromProvider(symbol, fetchDays, useYahoo)
+		// Requests missing the same symbol at once share one fetch. Once
+		// stored, the cache covers from the requested start, or from the
+		// earliest cached date when only the delta is fetched.
+		from := startDate
+		if lookup == "refresh" {
+			from = meta.EarliestDate
+		}
+		// Once ctx is done only the upstream fetch is skipped
+		var result *FetchResult
+		var shared bool
+		if err = ctx.Err(); err == nil {
+			result, shared, err = symbolFlights.do(ctx, symbolUpper, from, func(ctx context.Context) (*FetchResult, error) {
+				return fetchAndStore(ctx, cache, symbol, meta, fetchDays, days)
+			})
+		}
+		if shared {
+			fetchesCoalesced.Inc()
+			span.SetAttr("fetch.shared", true)
+		}
 		if err != nil {
-			// Provider failed — try serving stale cache if available
+			// Provider failed or was skipped — try serving stale cache if available
 			if meta != nil {
-				staleData, cacheErr := cache.GetDailyPrices(symbolUpper, startDate, today)
+				staleData, cacheErr := cache.GetDailyPrices(readCtx, symbolUpper, startDate, today)
 				if cacheErr == nil && len(staleData) > 0 {
-					incPE := meta.Source == "macrotrends"
-					return staleData, meta.TTMEPS, meta.CompanyName, incPE, nil
+					events, _ := cache.GetEvents(readCtx, symbolUpper, startDate, today)
+					record("stale")
+					slog.WarnContext(ctx, "Serving stale cache after provider failure",
+						"symbol", symbolUpper, "last_fetched", meta.LastFetched.Format(time.RFC3339), "error", err)
+					return cachedResult(meta, staleData, events), nil
 				}
 			}
-			return nil, 0, "", false, err
+			record("failed")
+			return nil, err
+		}
+		record(lookup)
+
+		// Serve full range from cache (includes old + new data),
+		// otherwise fall back to the provider data directly
+		served := *result
+		cachedData, cacheErr := cache.GetDailyPrices(readCtx, symbolUpper, startDate, today)
+		if cacheErr == nil && len(cachedData) > 0 {
+			served.Data = cachedData
+		} else {
+			// Callers compute changes in place, and every caller of the
+			// flight holds the same result
+			served.Data = slices.Clone(result.Data)
 		}
+		if events, err := cache.GetEvents(readCtx, symbolUpper, startDate, today); err == nil {
+			served.Events = events
+		}
+		return &served, nil
+	}
 
-		// Store new data in cache
-		if len(data) > 0 {
-			_ = cache.StoreDailyPrices(symbolUpper, data)
+	// No cache — fetch directly from provider
+	record("disabled")
+	return fetchFromProvider(ctx, symbol, days)
+}
+
+// batchFetchWorkers bounds concurrent upstream fetches for multi-symbol requests
+const batchFetchWorkers = 4
+
+// fetchAndStore fetches fetchDays of data for a cache miss and stores it.
+// meta is the symbol's fetch log entry before the fetch, if any; days is the
+// requested range, which decides whether a corporate event warrants a full
+// refresh.
+func fetchAndStore(ctx context.Context, cache *Cache, symbol string, meta *FetchMeta, fetchDays, days int) (*FetchResult, error) {
+	symbolUpper := strings.ToUpper(symbol)
+	result, err := fetchFromProvider(ctx, symbol, fetchDays)
+	if err != nil {
+		return nil, err
+	}
 
-			source := "yahoo"
-			if includePE {
-				source = "macrotrends"
+	// A new dividend or split rewrites every historical adjusted close,
+	// so refresh the whole cached range rather than just the delta
+	if meta != nil && fetchDays < days && hasEventsAfter(result.Events, meta.LatestDate) {
+		if earliest, err := time.Parse("2006-01-02", meta.EarliestDate); err == nil {
+			fullDays := int(time.Since(earliest).Hours()/24) + 1
+			full, err := fetchFromProvider(ctx, symbol, fullDays)
+			if err != nil {
+				slog.WarnContext(ctx, "Full refresh after corporate event failed", "symbol", symbolUpper, "error", err)
+			} else {
+				result = full
 			}
+		}
+	}
 
-			// Determine date range in cache
-			earliestDate := data[len(data)-1].Date // data is newest-first
-			latestDate := data[0].Date
-			if meta != nil && meta.EarliestDate < earliestDate {
-				earliestDate = meta.EarliestDate
+	// Store new data in cache. A failed write still serves the fetched
+	// data, but the next request will fetch it again. Writes ignore
+	// cancellation: the data has already been paid for.
+	ctx = context.WithoutCancel(ctx)
+	data := result.Data
+	if len(data) > 0 {
+		if err := cache.StoreDailyPrices(ctx, symbolUpper, data); err != nil {
+			slog.ErrorContext(ctx, "Cache store failed", "symbol", symbolUpper, "rows", len(data), "error", err)
+		}
+		if len(result.Events) > 0 {
+			if err := cache.StoreEvents(ctx, symbolUpper, result.Events); err != nil {
+				slog.ErrorContext(ctx, "Cache store failed", "symbol", symbolUpper, "events", len(result.Events), "error", err)
 			}
+		}
 
-			_ = cache.UpdateFetchLog(FetchMeta{
-				Symbol:       symbolUpper,
-				Source:       source,
-				CompanyName:  companyName,
-				TTMEPS:       ttmEPS,
-				LastFetched:  time.Now(),
-				LatestDate:   latestDate,
-				EarliestDate: earliestDate,
-			})
+		// Determine date range in cache
+		earliestDate := data[len(data)-1].Date // data is newest-first
+		latestDate := data[0].Date
+		if meta != nil && meta.EarliestDate < earliestDate {
+			earliestDate = meta.EarliestDate
 		}
 
-		// Serve full range from cache (includes old + new data)
-		cachedData, cacheErr := cache.GetDailyPrices(symbolUpper, startDate, today)
-		if cacheErr == nil && len(cachedData) > 0 {
-			return cachedData, ttmEPS, companyName, includePE, nil
+		err := cache.UpdateFetchLog(ctx, FetchMeta{
+			Symbol:       symbolUpper,
+			Source:       result.Source,
+			CompanyName:  result.CompanyName,
+			TTMEPS:       result.TTMEPS,
+			LastFetched:  time.Now(),
+			LatestDate:   latestDate,
+			EarliestDate: earliestDate,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Fetch log update failed", "symbol", symbolUpper, "error", err)
 		}
+	}
+	return result, nil
+}
+
+// fetchStockDataBatch fetches several symbols with a bounded worker pool.
+// Results and errors are indexed like symbols. Upstream fetches stop short
+// of ctx's deadline to leave time for the response; symbols that weren't
+// fetched by then are served from the cache if possible and otherwise fail
+// and are fetched in the background.
+func fetchStockDataBatch(ctx context.Context, cache *Cache, symbols []string, days int) ([]*FetchResult, []error) {
+	fetchCtx, cancel := batchFetchContext(ctx)
+	defer cancel()
+	results, errs := fetchBatch(symbols, func(symbol string) (*FetchResult, error) {
+		return fetchStockData(fetchCtx, cache, symbol, days)
+	})
+	warmSkipped(fetchCtx, cache, symbols, days, errs)
+	return results, errs
+}
 
-		// Fallback: return provider data directly
-		return data, ttmEPS, companyName, includePE, nil
+// fetchBatch runs fetch for every symbol with batchFetchWorkers workers.
+// Results and errors are indexed like symbols. Every symbol is attempted
+// even after the caller's context is done, so those that can be served from
+// the cache still are; fetch is expected to skip upstream requests itself.
+func fetchBatch(symbols []string, fetch func(symbol string) (*FetchResult, error)) ([]*FetchResult, []error) {
+	results := make([]*FetchResult, len(symbols))
+	errs := make([]error, len(symbols))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(batchFetchWorkers, len(symbols)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], errs[i] = fetch(symbols[i])
+			}
+		}()
 	}
+	for i := range symbols {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
 
-	// No cache — fetch directly from provider
-	return fetchFromProvider(symbol, days, useYahoo)
+	return results, errs
 }
 
 func main() {
+	setupLogging()
+	setupTracing()
+
+	// Subcommands (fetch, backfill, cache, serve); no arguments starts the server
+	if len(os.Args) > 1 {
+		code := runCommand(os.Args[1:], os.Stdout, os.Stderr)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		flushTraces(ctx)
+		cancel()
+		os.Exit(code)
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics are exposed at /metrics in the Prometheus text format. The format
+// is simple enough that a client library isn't worth the dependency.
+
+// latencyBuckets are histogram upper bounds in seconds, covering cache hits
+// (~20ms) through cold multi-provider fetches (~10s+)
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metricFamily is a metric that can write itself in the text format
+type metricFamily interface {
+	write(w io.Writer)
+}
+
+// registeredMetrics are written by /metrics in registration order
+var registeredMetrics []metricFamily
+
+// counterVec is a counter with labels
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64 // Keyed by joined label values
+}
+
+// newCounterVec creates and registers a counter
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+	registeredMetrics = append(registeredMetrics, c)
+	return c
+}
+
+// Inc adds one to the series with the given label values
+func (c *counterVec) Inc(values ...string) {
+	key := strings.Join(values, "\xff")
+	c.mu.Lock()
+	c.values[key]++
+	c.mu.Unlock()
+}
+
+// Value returns the current count of a series
+func (c *counterVec) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[strings.Join(values, "\xff")]
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, key, ""), formatMetricValue(c.values[key]))
+	}
+}
+
+// histogramVec is a histogram with labels
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+// histogram holds cumulative counts per bucket
+type histogram struct {
+	counts []uint64 // Indexed like buckets
+	count  uint64
+	sum    float64
+}
+
+// newHistogramVec creates and registers a histogram
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogram{}}
+	registeredMetrics = append(registeredMetrics, h)
+	return h
+}
+
+// Observe records a value in the series with the given label values
+func (h *histogramVec) Observe(v float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// Count returns the number of observations in a series
+func (h *histogramVec) Count(values ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[strings.Join(values, "\xff")]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		for i, upper := range h.buckets {
+			le := `le="` + formatMetricValue(upper) + `"`
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, le), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, `le="+Inf"`), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key, ""), formatMetricValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, key, ""), s.count)
+	}
+}
+
+// sortedKeys returns map keys in order, for stable output
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// formatLabels renders {name="value",...} from a joined series key, with an
+// optional extra pair such as le="0.5"
+func formatLabels(names []string, key, extra string) string {
+	var pairs []string
+	if len(names) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, names[i]+`="`+escapeLabel(v)+`"`)
+		}
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escapeLabel escapes a label value for the text format
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// writeGauge writes a single unlabelled gauge
+func writeGauge(w io.Writer, name, help string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatMetricValue(v))
+}
+
+var (
+	httpRequests = newCounterVec("stockfetcher_http_requests_total",
+		"HTTP requests by route, method and status code.", "route", "method", "code")
+	httpDuration = newHistogramVec("stockfetcher_http_request_duration_seconds",
+		"HTTP request latency by route.", latencyBuckets, "route", "method")
+
+	// Results: hit (fresh cache), refresh (stale, delta fetched), miss (range
+	// not cached, full fetch), stale (provider failed, stale rows served),
+	// failed (provider failed, nothing cached), disabled (no cache)
+	cacheLookups = newCounterVec("stockfetcher_cache_lookups_total",
+		"Stock data cache lookups by result.", "result")
+	fetchesCoalesced = newCounterVec("stockfetcher_coalesced_fetches_total",
+		"Cache misses served by a provider fetch another request had already started.")
+
+	providerFetches = newCounterVec("stockfetcher_provider_fetches_total",
+		"Provider fetches by provider and result (ok or an error class).", "provider", "result")
+	providerDuration = newHistogramVec("stockfetcher_provider_fetch_duration_seconds",
+		"Provider fetch latency, including every upstream request it makes.", latencyBuckets, "provider")
+	providerFallbacks = newCounterVec("stockfetcher_provider_fallbacks_total",
+		"Fetches served by a later provider in the chain after the first one failed.", "from", "to")
+
+	upstreamRequests = newCounterVec("stockfetcher_upstream_requests_total",
+		"Upstream HTTP requests by host and status (2xx, 3xx, 4xx, 429, 5xx, timeout or error).", "host", "status")
+	upstreamDuration = newHistogramVec("stockfetcher_upstream_request_duration_seconds",
+		"Upstream HTTP request latency by host.", latencyBuckets, "host")
+	upstreamRetries = newCounterVec("stockfetcher_upstream_retries_total",
+		"Upstream HTTP requests retried, by host and the status code (or error) that caused it.", "host", "reason")
+	upstreamRejected = newCounterVec("stockfetcher_upstream_rejected_total",
+		"Upstream HTTP requests failed without being sent because the host's circuit was open.", "host")
+)
+
+// classifyFetchError maps a provider error to a small set of metric classes
+func classifyFetchError(err error) string {
+	var netErr net.Error
+	var statusErr *StatusError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, errCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, ErrUpstreamThrottled):
+		return "rate_limited"
+	case errors.As(err, &statusErr) && statusErr.Code >= 500:
+		return "http_5xx"
+	case errors.As(err, &statusErr) && statusErr.Code >= 400:
+		return "http_4xx"
+	case errors.Is(err, ErrUnknownSymbol), errors.Is(err, ErrNoData):
+		return "not_found"
+	case errors.Is(err, ErrParse):
+		return "parse"
+	default:
+		return "error"
+	}
+}
+
+// metricsTransport records upstream HTTP request counts and latencies, and a
+// client span per request
+type metricsTransport struct {
+	base http.RoundTripper // nil means http.DefaultTransport
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	host := req.URL.Hostname()
+	_, span := startSpanKind(req.Context(), spanKindClient, req.Method+" "+host,
+		slog.String("http.request.method", req.Method), slog.String("server.address", host), slog.String("url.path", req.URL.Path))
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	upstreamDuration.Observe(time.Since(start).Seconds(), host)
+	spanErr := err
+	if err == nil {
+		span.SetAttr("http.response.status_code", resp.StatusCode)
+		if resp.StatusCode >= 400 {
+			spanErr = fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+	}
+	span.End(spanErr)
+
+	var netErr net.Error
+	switch {
+	case err != nil && (errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())):
+		upstreamRequests.Inc(host, "timeout")
+	case err != nil:
+		upstreamRequests.Inc(host, "error")
+	case resp.StatusCode == http.StatusTooManyRequests:
+		upstreamRequests.Inc(host, "429")
+	default:
+		upstreamRequests.Inc(host, fmt.Sprintf("%dxx", resp.StatusCode/100))
+	}
+	return resp, err
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush keeps streaming responses (NDJSON) working through the recorder
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// instrument wraps a handler with request count and latency metrics labelled
+// by its route pattern, so path parameters don't create new series
+func instrument(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		httpRequests.Inc(route, r.Method, strconv.Itoa(rec.status))
+		httpDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+	})
+}
+
+// handleMetrics writes every metric in the Prometheus text format
+// GET /metrics
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range registeredMetrics {
+		m.write(w)
+	}
+
+	fmt.Fprintf(w, "# HELP stockfetcher_build_info Build version and commit.\n# TYPE stockfetcher_build_info gauge\n")
+	fmt.Fprintf(w, "stockfetcher_build_info{version=\"%s\",commit=\"%s\"} 1\n", escapeLabel(Version), escapeLabel(CommitHash))
+	if s.cache != nil {
+		if size, err := s.cache.SizeBytes(r.Context()); err == nil {
+			writeGauge(w, "stockfetcher_cache_db_size_bytes", "Size of the SQLite cache database.", float64(size))
+		} else {
+			slog.WarnContext(r.Context(), "Cache size unavailable", "error", err)
+		}
+	}
+}
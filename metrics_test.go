@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsTextFormat(t *testing.T) {
+	c := &counterVec{name: "test_total", help: "Test counter.", labels: []string{"route", "code"}, values: map[string]float64{}}
+	c.Inc("/b", "200")
+	c.Inc("/a", "500")
+	c.Inc("/a", "500")
+	h := &histogramVec{name: "test_seconds", help: "Test histogram.", labels: []string{"route"}, buckets: []float64{0.1, 1}, series: map[string]*histogram{}}
+	h.Observe(0.05, `say "hi"`)
+	h.Observe(0.5, `say "hi"`)
+
+	var sb strings.Builder
+	c.write(&sb)
+	h.write(&sb)
+	want := `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{route="/a",code="500"} 2
+test_total{route="/b",code="200"} 1
+# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{route="say \"hi\"",le="0.1"} 1
+test_seconds_bucket{route="say \"hi\"",le="1"} 2
+test_seconds_bucket{route="say \"hi\"",le="+Inf"} 2
+test_seconds_sum{route="say \"hi\""} 0.55
+test_seconds_count{route="say \"hi\""} 2
+`
+	if sb.String() != want {
+		t.Errorf("Got:\n%s\nwant:\n%s", sb.String(), want)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	server := NewServer("0", newTestCache(t))
+	before := httpRequests.Value("/api/indices/", "GET", "404")
+
+	doJSON(t, server, "GET", "/api/health", "")
+	doJSON(t, server, "GET", "/api/indices/nope", "")
+	if got := httpRequests.Value("/api/indices/", "GET", "404"); got != before+1 {
+		t.Errorf("Route counter = %v, want %v (labelled by pattern, not path)", got, before+1)
+	}
+
+	w := doJSON(t, server, "GET", "/metrics", "")
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("Unexpected response %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{
+		`stockfetcher_http_requests_total{route="/api/health",method="GET",code="200"}`,
+		`stockfetcher_http_request_duration_seconds_bucket{route="/api/health",method="GET",le="+Inf"}`,
+		"# TYPE stockfetcher_cache_lookups_total counter",
+		"stockfetcher_cache_db_size_bytes ",
+		`stockfetcher_build_info{version="`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Metrics missing %q", want)
+		}
+	}
+}
+
+func TestCacheLookupMetrics(t *testing.T) {
+	p := &fakeProvider{name: "fake", data: reverseData(closes(time.Now().AddDate(0, 0, -2).Format("2006-01-02"), 100, 101))}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}}, p)
+	cache := newTestCache(t)
+
+	counts := func() [3]float64 {
+		return [3]float64{cacheLookups.Value("miss"), cacheLookups.Value("hit"), cacheLookups.Value("stale")}
+	}
+	start := counts()
+
+	_, _ = fetchStockData(t.Context(), cache, "AAPL", 1)
+	_, _ = fetchStockData(t.Context(), cache, "AAPL", 1)
+	p.err = errors.New("upstream down")
+	if _, err := refreshStockData(t.Context(), cache, "AAPL", 1); err != nil {
+		t.Fatalf("Stale data should be served: %v", err)
+	}
+
+	end := counts()
+	if end[0]-start[0] != 1 || end[1]-start[1] != 1 || end[2]-start[2] != 1 {
+		t.Errorf("Lookups miss/hit/stale went from %v to %v, want +1 each", start, end)
+	}
+}
+
+func TestProviderFallbackMetrics(t *testing.T) {
+	primary := &fakeProvider{name: "primary", err: &StatusError{Source: "iframe", Code: 503}}
+	backup := &fakeProvider{name: "backup", data: closes("2024-01-01", 100)}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"primary", "backup"}}}, primary, backup)
+
+	before := providerFallbacks.Value("primary", "backup")
+	failed := providerFetches.Value("primary", "http_5xx")
+	if _, err := fetchFromProvider(t.Context(), "AAPL", 30); err != nil {
+		t.Fatalf("fetchFromProvider: %v", err)
+	}
+	if providerFallbacks.Value("primary", "backup") != before+1 || providerFetches.Value("primary", "http_5xx") != failed+1 {
+		t.Error("Expected a primary→backup fallback and an http_5xx failure")
+	}
+	if providerDuration.Count("backup") == 0 {
+		t.Error("Expected a backup latency observation")
+	}
+}
+
+func TestClassifyFetchError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&StatusError{Source: "API", Code: 429, Body: "slow down"}, "rate_limited"},
+		{&StatusError{Source: "search", Code: 404}, "http_4xx"},
+		{fmt.Errorf("%w returned for symbol X", ErrNoData), "not_found"},
+		{fmt.Errorf("%w: unexpected EOF", ErrParse), "parse"},
+		{errors.New("request failed: connection reset by peer"), "error"},
+		{fmt.Errorf("%w: could not find chart data", ErrParse), "parse"},
+		{fmt.Errorf("%w X: not on macrotrends (ETF)", ErrUnknownSymbol), "not_found"},
+		{&StatusError{Source: "price history", Code: 502}, "http_5xx"},
+		{fmt.Errorf("host: %w: %w until 10:00:00", ErrUpstreamUnavailable, errCircuitOpen), "circuit_open"},
+		{fmt.Errorf("request failed: %w", context.DeadlineExceeded), "timeout"},
+	}
+	for _, tt := range tests {
+		if got := classifyFetchError(tt.err); got != tt.want {
+			t.Errorf("classifyFetchError(%q) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestMetricsTransport(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/limited" {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: &metricsTransport{}}
+	host := "127.0.0.1"
+	ok, limited := upstreamRequests.Value(host, "2xx"), upstreamRequests.Value(host, "429")
+	for _, path := range []string{"/ok", "/limited"} {
+		resp, err := client.Get(upstream.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		_ = resp.Body.Close()
+	}
+	if upstreamRequests.Value(host, "2xx") != ok+1 || upstreamRequests.Value(host, "429") != limited+1 {
+		t.Error("Expected one 2xx and one 429 upstream request")
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider is an upstream source of daily price bars, fundamentals and
+// company metadata. Implementations are registered by name and tried in
+// the order given by the provider chain for a symbol.
+type Provider interface {
+	// Name returns the registry key, also recorded as FetchMeta.Source
+	Name() string
+	// Fetch returns the last N days of data for a symbol. Upstream requests
+	// are made with ctx, which carries the request ID and trace.
+	Fetch(ctx context.Context, symbol string, days int) (*FetchResult, error)
+	// QuoteURL returns a human-facing page for the symbol on this provider
+	QuoteURL(symbol, companyName string) string
+}
+
+// Provider errors wrap one of these so handlers can tell a missing symbol
+// from a provider having a bad day. A chain failure joins every provider's
+// error, so it matches each kind any provider reported.
+var (
+	// ErrUnknownSymbol means the provider doesn't know the symbol
+	ErrUnknownSymbol = errors.New("unknown symbol")
+	// ErrNoData means the symbol exists but has no data for the range
+	ErrNoData = errors.New("no data")
+	// ErrUpstreamThrottled means the provider rate limited us (HTTP 429)
+	ErrUpstreamThrottled = errors.New("upstream throttled")
+	// ErrUpstreamUnavailable means the provider failed or couldn't be
+	// reached: 5xx responses, network errors and open circuits
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+	// ErrParse means the response wasn't in the expected format, e.g. the
+	// scrape markers in a macrotrends page have moved
+	ErrParse = errors.New("unexpected response format")
+)
+
+// StatusError is an unexpected HTTP status from a provider. It matches
+// ErrUpstreamThrottled for 429, ErrUnknownSymbol for 404 and
+// ErrUpstreamUnavailable for 5xx.
+type StatusError struct {
+	Source     string // What returned the status, e.g. "API" or "price history"
+	Code       int
+	Body       string        // Start of the response body, if worth reporting
+	RetryAfter time.Duration // From the Retry-After header, if any
+}
+
+// newStatusError builds a StatusError from a response, keeping up to 500
+// bytes of body
+func newStatusError(source string, resp *http.Response, body []byte) *StatusError {
+	e := &StatusError{Source: source, Code: resp.StatusCode, Body: string(body[:min(500, len(body))])}
+	e.RetryAfter, _ = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	return e
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("%s returned status %d", e.Source, e.Code)
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+// Unwrap returns the error kind for the status, if any
+func (e *StatusError) Unwrap() error {
+	switch {
+	case e.Code == http.StatusTooManyRequests:
+		return ErrUpstreamThrottled
+	case e.Code == http.StatusNotFound:
+		return ErrUnknownSymbol
+	case e.Code >= 500:
+		return ErrUpstreamUnavailable
+	default:
+		return nil
+	}
+}
+
+// FetchResult holds the daily bars and metadata served for a symbol
+type FetchResult struct {
+	Data        []StockData // newest-first
+	TTMEPS      float64
+	CompanyName string
+	IncludePE   bool
+	Source      string // name of the provider that served the data
+	Events      []CorporateEvent
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]Provider)
+)
+
+// RegisterProvider adds a provider to the registry, replacing any
+// provider already registered under the same name
+func RegisterProvider(p Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[p.Name()] = p
+}
+
+// GetProvider returns the registered provider with the given name
+func GetProvider(name string) (Provider, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	p, ok := providers[name]
+	return p, ok
+}
+
+// ProviderNames returns the names of all registered providers, sorted
+func ProviderNames() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterProvider(NewMacrotrendsFetcher())
+	RegisterProvider(NewYahooFetcher())
+	RegisterProvider(NewStooqFetcher())
+	RegisterProvider(NewLocalFileFetcher(os.Getenv("LOCAL_DATA_DIR")))
+}
+
+// ProviderChains maps markets and individual symbols to an ordered list of
+// provider names. Symbol overrides take precedence over the market chain.
+type ProviderChains struct {
+	Markets map[string][]string
+	Symbols map[string][]string
+}
+
+// defaultProviderChains returns the built-in chains: macrotrends (with P/E)
+// for US stocks falling back to Yahoo for ETFs, and Yahoo for HK stocks.
+// Stooq is the last resort for both when Yahoo throttles us.
+func defaultProviderChains() ProviderChains {
+	return ProviderChains{
+		Markets: map[string][]string{
+			"US": {"macrotrends", "yahoo", "stooq"},
+			"HK": {"yahoo", "stooq"},
+		},
+		Symbols: map[string][]string{},
+	}
+}
+
+// ChainFor returns the ordered provider names to try for a symbol
+func (c ProviderChains) ChainFor(symbol string) []string {
+	if chain, ok := c.Symbols[strings.ToUpper(symbol)]; ok && len(chain) > 0 {
+		return chain
+	}
+	if chain, ok := c.Markets[marketForSymbol(symbol)]; ok && len(chain) > 0 {
+		return chain
+	}
+	return c.Markets["US"]
+}
+
+// marketForSymbol returns the market code a symbol trades on: "HK" for
+// .HK symbols and "US" for everything else. These are the only markets a
+// PROVIDERS_<MARKET> chain can be set for.
+func marketForSymbol(symbol string) string {
+	if isHKStock(symbol) {
+		return "HK"
+	}
+	return "US"
+}
+
+// currencyForSymbol returns the trading currency for a symbol's market
+func currencyForSymbol(symbol string) string {
+	if marketForSymbol(symbol) == "HK" {
+		return "HKD"
+	}
+	return "USD"
+}
+
+// parseProviderList splits a comma-separated list of provider names
+func parseProviderList(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// loadProviderChains builds the provider chains from the defaults plus
+// environment overrides:
+//   - LOCAL_DATA_DIR set         → local files are tried first in every market
+//   - PROVIDERS_<MARKET>=a,b     → chain for a market (US or HK)
+//   - PROVIDER_OVERRIDES=SYM:a,b;SYM2:c → chain for individual symbols
+func loadProviderChains() ProviderChains {
+	chains := defaultProviderChains()
+
+	if os.Getenv("LOCAL_DATA_DIR") != "" {
+		for market, chain := range chains.Markets {
+			chains.Markets[market] = append([]string{"local"}, chain...)
+		}
+	}
+
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		market, ok := strings.CutPrefix(key, "PROVIDERS_")
+		if !ok || market == "" {
+			continue
+		}
+		market = strings.ToUpper(market)
+		if _, known := chains.Markets[market]; !known {
+			slog.Warn("Ignoring provider chain for an unknown market", "var", key, "markets", "US, HK")
+			continue
+		}
+		if list := parseProviderList(value); len(list) > 0 {
+			chains.Markets[market] = list
+		}
+	}
+
+	for _, entry := range strings.Split(os.Getenv("PROVIDER_OVERRIDES"), ";") {
+		symbol, list, ok := strings.Cut(entry, ":")
+		symbol = strings.ToUpper(strings.TrimSpace(symbol))
+		if !ok || symbol == "" {
+			continue
+		}
+		if names := parseProviderList(list); len(names) > 0 {
+			chains.Symbols[symbol] = names
+		}
+	}
+
+	return chains
+}
+
+// providerChains is the active chain configuration
+var providerChains = loadProviderChains()
+
+// providerTimeout bounds each provider attempt so a hung provider leaves
+// time to fall back to the next one (PROVIDER_TIMEOUT, 0 disables)
+var providerTimeout = envDuration("PROVIDER_TIMEOUT", 20*time.Second)
+
+// fetchFromProvider fetches stock data by walking the symbol's provider chain
+// and returning the first successful result. Each provider gets at most
+// providerTimeout; the walk stops once ctx is done.
+func fetchFromProvider(ctx context.Context, symbol string, days int) (*FetchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var errs []error
+	var chainFailed string // First provider that failed, for fallback metrics
+	for _, name := range providerChains.ChainFor(symbol) {
+		p, ok := GetProvider(name)
+		if !ok {
+			slog.WarnContext(ctx, "Unknown provider in chain", "provider", name, "symbol", symbol)
+			continue
+		}
+
+		pctx, span := startSpan(ctx, "provider.fetch",
+			slog.String("provider", name), slog.String("symbol", symbol), slog.Int("days", days))
+		cancel := func() {}
+		if providerTimeout > 0 {
+			pctx, cancel = context.WithTimeout(pctx, providerTimeout)
+		}
+		start := time.Now()
+		result, err := p.Fetch(pctx, symbol, days)
+		elapsed := time.Since(start)
+		cancel()
+		providerDuration.Observe(elapsed.Seconds(), name)
+		span.End(err)
+		if err != nil {
+			class := classifyFetchError(err)
+			providerFetches.Inc(name, class)
+			slog.WarnContext(ctx, "Provider fetch failed", "provider", name, "symbol", symbol,
+				"class", class, "duration_ms", elapsed.Milliseconds(), "error", err)
+			if chainFailed == "" {
+				chainFailed = name
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			// The caller gave up or its budget ran out; no fallback can finish
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		providerFetches.Inc(name, "ok")
+		if chainFailed != "" {
+			providerFallbacks.Inc(chainFailed, name)
+		}
+		slog.DebugContext(ctx, "Provider fetch", "provider", name, "symbol", symbol,
+			"rows", len(result.Data), "duration_ms", elapsed.Milliseconds())
+		result.Source = p.Name()
+		return result, nil
+	}
+
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no providers configured for %s", symbol)
+	}
+	return nil, errors.Join(errs...)
+}
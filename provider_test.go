@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeProvider is a Provider that returns canned data or an error
+type fakeProvider struct {
+	name  string
+	data  []StockData
+	err   error
+	calls int
+}
+
+func (p *fakeProvider) Name() string { return p.name }
+
+func (p *fakeProvider) QuoteURL(symbol, companyName string) string {
+	return "https://example.com/" + symbol
+}
+
+func (p *fakeProvider) Fetch(_ context.Context, symbol string, days int) (*FetchResult, error) {
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &FetchResult{Data: p.data, CompanyName: p.name + " co"}, nil
+}
+
+// symbolProvider is a Provider that returns canned data per symbol; safe for
+// concurrent use since it holds no mutable state
+type symbolProvider struct {
+	name string
+	data map[string][]StockData // Newest-first, keyed by upper-case symbol
+}
+
+func (p *symbolProvider) Name() string { return p.name }
+
+func (p *symbolProvider) QuoteURL(symbol, companyName string) string { return "" }
+
+func (p *symbolProvider) Fetch(_ context.Context, symbol string, days int) (*FetchResult, error) {
+	data, ok := p.data[symbol]
+	if !ok {
+		return nil, fmt.Errorf("unknown symbol %s", symbol)
+	}
+	return &FetchResult{Data: data, CompanyName: symbol + " co"}, nil
+}
+
+// withProviders registers providers and a chain config for the duration of a test
+func withProviders(t *testing.T, chains ProviderChains, ps ...Provider) {
+	t.Helper()
+	providersMu.Lock()
+	savedProviders := providers
+	providers = make(map[string]Provider)
+	providersMu.Unlock()
+	savedChains := providerChains
+
+	for _, p := range ps {
+		RegisterProvider(p)
+	}
+	providerChains = chains
+
+	t.Cleanup(func() {
+		providersMu.Lock()
+		providers = savedProviders
+		providersMu.Unlock()
+		providerChains = savedChains
+	})
+}
+
+func TestDefaultProvidersRegistered(t *testing.T) {
+	for _, name := range []string{"macrotrends", "yahoo", "stooq"} {
+		if _, ok := GetProvider(name); !ok {
+			t.Errorf("provider %q not registered", name)
+		}
+	}
+}
+
+func TestChainFor(t *testing.T) {
+	chains := defaultProviderChains()
+	chains.Symbols["SPY"] = []string{"yahoo"}
+
+	tests := []struct {
+		symbol   string
+		expected []string
+	}{
+		{"AAPL", []string{"macrotrends", "yahoo", "stooq"}},
+		{"0700.HK", []string{"yahoo", "stooq"}},
+		{"spy", []string{"yahoo"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.symbol, func(t *testing.T) {
+			got := chains.ChainFor(tt.symbol)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("ChainFor(%q) = %v, want %v", tt.symbol, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLoadProviderChainsFromEnv(t *testing.T) {
+	t.Setenv("PROVIDERS_HK", "stooq, yahoo")
+	t.Setenv("PROVIDERS_UK", "stooq")
+	t.Setenv("PROVIDER_OVERRIDES", "qqq:yahoo;BRK.B:macrotrends,yahoo")
+	logs := captureLogs(t)
+
+	chains := loadProviderChains()
+
+	if got := chains.ChainFor("0005.HK"); !reflect.DeepEqual(got, []string{"stooq", "yahoo"}) {
+		t.Errorf("HK chain = %v", got)
+	}
+	if got := chains.ChainFor("QQQ"); !reflect.DeepEqual(got, []string{"yahoo"}) {
+		t.Errorf("QQQ chain = %v", got)
+	}
+	if got := chains.ChainFor("BRK.B"); !reflect.DeepEqual(got, []string{"macrotrends", "yahoo"}) {
+		t.Errorf("BRK.B chain = %v", got)
+	}
+	// Only US and HK chains can be configured
+	if _, ok := chains.Markets["UK"]; ok || len(logRecords(t, logs, "Ignoring provider chain for an unknown market")) != 1 {
+		t.Errorf("PROVIDERS_UK should be ignored with a warning: %v", chains.Markets)
+	}
+}
+
+func TestFetchFromProviderFallback(t *testing.T) {
+	failing := &fakeProvider{name: "primary", err: fmt.Errorf("boom")}
+	backup := &fakeProvider{name: "backup", data: []StockData{{Date: "2024-01-02", Close: "10.00"}}}
+
+	withProviders(t, ProviderChains{
+		Markets: map[string][]string{"US": {"primary", "missing", "backup"}},
+	}, failing, backup)
+
+	result, err := fetchFromProvider(t.Context(), "TEST", 30)
+	if err != nil {
+		t.Fatalf("fetchFromProvider: %v", err)
+	}
+	if result.Source != "backup" {
+		t.Errorf("Source = %q, want %q", result.Source, "backup")
+	}
+	if failing.calls != 1 || backup.calls != 1 {
+		t.Errorf("calls = %d/%d, want 1/1", failing.calls, backup.calls)
+	}
+}
+
+func TestFetchFromProviderAllFail(t *testing.T) {
+	withProviders(t, ProviderChains{
+		Markets: map[string][]string{"US": {"a", "b"}},
+	},
+		&fakeProvider{name: "a", err: fmt.Errorf("a failed")},
+		&fakeProvider{name: "b", err: fmt.Errorf("b failed")},
+	)
+
+	if _, err := fetchFromProvider(t.Context(), "TEST", 30); err == nil {
+		t.Error("Expected error when all providers fail")
+	}
+}
+
+func TestFetchStockDataRecordsSource(t *testing.T) {
+	cache, err := NewCache(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+	defer cache.Close()
+
+	p := &fakeProvider{name: "fake", data: []StockData{
+		{Date: "2099-01-02", Open: "11.00", High: "12.00", Low: "10.00", Close: "11.50", Volume: "1M"},
+	}}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}}, p)
+
+	if _, err := fetchStockData(t.Context(), cache, "TEST", 30); err != nil {
+		t.Fatalf("fetchStockData: %v", err)
+	}
+
+	meta, err := cache.GetFetchMeta(t.Context(), "TEST")
+	if err != nil || meta == nil {
+		t.Fatalf("GetFetchMeta: %v %v", meta, err)
+	}
+	if meta.Source != "fake" {
+		t.Errorf("Source = %q, want %q", meta.Source, "fake")
+	}
+}
+
+func TestCachedResultIncludePE(t *testing.T) {
+	noPE := []StockData{{Date: "2024-01-02", Close: "10.00"}}
+	withPE := []StockData{{Date: "2024-01-02", Close: "10.00", PE: "20.00"}}
+	tests := []struct {
+		name string
+		meta FetchMeta
+		data []StockData
+		want bool
+	}{
+		{"macrotrends with negative EPS", FetchMeta{Source: "macrotrends", TTMEPS: -1.2}, noPE, true},
+		{"yahoo", FetchMeta{Source: "yahoo"}, noPE, false},
+		{"local file with EPS", FetchMeta{Source: "local", TTMEPS: 0.5}, withPE, true},
+	}
+	for _, tt := range tests {
+		if got := cachedResult(&tt.meta, tt.data, nil).IncludePE; got != tt.want {
+			t.Errorf("%s: IncludePE = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// hangingProvider blocks until its context is done
+type hangingProvider struct {
+	name  string
+	calls atomic.Int32
+}
+
+func (p *hangingProvider) Name() string { return p.name }
+
+func (p *hangingProvider) QuoteURL(symbol, companyName string) string { return "" }
+
+func (p *hangingProvider) Fetch(ctx context.Context, symbol string, days int) (*FetchResult, error) {
+	p.calls.Add(1)
+	<-ctx.Done()
+	return nil, fmt.Errorf("request failed: %w", ctx.Err())
+}
+
+// withProviderTimeout sets the per-provider deadline for the duration of a test
+func withProviderTimeout(t *testing.T, d time.Duration) {
+	t.Helper()
+	saved := providerTimeout
+	providerTimeout = d
+	t.Cleanup(func() { providerTimeout = saved })
+}
+
+func TestFetchFromProviderTimeout(t *testing.T) {
+	withProviderTimeout(t, 20*time.Millisecond)
+	slow := &hangingProvider{name: "slow"}
+	backup := &fakeProvider{name: "backup", data: []StockData{{Date: "2024-01-02", Close: "10.00"}}}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"slow", "backup"}}}, slow, backup)
+
+	timeouts := providerFetches.Value("slow", "timeout")
+	result, err := fetchFromProvider(t.Context(), "TEST", 30)
+	if err != nil || result.Source != "backup" {
+		t.Fatalf("Expected the backup after the slow provider's deadline: %v %v", result, err)
+	}
+	if providerFetches.Value("slow", "timeout") != timeouts+1 {
+		t.Error("Expected a timeout for the slow provider")
+	}
+}
+
+func TestFetchFromProviderCallerCancelled(t *testing.T) {
+	withProviderTimeout(t, time.Minute)
+	slow := &hangingProvider{name: "slow"}
+	backup := &fakeProvider{name: "backup", data: []StockData{{Date: "2024-01-02", Close: "10.00"}}}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"slow", "backup"}}}, slow, backup)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	_, err := fetchFromProvider(ctx, "TEST", 30)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the caller's deadline, got %v", err)
+	}
+	if backup.calls != 0 {
+		t.Error("No fallback should start once the caller's budget is spent")
+	}
+}
+
+func TestFetchStockDataBatchAfterBudget(t *testing.T) {
+	p := &symbolProvider{name: "fake", data: map[string][]StockData{"AAPL": cliSeries(), "MSFT": cliSeries()}}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}}, p)
+	cache := newTestCache(t)
+	for _, symbol := range []string{"AAPL", "MSFT"} {
+		if _, err := fetchStockData(t.Context(), cache, symbol, 30); err != nil {
+			t.Fatalf("fetchStockData(%s): %v", symbol, err)
+		}
+	}
+	// MSFT was last fetched two days ago
+	meta, _ := cache.GetFetchMeta(t.Context(), "MSFT")
+	meta.LastFetched = meta.LastFetched.AddDate(0, 0, -2)
+	if err := cache.UpdateFetchLog(t.Context(), *meta); err != nil {
+		t.Fatal(err)
+	}
+
+	slow := &hangingProvider{name: "slow"}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"slow"}}}, slow)
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	results, errs := fetchStockDataBatch(ctx, cache, []string{"AAPL", "MSFT", "TSLA"}, 30)
+
+	// Cached and stale symbols are still served; only the upstream fetch is skipped
+	for i, symbol := range []string{"AAPL", "MSFT"} {
+		if errs[i] != nil || len(results[i].Data) != 10 {
+			t.Errorf("%s should be served from the cache: %v", symbol, errs[i])
+		}
+	}
+	if !errors.Is(errs[2], context.Canceled) {
+		t.Errorf("Uncached symbol got %v, want context.Canceled", errs[2])
+	}
+	if slow.calls.Load() != 0 {
+		t.Errorf("Started %d upstream fetches after cancellation", slow.calls.Load())
+	}
+}
+
+func TestStaleCacheServedAfterTimeout(t *testing.T) {
+	withProviderTimeout(t, 20*time.Millisecond)
+	p := &fakeProvider{name: "fake", data: cliSeries()}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}}, p)
+	cache := newTestCache(t)
+	if _, err := fetchStockData(t.Context(), cache, "AAPL", 30); err != nil {
+		t.Fatalf("fetchStockData: %v", err)
+	}
+
+	// The provider now hangs past the request's own deadline
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"slow"}}}, &hangingProvider{name: "slow"})
+	withProviderTimeout(t, time.Minute)
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	result, err := refreshStockData(ctx, cache, "AAPL", 30)
+	if err != nil || len(result.Data) != 10 {
+		t.Errorf("Stale rows should be served after the deadline: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// volatileParams are query parameters that change on every call (date
+// ranges derived from time.Now) and are ignored when matching fixtures
+var volatileParams = map[string]bool{
+	"period1": true, "period2": true, // Yahoo chart range
+	"d1": true, "d2": true, // Stooq CSV range
+}
+
+// fixture is a recorded upstream response stored as JSON on disk
+type fixture struct {
+	Method      string `json:"method"`
+	URL         string `json:"url"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        string `json:"body"`
+	RecordedAt  string `json:"recorded_at"`
+}
+
+var unsafeFixtureChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// fixtureKey returns a stable file name for a request URL, dropping
+// volatile query parameters and sorting the rest
+func fixtureKey(u *url.URL) string {
+	query := u.Query()
+	for p := range volatileParams {
+		query.Del(p)
+	}
+
+	key := u.Host + u.Path
+	if encoded := query.Encode(); encoded != "" {
+		key += "?" + encoded
+	}
+
+	name := strings.Trim(unsafeFixtureChars.ReplaceAllString(key, "_"), "_")
+	if len(name) > 150 {
+		sum := sha1.Sum([]byte(key))
+		name = name[:150] + "-" + hex.EncodeToString(sum[:4])
+	}
+	return name + ".json"
+}
+
+// RecordingTransport performs real requests and saves every response
+// to a fixture directory
+type RecordingTransport struct {
+	Base http.RoundTripper
+	Dir  string
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	fx := fixture{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        string(body),
+		RecordedAt:  time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := saveFixture(t.Dir, req.URL, fx); err != nil {
+		slog.WarnContext(req.Context(), "Fixture record failed", "url", req.URL.String(), "error", err)
+	}
+
+	return resp, nil
+}
+
+// saveFixture writes a fixture for a request URL into dir
+func saveFixture(dir string, u *url.URL, fx fixture) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(fx, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, fixtureKey(u)), content, 0o644)
+}
+
+// ReplayTransport serves responses from a fixture directory and never
+// touches the network
+type ReplayTransport struct {
+	Dir string
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := filepath.Join(t.Dir, fixtureKey(req.URL))
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("no fixture for %s (%s): %w", req.URL, filepath.Base(path), err)
+	}
+
+	var fx fixture
+	if err := json.Unmarshal(content, &fx); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %w", filepath.Base(path), err)
+	}
+
+	header := make(http.Header)
+	if fx.ContentType != "" {
+		header.Set("Content-Type", fx.ContentType)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", fx.Status, http.StatusText(fx.Status)),
+		StatusCode:    fx.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(fx.Body)),
+		ContentLength: int64(len(fx.Body)),
+		Request:       req,
+	}, nil
+}
+
+// loadUpstreamTransport picks the transport for upstream fetchers:
+//   - UPSTREAM_MODE=record → real requests, responses saved to FIXTURE_DIR
+//   - UPSTREAM_MODE=replay → responses served from FIXTURE_DIR only
+//   - otherwise            → nil (http.DefaultTransport)
+func loadUpstreamTransport() http.RoundTripper {
+	dir := os.Getenv("FIXTURE_DIR")
+	if dir == "" {
+		dir = "fixtures"
+	}
+
+	switch strings.ToLower(os.Getenv("UPSTREAM_MODE")) {
+	case "record":
+		slog.Info("Recording upstream responses", "dir", dir)
+		return &RecordingTransport{Dir: dir}
+	case "replay":
+		slog.Info("Replaying upstream responses", "dir", dir)
+		return &ReplayTransport{Dir: dir}
+	default:
+		return nil
+	}
+}
+
+// upstreamTransport is the transport used by all upstream fetchers
+var upstreamTransport = loadUpstreamTransport()
+
+// newUpstreamClient creates an HTTP client for talking to data providers,
+// sharing the upstream scheduler's rate limits, retries and circuit breakers
+func newUpstreamClient() *http.Client {
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: upstream,
+	}
+}
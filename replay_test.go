@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFixtureKey(t *testing.T) {
+	a, _ := url.Parse("https://query1.finance.yahoo.com/v8/finance/chart/AAPL?period1=1&period2=2&interval=1d")
+	b, _ := url.Parse("https://query1.finance.yahoo.com/v8/finance/chart/AAPL?interval=1d&period1=3&period2=4")
+	if fixtureKey(a) != fixtureKey(b) {
+		t.Errorf("Expected volatile params to be ignored: %q != %q", fixtureKey(a), fixtureKey(b))
+	}
+
+	c, _ := url.Parse("https://query1.finance.yahoo.com/v8/finance/chart/MSFT?interval=1d")
+	if fixtureKey(a) == fixtureKey(c) {
+		t.Error("Expected different symbols to have different keys")
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = w.Write([]byte("recorded body"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	recorder := &http.Client{Transport: &RecordingTransport{Dir: dir}}
+	resp, err := recorder.Get(srv.URL + "/q/d/l/?s=aapl.us&d1=20240101")
+	if err != nil {
+		t.Fatalf("record request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "recorded body" {
+		t.Errorf("Recording should pass body through, got %q", body)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 fixture file, got %d", len(entries))
+	}
+
+	// Replay with the server gone and a different date range
+	srv.Close()
+	replayer := &http.Client{Transport: &ReplayTransport{Dir: dir}}
+	resp, err = replayer.Get(srv.URL + "/q/d/l/?s=aapl.us&d1=20250101")
+	if err != nil {
+		t.Fatalf("replay request: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTeapot || string(body) != "recorded body" {
+		t.Errorf("Replay = %d %q", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	if _, err := replayer.Get(srv.URL + "/missing"); err == nil {
+		t.Error("Expected error for missing fixture")
+	}
+}
+
+// saveTestFixture stores a 200 response for rawURL in dir
+func saveTestFixture(t *testing.T, dir, rawURL, body string) {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveFixture(dir, u, fixture{Method: "GET", URL: rawURL, Status: 200, Body: body}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStockEndpointReplay(t *testing.T) {
+	dir := t.TempDir()
+	today := time.Now().Format("2006-01-02")
+	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+
+	saveTestFixture(t, dir,
+		"https://www.macrotrends.net/production/stocks/desktop/ticker_search_list.php?q=AAPL",
+		`[{"n":"Apple Inc.","s":"AAPL/apple"}]`)
+	saveTestFixture(t, dir,
+		"https://www.macrotrends.net/production/stocks/desktop/fundamental_iframe.php?t=AAPL&type=pe-ratio&statement=price-ratios&freq=Q&sub=",
+		`<script>var chartData = [{"date":"2020-01-01","v1":100,"v2":5.0,"v3":20}];</script>`)
+	saveTestFixture(t, dir,
+		"https://www.macrotrends.net/production/stocks/desktop/stock_price_history.php?t=AAPL",
+		`<script>var dataDaily = [{"d":"`+yesterday+`","o":"99","h":"101","l":"98","c":"100","v":"50"},{"d":"`+today+`","o":"100","h":"106","l":"99","c":"105","v":"60"}];</script>`)
+
+	fetcher := NewMacrotrendsFetcher()
+	fetcher.client.Transport = &ReplayTransport{Dir: dir}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"macrotrends"}}}, fetcher)
+
+	server := NewServer("0", nil)
+	req := httptest.NewRequest("GET", "/api/stock/AAPL?days=30&period=daily", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Data StockResponse `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if resp.Data.CompanyName != "Apple" || resp.Data.TTM_EPS != 5 {
+		t.Errorf("Unexpected response: %+v", resp.Data)
+	}
+	if len(resp.Data.DailyData) != 2 || resp.Data.DailyData[0].PE != "21.00" {
+		t.Errorf("Unexpected daily data: %+v", resp.Data.DailyData)
+	}
+}
+
+func TestLoadUpstreamTransport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "fx")
+	t.Setenv("FIXTURE_DIR", dir)
+
+	t.Setenv("UPSTREAM_MODE", "replay")
+	if rt, ok := loadUpstreamTransport().(*ReplayTransport); !ok || rt.Dir != dir {
+		t.Errorf("Expected ReplayTransport for %s", dir)
+	}
+
+	t.Setenv("UPSTREAM_MODE", "record")
+	if _, ok := loadUpstreamTransport().(*RecordingTransport); !ok {
+		t.Error("Expected RecordingTransport")
+	}
+
+	t.Setenv("UPSTREAM_MODE", "")
+	if loadUpstreamTransport() != nil {
+		t.Error("Expected default transport")
+	}
+}
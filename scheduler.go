@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// refreshDays is how much history a scheduled refresh keeps cached; it
+// matches the default range of /api/stock so those requests are cache hits
+const refreshDays = 1825
+
+// maxRefreshErrors caps the per-symbol errors kept in a job's status
+const maxRefreshErrors = 20
+
+// Market is a trading session whose close triggers scheduled refreshes
+type Market struct {
+	Name        string
+	Location    *time.Location
+	CloseHour   int
+	CloseMinute int
+}
+
+// loadLocation loads a time zone, falling back to a fixed offset when the
+// host has no tzdata. The fallback ignores DST, which only ever delays a
+// US refresh by an hour.
+func loadLocation(name string, offsetHours int) *time.Location {
+	if loc, err := time.LoadLocation(name); err == nil {
+		return loc
+	}
+	return time.FixedZone(name, offsetHours*3600)
+}
+
+// markets are the sessions a refresh job can follow, keyed by config name
+var markets = map[string]Market{
+	"us": {Name: "US", Location: loadLocation("America/New_York", -5), CloseHour: 16},
+	"hk": {Name: "HK", Location: loadLocation("Asia/Hong_Kong", 8), CloseHour: 16},
+}
+
+// nextRefresh returns the first weekday close of market plus delay that is
+// after now. Exchange holidays are not modelled; a refresh on a holiday
+// simply finds no new rows.
+func nextRefresh(now time.Time, m Market, delay time.Duration) time.Time {
+	local := now.In(m.Location)
+	for d := 0; ; d++ {
+		close := time.Date(local.Year(), local.Month(), local.Day()+d, m.CloseHour, m.CloseMinute, 0, 0, m.Location)
+		if wd := close.Weekday(); wd == time.Saturday || wd == time.Sunday {
+			continue
+		}
+		if run := close.Add(delay); run.After(now) {
+			return run
+		}
+	}
+}
+
+// RefreshJob refreshes one index or watchlist after a market's close
+type RefreshJob struct {
+	Target string // Index key (e.g. sp500) or watchlist:<id>
+	Market string // Key into markets
+}
+
+// RefreshStatus reports the state of a refresh job
+type RefreshStatus struct {
+	Target       string            `json:"target"`
+	Market       string            `json:"market"`
+	NextRun      string            `json:"next_run,omitempty"`
+	Running      bool              `json:"running"`
+	LastStarted  string            `json:"last_started,omitempty"`
+	LastFinished string            `json:"last_finished,omitempty"`
+	DurationSec  float64           `json:"duration_sec,omitempty"`
+	Symbols      int               `json:"symbols"`
+	Refreshed    int               `json:"refreshed"`
+	Failed       int               `json:"failed"`
+	Error        string            `json:"error,omitempty"`  // Job-level failure, e.g. a deleted watchlist
+	Errors       map[string]string `json:"errors,omitempty"` // Per-symbol failures from the last run (capped)
+}
+
+// parseRefreshSchedule parses a comma-separated list of target@market
+// entries, e.g. "sp500@us,hangseng@hk,watchlist:1@us". The market may be
+// omitted for indices and defaults to hk when every member is an HK stock.
+func parseRefreshSchedule(spec string) ([]RefreshJob, error) {
+	var jobs []RefreshJob
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		target, market, hasMarket := strings.Cut(entry, "@")
+
+		if idStr, ok := strings.CutPrefix(target, "watchlist:"); ok {
+			if _, err := strconv.ParseInt(idStr, 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid watchlist ID in %q", entry)
+			}
+			if !hasMarket {
+				return nil, fmt.Errorf("%q needs a market (e.g. %s@us)", entry, target)
+			}
+		} else {
+			idx, ok := GetIndices()[target]
+			if !ok {
+				return nil, fmt.Errorf("unknown index %q", target)
+			}
+			if !hasMarket {
+				market = "us"
+				if isHKStock(idx.Symbols[0]) {
+					market = "hk"
+				}
+			}
+		}
+
+		if _, ok := markets[market]; !ok {
+			return nil, fmt.Errorf("unknown market %q (use us or hk)", market)
+		}
+		jobs = append(jobs, RefreshJob{Target: target, Market: market})
+	}
+	return jobs, nil
+}
+
+// envDuration reads a Go duration from the environment
+func envDuration(key string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d >= 0 {
+		return d
+	}
+	return def
+}
+
+// Scheduler refreshes configured symbol sets in the background after each
+// market's close so the first request of the day is served from the cache
+type Scheduler struct {
+	cache   *Cache
+	jobs    []RefreshJob
+	delay   time.Duration // After the market close
+	jitter  time.Duration // Random extra delay, spreads load on upstream providers
+	sem     chan struct{} // Bounds concurrent fetches across all jobs
+	now     func() time.Time
+	refresh func(ctx context.Context, symbol string) error
+
+	mu     sync.Mutex
+	status []RefreshStatus // Indexed like jobs
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler for jobs. Refreshes start delay after the
+// close plus up to jitter, with at most workers symbols fetched at once.
+func NewScheduler(cache *Cache, jobs []RefreshJob, workers int, delay, jitter time.Duration) *Scheduler {
+	s := &Scheduler{
+		cache:  cache,
+		jobs:   jobs,
+		delay:  delay,
+		jitter: jitter,
+		sem:    make(chan struct{}, max(workers, 1)),
+		now:    time.Now,
+		status: make([]RefreshStatus, len(jobs)),
+	}
+	s.refresh = func(ctx context.Context, symbol string) error {
+		_, err := refreshStockData(ctx, s.cache, symbol, refreshDays)
+		return err
+	}
+	for i, job := range jobs {
+		s.status[i] = RefreshStatus{Target: job.Target, Market: markets[job.Market].Name}
+	}
+	return s
+}
+
+// LoadScheduler builds a scheduler from the environment:
+//   - REFRESH_SCHEDULE=sp500@us,hangseng@hk → jobs (unset or empty disables)
+//   - REFRESH_WORKERS=4                     → concurrent fetches
+//   - REFRESH_DELAY=30m                     → wait after the close
+//   - REFRESH_JITTER=10m                    → maximum random extra wait
+//
+// Returns nil if no jobs are configured or the schedule is invalid.
+func LoadScheduler(cache *Cache) *Scheduler {
+	jobs, err := parseRefreshSchedule(os.Getenv("REFRESH_SCHEDULE"))
+	if err != nil {
+		slog.Warn("Invalid REFRESH_SCHEDULE, background refresh disabled", "error", err)
+		return nil
+	}
+	if len(jobs) == 0 || cache == nil {
+		return nil
+	}
+
+	workers := batchFetchWorkers
+	if n, err := strconv.Atoi(os.Getenv("REFRESH_WORKERS")); err == nil && n > 0 {
+		workers = n
+	}
+	return NewScheduler(cache, jobs, workers,
+		envDuration("REFRESH_DELAY", 30*time.Minute),
+		envDuration("REFRESH_JITTER", 10*time.Minute))
+}
+
+// Start runs every job's loop in the background until Stop is called
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	for i := range s.jobs {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.loop(ctx, i)
+		}()
+	}
+	slog.Info("Background refresh scheduled", "jobs", len(s.jobs))
+}
+
+// Stop cancels pending runs and waits for in-flight fetches to finish
+func (s *Scheduler) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+}
+
+// Status returns a copy of every job's status
+func (s *Scheduler) Status() []RefreshStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := make([]RefreshStatus, len(s.status))
+	for i, st := range s.status {
+		st.Errors = maps.Clone(st.Errors)
+		status[i] = st
+	}
+	return status
+}
+
+// loop waits for each scheduled run of job i and executes it
+func (s *Scheduler) loop(ctx context.Context, i int) {
+	market := markets[s.jobs[i].Market]
+	for {
+		next := nextRefresh(s.now(), market, s.delay)
+		if s.jitter > 0 {
+			next = next.Add(rand.N(s.jitter))
+		}
+		s.update(i, func(st *RefreshStatus) { st.NextRun = next.Format(time.RFC3339) })
+
+		timer := time.NewTimer(next.Sub(s.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.run(ctx, i)
+	}
+}
+
+// update applies fn to job i's status under the lock
+func (s *Scheduler) update(i int, fn func(*RefreshStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.status[i])
+}
+
+// jobSymbols resolves a job's symbols at run time, so watchlist edits apply
+// to the next refresh
+func (s *Scheduler) jobSymbols(ctx context.Context, job RefreshJob) ([]string, error) {
+	if idStr, ok := strings.CutPrefix(job.Target, "watchlist:"); ok {
+		id, _ := strconv.ParseInt(idStr, 10, 64)
+		wl, err := s.cache.GetWatchlist(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if wl == nil {
+			return nil, fmt.Errorf("watchlist %d not found", id)
+		}
+		return wl.Symbols, nil
+	}
+	return GetIndices()[job.Target].Symbols, nil
+}
+
+// acquire takes a fetch slot, or returns false once ctx is cancelled
+func (s *Scheduler) acquire(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case s.sem <- struct{}{}:
+	}
+	if ctx.Err() != nil {
+		<-s.sem
+		return false
+	}
+	return true
+}
+
+// run refreshes every symbol of job i. Cancellation stops new fetches but
+// lets in-flight ones finish.
+func (s *Scheduler) run(ctx context.Context, i int) {
+	job := s.jobs[i]
+	started := s.now()
+	symbols, err := s.jobSymbols(ctx, job)
+
+	// Each run is logged and traced like a request. Fetches get a context
+	// without cancellation so in-flight ones finish on shutdown.
+	runCtx, span := startSpan(withPriority(withRequestID(context.WithoutCancel(ctx), newRequestID()), priorityBackground), "refresh "+job.Target,
+		slog.String("refresh.target", job.Target), slog.Int("refresh.symbols", len(symbols)))
+	defer func() { span.End(err) }()
+
+	s.update(i, func(st *RefreshStatus) {
+		*st = RefreshStatus{
+			Target:      st.Target,
+			Market:      st.Market,
+			Running:     err == nil,
+			LastStarted: started.Format(time.RFC3339),
+			Symbols:     len(symbols),
+		}
+		if err != nil {
+			st.Error = err.Error()
+			st.LastFinished = st.LastStarted
+		}
+	})
+	if err != nil {
+		slog.ErrorContext(runCtx, "Refresh failed", "target", job.Target, "error", err)
+		return
+	}
+	slog.InfoContext(runCtx, "Refresh starting", "target", job.Target, "symbols", len(symbols))
+
+	var wg sync.WaitGroup
+	stopped := false
+	for _, sym := range symbols {
+		if stopped = !s.acquire(ctx); stopped {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-s.sem }()
+			err := s.refresh(runCtx, sym)
+			s.update(i, func(st *RefreshStatus) {
+				if err == nil {
+					st.Refreshed++
+					return
+				}
+				st.Failed++
+				if st.Errors == nil {
+					st.Errors = map[string]string{}
+				}
+				if len(st.Errors) < maxRefreshErrors {
+					st.Errors[sym] = err.Error()
+				}
+			})
+		}()
+	}
+	wg.Wait()
+
+	finished := s.now()
+	var refreshed, failed int
+	s.update(i, func(st *RefreshStatus) {
+		st.Running = false
+		st.LastFinished = finished.Format(time.RFC3339)
+		st.DurationSec = round2(finished.Sub(started).Seconds())
+		if stopped {
+			st.Error = "stopped before completion"
+		}
+		refreshed, failed = st.Refreshed, st.Failed
+	})
+	slog.InfoContext(runCtx, "Refresh finished", "target", job.Target, "refreshed", refreshed, "failed", failed,
+		"duration", finished.Sub(started).Round(time.Second).String())
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNextRefresh(t *testing.T) {
+	us, hk := markets["us"], markets["hk"]
+	ny := us.Location
+	tests := []struct {
+		name string
+		now  time.Time
+		m    Market
+		want time.Time
+	}{
+		{"before US close", time.Date(2024, 3, 5, 10, 0, 0, 0, ny), us, time.Date(2024, 3, 5, 16, 30, 0, 0, ny)},
+		{"Friday after run skips weekend and DST change", time.Date(2024, 3, 8, 17, 0, 0, 0, ny), us, time.Date(2024, 3, 11, 16, 30, 0, 0, ny)},
+		{"Saturday", time.Date(2024, 3, 9, 12, 0, 0, 0, ny), us, time.Date(2024, 3, 11, 16, 30, 0, 0, ny)},
+		{"HK from UTC", time.Date(2024, 3, 5, 8, 0, 0, 0, time.UTC), hk, time.Date(2024, 3, 5, 16, 30, 0, 0, hk.Location)},
+		{"HK after run", time.Date(2024, 3, 5, 9, 0, 0, 0, time.UTC), hk, time.Date(2024, 3, 6, 16, 30, 0, 0, hk.Location)},
+	}
+	for _, tt := range tests {
+		if got := nextRefresh(tt.now, tt.m, 30*time.Minute); !got.Equal(tt.want) {
+			t.Errorf("%s: nextRefresh = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseRefreshSchedule(t *testing.T) {
+	jobs, err := parseRefreshSchedule(" SP500@us, hangseng ,watchlist:3@hk,dow")
+	if err != nil {
+		t.Fatalf("parseRefreshSchedule: %v", err)
+	}
+	want := []RefreshJob{{"sp500", "us"}, {"hangseng", "hk"}, {"watchlist:3", "hk"}, {"dow", "us"}}
+	if len(jobs) != len(want) {
+		t.Fatalf("Got %+v, want %+v", jobs, want)
+	}
+	for i := range want {
+		if jobs[i] != want[i] {
+			t.Errorf("Job %d = %+v, want %+v", i, jobs[i], want[i])
+		}
+	}
+
+	if jobs, err := parseRefreshSchedule(""); err != nil || len(jobs) != 0 {
+		t.Errorf("Empty schedule = %+v, %v", jobs, err)
+	}
+	for _, spec := range []string{"ftse@us", "sp500@lse", "watchlist:x@us", "watchlist:1"} {
+		if _, err := parseRefreshSchedule(spec); err == nil {
+			t.Errorf("parseRefreshSchedule(%q) expected error", spec)
+		}
+	}
+}
+
+func TestSchedulerRun(t *testing.T) {
+	cache := newTestCache(t)
+	wl, _ := cache.CreateWatchlist(t.Context(), "Mine", "", []string{"AAPL", "MSFT", "BAD", "KO", "0700.HK"})
+	s := NewScheduler(cache, []RefreshJob{{"watchlist:" + jsonID(wl.ID), "us"}, {"watchlist:999", "us"}}, 2, 0, 0)
+
+	var active, peak atomic.Int32
+	var mu sync.Mutex
+	var seen []string
+	s.refresh = func(_ context.Context, symbol string) error {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		seen = append(seen, symbol)
+		mu.Unlock()
+		if symbol == "BAD" {
+			return errors.New("upstream failed")
+		}
+		return nil
+	}
+
+	s.run(context.Background(), 0)
+	s.run(context.Background(), 1)
+
+	if len(seen) != 5 || peak.Load() > 2 {
+		t.Errorf("Refreshed %v with %d concurrent, want 5 symbols with at most 2", seen, peak.Load())
+	}
+	status := s.Status()
+	if st := status[0]; st.Running || st.Symbols != 5 || st.Refreshed != 4 || st.Failed != 1 ||
+		st.Errors["BAD"] != "upstream failed" || st.LastFinished == "" || st.Error != "" {
+		t.Errorf("Unexpected status: %+v", st)
+	}
+	if st := status[1]; st.Error == "" || st.Symbols != 0 {
+		t.Errorf("Missing watchlist should fail the job: %+v", st)
+	}
+}
+
+func TestSchedulerRunStopped(t *testing.T) {
+	s := NewScheduler(nil, []RefreshJob{{"dow", "us"}}, 1, 0, 0)
+	var calls atomic.Int32
+	s.refresh = func(context.Context, string) error { calls.Add(1); return nil }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.run(ctx, 0)
+
+	if st := s.Status()[0]; calls.Load() != 0 || st.Error != "stopped before completion" || st.Symbols != 30 {
+		t.Errorf("Cancelled run made %d calls, status %+v", calls.Load(), st)
+	}
+}
+
+func TestSchedulerStartStop(t *testing.T) {
+	s := NewScheduler(nil, []RefreshJob{{"sp500", "us"}, {"hangseng", "hk"}}, 4, time.Hour, time.Minute)
+	s.refresh = func(context.Context, string) error { t.Error("Unexpected refresh"); return nil }
+	s.Start()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return")
+	}
+
+	for _, st := range s.Status() {
+		next, err := time.Parse(time.RFC3339, st.NextRun)
+		if err != nil || !next.After(time.Now()) {
+			t.Errorf("%s: next run %q should be in the future", st.Target, st.NextRun)
+		}
+	}
+}
+
+func TestRefreshStockDataBypassesFreshCache(t *testing.T) {
+	p := &fakeProvider{name: "fake", data: reverseData(closes(time.Now().AddDate(0, 0, -2).Format("2006-01-02"), 100, 101))}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}}, p)
+	cache := newTestCache(t)
+
+	if _, err := fetchStockData(t.Context(), cache, "AAPL", 1); err != nil {
+		t.Fatalf("fetchStockData: %v", err)
+	}
+	_, _ = fetchStockData(t.Context(), cache, "AAPL", 1)
+	if p.calls != 1 {
+		t.Fatalf("Fresh cache should serve the second fetch, got %d calls", p.calls)
+	}
+	if _, err := refreshStockData(t.Context(), cache, "AAPL", 1); err != nil || p.calls != 2 {
+		t.Errorf("refreshStockData should refetch: %d calls, %v", p.calls, err)
+	}
+}
+
+func TestRefreshStatusEndpoint(t *testing.T) {
+	w := doJSON(t, NewServer("0", nil), "GET", "/api/refresh", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	var resp struct {
+		Data struct {
+			Enabled bool            `json:"enabled"`
+			Jobs    []RefreshStatus `json:"jobs"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Data.Enabled || resp.Data.Jobs == nil {
+		t.Errorf("Unexpected response: %s", w.Body.String())
+	}
+}
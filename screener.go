@@ -0,0 +1,551 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxScreenFilters bounds the work a single screener request can ask for
+const maxScreenFilters = 20
+
+// screenData is one symbol's daily history prepared for evaluating filters
+type screenData struct {
+	data   []StockData // Oldest-first
+	closes []float64
+	latest time.Time
+}
+
+// newScreenData prepares fetched data (newest-first); nil if there is none
+func newScreenData(data []StockData) *screenData {
+	if len(data) == 0 {
+		return nil
+	}
+	d := &screenData{data: reverseData(data), closes: make([]float64, len(data))}
+	for i, bar := range d.data {
+		d.closes[i] = parseFloat(bar.Close)
+	}
+	d.latest, _ = time.Parse("2006-01-02", d.data[len(d.data)-1].Date)
+	return d
+}
+
+// indexOnOrBefore returns the index of the last bar dated on or before t, or -1
+func (d *screenData) indexOnOrBefore(t time.Time) int {
+	date := t.Format("2006-01-02")
+	return sort.Search(len(d.data), func(i int) bool { return d.data[i].Date > date }) - 1
+}
+
+// screenWindow limits a drop count to recent days: a rolling window
+// ("last quarter", "last 30 days") or the current calendar period ("this month")
+type screenWindow struct {
+	Name    string
+	Current PeriodType // Set for "this <period>"
+	Years   int        // Rolling length, for "last ..."
+	Months  int
+	Days    int
+}
+
+var windowPattern = regexp.MustCompile(`^(last|this)\s+(?:(\d+)\s+)?(day|week|month|quarter|year)s?$`)
+
+// parseScreenWindow parses "last quarter", "last 30 days", "this month", ...
+func parseScreenWindow(s string) (*screenWindow, error) {
+	s = strings.Join(strings.Fields(strings.ToLower(s)), " ")
+	m := windowPattern.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("invalid window %q (use e.g. 'last quarter', 'last 30 days' or 'this month')", s)
+	}
+	n := 1
+	if m[2] != "" {
+		n, _ = strconv.Atoi(m[2])
+	}
+	w := &screenWindow{Name: s}
+
+	if m[1] == "this" {
+		if m[2] != "" || m[3] == "day" {
+			return nil, fmt.Errorf("invalid window %q (use this week, month, quarter or year)", s)
+		}
+		w.Current = PeriodType(m[3] + "ly")
+		return w, nil
+	}
+
+	switch m[3] {
+	case "day":
+		w.Days = n
+	case "week":
+		w.Days = 7 * n
+	case "month":
+		w.Months = n
+	case "quarter":
+		w.Months = 3 * n
+	case "year":
+		w.Years = n
+	}
+	if w.lookback() < 1 || w.lookback() > 5*366 {
+		return nil, fmt.Errorf("window %q must be between 1 day and 5 years", s)
+	}
+	return w, nil
+}
+
+// lookback returns the calendar days of history the window spans
+func (w *screenWindow) lookback() int {
+	if w.Current != "" {
+		return 366
+	}
+	return w.Years*366 + w.Months*31 + w.Days
+}
+
+// dropCount counts drop days of the given bucket inside the window, using
+// the same buckets and close/low rules as the period drop counters
+func (w *screenWindow) dropCount(d *screenData, bucket int, low bool) (float64, bool) {
+	if w.Current != "" {
+		periods := AggregateToPeriods(d.data, w.Current)
+		if len(periods) == 0 {
+			return 0, false
+		}
+		counts := map[int]DropCount{2: periods[0].Drop2Pct, 3: periods[0].Drop3Pct, 4: periods[0].Drop4Pct, 5: periods[0].Drop5Pct}[bucket]
+		if low {
+			return float64(counts.Low), true
+		}
+		return float64(counts.Close), true
+	}
+
+	start := d.indexOnOrBefore(d.latest.AddDate(-w.Years, -w.Months, -w.Days))
+	if start < 0 {
+		return 0, false // History does not reach back far enough
+	}
+	var drop2, drop3, drop4, drop5 int
+	for i := start + 1; i < len(d.data); i++ {
+		closeDrop, lowDrop := calculateDrops(d.closes[i], parseFloat(d.data[i].Low), d.closes[i-1])
+		if low {
+			incrementDropCount(lowDrop, &drop2, &drop3, &drop4, &drop5)
+		} else {
+			incrementDropCount(closeDrop, &drop2, &drop3, &drop4, &drop5)
+		}
+	}
+	return float64([]int{0, 0, drop2, drop3, drop4, drop5}[bucket]), true
+}
+
+// screenField is a value computed per symbol, such as pe, change_1y or sma200
+type screenField struct {
+	Name     string // Canonical name, the key in result values
+	Pct      bool   // Value is a percentage
+	lookback int    // Calendar days of history needed
+	eval     func(*screenData) (float64, bool)
+}
+
+var (
+	changePattern    = regexp.MustCompile(`^change_(ytd|(\d+)([dwmy]))$`)
+	indicatorPattern = regexp.MustCompile(`^(sma|ema|rsi)(\d*)$`)
+	dropPattern      = regexp.MustCompile(`^drop_([2-5])pct(?:\.(close|low))?$`)
+)
+
+// tradingLookback converts a number of trading days into calendar days of
+// history, with room for holidays
+func tradingLookback(n int) int {
+	return n*7/5 + 10
+}
+
+// latestBarField reads a numeric column from the latest bar
+func latestBarField(name string, get func(StockData) string) screenField {
+	return screenField{Name: name, lookback: 7, eval: func(d *screenData) (float64, bool) {
+		v := parseFloat(get(d.data[len(d.data)-1]))
+		return v, v != 0
+	}}
+}
+
+// parseScreenField parses a field name; window applies to drop_ fields only
+func parseScreenField(name string, window *screenWindow) (screenField, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	if m := dropPattern.FindStringSubmatch(name); m != nil {
+		if window == nil {
+			return screenField{}, fmt.Errorf("%s needs a window, e.g. '%s >= 3 in last quarter'", name, name)
+		}
+		bucket, _ := strconv.Atoi(m[1])
+		low := m[2] == "low"
+		canonical := fmt.Sprintf("drop_%dpct.close in %s", bucket, window.Name)
+		if low {
+			canonical = fmt.Sprintf("drop_%dpct.low in %s", bucket, window.Name)
+		}
+		return screenField{Name: canonical, lookback: window.lookback(), eval: func(d *screenData) (float64, bool) {
+			return window.dropCount(d, bucket, low)
+		}}, nil
+	}
+	if window != nil {
+		return screenField{}, fmt.Errorf("a window only applies to drop_ fields, not %s", name)
+	}
+
+	switch name {
+	case "close":
+		return latestBarField(name, func(s StockData) string { return s.Close }), nil
+	case "open":
+		return latestBarField(name, func(s StockData) string { return s.Open }), nil
+	case "high":
+		return latestBarField(name, func(s StockData) string { return s.High }), nil
+	case "low":
+		return latestBarField(name, func(s StockData) string { return s.Low }), nil
+	case "volume":
+		return screenField{Name: name, lookback: 7, eval: func(d *screenData) (float64, bool) {
+			v := parseVolume(d.data[len(d.data)-1].Volume)
+			return v, v != 0
+		}}, nil
+	case "pe":
+		return latestBarField(name, func(s StockData) string { return s.PE }), nil
+	case "eps":
+		// TTM EPS implied by the latest close and P/E
+		return screenField{Name: name, lookback: 7, eval: func(d *screenData) (float64, bool) {
+			latest := d.data[len(d.data)-1]
+			pe := parseFloat(latest.PE)
+			return parseFloat(latest.Close) / pe, pe != 0
+		}}, nil
+	case "high_52w", "low_52w", "from_high_52w":
+		return screenField{Name: name, Pct: name == "from_high_52w", lookback: 366, eval: func(d *screenData) (float64, bool) {
+			return range52w(d, name)
+		}}, nil
+	}
+
+	if m := changePattern.FindStringSubmatch(name); m != nil {
+		return parseChangeField(name, m)
+	}
+
+	if m := indicatorPattern.FindStringSubmatch(name); m != nil {
+		kind, period := m[1], 14
+		if m[2] != "" {
+			period, _ = strconv.Atoi(m[2])
+		} else if kind != "rsi" {
+			return screenField{}, fmt.Errorf("%s needs a period, e.g. %s50", kind, kind)
+		}
+		if period < 2 || period > maxIndicatorPeriod {
+			return screenField{}, fmt.Errorf("%s period must be between 2 and %d", kind, maxIndicatorPeriod)
+		}
+		lookback := tradingLookback(period)
+		if kind != "sma" {
+			lookback = tradingLookback(period * 3) // Smoothed indicators need a warm-up
+		}
+		return screenField{Name: name, lookback: lookback, eval: func(d *screenData) (float64, bool) {
+			var values []float64
+			switch kind {
+			case "sma":
+				values = sma(d.closes, period)
+			case "ema":
+				values = ema(d.closes, period)
+			default:
+				values = rsi(d.closes, period)
+			}
+			v := values[len(values)-1]
+			return v, !math.IsNaN(v)
+		}}, nil
+	}
+
+	return screenField{}, fmt.Errorf("unknown field %q", name)
+}
+
+// parseChangeField builds a percent change over a calendar span (change_1m,
+// change_1y, change_ytd) or trading days (change_5d)
+func parseChangeField(name string, m []string) (screenField, error) {
+	if m[1] == "ytd" {
+		return screenField{Name: name, Pct: true, lookback: 366, eval: func(d *screenData) (float64, bool) {
+			yearEnd := time.Date(d.latest.Year()-1, 12, 31, 0, 0, 0, 0, time.UTC)
+			return changeSince(d, d.indexOnOrBefore(yearEnd))
+		}}, nil
+	}
+
+	n, _ := strconv.Atoi(m[2])
+	var years, months, days, lookback int
+	switch m[3] {
+	case "d":
+		lookback = tradingLookback(n)
+	case "w":
+		days, lookback = 7*n, 7*n+7
+	case "m":
+		months, lookback = n, 31*n+7
+	case "y":
+		years, lookback = n, 366*n+7
+	}
+	if n < 1 || lookback > 10*366 {
+		return screenField{}, fmt.Errorf("%s must span between 1 day and 10 years", name)
+	}
+
+	return screenField{Name: name, Pct: true, lookback: lookback, eval: func(d *screenData) (float64, bool) {
+		if m[3] == "d" {
+			return changeSince(d, len(d.data)-1-n)
+		}
+		return changeSince(d, d.indexOnOrBefore(d.latest.AddDate(-years, -months, -days)))
+	}}, nil
+}
+
+// changeSince returns the percent change from bar i's close to the latest close
+func changeSince(d *screenData, i int) (float64, bool) {
+	if i < 0 || d.closes[i] <= 0 {
+		return 0, false
+	}
+	return (d.closes[len(d.closes)-1] - d.closes[i]) / d.closes[i] * 100, true
+}
+
+// range52w computes the 52-week high, low or distance below the high
+func range52w(d *screenData, name string) (float64, bool) {
+	start := d.indexOnOrBefore(d.latest.AddDate(-1, 0, 0)) + 1
+	var high, low float64
+	for i := start; i < len(d.data); i++ {
+		h, l := parseFloat(d.data[i].High), parseFloat(d.data[i].Low)
+		if h == 0 {
+			h = d.closes[i]
+		}
+		if l == 0 {
+			l = d.closes[i]
+		}
+		if i == start || h > high {
+			high = h
+		}
+		if i == start || l < low {
+			low = l
+		}
+	}
+	switch {
+	case high <= 0:
+		return 0, false
+	case name == "high_52w":
+		return high, true
+	case name == "low_52w":
+		return low, true
+	default:
+		return (d.closes[len(d.closes)-1] - high) / high * 100, true
+	}
+}
+
+// ScreenFilter is one parsed condition, e.g. "pe < 20" or "close < sma200"
+type ScreenFilter struct {
+	Left  screenField
+	Op    string
+	Right *screenField // Compared against another field, or
+	Value float64      // a constant
+}
+
+// String renders the filter in canonical form
+func (f ScreenFilter) String() string {
+	right := strconv.FormatFloat(f.Value, 'f', -1, 64)
+	if f.Right != nil {
+		right = f.Right.Name
+	} else if f.Left.Pct {
+		right += "%"
+	}
+	left, window, hasWindow := strings.Cut(f.Left.Name, " in ")
+	if hasWindow {
+		return fmt.Sprintf("%s %s %s in %s", left, f.Op, right, window)
+	}
+	return fmt.Sprintf("%s %s %s", left, f.Op, right)
+}
+
+var filterPattern = regexp.MustCompile(`^\s*([a-z0-9_.]+)\s*(<=|>=|==|!=|<|>|=)\s*(.+?)\s*$`)
+
+// ParseScreenFilter parses "field op value|field [in window]"
+func ParseScreenFilter(expr string) (ScreenFilter, error) {
+	text := strings.ToLower(strings.TrimSpace(expr))
+
+	var window *screenWindow
+	if i := strings.LastIndex(text, " in "); i >= 0 {
+		w, err := parseScreenWindow(text[i+4:])
+		if err != nil {
+			return ScreenFilter{}, err
+		}
+		window, text = w, text[:i]
+	}
+
+	m := filterPattern.FindStringSubmatch(text)
+	if m == nil {
+		return ScreenFilter{}, fmt.Errorf("invalid filter %q (use e.g. 'pe < 20')", expr)
+	}
+	op := m[2]
+	if op == "==" {
+		op = "="
+	}
+
+	left, err := parseScreenField(m[1], window)
+	if err != nil {
+		return ScreenFilter{}, err
+	}
+	f := ScreenFilter{Left: left, Op: op}
+
+	raw := m[3]
+	pct := strings.HasSuffix(raw, "%")
+	if v, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(raw, "%")), 64); err == nil {
+		if pct && !left.Pct {
+			return ScreenFilter{}, fmt.Errorf("%s is not a percentage", m[1])
+		}
+		f.Value = v
+		return f, nil
+	}
+
+	right, err := parseScreenField(raw, nil)
+	if err != nil {
+		return ScreenFilter{}, err
+	}
+	f.Right = &right
+	return f, nil
+}
+
+// compareValues applies op to a and b
+func compareValues(a float64, op string, b float64) bool {
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "!=":
+		return a != b
+	default:
+		return a == b
+	}
+}
+
+// ScreenItem is a symbol that passed every filter
+type ScreenItem struct {
+	Symbol      string             `json:"symbol"`
+	CompanyName string             `json:"company_name"`
+	Date        string             `json:"date"`   // Latest trading day
+	Values      map[string]float64 `json:"values"` // Every field used by the filters and sort
+}
+
+// ScreenResult is the outcome of a screen over an index or watchlist
+type ScreenResult struct {
+	Universe string            `json:"universe"` // index:<key> or watchlist:<id>
+	Name     string            `json:"name"`
+	Filters  []string          `json:"filters"`
+	Sort     string            `json:"sort,omitempty"`
+	Order    string            `json:"order,omitempty"`
+	Scanned  int               `json:"scanned"`
+	Matched  int               `json:"matched"` // Before limit
+	Items    []ScreenItem      `json:"items"`
+	Uncached []string          `json:"uncached,omitempty"` // Symbols with no cached prices, which are not fetched
+	Errors   map[string]string `json:"errors,omitempty"`   // Symbols whose cached prices could not be read
+}
+
+// screenLookback returns the calendar days of history the filters and sort need
+func screenLookback(filters []ScreenFilter, sortField *screenField) int {
+	days := 30
+	for _, f := range filters {
+		days = max(days, f.Left.lookback)
+		if f.Right != nil {
+			days = max(days, f.Right.lookback)
+		}
+	}
+	if sortField != nil {
+		days = max(days, sortField.lookback)
+	}
+	return days + 10 // The latest bar can be a few days old
+}
+
+// evaluateScreen returns the item for a symbol if it passes every filter.
+// A filter whose value is unavailable (no P/E, too little history) fails.
+func evaluateScreen(d *screenData, filters []ScreenFilter, sortField *screenField) (map[string]float64, bool) {
+	values := map[string]float64{}
+	eval := func(f screenField) (float64, bool) {
+		v, ok := f.eval(d)
+		if ok {
+			values[f.Name] = math.Round(v*100) / 100
+		}
+		return v, ok
+	}
+
+	for _, f := range filters {
+		left, ok := eval(f.Left)
+		if !ok {
+			return nil, false
+		}
+		right := f.Value
+		if f.Right != nil {
+			if right, ok = eval(*f.Right); !ok {
+				return nil, false
+			}
+		}
+		if !compareValues(left, f.Op, right) {
+			return nil, false
+		}
+	}
+	if sortField != nil {
+		eval(*sortField)
+	}
+	return values, true
+}
+
+// RunScreen evaluates every symbol's cached prices and keeps those passing
+// every filter, sorted by sortField (nil keeps the universe order; symbols
+// missing the sort value go last). Nothing is fetched upstream, so a screen
+// of a whole index answers at once: symbols that aren't cached are listed as
+// such, and each symbol is screened as of its latest cached day.
+func RunScreen(ctx context.Context, cache *Cache, symbols []string, filters []ScreenFilter, sortField *screenField, desc bool) ScreenResult {
+	lookback := screenLookback(filters, sortField)
+	names := GetCompanyNamesForSymbols(symbols)
+
+	result := ScreenResult{Scanned: len(symbols), Items: []ScreenItem{}}
+	for _, f := range filters {
+		result.Filters = append(result.Filters, f.String())
+	}
+
+	for _, sym := range symbols {
+		data, err := cachedScreenData(ctx, cache, strings.ToUpper(sym), lookback)
+		if err != nil {
+			if result.Errors == nil {
+				result.Errors = map[string]string{}
+			}
+			result.Errors[sym] = err.Error()
+			continue
+		}
+		d := newScreenData(data)
+		if d == nil {
+			result.Uncached = append(result.Uncached, sym)
+			continue
+		}
+		values, ok := evaluateScreen(d, filters, sortField)
+		if !ok {
+			continue
+		}
+		result.Items = append(result.Items, ScreenItem{
+			Symbol:      strings.ToUpper(sym),
+			CompanyName: names[sym],
+			Date:        d.data[len(d.data)-1].Date,
+			Values:      values,
+		})
+	}
+
+	if sortField != nil {
+		key := sortField.Name
+		sort.SliceStable(result.Items, func(i, j int) bool {
+			a, aok := result.Items[i].Values[key]
+			b, bok := result.Items[j].Values[key]
+			if aok != bok {
+				return aok
+			}
+			if desc {
+				return a > b
+			}
+			return a < b
+		})
+	}
+	result.Matched = len(result.Items)
+	return result
+}
+
+// cachedScreenData returns lookback calendar days of a symbol's cached
+// prices (newest-first) up to its latest cached day, or nil if none are
+// cached
+func cachedScreenData(ctx context.Context, cache *Cache, symbol string, lookback int) ([]StockData, error) {
+	meta, err := cache.GetFetchMeta(ctx, symbol)
+	if err != nil || meta == nil {
+		return nil, err
+	}
+	latest, err := time.Parse("2006-01-02", meta.LatestDate)
+	if err != nil {
+		return nil, nil
+	}
+	return cache.GetDailyPrices(ctx, symbol, latest.AddDate(0, 0, -lookback).Format("2006-01-02"), meta.LatestDate)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// screenerSeries builds the newest-first test series used by the screener tests:
+//   - UP rises 0.5 a day from 100 over 400 days, P/E 15
+//   - DOWN is flat at 100 with 5%+ intraday drops on 2024-01-16, 2024-01-21 and 2024-02-02, P/E 30
+//   - NEW has only 10 days of history and no P/E
+func screenerSeries() map[string][]StockData {
+	up := make([]float64, 400)
+	flat := make([]float64, 400)
+	for i := range up {
+		up[i] = 100 + float64(i)*0.5
+		flat[i] = 100
+	}
+
+	series := map[string][]StockData{
+		"UP":   closes("2023-01-01", up...),
+		"DOWN": closes("2023-01-01", flat...),
+		"NEW":  closes("2024-01-26", flat[:10]...),
+	}
+	for _, data := range series {
+		for i := range data {
+			data[i].Low = data[i].Close
+		}
+	}
+	for _, i := range []int{380, 385, 397} {
+		series["DOWN"][i].Low = "94"
+	}
+	series["UP"][399].PE = "15"
+	series["DOWN"][399].PE = "30"
+
+	for sym, data := range series {
+		series[sym] = reverseData(data)
+	}
+	return series
+}
+
+// cacheSeries stores newest-first series in a new test cache, as fetches would
+func cacheSeries(t *testing.T, series map[string][]StockData) *Cache {
+	t.Helper()
+	cache := newTestCache(t)
+	for sym, data := range series {
+		if err := cache.StoreDailyPrices(t.Context(), sym, data); err != nil {
+			t.Fatal(err)
+		}
+		err := cache.UpdateFetchLog(t.Context(), FetchMeta{Symbol: sym, Source: "fake", LastFetched: time.Now(),
+			LatestDate: data[0].Date, EarliestDate: data[len(data)-1].Date})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cache
+}
+
+func TestParseScreenFilter(t *testing.T) {
+	valid := map[string]string{
+		"pe < 20":                            "pe < 20",
+		"Change_1Y>10%":                      "change_1y > 10%",
+		"change_1y > 10":                     "change_1y > 10%",
+		"drop_5pct.low >= 3 in last quarter": "drop_5pct.low >= 3 in last quarter",
+		"drop_4pct == 0 in   last 30   days": "drop_4pct.close = 0 in last 30 days",
+		"drop_2pct.close > 1 in this month":  "drop_2pct.close > 1 in this month",
+		"close < sma200":                     "close < sma200",
+		"rsi <= 30":                          "rsi <= 30",
+		"from_high_52w <= -20%":              "from_high_52w <= -20%",
+		"volume != 0":                        "volume != 0",
+		"change_5d>=change_ytd":              "change_5d >= change_ytd",
+	}
+	for expr, want := range valid {
+		f, err := ParseScreenFilter(expr)
+		if err != nil {
+			t.Errorf("ParseScreenFilter(%q) error = %v", expr, err)
+			continue
+		}
+		if got := f.String(); got != want {
+			t.Errorf("ParseScreenFilter(%q) = %q, want %q", expr, got, want)
+		}
+	}
+
+	invalid := []string{
+		"pe < 20%",                           // P/E is not a percentage
+		"drop_5pct >= 3",                     // Missing window
+		"close > 1 in last quarter",          // Window on a non-drop field
+		"drop_5pct.low >= 3 in next week",    // Bad window
+		"drop_5pct.low >= 3 in this 2 weeks", // Current-period windows take no count
+		"drop_6pct >= 1 in last month",
+		"sma < close",
+		"sma1 < close",
+		"change_0d > 1",
+		"beta > 1",
+		"pe",
+	}
+	for _, expr := range invalid {
+		if _, err := ParseScreenFilter(expr); err == nil {
+			t.Errorf("ParseScreenFilter(%q) expected error", expr)
+		}
+	}
+}
+
+func TestScreenFields(t *testing.T) {
+	series := screenerSeries()
+	up, down := newScreenData(series["UP"]), newScreenData(series["DOWN"])
+
+	tests := []struct {
+		field string
+		data  *screenData
+		want  float64
+	}{
+		{"close", up, 299.5},
+		{"pe", up, 15},
+		{"eps", up, 299.5 / 15},
+		{"sma200", up, 249.75},
+		{"change_1d", up, 0.5 / 299 * 100},
+		{"change_1y", up, 182.5 / 117 * 100}, // 2024-02-04 vs 2023-02-04
+		{"high_52w", up, 299.5},
+		{"from_high_52w", up, 0},
+		{"rsi", up, 100},
+		{"drop_5pct.low in last quarter", down, 3},
+		{"drop_5pct.close in last quarter", down, 0},
+		{"drop_5pct.low in last week", down, 1},
+		{"drop_5pct.low in this month", down, 1},
+		{"drop_5pct.low in this year", down, 3},
+	}
+	for _, tt := range tests {
+		var window *screenWindow
+		name, w, hasWindow := strings.Cut(tt.field, " in ")
+		if hasWindow {
+			window, _ = parseScreenWindow(w)
+		}
+		f, err := parseScreenField(name, window)
+		if err != nil {
+			t.Fatalf("parseScreenField(%q): %v", tt.field, err)
+		}
+		got, ok := f.eval(tt.data)
+		if !ok || roundPct(got) != roundPct(tt.want) {
+			t.Errorf("%s = %v (ok=%v), want %v", tt.field, got, ok, tt.want)
+		}
+	}
+
+	// Too little history or no P/E makes the value unavailable
+	short := newScreenData(series["NEW"])
+	for _, name := range []string{"pe", "sma200", "change_1y"} {
+		f, _ := parseScreenField(name, nil)
+		if _, ok := f.eval(short); ok {
+			t.Errorf("%s should be unavailable for a short series", name)
+		}
+	}
+}
+
+func TestRunScreen(t *testing.T) {
+	// Nothing is fetched: MISSING has no provider data to fall back to either
+	p := &fakeProvider{name: "fake", data: screenerSeries()["UP"]}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}}, p)
+	cache := cacheSeries(t, screenerSeries())
+	symbols := []string{"UP", "DOWN", "NEW", "MISSING"}
+
+	parse := func(exprs ...string) []ScreenFilter {
+		var filters []ScreenFilter
+		for _, expr := range exprs {
+			f, err := ParseScreenFilter(expr)
+			if err != nil {
+				t.Fatalf("ParseScreenFilter(%q): %v", expr, err)
+			}
+			filters = append(filters, f)
+		}
+		return filters
+	}
+	matched := func(r ScreenResult) []string {
+		var syms []string
+		for _, item := range r.Items {
+			syms = append(syms, item.Symbol)
+		}
+		return syms
+	}
+
+	tests := []struct {
+		filters []string
+		want    []string
+	}{
+		{[]string{"pe < 20"}, []string{"UP"}},
+		{[]string{"change_1y > 10%"}, []string{"UP"}},
+		{[]string{"drop_5pct.low >= 3 in last quarter"}, []string{"DOWN"}},
+		{[]string{"close <= sma200", "pe > 0"}, []string{"DOWN"}},
+		{[]string{"close < sma200"}, nil},
+		{[]string{"close > 0"}, []string{"UP", "DOWN", "NEW"}},
+	}
+	for _, tt := range tests {
+		result := RunScreen(t.Context(), cache, symbols, parse(tt.filters...), nil, false)
+		got := matched(result)
+		if len(got) != len(tt.want) {
+			t.Errorf("%v matched %v, want %v", tt.filters, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%v matched %v, want %v", tt.filters, got, tt.want)
+				break
+			}
+		}
+		if result.Scanned != 4 || len(result.Uncached) != 1 || result.Uncached[0] != "MISSING" || result.Errors != nil {
+			t.Errorf("Expected 4 scanned with MISSING uncached, got %d %v %v", result.Scanned, result.Uncached, result.Errors)
+		}
+	}
+	if p.calls != 0 {
+		t.Errorf("The screener fetched %d times, want none", p.calls)
+	}
+
+	// Sorted descending by 1-year change; NEW has no 1-year history so it goes last
+	sortField, _ := parseScreenField("change_1y", nil)
+	result := RunScreen(t.Context(), cache, symbols, parse("close > 0"), &sortField, true)
+	if got := matched(result); len(got) != 3 || got[0] != "UP" || got[1] != "DOWN" || got[2] != "NEW" {
+		t.Errorf("Sorted = %v, want [UP DOWN NEW]", got)
+	}
+	if v := result.Items[0].Values; v["close"] != 299.5 || v["change_1y"] != 155.98 {
+		t.Errorf("Unexpected values: %v", v)
+	}
+}
+
+func TestScreenerEndpoint(t *testing.T) {
+	series := screenerSeries()
+	data := map[string][]StockData{}
+	for _, sym := range DowIndex.Symbols {
+		data[sym] = series["DOWN"]
+	}
+	data["AAPL"] = series["UP"]
+	server := NewServer("0", cacheSeries(t, data))
+
+	w := doJSON(t, server, "GET", "/api/screener?index=dow&filter=pe%3C20%3Bchange_1y%3E10%25&sort=pe", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data ScreenResult `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if r := resp.Data; r.Universe != "index:dow" || r.Scanned != 30 || r.Matched != 1 || r.Items[0].Symbol != "AAPL" ||
+		len(r.Filters) != 2 || r.Sort != "pe" || r.Order != "asc" {
+		t.Errorf("Unexpected result: %+v", r)
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"index=dow", http.StatusBadRequest},
+		{"filter=pe%3C20", http.StatusBadRequest},
+		{"index=ftse&filter=pe%3C20", http.StatusNotFound},
+		{"index=dow&filter=pe%3C%3C20", http.StatusBadRequest},
+		{"index=dow&filter=pe%3C20&sort=beta", http.StatusBadRequest},
+		{"index=dow&filter=pe%3C20&order=up", http.StatusBadRequest},
+		{"index=dow&filter=pe%3C20&limit=0", http.StatusBadRequest},
+		{"watchlist=1&filter=pe%3C20", http.StatusServiceUnavailable},
+		{"index=dow&filter=pe%3C20", http.StatusServiceUnavailable},
+	}
+	// The screener reads the cache, so every valid request needs one
+	server = NewServer("0", nil)
+	for _, tt := range tests {
+		if w := doJSON(t, server, "GET", "/api/screener?"+tt.query, ""); w.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.query, tt.want, w.Code)
+		}
+	}
+}
@@ -4,12 +4,17 @@ import (
 	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
-	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
@@ -24,6 +29,7 @@ type APIResponse struct {
 	Success bool        `json:"success"`
 	Data    interface{} `json:"data,omitempty"`
 	Error   string      `json:"error,omitempty"`
+	Code    string      `json:"code,omitempty"` // Machine-readable error code, see fetchErrorStatus
 }
 
 // StockRequest represents a stock data request
@@ -45,6 +51,18 @@ type StockResponse struct {
 	RecordCount int          `json:"record_count"`
 	DailyData   []StockData  `json:"daily_data,omitempty"`
 	PeriodData  []PeriodData `json:"period_data,omitempty"`
+
+	// Populated when indicators=... is requested
+	Indicators []IndicatorSeries `json:"indicators,omitempty"`
+
+	// Populated when adjusted=true; Adjusted is false when the source has
+	// no adjusted closes and the prices are unadjusted
+	Adjusted  *bool            `json:"adjusted,omitempty"`
+	Dividends []CorporateEvent `json:"dividends,omitempty"`
+	Splits    []CorporateEvent `json:"splits,omitempty"`
+
+	// Risk and return statistics for the requested range and period type
+	Summary *RiskStats `json:"summary,omitempty"`
 }
 
 // Server holds the HTTP server and its dependencies
@@ -52,15 +70,44 @@ type Server struct {
 	port   string
 	router *http.ServeMux
 	cache  *Cache
+	alerts *AlertEngine // nil without a cache
+
+	scheduler *Scheduler // nil unless REFRESH_SCHEDULE is set; runs only under Start
+
+	requestTimeout time.Duration // Budget for each request; 0 means none
+}
+
+// serverWriteTimeout is how long a handler has to write its response
+const serverWriteTimeout = 60 * time.Second
+
+// lambdaRequestTimeout is just under API Gateway's 29-second integration
+// timeout, after which the client has already been sent a 504
+const lambdaRequestTimeout = 28 * time.Second
+
+// defaultRequestTimeout returns the request budget when REQUEST_TIMEOUT is
+// unset: nobody is waiting for the response after the write timeout, or
+// after API Gateway gives up on Lambda
+func defaultRequestTimeout() time.Duration {
+	if os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "" {
+		return lambdaRequestTimeout
+	}
+	return serverWriteTimeout - 5*time.Second
 }
 
 // NewServer creates a new HTTP server
 func NewServer(port string, cache *Cache) *Server {
 	s := &Server{
-		port:   port,
-		router: http.NewServeMux(),
-		cache:  cache,
+		port:           port,
+		router:         http.NewServeMux(),
+		cache:          cache,
+		requestTimeout: envDuration("REQUEST_TIMEOUT", defaultRequestTimeout()),
+	}
+	// Alert rules are evaluated whenever fresh prices are stored
+	if cache != nil {
+		s.alerts = NewAlertEngine(cache)
+		cache.SetStoreHook(s.alerts.Evaluate)
 	}
+	s.scheduler = LoadScheduler(cache)
 	s.setupRoutes()
 	return s
 }
@@ -68,31 +115,92 @@ func NewServer(port string, cache *Cache) *Server {
 // setupRoutes configures all HTTP routes
 func (s *Server) setupRoutes() {
 	// API routes
-	s.router.HandleFunc("/api/health", s.handleHealth)
-	s.router.HandleFunc("/api/stock/", s.handleStock)
-	s.router.HandleFunc("/api/stock-excel/", s.handleStockExcel)
-	s.router.HandleFunc("/api/indices", s.handleIndices)
-	s.router.HandleFunc("/api/indices/", s.handleIndexSymbols)
+	s.handle("/metrics", s.handleMetrics)
+	s.handle("/api/health", s.handleHealth)
+	s.handle("/api/stock/", s.handleStock)
+	s.handle("/api/stock-excel/", s.handleStockExcel)
+	s.handle("/api/stock-csv/", s.handleStockCSV)
+	s.handle("/api/stock-ndjson/", s.handleStockNDJSON)
+	s.handle("/api/indices", s.handleIndices)
+	s.handle("/api/indices/", s.handleIndexSymbols)
+	s.handle("/api/correlation", s.handleCorrelation)
+	s.handle("/api/screener", s.handleScreener)
+	s.handle("/api/backtest", s.handleBacktest)
+	s.handle("/api/backtest-excel", s.handleBacktestExcel)
+	s.handle("/api/watchlists", s.handleWatchlists)
+	s.handle("/api/watchlists/", s.handleWatchlist)
+	s.handle("/api/alerts", s.handleAlerts)
+	s.handle("/api/alerts/", s.handleAlert)
+	s.handle("/api/refresh", s.handleRefreshStatus)
 
 	// Static files (frontend)
 	webContent, _ := fs.Sub(webFS, "web")
 	fileServer := http.FileServer(http.FS(webContent))
-	s.router.Handle("/", fileServer)
+	s.router.Handle("/", instrument("/", fileServer))
 }
 
-// ServeHTTP implements http.Handler
+// handle registers a handler with request metrics labelled by its pattern
+func (s *Server) handle(pattern string, handler http.HandlerFunc) {
+	s.router.Handle(pattern, instrument(pattern, handler))
+}
+
+// ServeHTTP implements http.Handler. Each request gets an ID (the client's
+// X-Request-ID when valid) and a root span, both carried by its context and
+// attached to every log line written with it. The context is cancelled when
+// the client disconnects or the request budget runs out, which stops
+// upstream fetches and cache queries made on its behalf.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Add CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Request-ID, Traceparent")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, Content-Disposition")
 
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	s.router.ServeHTTP(w, r)
+	requestID := r.Header.Get("X-Request-ID")
+	if !validRequestID(requestID) {
+		requestID = newRequestID()
+	}
+	w.Header().Set("X-Request-ID", requestID)
+
+	start := time.Now()
+	ctx, span := startRemoteSpan(withRequestID(r.Context(), requestID), r.Method, r.Header.Get("Traceparent"),
+		slog.String("http.request.method", r.Method), slog.String("url.path", r.URL.Path), slog.String("request.id", requestID))
+	if s.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.requestTimeout)
+		defer cancel()
+	}
+	r = r.WithContext(ctx)
+	rec := &statusRecorder{ResponseWriter: w}
+
+	s.router.ServeHTTP(rec, r)
+
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	elapsed := time.Since(start)
+	if r.Pattern != "" {
+		span.SetName(r.Method + " " + r.Pattern)
+	}
+	span.SetAttr("http.response.status_code", rec.status)
+	var spanErr error
+	if rec.status >= 500 {
+		spanErr = fmt.Errorf("HTTP %d", rec.status)
+	}
+	span.End(spanErr)
+
+	// Scrapes and health checks would drown out real traffic
+	level := slog.LevelInfo
+	if r.URL.Path == "/metrics" || r.URL.Path == "/api/health" {
+		level = slog.LevelDebug
+	}
+	slog.Log(ctx, level, "HTTP request", "method", r.Method, "path", r.URL.Path,
+		"status", rec.status, "duration_ms", elapsed.Milliseconds())
 }
 
 // Start starts the HTTP server with graceful shutdown
@@ -101,7 +209,7 @@ func (s *Server) Start() error {
 		Addr:         ":" + s.port,
 		Handler:      s,
 		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 60 * time.Second,
+		WriteTimeout: serverWriteTimeout,
 		IdleTimeout:  120 * time.Second,
 	}
 
@@ -112,25 +220,38 @@ func (s *Server) Start() error {
 
 	go func() {
 		<-quit
-		log.Println("Server is shutting down...")
+		slog.Info("Server is shutting down")
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
 		if err := server.Shutdown(ctx); err != nil {
-			log.Fatalf("Server forced to shutdown: %v", err)
+			slog.Error("Server forced to shutdown", "error", err)
+			os.Exit(1)
 		}
 		close(done)
 	}()
 
-	log.Printf("Stock Fetcher %s (commit: %s, built: %s)", Version, CommitHash, BuildTime)
-	log.Printf("Server starting on port %s", s.port)
+	slog.Info("Stock Fetcher starting", "version", Version, "commit", CommitHash, "built", BuildTime, "port", s.port)
+	if s.scheduler != nil {
+		s.scheduler.Start()
+	}
 	if err := server.ListenAndServe(); err != http.ErrServerClosed {
 		return err
 	}
 
 	<-done
-	log.Println("Server stopped")
+	// Scheduled fetches can still store prices and fire alerts
+	if s.scheduler != nil {
+		s.scheduler.Stop()
+	}
+	if s.alerts != nil {
+		s.alerts.Stop()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	flushTraces(ctx)
+	slog.Info("Server stopped")
 	return nil
 }
 
@@ -146,14 +267,91 @@ func writeJSON(w http.ResponseWriter, status int, data interface{}) {
 	_ = json.NewEncoder(w).Encode(data)
 }
 
-// writeError writes an error response
+// writeError writes an error response with the default code for its status
 func writeError(w http.ResponseWriter, status int, message string) {
+	writeErrorCode(w, status, errorCode(status), message)
+}
+
+// writeErrorCode writes an error response with a specific code
+func writeErrorCode(w http.ResponseWriter, status int, code, message string) {
 	writeJSON(w, status, APIResponse{
 		Success: false,
 		Error:   message,
+		Code:    code,
 	})
 }
 
+// errorCode returns the default error code for a status
+func errorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "bad_request"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusMethodNotAllowed:
+		return "method_not_allowed"
+	case http.StatusConflict:
+		return "conflict"
+	case http.StatusServiceUnavailable:
+		return "unavailable"
+	default:
+		return "internal"
+	}
+}
+
+// statusClientClosedRequest is nginx's status for a client that went away
+// before the response; it only shows up in logs and metrics
+const statusClientClosedRequest = 499
+
+// fetchErrorStatus maps a stock data fetch error to a status and code.
+// Errors worth retrying come first: a chain failure matches every
+// provider's error, and a symbol one provider doesn't know (macrotrends and
+// ETFs) may only have failed on the next because it was throttled.
+//
+//	timeout              504  provider or request deadline passed; retry
+//	upstream_throttled   429  provider rate limited us; retry after Retry-After
+//	upstream_unavailable 503  provider down, unreachable or circuit open; retry
+//	upstream_parse_error 502  provider response changed format; don't retry
+//	upstream_error       502  other unexpected provider status
+//	unknown_symbol       404  no provider knows the symbol
+//	no_data              404  no data in the requested range
+//	internal             500  anything else
+func fetchErrorStatus(err error) (int, string) {
+	var netErr net.Error
+	var statusErr *StatusError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return statusClientClosedRequest, "canceled"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout, "timeout"
+	case errors.Is(err, ErrUpstreamThrottled):
+		return http.StatusTooManyRequests, "upstream_throttled"
+	case errors.Is(err, ErrUpstreamUnavailable):
+		return http.StatusServiceUnavailable, "upstream_unavailable"
+	case errors.Is(err, ErrParse):
+		return http.StatusBadGateway, "upstream_parse_error"
+	case errors.As(err, &statusErr) && statusErr.Code != http.StatusNotFound:
+		return http.StatusBadGateway, "upstream_error"
+	case errors.Is(err, ErrUnknownSymbol):
+		return http.StatusNotFound, "unknown_symbol"
+	case errors.Is(err, ErrNoData):
+		return http.StatusNotFound, "no_data"
+	default:
+		return http.StatusInternalServerError, "internal"
+	}
+}
+
+// writeFetchError writes the response for a failed stock data fetch,
+// passing on the provider's Retry-After when it throttled us
+func writeFetchError(w http.ResponseWriter, message string, err error) {
+	status, code := fetchErrorStatus(err)
+	var statusErr *StatusError
+	if status == http.StatusTooManyRequests && errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(statusErr.RetryAfter.Seconds()))))
+	}
+	writeErrorCode(w, status, code, message)
+}
+
 // writeSuccess writes a success response
 func writeSuccess(w http.ResponseWriter, data interface{}) {
 	writeJSON(w, http.StatusOK, APIResponse{
@@ -163,17 +361,24 @@ func writeSuccess(w http.ResponseWriter, data interface{}) {
 }
 
 // handleHealth handles health check requests
+// Status is "degraded" while any upstream host's circuit is open; a
+// half_open circuit is already admitting a trial request and doesn't count.
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	writeSuccess(w, map[string]string{
-		"status":     "ok",
+	status := "ok"
+	if len(upstream.openCircuits()) > 0 {
+		status = "degraded"
+	}
+	writeSuccess(w, map[string]any{
+		"status":     status,
 		"version":    Version,
 		"commit":     CommitHash,
 		"build_time": BuildTime,
+		"upstream":   upstream.Status(),
 	})
 }
 
 // handleStock handles stock data requests
-// GET /api/stock/{symbol}?days=365&period=daily
+// GET /api/stock/{symbol}?days=365&period=daily&adjusted=true&indicators=sma:50,rsi:14&risk_free=4.5
 func (s *Server) handleStock(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
@@ -188,6 +393,12 @@ func (s *Server) handleStock(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Sub-resources: /api/stock/{symbol}/drawdowns
+	if sym, ok := strings.CutSuffix(symbol, "/drawdowns"); ok && sym != "" {
+		s.handleDrawdowns(w, r, sym)
+		return
+	}
+
 	// Parse query parameters
 	days := 1825
 	if d := r.URL.Query().Get("days"); d != "" {
@@ -211,50 +422,73 @@ func (s *Server) handleStock(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Fetch data
-	useYahoo := isHKStock(symbol)
-	data, ttmEPS, companyName, includePE, err := fetchStockData(s.cache, symbol, days, useYahoo)
+	adjusted, _ := strconv.ParseBool(r.URL.Query().Get("adjusted"))
+
+	indicators, err := ParseIndicators(r.URL.Query().Get("indicators"))
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to fetch data: %v", err))
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid indicators: %v", err))
 		return
 	}
 
-	if len(data) == 0 {
-		writeError(w, http.StatusNotFound, "No data found for symbol")
+	// Annual risk-free rate in percent, used for Sharpe and Sortino
+	riskFree := defaultRiskFreeRate()
+	if rf := r.URL.Query().Get("risk_free"); rf != "" {
+		parsed, err := strconv.ParseFloat(strings.TrimSuffix(rf, "%"), 64)
+		if err != nil || parsed < -100 || parsed > 100 {
+			writeError(w, http.StatusBadRequest, "Invalid risk_free. Use an annual rate in percent, e.g. 4.5")
+			return
+		}
+		riskFree = parsed
+	}
+
+	// Fetch data
+	result, err := fetchStockData(r.Context(), s.cache, symbol, days)
+	if err != nil {
+		writeFetchError(w, fmt.Sprintf("Failed to fetch data: %v", err), err)
+		return
+	}
+	if len(result.Data) == 0 {
+		writeErrorCode(w, http.StatusNotFound, "no_data", "No data found for symbol")
 		return
 	}
 
-	// Determine data source, provider URL, and currency
-	dataSource := "macrotrends"
-	var providerURL string
+	writeSuccess(w, buildStockResponse(symbol, result, period, adjusted, indicators, riskFree))
+}
+
+// buildStockResponse shapes fetched data (newest-first) into the
+// /api/stock response for a validated period
+func buildStockResponse(symbol string, result *FetchResult, period string, adjusted bool, indicators []IndicatorSpec, riskFree float64) StockResponse {
+	data := result.Data
+
+	// Determine provider URL from the provider that served the data
 	upperSymbol := strings.ToUpper(symbol)
-	currency := "USD"
-	if useYahoo {
-		currency = "HKD"
-	}
-	if useYahoo || !includePE {
-		dataSource = "yahoo"
-		providerURL = fmt.Sprintf("https://finance.yahoo.com/quote/%s", upperSymbol)
-	} else {
-		slug := companyName
-		if slug == "" {
-			slug = strings.ToLower(symbol)
-		}
-		providerURL = fmt.Sprintf("https://www.macrotrends.net/stocks/charts/%s/%s/stock-price-history", upperSymbol, slug)
+	var providerURL string
+	if p, ok := GetProvider(result.Source); ok {
+		providerURL = p.QuoteURL(upperSymbol, result.CompanyName)
 	}
 
 	// Build response
 	resp := StockResponse{
 		Symbol:      upperSymbol,
-		CompanyName: formatCompanyName(companyName),
-		DataSource:  dataSource,
+		CompanyName: formatCompanyName(result.CompanyName),
+		DataSource:  result.Source,
 		ProviderURL: providerURL,
-		Currency:    currency,
+		Currency:    currencyForSymbol(symbol),
 		PeriodType:  period,
 	}
 
-	if includePE {
-		resp.TTM_EPS = ttmEPS
+	if result.IncludePE {
+		resp.TTM_EPS = result.TTMEPS
+	}
+
+	// Back-adjust OHLC for splits and dividends (data is newest-first)
+	if adjusted {
+		applied := hasAdjClose(data)
+		resp.Adjusted = &applied
+		if applied {
+			data = reverseData(adjustPrices(reverseData(data)))
+			resp.Dividends, resp.Splits = splitEvents(result.Events)
+		}
 	}
 
 	// Aggregate if period is not daily
@@ -265,12 +499,896 @@ func (s *Server) handleStock(w http.ResponseWriter, r *http.Request) {
 		periodData := AggregateToPeriods(reversedData, periodType)
 		resp.PeriodData = periodData
 		resp.RecordCount = len(periodData)
+		if len(indicators) > 0 {
+			resp.Indicators = ComputePeriodIndicators(periodData, indicators)
+		}
 	} else {
 		resp.DailyData = data
 		resp.RecordCount = len(data)
+		if len(indicators) > 0 {
+			resp.Indicators = ComputeDailyIndicators(data, indicators)
+		}
+	}
+
+	resp.Summary = ComputeStockStats(reverseData(data), period, riskFree)
+	return resp
+}
+
+// handleDrawdowns handles drawdown analysis requests
+// GET /api/stock/{symbol}/drawdowns?days=1825&threshold=10&adjusted=true
+func (s *Server) handleDrawdowns(w http.ResponseWriter, r *http.Request, symbol string) {
+	query := r.URL.Query()
+
+	days := 1825
+	if d := query.Get("days"); d != "" {
+		if parsed, err := strconv.Atoi(d); err == nil && parsed > 0 {
+			days = parsed
+		}
+	}
+
+	threshold := 10.0
+	if t := query.Get("threshold"); t != "" {
+		parsed, err := strconv.ParseFloat(strings.TrimSuffix(t, "%"), 64)
+		if err != nil || parsed < 0 || parsed >= 100 {
+			writeError(w, http.StatusBadRequest, "Invalid threshold. Use a percentage between 0 and 100")
+			return
+		}
+		threshold = parsed
+	}
+
+	adjusted, _ := strconv.ParseBool(query.Get("adjusted"))
+
+	result, err := fetchStockData(r.Context(), s.cache, symbol, days)
+	if err != nil {
+		writeFetchError(w, fmt.Sprintf("Failed to fetch data: %v", err), err)
+		return
+	}
+	if len(result.Data) == 0 {
+		writeErrorCode(w, http.StatusNotFound, "no_data", "No data found for symbol")
+		return
+	}
+
+	// Drawdowns are computed oldest-first
+	data := reverseData(result.Data)
+	applied := adjusted && hasAdjClose(data)
+	if applied {
+		data = adjustPrices(data)
+	}
+
+	report := AnalyzeDrawdowns(data, threshold)
+	report.Symbol = strings.ToUpper(symbol)
+	report.CompanyName = formatCompanyName(result.CompanyName)
+	if adjusted {
+		report.Adjusted = &applied
+	}
+
+	writeSuccess(w, report)
+}
+
+// maxCorrelationSymbols bounds a correlation request; large enough for the
+// S&P 500. Symbols not cached yet are limited by the request budget (see
+// fetchStockDataBatch).
+const maxCorrelationSymbols = 600
+
+// defaultBenchmark picks a broad-market ETF for the symbols' market
+func defaultBenchmark(symbols []string) string {
+	for _, sym := range symbols {
+		if !isHKStock(sym) {
+			return "SPY"
+		}
+	}
+	return "2800.HK" // Tracker Fund of Hong Kong
+}
+
+// handleCorrelation handles return-correlation and beta requests
+// GET /api/correlation?symbols=AAPL,MSFT,0700.HK&days=365&period=daily&benchmark=SPY&adjusted=true
+// GET /api/correlation?index=dow&period=weekly
+func (s *Server) handleCorrelation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	query := r.URL.Query()
+
+	// Symbols come from an explicit list or an index
+	var symbols []string
+	if key := query.Get("index"); key != "" {
+		idx, exists := GetIndices()[strings.ToLower(key)]
+		if !exists {
+			writeError(w, http.StatusNotFound, "Index not found")
+			return
+		}
+		symbols = idx.Symbols
+	} else {
+		seen := map[string]bool{}
+		for _, sym := range strings.Split(query.Get("symbols"), ",") {
+			sym = strings.ToUpper(strings.TrimSpace(sym))
+			if sym != "" && !seen[sym] {
+				seen[sym] = true
+				symbols = append(symbols, sym)
+			}
+		}
+	}
+	if len(symbols) < 2 {
+		writeError(w, http.StatusBadRequest, "At least two symbols (or an index) are required")
+		return
+	}
+	if len(symbols) > maxCorrelationSymbols {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Too many symbols (max %d)", maxCorrelationSymbols))
+		return
+	}
+
+	days := 365
+	if d := query.Get("days"); d != "" {
+		if parsed, err := strconv.Atoi(d); err == nil && parsed > 0 {
+			days = parsed
+		}
+	}
+
+	period := query.Get("period")
+	if period == "" {
+		period = "daily"
+	}
+	if period != "daily" {
+		if _, err := ParsePeriodType(period); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid period. Use: daily, weekly, monthly, quarterly, yearly")
+			return
+		}
+	}
+
+	adjusted, _ := strconv.ParseBool(query.Get("adjusted"))
+
+	benchmark := strings.ToUpper(strings.TrimSpace(query.Get("benchmark")))
+	if benchmark == "" {
+		benchmark = defaultBenchmark(symbols)
+	}
+
+	// Fetch every symbol plus the benchmark
+	toFetch := symbols
+	if !slices.Contains(symbols, benchmark) {
+		toFetch = append(slices.Clone(symbols), benchmark)
+	}
+	results, errs := fetchStockDataBatch(r.Context(), s.cache, toFetch, days)
+
+	report := CorrelationReport{
+		Benchmark:  benchmark,
+		PeriodType: period,
+		Errors:     map[string]string{},
+	}
+	if adjusted {
+		report.Adjusted = map[string]bool{}
+	}
+	var series []closeSeries
+	var benchSeries closeSeries
+	var failures []string
+	var failed []error
+	for i, sym := range toFetch {
+		if errs[i] == nil && len(results[i].Data) == 0 {
+			errs[i] = fmt.Errorf("%w found", ErrNoData)
+		}
+		if errs[i] != nil {
+			report.Errors[sym] = errs[i].Error()
+			if slices.Contains(symbols, sym) {
+				failures = append(failures, fmt.Sprintf("%s: %v", sym, errs[i]))
+				failed = append(failed, errs[i])
+			}
+			continue
+		}
+
+		data := reverseData(results[i].Data) // oldest-first
+		if adjusted {
+			// One matrix can mix adjusted and raw series
+			report.Adjusted[sym] = hasAdjClose(data)
+			if report.Adjusted[sym] {
+				data = adjustPrices(data)
+			}
+		}
+		if report.StartDate == "" || data[0].Date < report.StartDate {
+			report.StartDate = data[0].Date
+		}
+		if last := data[len(data)-1].Date; last > report.EndDate {
+			report.EndDate = last
+		}
+
+		cs := newCloseSeries(data, period)
+		if sym == benchmark {
+			benchSeries = cs
+		}
+		if slices.Contains(symbols, sym) {
+			report.Symbols = append(report.Symbols, sym)
+			series = append(series, cs)
+		}
+	}
+
+	// Fewer than two symbols means at least one failed; its error decides
+	// the status, as for a single stock
+	if len(report.Symbols) < 2 {
+		writeFetchError(w, "Failed to fetch data for at least two symbols: "+strings.Join(failures, "; "), errors.Join(failed...))
+		return
+	}
+	report.Matrix, report.Observations, report.Betas = ComputeCorrelation(report.Symbols, series, benchSeries)
+
+	writeSuccess(w, report)
+}
+
+// maxBacktestSymbols bounds the number of positions in a backtest
+const maxBacktestSymbols = 50
+
+// parseBacktestParams parses backtest query parameters into a config,
+// the number of days to fetch and whether to use adjusted prices
+func parseBacktestParams(query url.Values) (BacktestConfig, int, bool, error) {
+	cfg := BacktestConfig{
+		Rebalance:    "monthly",
+		StartCapital: 10000,
+		RiskFreePct:  defaultRiskFreeRate(),
+		StatsPeriod:  "daily",
+	}
+
+	for _, sym := range strings.Split(query.Get("symbols"), ",") {
+		if sym = strings.ToUpper(strings.TrimSpace(sym)); sym != "" {
+			if slices.Contains(cfg.Symbols, sym) {
+				return cfg, 0, false, fmt.Errorf("duplicate symbol %s", sym)
+			}
+			cfg.Symbols = append(cfg.Symbols, sym)
+		}
+	}
+	if len(cfg.Symbols) == 0 {
+		return cfg, 0, false, fmt.Errorf("symbols is required")
+	}
+	if len(cfg.Symbols) > maxBacktestSymbols {
+		return cfg, 0, false, fmt.Errorf("too many symbols (max %d)", maxBacktestSymbols)
+	}
+
+	// Weights are relative, so "60,40" and "0.6,0.4" are the same; default equal
+	if ws := query.Get("weights"); ws != "" {
+		parts := strings.Split(ws, ",")
+		if len(parts) != len(cfg.Symbols) {
+			return cfg, 0, false, fmt.Errorf("weights must have one value per symbol")
+		}
+		var total float64
+		for _, part := range parts {
+			w, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(part), "%"), 64)
+			if err != nil || w < 0 {
+				return cfg, 0, false, fmt.Errorf("invalid weight %q", part)
+			}
+			cfg.Weights = append(cfg.Weights, w)
+			total += w
+		}
+		if total <= 0 {
+			return cfg, 0, false, fmt.Errorf("weights must not all be zero")
+		}
+		for i := range cfg.Weights {
+			cfg.Weights[i] /= total
+		}
+	} else {
+		for range cfg.Symbols {
+			cfg.Weights = append(cfg.Weights, 1/float64(len(cfg.Symbols)))
+		}
+	}
+
+	if rb := query.Get("rebalance"); rb != "" {
+		if rb != "none" && rb != "daily" {
+			if _, err := ParsePeriodType(rb); err != nil {
+				return cfg, 0, false, fmt.Errorf("invalid rebalance (use none, daily, weekly, monthly, quarterly, yearly)")
+			}
+		}
+		cfg.Rebalance = rb
+	}
+
+	// Non-negative numeric parameters
+	for _, p := range []struct {
+		name string
+		dst  *float64
+	}{
+		{"capital", &cfg.StartCapital},
+		{"cost_bps", &cfg.CostBps},
+		{"fee", &cfg.FeePerTrade},
+	} {
+		if v := query.Get(p.name); v != "" {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil || parsed < 0 {
+				return cfg, 0, false, fmt.Errorf("invalid %s", p.name)
+			}
+			*p.dst = parsed
+		}
+	}
+	if cfg.StartCapital <= 0 {
+		return cfg, 0, false, fmt.Errorf("capital must be positive")
+	}
+
+	if rf := query.Get("risk_free"); rf != "" {
+		parsed, err := strconv.ParseFloat(strings.TrimSuffix(rf, "%"), 64)
+		if err != nil || parsed < -100 || parsed > 100 {
+			return cfg, 0, false, fmt.Errorf("invalid risk_free")
+		}
+		cfg.RiskFreePct = parsed
+	}
+
+	if period := query.Get("period"); period != "" {
+		if period != "daily" {
+			if _, err := ParsePeriodType(period); err != nil {
+				return cfg, 0, false, fmt.Errorf("invalid period (use daily, weekly, monthly, quarterly, yearly)")
+			}
+		}
+		cfg.StatsPeriod = period
+	}
+
+	days := 1825
+	if d := query.Get("days"); d != "" {
+		if parsed, err := strconv.Atoi(d); err == nil && parsed > 0 {
+			days = parsed
+		}
+	}
+	adjusted, _ := strconv.ParseBool(query.Get("adjusted"))
+
+	return cfg, days, adjusted, nil
+}
+
+// runBacktest parses the request, fetches every symbol through the cache
+// and runs the simulation. It writes an error response and returns false
+// on failure.
+func (s *Server) runBacktest(w http.ResponseWriter, r *http.Request) (BacktestResult, bool) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return BacktestResult{}, false
+	}
+
+	cfg, days, adjusted, err := parseBacktestParams(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid backtest: %v", err))
+		return BacktestResult{}, false
+	}
+
+	results, errs := fetchStockDataBatch(r.Context(), s.cache, cfg.Symbols, days)
+	var failures []string
+	var failed []error
+	series := make([][]StockData, len(cfg.Symbols))
+	var applied map[string]bool
+	if adjusted {
+		applied = map[string]bool{}
+	}
+	for i, sym := range cfg.Symbols {
+		if errs[i] == nil && len(results[i].Data) == 0 {
+			errs[i] = fmt.Errorf("%w found", ErrNoData)
+		}
+		if errs[i] != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", sym, errs[i]))
+			failed = append(failed, errs[i])
+			continue
+		}
+		series[i] = reverseData(results[i].Data) // oldest-first
+		if adjusted {
+			applied[sym] = hasAdjClose(series[i])
+			if applied[sym] {
+				series[i] = adjustPrices(series[i])
+			}
+		}
+	}
+	// Every position needs prices, so any failure fails the backtest
+	if len(failures) > 0 {
+		writeFetchError(w, "Failed to fetch data: "+strings.Join(failures, "; "), errors.Join(failed...))
+		return BacktestResult{}, false
+	}
+
+	result := RunBacktest(cfg, series)
+	result.Adjusted = applied
+	if len(result.Equity) == 0 {
+		writeError(w, http.StatusNotFound, "No overlapping price history for the requested symbols")
+		return BacktestResult{}, false
+	}
+	return result, true
+}
+
+// handleBacktest handles portfolio backtest requests
+// GET /api/backtest?symbols=AAPL,MSFT&weights=60,40&rebalance=monthly&capital=10000&cost_bps=5&fee=1&days=1825
+func (s *Server) handleBacktest(w http.ResponseWriter, r *http.Request) {
+	if result, ok := s.runBacktest(w, r); ok {
+		writeSuccess(w, result)
+	}
+}
+
+// handleBacktestExcel handles backtest Excel export requests
+// GET /api/backtest-excel?symbols=AAPL,MSFT&weights=60,40&rebalance=monthly
+func (s *Server) handleBacktestExcel(w http.ResponseWriter, r *http.Request) {
+	result, ok := s.runBacktest(w, r)
+	if !ok {
+		return
+	}
+
+	f, err := GenerateBacktestExcel(result)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to generate Excel")
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	filename := fmt.Sprintf("backtest_%s_%s.xlsx", strings.Join(result.Symbols, "_"), result.Rebalance)
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+
+	if err := f.Write(w); err != nil {
+		slog.ErrorContext(r.Context(), "Excel write failed", "error", err)
+	}
+}
+
+// WatchlistRequest is the body for creating or replacing a watchlist
+type WatchlistRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Symbols     []string `json:"symbols"`
+}
+
+// maxWatchlistSymbols bounds the size of a watchlist
+const maxWatchlistSymbols = 500
+
+// decodeWatchlistRequest reads and validates a watchlist body; requireName
+// is false when only symbols are being added
+func decodeWatchlistRequest(w http.ResponseWriter, r *http.Request, requireName bool) (WatchlistRequest, error) {
+	var req WatchlistRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		return req, fmt.Errorf("invalid JSON body: %v", err)
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	req.Symbols = normalizeSymbols(req.Symbols)
+	if requireName && (req.Name == "" || len(req.Name) > 100) {
+		return req, fmt.Errorf("name is required (up to 100 characters)")
+	}
+	if len(req.Symbols) > maxWatchlistSymbols {
+		return req, fmt.Errorf("too many symbols (max %d)", maxWatchlistSymbols)
+	}
+	return req, nil
+}
+
+// handleWatchlists handles the watchlist collection
+// GET  /api/watchlists
+// POST /api/watchlists {"name": "...", "description": "...", "symbols": ["AAPL", "0700.HK"]}
+func (s *Server) handleWatchlists(w http.ResponseWriter, r *http.Request) {
+	if s.cache == nil {
+		writeError(w, http.StatusServiceUnavailable, "Watchlists require the cache database")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		lists, err := s.cache.ListWatchlists(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to list watchlists: %v", err))
+			return
+		}
+		writeSuccess(w, lists)
+
+	case http.MethodPost:
+		req, err := decodeWatchlistRequest(w, r, true)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		wl, err := s.cache.CreateWatchlist(r.Context(), req.Name, req.Description, req.Symbols)
+		if errors.Is(err, ErrWatchlistExists) {
+			writeError(w, http.StatusConflict, "Watchlist name already exists")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create watchlist: %v", err))
+			return
+		}
+		writeJSON(w, http.StatusCreated, APIResponse{Success: true, Data: wl})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleWatchlist handles a single watchlist and its sub-resources
+// GET    /api/watchlists/{id}
+// PUT    /api/watchlists/{id}                   (replace name, description and symbols)
+// DELETE /api/watchlists/{id}
+// POST   /api/watchlists/{id}/symbols           {"symbols": ["MSFT"]}
+// DELETE /api/watchlists/{id}/symbols/{symbol}
+// GET    /api/watchlists/{id}/snapshot?period=monthly
+// GET    /api/watchlists/{id}/excel?days=1825&period=monthly
+func (s *Server) handleWatchlist(w http.ResponseWriter, r *http.Request) {
+	if s.cache == nil {
+		writeError(w, http.StatusServiceUnavailable, "Watchlists require the cache database")
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/watchlists/"), "/")
+	parts := strings.Split(path, "/")
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, "Invalid watchlist ID")
+		return
+	}
+
+	route := strings.Join(append([]string{r.Method}, parts[1:]...), " ")
+	switch {
+	case route == "GET":
+		wl, err := s.cache.GetWatchlist(r.Context(), id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to load watchlist: %v", err))
+			return
+		}
+		if wl == nil {
+			writeError(w, http.StatusNotFound, "Watchlist not found")
+			return
+		}
+		writeSuccess(w, wl)
+
+	case route == "PUT":
+		req, err := decodeWatchlistRequest(w, r, true)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		wl, err := s.cache.UpdateWatchlist(r.Context(), id, req.Name, req.Description, req.Symbols)
+		if errors.Is(err, ErrWatchlistExists) {
+			writeError(w, http.StatusConflict, "Watchlist name already exists")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to update watchlist: %v", err))
+			return
+		}
+		if wl == nil {
+			writeError(w, http.StatusNotFound, "Watchlist not found")
+			return
+		}
+		writeSuccess(w, wl)
+
+	case route == "DELETE":
+		found, err := s.cache.DeleteWatchlist(r.Context(), id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to delete watchlist: %v", err))
+			return
+		}
+		if !found {
+			writeError(w, http.StatusNotFound, "Watchlist not found")
+			return
+		}
+		writeSuccess(w, map[string]interface{}{"id": id, "deleted": true})
+
+	case route == "POST symbols":
+		req, err := decodeWatchlistRequest(w, r, false)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		wl, err := s.cache.AddWatchlistSymbols(r.Context(), id, req.Symbols)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to update watchlist: %v", err))
+			return
+		}
+		if wl == nil {
+			writeError(w, http.StatusNotFound, "Watchlist not found")
+			return
+		}
+		writeSuccess(w, wl)
+
+	case len(parts) == 3 && r.Method == http.MethodDelete && parts[1] == "symbols":
+		found, err := s.cache.RemoveWatchlistSymbol(r.Context(), id, parts[2])
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to update watchlist: %v", err))
+			return
+		}
+		if !found {
+			writeError(w, http.StatusNotFound, "Watchlist or symbol not found")
+			return
+		}
+		writeSuccess(w, map[string]interface{}{"id": id, "symbol": strings.ToUpper(parts[2]), "deleted": true})
+
+	case route == "GET snapshot":
+		s.handleWatchlistSnapshot(w, r, id)
+
+	case route == "GET excel":
+		wl, err := s.cache.GetWatchlist(r.Context(), id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to load watchlist: %v", err))
+			return
+		}
+		if wl == nil {
+			writeError(w, http.StatusNotFound, "Watchlist not found")
+			return
+		}
+		s.handleGroupExcel(w, r, fmt.Sprintf("watchlist_%d", id), wl.Name, wl.Symbols)
+
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// handleWatchlistSnapshot returns the latest close, day change, period
+// change and P/E for every member of a watchlist
+// GET /api/watchlists/{id}/snapshot?period=monthly
+func (s *Server) handleWatchlistSnapshot(w http.ResponseWriter, r *http.Request, id int64) {
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = "monthly"
+	}
+	periodType, err := ParsePeriodType(period)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid period. Use: weekly, monthly, quarterly, yearly")
+		return
+	}
+
+	wl, err := s.cache.GetWatchlist(r.Context(), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to load watchlist: %v", err))
+		return
+	}
+	if wl == nil {
+		writeError(w, http.StatusNotFound, "Watchlist not found")
+		return
+	}
+
+	writeSuccess(w, buildSnapshot(r.Context(), s.cache, strconv.FormatInt(id, 10), wl.Name, wl.Symbols, periodType))
+}
+
+// decodeAlertRule reads and validates an alert rule body
+func decodeAlertRule(w http.ResponseWriter, r *http.Request) (AlertRule, error) {
+	rule := AlertRule{Enabled: true}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&rule); err != nil {
+		return rule, fmt.Errorf("invalid JSON body: %v", err)
+	}
+	return rule, rule.Validate()
+}
+
+// handleAlerts handles the alert rule collection
+// GET  /api/alerts
+// POST /api/alerts {"scope_type": "index", "scope": "dow", "condition": "drop_low", "threshold": 5, "webhook_url": "..."}
+func (s *Server) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	if s.cache == nil {
+		writeError(w, http.StatusServiceUnavailable, "Alerts require the cache database")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rules, err := s.cache.ListAlertRules(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to list alerts: %v", err))
+			return
+		}
+		writeSuccess(w, rules)
+
+	case http.MethodPost:
+		rule, err := decodeAlertRule(w, r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		created, err := s.cache.CreateAlertRule(r.Context(), rule)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create alert: %v", err))
+			return
+		}
+		writeJSON(w, http.StatusCreated, APIResponse{Success: true, Data: created})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleAlert handles a single alert rule and the alert history
+// GET    /api/alerts/{id}
+// PUT    /api/alerts/{id}
+// DELETE /api/alerts/{id}
+// GET    /api/alerts/history?rule_id=1&symbol=AAPL&limit=100
+func (s *Server) handleAlert(w http.ResponseWriter, r *http.Request) {
+	if s.cache == nil {
+		writeError(w, http.StatusServiceUnavailable, "Alerts require the cache database")
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/alerts/"), "/")
+	if path == "history" {
+		s.handleAlertHistory(w, r)
+		return
+	}
+	id, err := strconv.ParseInt(path, 10, 64)
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, "Invalid alert ID")
+		return
+	}
+
+	var rule *AlertRule
+	switch r.Method {
+	case http.MethodGet:
+		rule, err = s.cache.GetAlertRule(r.Context(), id)
+
+	case http.MethodPut:
+		var update AlertRule
+		if update, err = decodeAlertRule(w, r); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		rule, err = s.cache.UpdateAlertRule(r.Context(), id, update)
+
+	case http.MethodDelete:
+		var found bool
+		if found, err = s.cache.DeleteAlertRule(r.Context(), id); err == nil && found {
+			writeSuccess(w, map[string]interface{}{"id": id, "deleted": true})
+			return
+		}
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Alert request failed: %v", err))
+		return
+	}
+	if rule == nil {
+		writeError(w, http.StatusNotFound, "Alert not found")
+		return
+	}
+	writeSuccess(w, rule)
+}
+
+// handleAlertHistory returns triggered alerts, newest first
+func (s *Server) handleAlertHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	query := r.URL.Query()
+
+	var ruleID int64
+	if v := query.Get("rule_id"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, "Invalid rule_id")
+			return
+		}
+		ruleID = parsed
+	}
+	limit := 100
+	if v := query.Get("limit"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 && parsed <= 1000 {
+			limit = parsed
+		}
+	}
+
+	events, err := s.cache.AlertHistory(r.Context(), ruleID, strings.ToUpper(query.Get("symbol")), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to load alert history: %v", err))
+		return
+	}
+	writeSuccess(w, events)
+}
+
+// handleScreener filters the constituents of an index or watchlist
+// GET /api/screener?index=sp500&filter=pe<20&filter=change_1y>10%25&sort=change_1y&order=desc&limit=50
+// GET /api/screener?watchlist=1&filter=drop_5pct.low>=3 in last quarter
+func (s *Server) handleScreener(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	query := r.URL.Query()
+
+	// Filters may be repeated or separated by semicolons
+	var filters []ScreenFilter
+	for _, param := range query["filter"] {
+		for _, expr := range strings.Split(param, ";") {
+			if strings.TrimSpace(expr) == "" {
+				continue
+			}
+			f, err := ParseScreenFilter(expr)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			filters = append(filters, f)
+		}
+	}
+	if len(filters) == 0 {
+		writeError(w, http.StatusBadRequest, "At least one filter is required (e.g. filter=pe<20)")
+		return
+	}
+	if len(filters) > maxScreenFilters {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Too many filters (max %d)", maxScreenFilters))
+		return
+	}
+
+	var sortField *screenField
+	if name := query.Get("sort"); name != "" {
+		f, err := parseScreenField(name, nil)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid sort: "+err.Error())
+			return
+		}
+		sortField = &f
+	}
+	order := strings.ToLower(query.Get("order"))
+	if order == "" {
+		order = "asc"
+	}
+	if order != "asc" && order != "desc" {
+		writeError(w, http.StatusBadRequest, "Invalid order. Use: asc, desc")
+		return
+	}
+
+	limit := 0
+	if l := query.Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed < 1 {
+			writeError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = parsed
+	}
+
+	// The universe is an index or a watchlist
+	var universe, name string
+	var symbols []string
+	switch {
+	case query.Get("index") != "":
+		key := strings.ToLower(query.Get("index"))
+		idx, exists := GetIndices()[key]
+		if !exists {
+			writeError(w, http.StatusNotFound, "Index not found")
+			return
+		}
+		universe, name, symbols = "index:"+key, idx.Name, idx.Symbols
+	case query.Get("watchlist") != "":
+		id, err := strconv.ParseInt(query.Get("watchlist"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid watchlist ID")
+			return
+		}
+		if s.cache == nil {
+			writeError(w, http.StatusServiceUnavailable, "Watchlists require the cache database")
+			return
+		}
+		wl, err := s.cache.GetWatchlist(r.Context(), id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to load watchlist: %v", err))
+			return
+		}
+		if wl == nil {
+			writeError(w, http.StatusNotFound, "Watchlist not found")
+			return
+		}
+		universe, name, symbols = fmt.Sprintf("watchlist:%d", id), wl.Name, wl.Symbols
+	default:
+		writeError(w, http.StatusBadRequest, "index or watchlist is required")
+		return
+	}
+
+	if s.cache == nil {
+		writeError(w, http.StatusServiceUnavailable, "The screener requires the cache database")
+		return
+	}
+	result := RunScreen(r.Context(), s.cache, symbols, filters, sortField, order == "desc")
+	result.Universe, result.Name = universe, name
+	if sortField != nil {
+		result.Sort, result.Order = sortField.Name, order
+	}
+	if limit > 0 && len(result.Items) > limit {
+		result.Items = result.Items[:limit]
 	}
+	writeSuccess(w, result)
+}
 
-	writeSuccess(w, resp)
+// handleRefreshStatus reports the background refresh jobs
+// GET /api/refresh
+func (s *Server) handleRefreshStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	jobs := []RefreshStatus{}
+	if s.scheduler != nil {
+		jobs = s.scheduler.Status()
+	}
+	writeSuccess(w, map[string]interface{}{
+		"enabled": s.scheduler != nil,
+		"jobs":    jobs,
+	})
 }
 
 // handleIndices handles index list requests
@@ -298,6 +1416,8 @@ func (s *Server) handleIndices(w http.ResponseWriter, r *http.Request) {
 
 // handleIndexSymbols handles index symbol list requests
 // GET /api/indices/{name}
+// GET /api/indices/{name}/snapshot?period=monthly
+// GET /api/indices/{name}/excel?days=1825&period=monthly
 func (s *Server) handleIndexSymbols(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
@@ -306,7 +1426,7 @@ func (s *Server) handleIndexSymbols(w http.ResponseWriter, r *http.Request) {
 
 	// Parse index name from path
 	path := strings.TrimPrefix(r.URL.Path, "/api/indices/")
-	indexName := strings.TrimSuffix(path, "/")
+	indexName, sub, _ := strings.Cut(strings.TrimSuffix(path, "/"), "/")
 	if indexName == "" {
 		writeError(w, http.StatusBadRequest, "Index name is required")
 		return
@@ -319,6 +1439,19 @@ func (s *Server) handleIndexSymbols(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	switch sub {
+	case "":
+	case "snapshot":
+		s.handleIndexSnapshot(w, r, strings.ToLower(indexName), idx)
+		return
+	case "excel":
+		s.handleGroupExcel(w, r, strings.ToLower(indexName), idx.Name, idx.Symbols)
+		return
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
 	writeSuccess(w, map[string]interface{}{
 		"key":         indexName,
 		"name":        idx.Name,
@@ -329,8 +1462,63 @@ func (s *Server) handleIndexSymbols(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleIndexSnapshot returns the latest close, period change, P/E and
+// current-period drop counts for every constituent of an index. Symbols are
+// fetched through the cache with a bounded worker pool; failures are
+// reported per symbol.
+func (s *Server) handleIndexSnapshot(w http.ResponseWriter, r *http.Request, key string, idx Index) {
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = "monthly"
+	}
+	periodType, err := ParsePeriodType(period)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid period. Use: weekly, monthly, quarterly, yearly")
+		return
+	}
+
+	writeSuccess(w, buildSnapshot(r.Context(), s.cache, key, idx.Name, idx.Symbols, periodType))
+}
+
+// handleGroupExcel writes a workbook with a summary sheet and one sheet per
+// symbol. Symbols are fetched through the cache with a bounded worker pool.
+func (s *Server) handleGroupExcel(w http.ResponseWriter, r *http.Request, key, name string, symbols []string) {
+	days := 1825
+	if d := r.URL.Query().Get("days"); d != "" {
+		if parsed, err := strconv.Atoi(d); err == nil && parsed > 0 {
+			days = parsed
+		}
+	}
+	period := periodOrDefault(r)
+	if _, err := ParsePeriodType(period); err != nil && period != "daily" {
+		writeError(w, http.StatusBadRequest, "Invalid period. Use: daily, weekly, monthly, quarterly, yearly")
+		return
+	}
+
+	results, errs := fetchStockDataBatch(r.Context(), s.cache, symbols, days)
+	f, err := GenerateGroupExcel(GroupExcelParams{
+		Name:    name,
+		Period:  period,
+		Symbols: symbols,
+		Results: results,
+		Errors:  errs,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to generate Excel")
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	filename := fmt.Sprintf("%s_%s.xlsx", key, period)
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	if err := f.Write(w); err != nil {
+		slog.ErrorContext(r.Context(), "Excel write failed", "error", err)
+	}
+}
+
 // handleStockExcel handles Excel export requests
-// GET /api/stock-excel/{symbol}?days=365&period=daily
+// GET /api/stock-excel/{symbol}?days=365&period=daily&adjusted=true&indicators=sma:50,rsi:14
 func (s *Server) handleStockExcel(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
@@ -358,33 +1546,20 @@ func (s *Server) handleStockExcel(w http.ResponseWriter, r *http.Request) {
 	if period == "" {
 		period = "monthly"
 	}
-
-	// Determine data source
-	useYahoo := isHKStock(symbol)
-
-	// Fetch stock data
-	data, ttmEPS, companyName, includePE, err := fetchStockData(s.cache, symbol, days, useYahoo)
+	adjusted, _ := strconv.ParseBool(query.Get("adjusted"))
+	indicators, err := ParseIndicators(query.Get("indicators"))
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid indicators: %v", err))
 		return
 	}
 
-	// Prepare Excel params
-	params := ExcelParams{
-		Symbol:      symbol,
-		CompanyName: companyName,
-		Period:      period,
-		TTMEPS:      ttmEPS,
-		IncludePE:   includePE,
-	}
-
-	if period == "daily" {
-		params.Data = data
-	} else {
-		periodType, _ := ParsePeriodType(period)
-		reversedData := reverseData(data)
-		params.PeriodData = AggregateToPeriods(reversedData, periodType)
+	// Fetch stock data
+	result, err := fetchStockData(r.Context(), s.cache, symbol, days)
+	if err != nil {
+		writeFetchError(w, err.Error(), err)
+		return
 	}
+	params := buildExcelParams(symbol, result, period, adjusted, indicators)
 
 	// Generate Excel file
 	f, err := GenerateExcel(params)
@@ -401,10 +1576,46 @@ func (s *Server) handleStockExcel(w http.ResponseWriter, r *http.Request) {
 
 	// Write to response
 	if err := f.Write(w); err != nil {
-		log.Printf("Error writing Excel file: %v", err)
+		slog.ErrorContext(r.Context(), "Excel write failed", "error", err)
 	}
 }
 
+// buildExcelParams shapes fetched data (newest-first) into Excel export
+// parameters for a validated period
+func buildExcelParams(symbol string, result *FetchResult, period string, adjusted bool, indicators []IndicatorSpec) ExcelParams {
+	data := result.Data
+
+	params := ExcelParams{
+		Symbol:      symbol,
+		CompanyName: result.CompanyName,
+		Period:      period,
+		TTMEPS:      result.TTMEPS,
+		IncludePE:   result.IncludePE,
+	}
+
+	if adjusted && hasAdjClose(data) {
+		data = reverseData(adjustPrices(reverseData(data)))
+		params.Adjusted = true
+		params.Events = result.Events
+	}
+
+	params.Data = data
+	if period == "daily" {
+		if len(indicators) > 0 {
+			params.Indicators = ComputeDailyIndicators(data, indicators)
+		}
+	} else {
+		periodType, _ := ParsePeriodType(period)
+		reversedData := reverseData(data)
+		params.PeriodData = AggregateToPeriods(reversedData, periodType)
+		if len(indicators) > 0 {
+			params.Indicators = ComputePeriodIndicators(params.PeriodData, indicators)
+		}
+	}
+
+	return params
+}
+
 // runServer starts the web server (called from main)
 func runServer(port string) error {
 	cache := InitCache()
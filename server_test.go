@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
 )
 
 func TestHealthEndpoint(t *testing.T) {
@@ -97,6 +103,74 @@ func TestIndexSymbolsNotFound(t *testing.T) {
 	}
 }
 
+func TestIndexSnapshotEndpoint(t *testing.T) {
+	// 60 days to 2024-02-29 with a 6% drop on 2024-02-10
+	values := make([]float64, 60)
+	for i := range values {
+		values[i] = 100
+	}
+	for i := 40; i < 60; i++ {
+		values[i] = 94
+	}
+	data := closes("2024-01-01", values...)
+	for i := range data {
+		data[i].Low = data[i].Close
+	}
+	data = reverseData(data)
+
+	fetched := map[string][]StockData{}
+	for _, sym := range DowIndex.Symbols {
+		if sym != "DOW" {
+			fetched[sym] = data
+		}
+	}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}},
+		&symbolProvider{name: "fake", data: fetched})
+	server := NewServer("0", nil)
+
+	req := httptest.NewRequest("GET", "/api/indices/dow/snapshot?period=monthly", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Data Snapshot `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	snap := resp.Data
+	if snap.Key != "dow" || snap.Count != 30 || snap.Failed != 1 || len(snap.Items) != 30 {
+		t.Fatalf("Unexpected snapshot: key=%s count=%d failed=%d items=%d", snap.Key, snap.Count, snap.Failed, len(snap.Items))
+	}
+
+	aapl := snap.Items[0]
+	if aapl.Symbol != "AAPL" || aapl.CompanyName != GetCompanyName("AAPL") || aapl.Date != "2024-02-29" || *aapl.Close != 94 {
+		t.Errorf("Unexpected AAPL item: %+v", aapl)
+	}
+	if aapl.PeriodChangePct == nil || *aapl.PeriodChangePct != -6 {
+		t.Errorf("Period change = %v, want -6", aapl.PeriodChangePct)
+	}
+	if aapl.Drop5Pct == nil || *aapl.Drop5Pct != (DropCount{Close: 1, Low: 1}) {
+		t.Errorf("Drop5Pct = %v, want 1/1", aapl.Drop5Pct)
+	}
+
+	for _, item := range snap.Items {
+		if item.Symbol == "DOW" && (item.Error == "" || item.CompanyName == "") {
+			t.Errorf("DOW should fail with its company name: %+v", item)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/api/indices/dow/snapshot?period=hourly", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Invalid period: expected 400, got %d", w.Code)
+	}
+}
+
 func TestStockEndpointMissingSymbol(t *testing.T) {
 	server := NewServer("0", nil)
 
@@ -156,3 +230,120 @@ func TestStaticFiles(t *testing.T) {
 		t.Error("Expected HTML to contain 'Stock Fetcher'")
 	}
 }
+
+func TestIndexExcelEndpoint(t *testing.T) {
+	data := reverseData(closes("2024-01-01", 100, 101, 102))
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}},
+		&symbolProvider{name: "fake", data: map[string][]StockData{"AAPL": data, "MSFT": data}})
+	server := NewServer("0", nil)
+
+	w := doJSON(t, server, "GET", "/api/indices/dow/excel?period=weekly", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, "dow_weekly.xlsx") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	f, err := excelize.OpenReader(w.Body)
+	if err != nil {
+		t.Fatalf("Invalid workbook: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	// Only the two fetched constituents get a sheet; the rest are errors in the summary
+	if sheets := f.GetSheetList(); len(sheets) != 3 || sheets[0] != "Summary" {
+		t.Errorf("Sheets = %v", sheets)
+	}
+	if rows, _ := f.GetRows("Summary"); len(rows) != 4+len(DowIndex.Symbols) {
+		t.Errorf("Summary has %d rows, want a row per constituent", len(rows))
+	}
+
+	if w := doJSON(t, server, "GET", "/api/indices/dow/excel?period=hourly", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Invalid period: expected 400, got %d", w.Code)
+	}
+}
+
+func TestRequestBudget(t *testing.T) {
+	slow := &hangingProvider{name: "slow"}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"slow"}}}, slow)
+	server := NewServer("0", nil)
+	server.requestTimeout = 30 * time.Millisecond
+
+	start := time.Now()
+	w := doJSON(t, server, "GET", "/api/stock/AAPL", "")
+	if w.Code == http.StatusOK || time.Since(start) > 5*time.Second {
+		t.Errorf("Expected a prompt failure, got %d after %s", w.Code, time.Since(start))
+	}
+	if !strings.Contains(w.Body.String(), "deadline exceeded") {
+		t.Errorf("Unexpected body: %s", w.Body.String())
+	}
+}
+
+func TestDefaultRequestTimeout(t *testing.T) {
+	t.Setenv("AWS_LAMBDA_FUNCTION_NAME", "")
+	if got := defaultRequestTimeout(); got != serverWriteTimeout-5*time.Second {
+		t.Errorf("Server budget = %s", got)
+	}
+	t.Setenv("AWS_LAMBDA_FUNCTION_NAME", "stock-fetcher")
+	if got := defaultRequestTimeout(); got != lambdaRequestTimeout {
+		t.Errorf("Lambda budget = %s", got)
+	}
+}
+
+func TestFetchErrorStatus(t *testing.T) {
+	unknown := fmt.Errorf("macrotrends: %w AAPL: not on macrotrends", ErrUnknownSymbol)
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{unknown, http.StatusNotFound, "unknown_symbol"},
+		{fmt.Errorf("%w returned by stooq", ErrNoData), http.StatusNotFound, "no_data"},
+		{&StatusError{Source: "API", Code: 429}, http.StatusTooManyRequests, "upstream_throttled"},
+		{&StatusError{Source: "iframe", Code: 503}, http.StatusServiceUnavailable, "upstream_unavailable"},
+		{&StatusError{Source: "iframe", Code: 403}, http.StatusBadGateway, "upstream_error"},
+		{fmt.Errorf("%w: could not find chart data", ErrParse), http.StatusBadGateway, "upstream_parse_error"},
+		{fmt.Errorf("request failed: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, "timeout"},
+		{errors.New("no providers configured for AAPL"), http.StatusInternalServerError, "internal"},
+		// A symbol one provider doesn't know, while the next was throttled,
+		// may well exist: the client should retry
+		{errors.Join(unknown, &StatusError{Source: "API", Code: 429}), http.StatusTooManyRequests, "upstream_throttled"},
+		{errors.Join(unknown, fmt.Errorf("%w returned for symbol AAPL", ErrNoData)), http.StatusNotFound, "unknown_symbol"},
+	}
+	for _, tt := range tests {
+		if status, code := fetchErrorStatus(tt.err); status != tt.status || code != tt.code {
+			t.Errorf("fetchErrorStatus(%q) = %d %s, want %d %s", tt.err, status, code, tt.status, tt.code)
+		}
+	}
+}
+
+func TestStockEndpointErrorCodes(t *testing.T) {
+	primary := &fakeProvider{name: "primary", err: fmt.Errorf("%w ZZZZ: no search results", ErrUnknownSymbol)}
+	backup := &fakeProvider{name: "backup", err: &StatusError{Source: "API", Code: 429, RetryAfter: 1500 * time.Millisecond}}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"primary", "backup"}}}, primary, backup)
+	server := NewServer("0", nil)
+
+	w := doJSON(t, server, "GET", "/api/stock/ZZZZ", "")
+	var resp APIResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusTooManyRequests || resp.Code != "upstream_throttled" || w.Header().Get("Retry-After") != "2" {
+		t.Errorf("Got %d %q Retry-After=%q", w.Code, resp.Code, w.Header().Get("Retry-After"))
+	}
+
+	backup.err = fmt.Errorf("%w returned for symbol ZZZZ", ErrNoData)
+	w = doJSON(t, server, "GET", "/api/stock-excel/ZZZZ", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNotFound || resp.Code != "unknown_symbol" {
+		t.Errorf("Excel export got %d %q", w.Code, resp.Code)
+	}
+
+	// Other errors carry the default code for their status
+	w = doJSON(t, server, "GET", "/api/stock/ZZZZ?period=hourly", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Code != "bad_request" {
+		t.Errorf("Bad request got %q", resp.Code)
+	}
+}
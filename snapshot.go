@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+)
+
+// Snapshot is the latest state of every member of a watchlist or index
+type Snapshot struct {
+	Key    string         `json:"key"` // Watchlist ID or index key
+	Name   string         `json:"name"`
+	Period string         `json:"period"`
+	Count  int            `json:"count"`
+	Failed int            `json:"failed"` // Items with an Error
+	Items  []SnapshotItem `json:"items"`
+}
+
+// SnapshotItem is the latest state of one symbol in a watchlist or index
+type SnapshotItem struct {
+	Symbol          string   `json:"symbol"`
+	CompanyName     string   `json:"company_name"`
+	Date            string   `json:"date,omitempty"` // Latest trading day
+	Close           *float64 `json:"close,omitempty"`
+	DayChangePct    *float64 `json:"day_change_pct,omitempty"`
+	PeriodChangePct *float64 `json:"period_change_pct,omitempty"` // Latest close vs the previous period's close
+	PE              *float64 `json:"pe,omitempty"`
+
+	// Drop days so far in the current period (C/L)
+	Drop2Pct *DropCount `json:"drop_2pct,omitempty"`
+	Drop3Pct *DropCount `json:"drop_3pct,omitempty"`
+	Drop4Pct *DropCount `json:"drop_4pct,omitempty"`
+	Drop5Pct *DropCount `json:"drop_5pct,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// snapshotDays returns how many days to fetch so the previous period's
+// close is included
+func snapshotDays(period PeriodType) int {
+	switch period {
+	case PeriodWeekly:
+		return 21
+	case PeriodQuarterly:
+		return 200
+	case PeriodYearly:
+		return 400
+	default:
+		return 70
+	}
+}
+
+// newSnapshotItem summarizes fetched data (newest-first) for one symbol
+func newSnapshotItem(symbol, companyName string, result *FetchResult, period PeriodType) SnapshotItem {
+	item := SnapshotItem{Symbol: symbol, CompanyName: companyName}
+	if result == nil || len(result.Data) == 0 {
+		item.Error = "no data found"
+		return item
+	}
+	if item.CompanyName == "" {
+		item.CompanyName = formatCompanyName(result.CompanyName)
+	}
+
+	data := result.Data
+	latest := data[0]
+	close := parseFloat(latest.Close)
+	item.Date = latest.Date
+	item.Close = &close
+
+	if len(data) > 1 {
+		if prev := parseFloat(data[1].Close); prev > 0 {
+			item.DayChangePct = roundPtr((close - prev) / prev * 100)
+		}
+	}
+
+	periods := AggregateToPeriods(reverseData(data), period)
+	if len(periods) > 1 {
+		if prev := parseFloat(periods[1].Close); prev > 0 {
+			item.PeriodChangePct = roundPtr((close - prev) / prev * 100)
+		}
+	}
+	if len(periods) > 0 {
+		current := periods[0]
+		item.Drop2Pct, item.Drop3Pct = &current.Drop2Pct, &current.Drop3Pct
+		item.Drop4Pct, item.Drop5Pct = &current.Drop4Pct, &current.Drop5Pct
+	}
+
+	if pe := parseFloat(latest.PE); pe != 0 {
+		item.PE = roundPtr(pe)
+	}
+	return item
+}
+
+// roundPtr rounds to 2 decimals and returns a pointer for optional JSON fields
+func roundPtr(v float64) *float64 {
+	r := math.Round(v*100) / 100
+	return &r
+}
+
+// buildSnapshot fetches every symbol concurrently and summarizes each one.
+// Per-symbol failures are reported in the item's Error field.
+func buildSnapshot(ctx context.Context, cache *Cache, key, name string, symbols []string, period PeriodType) Snapshot {
+	results, errs := fetchStockDataBatch(ctx, cache, symbols, snapshotDays(period))
+	names := GetCompanyNamesForSymbols(symbols)
+
+	snap := Snapshot{Key: key, Name: name, Period: string(period), Count: len(symbols)}
+	snap.Items = make([]SnapshotItem, len(symbols))
+	for i, sym := range symbols {
+		upper := strings.ToUpper(sym)
+		if errs[i] != nil {
+			snap.Items[i] = SnapshotItem{Symbol: upper, CompanyName: names[sym], Error: errs[i].Error()}
+		} else {
+			snap.Items[i] = newSnapshotItem(upper, names[sym], results[i], period)
+		}
+		if snap.Items[i].Error != "" {
+			snap.Failed++
+		}
+	}
+	return snap
+}
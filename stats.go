@@ -0,0 +1,218 @@
+package main
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ReturnPoint is a single period's return
+type ReturnPoint struct {
+	Date      string  `json:"date"` // Day or period label
+	ReturnPct float64 `json:"return_pct"`
+}
+
+// RiskStats summarizes risk and return over a price series
+type RiskStats struct {
+	StartDate          string       `json:"start_date"`
+	EndDate            string       `json:"end_date"`
+	Years              float64      `json:"years"`
+	PeriodType         string       `json:"period_type"` // Frequency of the returns below
+	Periods            int          `json:"periods"`     // Number of returns
+	TotalReturnPct     float64      `json:"total_return_pct"`
+	CAGRPct            float64      `json:"cagr_pct"`
+	VolatilityPct      float64      `json:"volatility_pct"` // Annualized standard deviation of returns
+	RiskFreeRatePct    float64      `json:"risk_free_rate_pct"`
+	Sharpe             float64      `json:"sharpe"`
+	Sortino            float64      `json:"sortino"`
+	BestDay            *ReturnPoint `json:"best_day,omitempty"`
+	WorstDay           *ReturnPoint `json:"worst_day,omitempty"`
+	BestPeriod         *ReturnPoint `json:"best_period,omitempty"`
+	WorstPeriod        *ReturnPoint `json:"worst_period,omitempty"`
+	PositivePeriodsPct float64      `json:"positive_periods_pct"`
+	MaxDrawdownPct     float64      `json:"max_drawdown_pct"`
+}
+
+// periodsPerYear returns the annualization factor for a period type
+func periodsPerYear(periodType string) float64 {
+	switch PeriodType(periodType) {
+	case PeriodWeekly:
+		return 52
+	case PeriodMonthly:
+		return 12
+	case PeriodQuarterly:
+		return 4
+	case PeriodYearly:
+		return 1
+	default:
+		return 252 // trading days
+	}
+}
+
+// defaultRiskFreeRate returns the annual risk-free rate in percent from
+// the RISK_FREE_RATE env var (e.g. "4.5"), or 0 if unset
+func defaultRiskFreeRate() float64 {
+	if v, err := strconv.ParseFloat(os.Getenv("RISK_FREE_RATE"), 64); err == nil {
+		return v
+	}
+	return 0
+}
+
+// ComputeStockStats computes risk statistics for daily stock data
+// (oldest-first) at the given period type ("daily", "weekly", ...)
+func ComputeStockStats(data []StockData, periodType string, riskFreePct float64) *RiskStats {
+	dates := make([]string, 0, len(data))
+	values := make([]float64, 0, len(data))
+	for _, d := range data {
+		if v := parseFloat(d.Close); v > 0 {
+			dates = append(dates, d.Date)
+			values = append(values, v)
+		}
+	}
+	return ComputeRiskStats(dates, values, periodType, riskFreePct)
+}
+
+// ComputeRiskStats computes risk statistics for a daily value series
+// (prices or portfolio equity, oldest-first). Returns nil if there are
+// fewer than two points.
+func ComputeRiskStats(dates []string, values []float64, periodType string, riskFreePct float64) *RiskStats {
+	if len(values) < 2 || len(dates) != len(values) {
+		return nil
+	}
+
+	first, last := values[0], values[len(values)-1]
+	stats := &RiskStats{
+		StartDate:       dates[0],
+		EndDate:         dates[len(dates)-1],
+		PeriodType:      periodType,
+		RiskFreeRatePct: riskFreePct,
+		TotalReturnPct:  roundPct((last/first - 1) * 100),
+	}
+
+	days := daysBetween(stats.StartDate, stats.EndDate)
+	stats.Years = math.Round(float64(days)/365.25*100) / 100
+	if days > 0 && first > 0 && last > 0 {
+		stats.CAGRPct = roundPct((math.Pow(last/first, 365.25/float64(days)) - 1) * 100)
+	}
+
+	// Best and worst single day, and the deepest fall from a peak
+	daily := seriesReturns(dates, values)
+	stats.BestDay, stats.WorstDay = bestWorst(daily)
+	stats.MaxDrawdownPct = maxDrawdownPct(values)
+
+	// Returns at the requested frequency
+	returns := daily
+	if periodType != "daily" && periodType != "" {
+		returns = periodReturns(dates, values, PeriodType(periodType))
+	}
+	stats.Periods = len(returns)
+	stats.BestPeriod, stats.WorstPeriod = bestWorst(returns)
+	if len(returns) == 0 {
+		return stats
+	}
+
+	ppy := periodsPerYear(periodType)
+	rfPerPeriod := riskFreePct / 100 / ppy
+
+	var sum, positive float64
+	for _, r := range returns {
+		sum += r.ReturnPct / 100
+		if r.ReturnPct > 0 {
+			positive++
+		}
+	}
+	mean := sum / float64(len(returns))
+	stats.PositivePeriodsPct = roundPct(positive / float64(len(returns)) * 100)
+
+	var variance, downside float64
+	for _, r := range returns {
+		x := r.ReturnPct / 100
+		variance += (x - mean) * (x - mean)
+		if excess := x - rfPerPeriod; excess < 0 {
+			downside += excess * excess
+		}
+	}
+	if len(returns) > 1 {
+		variance /= float64(len(returns) - 1)
+	}
+	stdev := math.Sqrt(variance)
+	downsideDev := math.Sqrt(downside / float64(len(returns)))
+
+	stats.VolatilityPct = roundPct(stdev * math.Sqrt(ppy) * 100)
+	if stdev > 0 {
+		stats.Sharpe = math.Round((mean-rfPerPeriod)/stdev*math.Sqrt(ppy)*100) / 100
+	}
+	if downsideDev > 0 {
+		stats.Sortino = math.Round((mean-rfPerPeriod)/downsideDev*math.Sqrt(ppy)*100) / 100
+	}
+	return stats
+}
+
+// seriesReturns returns the point-to-point percentage returns of a series
+func seriesReturns(dates []string, values []float64) []ReturnPoint {
+	var returns []ReturnPoint
+	for i := 1; i < len(values); i++ {
+		if values[i-1] > 0 {
+			returns = append(returns, ReturnPoint{
+				Date:      dates[i],
+				ReturnPct: (values[i]/values[i-1] - 1) * 100,
+			})
+		}
+	}
+	return returns
+}
+
+// periodReturns returns period-over-period returns using each period's
+// last value, matching the Change column of AggregateToPeriods
+func periodReturns(dates []string, values []float64, periodType PeriodType) []ReturnPoint {
+	var keys []string
+	var closes []float64
+	for i, d := range dates {
+		t, err := time.Parse("2006-01-02", d)
+		if err != nil {
+			continue
+		}
+		key := getPeriodKey(t, periodType)
+		if len(keys) > 0 && keys[len(keys)-1] == key {
+			closes[len(closes)-1] = values[i]
+			continue
+		}
+		keys = append(keys, key)
+		closes = append(closes, values[i])
+	}
+	return seriesReturns(keys, closes)
+}
+
+// bestWorst returns the highest and lowest returns, rounded
+func bestWorst(returns []ReturnPoint) (*ReturnPoint, *ReturnPoint) {
+	if len(returns) == 0 {
+		return nil, nil
+	}
+	best, worst := returns[0], returns[0]
+	for _, r := range returns[1:] {
+		if r.ReturnPct > best.ReturnPct {
+			best = r
+		}
+		if r.ReturnPct < worst.ReturnPct {
+			worst = r
+		}
+	}
+	best.ReturnPct = roundPct(best.ReturnPct)
+	worst.ReturnPct = roundPct(worst.ReturnPct)
+	return &best, &worst
+}
+
+// maxDrawdownPct returns the deepest peak-to-trough decline of a series
+func maxDrawdownPct(values []float64) float64 {
+	var peak, maxDepth float64
+	for _, v := range values {
+		if v > peak {
+			peak = v
+		}
+		if peak > 0 {
+			maxDepth = math.Min(maxDepth, (v-peak)/peak*100)
+		}
+	}
+	return roundPct(maxDepth)
+}
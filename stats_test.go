@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestComputeStockStats_Daily(t *testing.T) {
+	// +10%, -10%, +10%
+	stats := ComputeStockStats(closes("2024-01-01", 100, 110, 99, 108.9), "daily", 0)
+	if stats == nil {
+		t.Fatal("Expected stats, got nil")
+	}
+
+	if stats.Periods != 3 || stats.TotalReturnPct != 8.9 || stats.PositivePeriodsPct != 66.67 {
+		t.Errorf("Unexpected totals: %+v", stats)
+	}
+	if stats.BestDay.Date != "2024-01-02" || stats.BestDay.ReturnPct != 10 {
+		t.Errorf("BestDay = %+v", stats.BestDay)
+	}
+	if stats.WorstDay.Date != "2024-01-03" || stats.WorstDay.ReturnPct != -10 {
+		t.Errorf("WorstDay = %+v", stats.WorstDay)
+	}
+	if *stats.BestPeriod != *stats.BestDay {
+		t.Errorf("Daily best period should equal best day: %+v", stats.BestPeriod)
+	}
+	if stats.MaxDrawdownPct != -10 {
+		t.Errorf("MaxDrawdownPct = %v, want -10", stats.MaxDrawdownPct)
+	}
+
+	// Mean 3.33%, sample SD 11.55%, downside deviation 5.77%, annualized by sqrt(252)
+	if stats.VolatilityPct != 183.3 || stats.Sharpe != 4.58 || stats.Sortino != 9.17 {
+		t.Errorf("Vol/Sharpe/Sortino = %v/%v/%v", stats.VolatilityPct, stats.Sharpe, stats.Sortino)
+	}
+}
+
+func TestComputeStockStats_RiskFree(t *testing.T) {
+	data := closes("2024-01-01", 100, 110, 99, 108.9)
+	base := ComputeStockStats(data, "daily", 0)
+	withRF := ComputeStockStats(data, "daily", 5)
+
+	if withRF.RiskFreeRatePct != 5 || withRF.Sharpe >= base.Sharpe || withRF.Sortino >= base.Sortino {
+		t.Errorf("Risk-free rate should lower Sharpe/Sortino: %+v vs %+v", withRF, base)
+	}
+	if withRF.VolatilityPct != base.VolatilityPct {
+		t.Errorf("Volatility should not depend on the risk-free rate")
+	}
+}
+
+func TestComputeStockStats_Monthly(t *testing.T) {
+	data := []StockData{
+		{Date: "2024-01-31", Close: "100"},
+		{Date: "2024-02-15", Close: "105"},
+		{Date: "2024-02-29", Close: "110"},
+		{Date: "2024-03-29", Close: "99"},
+	}
+	stats := ComputeStockStats(data, "monthly", 0)
+
+	if stats.Periods != 2 || stats.PeriodType != "monthly" {
+		t.Fatalf("Expected 2 monthly returns, got %+v", stats)
+	}
+	if stats.BestPeriod.Date != "2024-02" || stats.BestPeriod.ReturnPct != 10 {
+		t.Errorf("BestPeriod = %+v", stats.BestPeriod)
+	}
+	if stats.WorstPeriod.Date != "2024-03" || stats.WorstPeriod.ReturnPct != -10 {
+		t.Errorf("WorstPeriod = %+v", stats.WorstPeriod)
+	}
+	// Best day still comes from the daily series
+	if stats.BestDay.Date != "2024-02-15" || stats.BestDay.ReturnPct != 5 {
+		t.Errorf("BestDay = %+v", stats.BestDay)
+	}
+	if stats.PositivePeriodsPct != 50 {
+		t.Errorf("PositivePeriodsPct = %v, want 50", stats.PositivePeriodsPct)
+	}
+}
+
+func TestComputeStockStats_SinglePeriod(t *testing.T) {
+	// Both days fall in one month, so there is no monthly return, but the
+	// daily drawdown still counts
+	stats := ComputeStockStats(closes("2024-01-01", 100, 90, 95), "monthly", 0)
+	if stats.Periods != 0 || stats.BestPeriod != nil {
+		t.Errorf("Expected no monthly returns: %+v", stats)
+	}
+	if stats.MaxDrawdownPct != -10 {
+		t.Errorf("MaxDrawdownPct = %v, want -10", stats.MaxDrawdownPct)
+	}
+}
+
+func TestComputeStockStats_CAGR(t *testing.T) {
+	// Doubling over two years (731 days, leap year included) is ~41.4% a year
+	data := []StockData{
+		{Date: "2020-01-01", Close: "100"},
+		{Date: "2021-01-01", Close: "150"},
+		{Date: "2022-01-01", Close: "200"},
+	}
+	stats := ComputeStockStats(data, "yearly", 0)
+	if stats.Years != 2 || stats.CAGRPct != 41.39 {
+		t.Errorf("Years/CAGR = %v/%v", stats.Years, stats.CAGRPct)
+	}
+}
+
+func TestComputeStockStats_TooShort(t *testing.T) {
+	if stats := ComputeStockStats(closes("2024-01-01", 100), "daily", 0); stats != nil {
+		t.Errorf("Expected nil for a single point, got %+v", stats)
+	}
+}
+
+func TestStockEndpointSummary(t *testing.T) {
+	data := reverseData(closes(time.Now().AddDate(0, 0, -4).Format("2006-01-02"), 100, 90, 99, 110, 105))
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}},
+		&fakeProvider{name: "fake", data: data})
+
+	server := NewServer("0", nil)
+	req := httptest.NewRequest("GET", "/api/stock/TEST?days=30&period=daily&risk_free=4.5", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Data StockResponse `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	summary := resp.Data.Summary
+	if summary == nil {
+		t.Fatal("Expected summary in response")
+	}
+	if summary.RiskFreeRatePct != 4.5 || summary.TotalReturnPct != 5 || summary.MaxDrawdownPct != -10 {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+}
+
+func TestStockEndpointInvalidRiskFree(t *testing.T) {
+	server := NewServer("0", nil)
+	req := httptest.NewRequest("GET", "/api/stock/AAPL?risk_free=abc", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
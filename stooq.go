@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StooqFetcher fetches daily OHLCV data from Stooq CSV downloads
+type StooqFetcher struct {
+	client  *http.Client
+	baseURL string
+}
+
+// NewStooqFetcher creates a new Stooq fetcher
+func NewStooqFetcher() *StooqFetcher {
+	return &StooqFetcher{
+		client:  newUpstreamClient(),
+		baseURL: "https://stooq.com",
+	}
+}
+
+// stooqSymbol converts a symbol to Stooq's ticker format.
+// US tickers get a ".us" suffix; HK tickers drop leading zeros ("0700.HK" → "700.hk").
+func stooqSymbol(symbol string) string {
+	s := strings.ToLower(symbol)
+	if isHKStock(symbol) {
+		code := strings.TrimLeft(strings.TrimSuffix(s, ".hk"), "0")
+		return code + ".hk"
+	}
+	if !strings.Contains(s, ".") {
+		return s + ".us"
+	}
+	// Class shares like BRK.B are written with a dash on Stooq
+	if parts := strings.Split(s, "."); len(parts) == 2 && len(parts[1]) == 1 {
+		return parts[0] + "-" + parts[1] + ".us"
+	}
+	return s
+}
+
+// FetchHistoricalData downloads the daily CSV for a symbol between two dates
+func (f *StooqFetcher) FetchHistoricalData(ctx context.Context, symbol string, startDate, endDate time.Time) ([]StockData, error) {
+	url := fmt.Sprintf("%s/q/d/l/?s=%s&i=d&d1=%s&d2=%s",
+		f.baseURL,
+		stooqSymbol(symbol),
+		startDate.Format("20060102"),
+		endDate.Format("20060102"),
+	)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+	req.Header.Set("Accept", "text/csv")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError("stooq", resp, nil)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseStooqCSV(string(body))
+}
+
+// parseStooqCSV converts a Stooq daily CSV (Date,Open,High,Low,Close,Volume)
+// into StockData sorted oldest-first
+func parseStooqCSV(body string) ([]StockData, error) {
+	body = strings.TrimSpace(body)
+	if body == "" || strings.HasPrefix(body, "No data") {
+		return nil, fmt.Errorf("%w returned by stooq", ErrNoData)
+	}
+
+	reader := csv.NewReader(strings.NewReader(body))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%w: stooq CSV: %w", ErrParse, err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("%w returned by stooq", ErrNoData)
+	}
+
+	// Map header names to column indexes
+	cols := make(map[string]int)
+	for i, h := range records[0] {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, required := range []string{"date", "open", "high", "low", "close"} {
+		if _, ok := cols[required]; !ok {
+			return nil, fmt.Errorf("%w: stooq CSV missing %q column", ErrParse, required)
+		}
+	}
+
+	field := func(rec []string, name string) string {
+		i, ok := cols[name]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+
+	var data []StockData
+	for _, rec := range records[1:] {
+		closeVal, err := strconv.ParseFloat(field(rec, "close"), 64)
+		if err != nil || closeVal == 0 {
+			continue
+		}
+		volume, _ := strconv.ParseFloat(field(rec, "volume"), 64)
+
+		data = append(data, StockData{
+			Date:   field(rec, "date"),
+			Open:   formatFloat(parseFloat(field(rec, "open"))),
+			High:   formatFloat(parseFloat(field(rec, "high"))),
+			Low:    formatFloat(parseFloat(field(rec, "low"))),
+			Close:  formatFloat(closeVal),
+			Volume: formatVolume(int64(volume)),
+		})
+	}
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w returned by stooq", ErrNoData)
+	}
+
+	computeChanges(data)
+	return data, nil
+}
+
+// Name implements Provider
+func (f *StooqFetcher) Name() string {
+	return "stooq"
+}
+
+// QuoteURL implements Provider
+func (f *StooqFetcher) QuoteURL(symbol, companyName string) string {
+	return fmt.Sprintf("https://stooq.com/q/?s=%s", stooqSymbol(symbol))
+}
+
+// Fetch implements Provider, returning daily prices (no P/E)
+func (f *StooqFetcher) Fetch(ctx context.Context, symbol string, days int) (*FetchResult, error) {
+	endDate := time.Now()
+	startDate := endDate.AddDate(0, 0, -days)
+
+	data, err := f.FetchHistoricalData(ctx, symbol, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FetchResult{
+		Data:        reverseData(data),
+		CompanyName: GetCompanyName(strings.ToUpper(symbol)),
+	}, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const stooqAAPLCSV = `Date,Open,High,Low,Close,Volume
+2024-01-02,187.15,188.44,183.89,185.64,82488674
+2024-01-03,184.22,185.88,183.43,184.25,58414460
+2024-01-04,182.15,183.0872,180.88,181.91,71983570
+`
+
+func TestStooqSymbol(t *testing.T) {
+	tests := []struct {
+		symbol   string
+		expected string
+	}{
+		{"AAPL", "aapl.us"},
+		{"aapl", "aapl.us"},
+		{"BRK.B", "brk-b.us"},
+		{"0700.HK", "700.hk"},
+		{"9988.HK", "9988.hk"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.symbol, func(t *testing.T) {
+			if got := stooqSymbol(tt.symbol); got != tt.expected {
+				t.Errorf("stooqSymbol(%q) = %q, want %q", tt.symbol, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseStooqCSV(t *testing.T) {
+	data, err := parseStooqCSV(stooqAAPLCSV)
+	if err != nil {
+		t.Fatalf("parseStooqCSV() error = %v", err)
+	}
+
+	if len(data) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(data))
+	}
+
+	first := data[0]
+	if first.Date != "2024-01-02" || first.Close != "185.64" || first.Low != "183.89" {
+		t.Errorf("Unexpected first record: %+v", first)
+	}
+	if first.Volume != "82.49M" {
+		t.Errorf("Volume = %q, want %q", first.Volume, "82.49M")
+	}
+	if first.Change != "" {
+		t.Errorf("First record change should be empty, got %q", first.Change)
+	}
+	if data[1].Change != "-0.75%" {
+		t.Errorf("Second record change = %q, want %q", data[1].Change, "-0.75%")
+	}
+}
+
+func TestParseStooqCSV_NoData(t *testing.T) {
+	for _, body := range []string{"", "No data", "Date,Open,High,Low,Close,Volume\n"} {
+		if _, err := parseStooqCSV(body); err == nil {
+			t.Errorf("parseStooqCSV(%q) expected error", body)
+		}
+	}
+}
+
+func TestStooqFetch(t *testing.T) {
+	var gotQuery string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+		w.Header().Set("Content-Type", "text/csv")
+		_, _ = w.Write([]byte(stooqAAPLCSV))
+	}))
+	defer srv.Close()
+
+	fetcher := NewStooqFetcher()
+	fetcher.baseURL = srv.URL
+
+	result, err := fetcher.Fetch(t.Context(), "AAPL", 30)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+
+	if !strings.Contains(gotQuery, "s=aapl.us") || !strings.Contains(gotQuery, "i=d") {
+		t.Errorf("Unexpected query %q", gotQuery)
+	}
+	if !strings.Contains(gotQuery, "d2="+time.Now().Format("20060102")) {
+		t.Errorf("Expected end date in query %q", gotQuery)
+	}
+
+	// Provider results are newest-first
+	if len(result.Data) != 3 || result.Data[0].Date != "2024-01-04" {
+		t.Errorf("Unexpected data order: %+v", result.Data)
+	}
+	if result.CompanyName != "Apple" {
+		t.Errorf("CompanyName = %q, want %q", result.CompanyName, "Apple")
+	}
+}
+
+func TestStooqFetchCancelled(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	fetcher := NewStooqFetcher()
+	fetcher.baseURL = srv.URL
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := fetcher.Fetch(ctx, "AAPL", 30); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Fetch() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Fetch took %s after its deadline", elapsed)
+	}
+}
+
+func TestStooqFetchErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("s") {
+		case "busy.us":
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "gone.us":
+			_, _ = w.Write([]byte("No data"))
+		case "odd.us":
+			_, _ = w.Write([]byte("Date,Price\n2024-01-02,10\n"))
+		}
+	}))
+	defer srv.Close()
+
+	fetcher := NewStooqFetcher()
+	fetcher.baseURL = srv.URL
+	tests := map[string]error{
+		"BUSY": ErrUpstreamThrottled,
+		"GONE": ErrNoData,
+		"ODD":  ErrParse,
+	}
+	for symbol, want := range tests {
+		if _, err := fetcher.Fetch(t.Context(), symbol, 30); !errors.Is(err, want) {
+			t.Errorf("Fetch(%s) error = %v, want %v", symbol, err, want)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Spans are a small subset of OpenTelemetry tracing: enough to see where a
+// slow request spent its time (cache, each provider, each upstream request).
+// Finished spans are logged at debug level and, when an OTLP endpoint is
+// configured, exported to a collector as OTLP/HTTP JSON. Like /metrics, the
+// wire format is simple enough that the SDK isn't worth the dependency.
+
+// Span kinds, as numbered by OTLP
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+)
+
+// span is one timed operation within a trace
+type span struct {
+	name     string
+	kind     int
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte // Zero for a root span
+	start    time.Time
+
+	mu    sync.Mutex
+	attrs []slog.Attr
+	ended bool
+}
+
+// startSpan starts a span as a child of the context's span, or as the root of
+// a new trace. The returned context carries the new span.
+func startSpan(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, *span) {
+	return startSpanKind(ctx, spanKindInternal, name, attrs...)
+}
+
+// startSpanKind is startSpan with an explicit span kind
+func startSpanKind(ctx context.Context, kind int, name string, attrs ...slog.Attr) (context.Context, *span) {
+	s := &span{name: name, kind: kind, start: time.Now(), attrs: attrs}
+	if parent := spanFrom(ctx); parent != nil {
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else {
+		putRandom(s.traceID[:])
+	}
+	putRandom(s.spanID[:])
+	return context.WithValue(ctx, spanKey, s), s
+}
+
+// startRemoteSpan starts a server span continuing the trace in a W3C
+// traceparent header, or a new trace if the header is missing or invalid
+func startRemoteSpan(ctx context.Context, name, traceparent string, attrs ...slog.Attr) (context.Context, *span) {
+	ctx, s := startSpanKind(ctx, spanKindServer, name, attrs...)
+	if traceID, parentID, ok := parseTraceparent(traceparent); ok {
+		s.traceID, s.parentID = traceID, parentID
+	}
+	return ctx, s
+}
+
+// spanFrom returns the context's current span, or nil
+func spanFrom(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey).(*span)
+	return s
+}
+
+// TraceID returns the span's trace ID in hex
+func (s *span) TraceID() string { return hex.EncodeToString(s.traceID[:]) }
+
+// SpanID returns the span's ID in hex
+func (s *span) SpanID() string { return hex.EncodeToString(s.spanID[:]) }
+
+// SetName renames the span, e.g. once the route of a request is known
+func (s *span) SetName(name string) {
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+}
+
+// SetAttr adds an attribute to the span
+func (s *span) SetAttr(key string, value any) {
+	s.mu.Lock()
+	s.attrs = append(s.attrs, slog.Any(key, value))
+	s.mu.Unlock()
+}
+
+// End finishes the span, marking it failed if err is non-nil. Only the
+// first call has an effect.
+func (s *span) End(err error) {
+	end := time.Now()
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	name, attrs := s.name, s.attrs
+	s.mu.Unlock()
+
+	if slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		args := []any{"span", name, "trace_id", s.TraceID(), "span_id", s.SpanID(),
+			"duration_ms", end.Sub(s.start).Milliseconds()}
+		for _, a := range attrs {
+			args = append(args, a)
+		}
+		if err != nil {
+			args = append(args, "error", err)
+		}
+		slog.Debug("Span finished", args...)
+	}
+	if e := traceExporter; e != nil {
+		e.export(s.otlp(name, attrs, end, err))
+	}
+}
+
+// putRandom fills b with random bytes, never all zero (an invalid ID)
+func putRandom(b []byte) {
+	for {
+		for i := range b {
+			b[i] = byte(rand.Uint32())
+		}
+		for _, c := range b {
+			if c != 0 {
+				return
+			}
+		}
+	}
+}
+
+// parseTraceparent extracts the trace and parent span IDs from a W3C
+// traceparent header: 00-<32 hex trace ID>-<16 hex span ID>-<2 hex flags>
+func parseTraceparent(h string) (traceID [16]byte, spanID [8]byte, ok bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return traceID, spanID, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return traceID, spanID, false
+	}
+	if _, err := hex.Decode(spanID[:], []byte(parts[2])); err != nil {
+		return traceID, spanID, false
+	}
+	return traceID, spanID, traceID != [16]byte{} && spanID != [8]byte{}
+}
+
+// OTLP/HTTP JSON encoding, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"` // 2 = error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+// otlp converts a finished span to its OTLP form
+func (s *span) otlp(name string, attrs []slog.Attr, end time.Time, err error) otlpSpan {
+	o := otlpSpan{
+		TraceID:           s.TraceID(),
+		SpanID:            s.SpanID(),
+		Name:              name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+	}
+	if s.parentID != [8]byte{} {
+		o.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	for _, a := range attrs {
+		o.Attributes = append(o.Attributes, otlpAttr(a))
+	}
+	if err != nil {
+		o.Status = otlpStatus{Code: 2, Message: err.Error()}
+	}
+	return o
+}
+
+// otlpAttr converts an attribute to an OTLP key/value
+func otlpAttr(a slog.Attr) otlpKeyValue {
+	v := a.Value.Resolve()
+	var value map[string]any
+	switch v.Kind() {
+	case slog.KindInt64:
+		value = map[string]any{"intValue": strconv.FormatInt(v.Int64(), 10)} // int64 is a JSON string in OTLP
+	case slog.KindUint64:
+		value = map[string]any{"intValue": strconv.FormatUint(v.Uint64(), 10)}
+	case slog.KindFloat64:
+		value = map[string]any{"doubleValue": v.Float64()}
+	case slog.KindBool:
+		value = map[string]any{"boolValue": v.Bool()}
+	default:
+		value = map[string]any{"stringValue": v.String()}
+	}
+	return otlpKeyValue{Key: a.Key, Value: value}
+}
+
+const (
+	otlpBatchSize     = 256
+	otlpQueueSize     = 4096
+	otlpFlushInterval = 5 * time.Second
+)
+
+// otlpExporter batches finished spans and POSTs them to an OTLP/HTTP
+// collector. Spans are dropped rather than blocking requests when the
+// queue is full.
+type otlpExporter struct {
+	url      string
+	resource []otlpKeyValue
+	client   *http.Client
+
+	spans chan otlpSpan
+	flush chan chan struct{}
+}
+
+// traceExporter is the active exporter; nil disables export
+var traceExporter *otlpExporter
+
+// newOTLPExporter starts an exporter sending to url (e.g.
+// http://localhost:4318/v1/traces)
+func newOTLPExporter(url, service string) *otlpExporter {
+	e := &otlpExporter{
+		url: url,
+		resource: []otlpKeyValue{
+			otlpAttr(slog.String("service.name", service)),
+			otlpAttr(slog.String("service.version", Version)),
+		},
+		// Not the upstream client: exports must not create spans of their own
+		client: &http.Client{Timeout: 10 * time.Second},
+		spans:  make(chan otlpSpan, otlpQueueSize),
+		flush:  make(chan chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// export queues a finished span
+func (e *otlpExporter) export(s otlpSpan) {
+	select {
+	case e.spans <- s:
+	default:
+	}
+}
+
+// Flush sends every queued span, waiting until done or ctx is cancelled
+func (e *otlpExporter) Flush(ctx context.Context) {
+	done := make(chan struct{})
+	select {
+	case e.flush <- done:
+	case <-ctx.Done():
+		return
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+func (e *otlpExporter) run() {
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+
+	var batch []otlpSpan
+	for {
+		select {
+		case s := <-e.spans:
+			if batch = append(batch, s); len(batch) >= otlpBatchSize {
+				e.send(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			e.send(batch)
+			batch = nil
+		case done := <-e.flush:
+			for drained := false; !drained; {
+				select {
+				case s := <-e.spans:
+					batch = append(batch, s)
+				default:
+					drained = true
+				}
+			}
+			e.send(batch)
+			batch = nil
+			close(done)
+		}
+	}
+}
+
+// send POSTs one batch of spans
+func (e *otlpExporter) send(batch []otlpSpan) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := e.payload(batch)
+	if err != nil {
+		slog.Warn("Trace export failed", "error", err)
+		return
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("Trace export failed", "spans", len(batch), "error", err)
+		return
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		slog.Warn("Trace export failed", "spans", len(batch), "error", fmt.Sprintf("collector returned status %d", resp.StatusCode))
+	}
+}
+
+// payload encodes a batch of spans as an OTLP ExportTraceServiceRequest
+func (e *otlpExporter) payload(batch []otlpSpan) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": e.resource},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "stock-fetcher", "version": Version},
+				"spans": batch,
+			}},
+		}},
+	})
+}
+
+// setupTracing enables span export from the standard OpenTelemetry
+// environment variables:
+//   - OTEL_EXPORTER_OTLP_TRACES_ENDPOINT → full URL of the traces endpoint
+//   - OTEL_EXPORTER_OTLP_ENDPOINT        → collector base URL; /v1/traces is appended
+//   - OTEL_SERVICE_NAME                  → service.name (default stock-fetcher)
+func setupTracing() {
+	url := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if url == "" {
+		if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			url = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+	if url == "" {
+		return
+	}
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "stock-fetcher"
+	}
+	traceExporter = newOTLPExporter(url, service)
+	slog.Info("Exporting traces", "endpoint", url)
+}
+
+// flushTraces sends queued spans before the process exits or freezes
+func flushTraces(ctx context.Context) {
+	if traceExporter != nil {
+		traceExporter.Flush(ctx)
+	}
+}
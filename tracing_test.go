@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	traceID, spanID, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok || traceID[0] != 0x4b || spanID[7] != 0xb7 {
+		t.Errorf("Valid header: ok=%v trace=%x span=%x", ok, traceID, spanID)
+	}
+	for _, h := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-zzf067aa0ba902b7-01",
+	} {
+		if _, _, ok := parseTraceparent(h); ok {
+			t.Errorf("parseTraceparent(%q) should fail", h)
+		}
+	}
+}
+
+func TestSpanParentage(t *testing.T) {
+	ctx, root := startSpan(t.Context(), "root")
+	_, child := startSpan(ctx, "child")
+	if child.traceID != root.traceID || child.parentID != root.spanID || root.parentID != [8]byte{} {
+		t.Error("Child span should share the trace and point at its parent")
+	}
+	if spanFrom(ctx) != root {
+		t.Error("Context should carry the span")
+	}
+}
+
+func TestOTLPExport(t *testing.T) {
+	var mu sync.Mutex
+	var spans []otlpSpan
+	var service string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				Resource struct {
+					Attributes []otlpKeyValue `json:"attributes"`
+				} `json:"resource"`
+				ScopeSpans []struct {
+					Spans []otlpSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if r.URL.Path != "/v1/traces" || json.NewDecoder(r.Body).Decode(&req) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			service, _ = rs.Resource.Attributes[0].Value["stringValue"].(string)
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	defer collector.Close()
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL+"/")
+	t.Setenv("OTEL_SERVICE_NAME", "test-fetcher")
+	setupTracing()
+	t.Cleanup(func() { traceExporter = nil })
+
+	p := &fakeProvider{name: "fake", data: cliSeries()}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}}, p)
+	server := NewServer("0", newTestCache(t))
+
+	req := httptest.NewRequest("GET", "/api/stock/AAPL?days=30", nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	server.ServeHTTP(httptest.NewRecorder(), req)
+	flushTraces(t.Context())
+
+	mu.Lock()
+	defer mu.Unlock()
+	if service != "test-fetcher" {
+		t.Errorf("service.name = %q", service)
+	}
+	byName := map[string]otlpSpan{}
+	for _, s := range spans {
+		if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("Span %s is not in the caller's trace", s.Name)
+		}
+		byName[s.Name] = s
+	}
+
+	root, fetch, provider := byName["GET /api/stock/"], byName["fetchStockData"], byName["provider.fetch"]
+	if root.Kind != spanKindServer || root.ParentSpanID != "00f067aa0ba902b7" {
+		t.Fatalf("Unexpected root span: %+v (all: %v)", root, spans)
+	}
+	if fetch.ParentSpanID != root.SpanID || provider.ParentSpanID != fetch.SpanID {
+		t.Errorf("Spans should nest request → fetchStockData → provider.fetch: %+v", spans)
+	}
+	if store, ok := byName["cache.StoreDailyPrices"]; !ok || store.ParentSpanID != fetch.SpanID {
+		t.Errorf("Missing cache span: %+v", store)
+	}
+	for _, a := range fetch.Attributes {
+		if a.Key == "cache.result" && a.Value["stringValue"] != "miss" {
+			t.Errorf("cache.result = %v, want miss", a.Value)
+		}
+	}
+}
+
+// otlpTraceExample is the ExportTraceServiceRequest example from
+// opentelemetry-proto (examples/trace.json)
+const otlpTraceExample = `{
+  "resourceSpans": [{
+    "resource": {
+      "attributes": [{"key": "service.name", "value": {"stringValue": "my.service"}}]
+    },
+    "scopeSpans": [{
+      "scope": {
+        "name": "my.library",
+        "version": "1.0.0",
+        "attributes": [{"key": "my.scope.attribute", "value": {"stringValue": "some scope attribute"}}]
+      },
+      "spans": [{
+        "traceId": "5B8EFFF798038103D269B633813FC60C",
+        "spanId": "EEE19B7EC3C1B174",
+        "parentSpanId": "EEE19B7EC3C1B173",
+        "name": "I'm a server span",
+        "startTimeUnixNano": "1544712660000000000",
+        "endTimeUnixNano": "1544712661000000000",
+        "kind": 2,
+        "attributes": [{"key": "my.span.attr", "value": {"stringValue": "some value"}}]
+      }]
+    }]
+  }]
+}`
+
+// The OTLP JSON mapping of ExportTraceServiceRequest, with the field types
+// the spec requires: 64-bit integers as strings and enums as integers.
+// Decoding with unknown fields disallowed rejects anything a collector
+// wouldn't recognise.
+type (
+	schemaRequest struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes             []schemaKeyValue `json:"attributes"`
+				DroppedAttributesCount uint32           `json:"droppedAttributesCount"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Scope struct {
+					Name                   string           `json:"name"`
+					Version                string           `json:"version"`
+					Attributes             []schemaKeyValue `json:"attributes"`
+					DroppedAttributesCount uint32           `json:"droppedAttributesCount"`
+				} `json:"scope"`
+				Spans     []schemaSpan `json:"spans"`
+				SchemaURL string       `json:"schemaUrl"`
+			} `json:"scopeSpans"`
+			SchemaURL string `json:"schemaUrl"`
+		} `json:"resourceSpans"`
+	}
+	schemaSpan struct {
+		TraceID                string           `json:"traceId"`
+		SpanID                 string           `json:"spanId"`
+		TraceState             string           `json:"traceState"`
+		ParentSpanID           string           `json:"parentSpanId"`
+		Flags                  uint32           `json:"flags"`
+		Name                   string           `json:"name"`
+		Kind                   int              `json:"kind"`
+		StartTimeUnixNano      string           `json:"startTimeUnixNano"`
+		EndTimeUnixNano        string           `json:"endTimeUnixNano"`
+		Attributes             []schemaKeyValue `json:"attributes"`
+		DroppedAttributesCount uint32           `json:"droppedAttributesCount"`
+		DroppedEventsCount     uint32           `json:"droppedEventsCount"`
+		DroppedLinksCount      uint32           `json:"droppedLinksCount"`
+		Status                 struct {
+			Message string `json:"message"`
+			Code    int    `json:"code"`
+		} `json:"status"`
+	}
+	schemaKeyValue struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue *string  `json:"stringValue"`
+			BoolValue   *bool    `json:"boolValue"`
+			IntValue    *string  `json:"intValue"`
+			DoubleValue *float64 `json:"doubleValue"`
+		} `json:"value"`
+	}
+)
+
+// checkOTLPSchema decodes body strictly as an ExportTraceServiceRequest and
+// checks the values the JSON types can't express
+func checkOTLPSchema(body []byte) ([]schemaSpan, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	var req schemaRequest
+	if err := dec.Decode(&req); err != nil {
+		return nil, err
+	}
+
+	var spans []schemaSpan
+	var attrs []schemaKeyValue
+	for _, rs := range req.ResourceSpans {
+		attrs = append(attrs, rs.Resource.Attributes...)
+		for _, ss := range rs.ScopeSpans {
+			attrs = append(attrs, ss.Scope.Attributes...)
+			for _, s := range ss.Spans {
+				spans = append(spans, s)
+				attrs = append(attrs, s.Attributes...)
+			}
+		}
+	}
+	isID := func(id string, n int) bool {
+		b, err := hex.DecodeString(id)
+		return err == nil && len(b) == n
+	}
+	for _, s := range spans {
+		if !isID(s.TraceID, 16) || !isID(s.SpanID, 8) || (s.ParentSpanID != "" && !isID(s.ParentSpanID, 8)) {
+			return nil, errors.New("span " + s.Name + " has a malformed ID")
+		}
+		start, err1 := strconv.ParseUint(s.StartTimeUnixNano, 10, 64)
+		end, err2 := strconv.ParseUint(s.EndTimeUnixNano, 10, 64)
+		if err1 != nil || err2 != nil || end < start {
+			return nil, errors.New("span " + s.Name + " has malformed times")
+		}
+		if s.Kind < 0 || s.Kind > 5 || s.Status.Code < 0 || s.Status.Code > 2 {
+			return nil, errors.New("span " + s.Name + " has an unknown kind or status")
+		}
+	}
+	for _, a := range attrs {
+		v := a.Value
+		set := 0
+		for _, ok := range []bool{v.StringValue != nil, v.BoolValue != nil, v.IntValue != nil, v.DoubleValue != nil} {
+			if ok {
+				set++
+			}
+		}
+		if set != 1 {
+			return nil, errors.New("attribute " + a.Key + " should have exactly one value")
+		}
+		if v.IntValue != nil {
+			if _, err := strconv.ParseInt(*v.IntValue, 10, 64); err != nil {
+				return nil, errors.New("attribute " + a.Key + " has a malformed intValue")
+			}
+		}
+	}
+	return spans, nil
+}
+
+func TestOTLPPayloadSchema(t *testing.T) {
+	// The checker accepts the spec's own example
+	if spans, err := checkOTLPSchema([]byte(otlpTraceExample)); err != nil || len(spans) != 1 {
+		t.Fatalf("Spec example rejected: %v", err)
+	}
+	if _, err := checkOTLPSchema([]byte(`{"resourceSpans":[{"scopeSpans":[{"spans":[{"kind":"SPAN_KIND_SERVER"}]}]}]}`)); err == nil {
+		t.Error("Enum names should be rejected")
+	}
+
+	e := &otlpExporter{resource: []otlpKeyValue{otlpAttr(slog.String("service.name", "stock-fetcher"))}}
+	ctx, root := startRemoteSpan(t.Context(), "GET /api/stock/", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, child := startSpan(ctx, "fetchStockData",
+		slog.String("symbol", "AAPL"), slog.Int("days", 30), slog.Uint64("bytes", 1<<40),
+		slog.Float64("ratio", 0.5), slog.Bool("stale", true), slog.Duration("wait", time.Second))
+	end := time.Now()
+	body, err := e.payload([]otlpSpan{
+		root.otlp(root.name, root.attrs, end, nil),
+		child.otlp(child.name, child.attrs, end, errors.New("upstream unavailable")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans, err := checkOTLPSchema(body)
+	if err != nil {
+		t.Fatalf("Exported payload doesn't match the OTLP schema: %v\n%s", err, body)
+	}
+	if len(spans) != 2 || spans[0].Kind != spanKindServer || spans[1].Kind != spanKindInternal || spans[1].Status.Code != 2 {
+		t.Errorf("Unexpected spans: %+v", spans)
+	}
+	if len(spans[1].Attributes) != 6 || *spans[1].Attributes[1].Value.IntValue != "30" {
+		t.Errorf("Unexpected attributes: %s", body)
+	}
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every upstream fetcher shares one client layer, the upstreamScheduler,
+// which keeps providers from throttling us and fails fast when they do:
+//   - a token bucket per host spaces requests out, serving interactive
+//     requests before background refreshes when both are waiting
+//   - 429s, gateway errors and network errors are retried with exponential
+//     backoff; a Retry-After header sets the wait and pauses the whole host
+//   - after repeated failures a host's circuit opens and its requests fail
+//     immediately for a cool-down, so the provider chain moves straight on
+//     to the next provider instead of waiting out another timeout
+
+// priority orders requests waiting on the same host
+type priority int
+
+const (
+	priorityInteractive priority = iota // API and CLI requests
+	priorityBackground                  // Scheduled refreshes
+)
+
+// withPriority returns a context whose upstream requests have priority p
+func withPriority(ctx context.Context, p priority) context.Context {
+	return context.WithValue(ctx, priorityKey, p)
+}
+
+// priorityFrom returns the context's request priority, interactive by default
+func priorityFrom(ctx context.Context) priority {
+	p, _ := ctx.Value(priorityKey).(priority)
+	return p
+}
+
+// errCircuitOpen is returned for requests to a host whose circuit is open
+var errCircuitOpen = errors.New("circuit open")
+
+// rateLimit is a host's token bucket: rate requests per second on average,
+// bursts of up to burst
+type rateLimit struct {
+	rate  float64
+	burst int
+}
+
+// defaultRateLimits keep clear of the limits the providers enforce. Hosts
+// not listed are unlimited.
+var defaultRateLimits = map[string]rateLimit{
+	"query1.finance.yahoo.com": {rate: 2, burst: 5},
+	"www.macrotrends.net":      {rate: 1, burst: 3},
+}
+
+// loadRateLimits returns the default limits overridden by
+// UPSTREAM_RATE_LIMITS, e.g. "www.macrotrends.net:0.5:2;stooq.com:5" (host,
+// requests per second and optional burst; a rate of 0 removes the limit)
+func loadRateLimits() map[string]rateLimit {
+	limits := make(map[string]rateLimit, len(defaultRateLimits))
+	for host, l := range defaultRateLimits {
+		limits[host] = l
+	}
+	for _, entry := range strings.Split(os.Getenv("UPSTREAM_RATE_LIMITS"), ";") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		host := strings.ToLower(parts[0])
+		if host == "" || len(parts) < 2 || len(parts) > 3 {
+			continue
+		}
+		rate, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || rate < 0 {
+			continue
+		}
+		if rate == 0 {
+			delete(limits, host)
+			continue
+		}
+		burst := max(1, int(rate))
+		if len(parts) == 3 {
+			if burst, err = strconv.Atoi(parts[2]); err != nil || burst < 1 {
+				continue
+			}
+		}
+		limits[host] = rateLimit{rate: rate, burst: burst}
+	}
+	return limits
+}
+
+// hostState is the rate limiter and circuit breaker for one host
+type hostState struct {
+	mu    sync.Mutex
+	limit rateLimit // Zero rate means unlimited
+
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time          // From the latest Retry-After
+	queues      [2][]chan struct{} // Waiting requests by priority
+	timer       *time.Timer        // Wakes waiters when a token is due
+
+	failures  int       // Consecutive failed requests
+	openUntil time.Time // Zero while the circuit is closed
+	probing   bool      // A half-open trial request is in flight
+}
+
+func newHostState(limit rateLimit) *hostState {
+	return &hostState{limit: limit, tokens: float64(limit.burst), last: time.Now()}
+}
+
+// refill adds the tokens earned since the last refill
+func (h *hostState) refill(now time.Time) {
+	if h.limit.rate > 0 {
+		h.tokens = min(float64(h.limit.burst), h.tokens+now.Sub(h.last).Seconds()*h.limit.rate)
+	}
+	h.last = now
+}
+
+// available reports whether a request may start now
+func (h *hostState) available(now time.Time) bool {
+	return !now.Before(h.pausedUntil) && (h.limit.rate <= 0 || h.tokens >= 1)
+}
+
+func (h *hostState) take() {
+	if h.limit.rate > 0 {
+		h.tokens--
+	}
+}
+
+// acquire waits for the host's rate limit, behind any waiting requests of
+// the same or higher priority
+func (h *hostState) acquire(ctx context.Context, p priority) error {
+	h.mu.Lock()
+	now := time.Now()
+	h.refill(now)
+	ahead := len(h.queues[priorityInteractive])
+	if p == priorityBackground {
+		ahead += len(h.queues[priorityBackground])
+	}
+	if ahead == 0 && h.available(now) {
+		h.take()
+		h.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	h.queues[p] = append(h.queues[p], ready)
+	h.schedule(now)
+	h.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if i := indexOf(h.queues[p], ready); i >= 0 {
+			h.queues[p] = append(h.queues[p][:i], h.queues[p][i+1:]...)
+		} else if h.limit.rate > 0 {
+			// Granted just as we gave up: pass the token on
+			h.tokens++
+			h.dispatch(time.Now())
+		}
+		return ctx.Err()
+	}
+}
+
+func indexOf(queue []chan struct{}, ch chan struct{}) int {
+	for i, c := range queue {
+		if c == ch {
+			return i
+		}
+	}
+	return -1
+}
+
+// dispatch grants tokens to waiting requests, interactive first. Called
+// with h.mu held.
+func (h *hostState) dispatch(now time.Time) {
+	h.refill(now)
+	for h.available(now) {
+		var p priority
+		switch {
+		case len(h.queues[priorityInteractive]) > 0:
+			p = priorityInteractive
+		case len(h.queues[priorityBackground]) > 0:
+			p = priorityBackground
+		default:
+			return
+		}
+		h.take()
+		close(h.queues[p][0])
+		h.queues[p] = h.queues[p][1:]
+	}
+	h.schedule(now)
+}
+
+// schedule arms the timer for when the next waiting request can start.
+// Called with h.mu held.
+func (h *hostState) schedule(now time.Time) {
+	if len(h.queues[priorityInteractive])+len(h.queues[priorityBackground]) == 0 {
+		return
+	}
+	wait := h.pausedUntil.Sub(now)
+	if h.limit.rate > 0 && h.tokens < 1 {
+		wait = max(wait, time.Duration((1-h.tokens)/h.limit.rate*float64(time.Second)))
+	}
+	wait = max(wait, time.Millisecond)
+	if h.timer == nil {
+		h.timer = time.AfterFunc(wait, func() {
+			h.mu.Lock()
+			h.dispatch(time.Now())
+			h.mu.Unlock()
+		})
+	} else {
+		h.timer.Reset(wait)
+	}
+}
+
+// pause holds every request to the host until the given time
+func (h *hostState) pause(until time.Time) {
+	h.mu.Lock()
+	if until.After(h.pausedUntil) {
+		h.pausedUntil = until
+	}
+	h.mu.Unlock()
+}
+
+// allow returns errCircuitOpen while the circuit is open. Once the
+// cool-down has passed a single trial request is let through (probe).
+func (h *hostState) allow(now time.Time) (probe bool, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case h.openUntil.IsZero():
+		return false, nil
+	case now.Before(h.openUntil):
+		return false, fmt.Errorf("%w until %s", errCircuitOpen, h.openUntil.Format(time.TimeOnly))
+	case h.probing:
+		return false, fmt.Errorf("%w, trial request in flight", errCircuitOpen)
+	default:
+		h.probing = true
+		return true, nil
+	}
+}
+
+// record updates the circuit breaker with a request's outcome and reports
+// whether it opened the circuit
+func (h *hostState) record(probe, failed bool, threshold int, cooldown time.Duration) (opened bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if probe {
+		h.probing = false
+	}
+	if !failed {
+		h.failures = 0
+		h.openUntil = time.Time{}
+		return false
+	}
+	h.failures++
+	if probe || (h.openUntil.IsZero() && h.failures >= threshold) {
+		h.openUntil = time.Now().Add(cooldown)
+		return true
+	}
+	return false
+}
+
+// abandon gives up a trial request without an outcome, letting another
+// request try
+func (h *hostState) abandon(probe bool) {
+	if probe {
+		h.mu.Lock()
+		h.probing = false
+		h.mu.Unlock()
+	}
+}
+
+// upstreamScheduler is the http.RoundTripper shared by upstream fetchers
+type upstreamScheduler struct {
+	base   http.RoundTripper // nil means http.DefaultTransport
+	limits map[string]rateLimit
+
+	maxAttempts      int           // Including the first
+	baseBackoff      time.Duration // Doubled on each retry
+	maxBackoff       time.Duration // Also caps Retry-After
+	failureThreshold int           // Consecutive failures that open a circuit
+	cooldown         time.Duration // How long a circuit stays open
+
+	mu    sync.Mutex
+	hosts map[string]*hostState // By host[:port]
+}
+
+// newUpstreamScheduler creates a scheduler with settings from the
+// environment:
+//   - UPSTREAM_RATE_LIMITS → per-host limits, see loadRateLimits
+//   - UPSTREAM_RETRIES=2   → retries after the first attempt
+//   - UPSTREAM_BREAKER_THRESHOLD=5 → consecutive failures that open a circuit
+//   - UPSTREAM_BREAKER_COOLDOWN=30s → how long it stays open
+func newUpstreamScheduler(base http.RoundTripper) *upstreamScheduler {
+	s := &upstreamScheduler{
+		base:             base,
+		limits:           loadRateLimits(),
+		maxAttempts:      3,
+		baseBackoff:      500 * time.Millisecond,
+		maxBackoff:       10 * time.Second,
+		failureThreshold: 5,
+		cooldown:         envDuration("UPSTREAM_BREAKER_COOLDOWN", 30*time.Second),
+		hosts:            make(map[string]*hostState),
+	}
+	if n, err := strconv.Atoi(os.Getenv("UPSTREAM_RETRIES")); err == nil && n >= 0 {
+		s.maxAttempts = n + 1
+	}
+	if n, err := strconv.Atoi(os.Getenv("UPSTREAM_BREAKER_THRESHOLD")); err == nil && n > 0 {
+		s.failureThreshold = n
+	}
+	// Configured hosts show up in the health endpoint before first use
+	for host, limit := range s.limits {
+		s.hosts[host] = newHostState(limit)
+	}
+	return s
+}
+
+// upstream is the scheduler behind every upstream fetcher's client
+var upstream = newUpstreamScheduler(&metricsTransport{base: upstreamTransport})
+
+// host returns the state for a request's host, creating it on first use
+func (s *upstreamScheduler) host(key, name string) *hostState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.hosts[key]
+	if !ok {
+		h = newHostState(s.limits[name])
+		s.hosts[key] = h
+	}
+	return h
+}
+
+// RoundTrip implements http.RoundTripper
+func (s *upstreamScheduler) RoundTrip(req *http.Request) (*http.Response, error) {
+	name := req.URL.Hostname()
+	h := s.host(req.URL.Host, name)
+	probe, err := h.allow(time.Now())
+	if err != nil {
+		upstreamRejected.Inc(name)
+		return nil, fmt.Errorf("%s: %w: %w", name, ErrUpstreamUnavailable, err)
+	}
+
+	resp, err := s.roundTrip(req, h, name)
+	if err != nil && req.Context().Err() != nil {
+		// The caller cancelling or running out of time says nothing about
+		// the host's health
+		h.abandon(probe)
+		return resp, err
+	}
+
+	failed := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	if h.record(probe, failed, s.failureThreshold, s.cooldown) {
+		slog.WarnContext(req.Context(), "Upstream circuit opened", "host", name, "cooldown", s.cooldown)
+	}
+	if err != nil {
+		// Network errors
+		err = fmt.Errorf("%w: %w", ErrUpstreamUnavailable, err)
+	}
+	return resp, err
+}
+
+// roundTrip sends req, retrying failures that may be transient
+func (s *upstreamScheduler) roundTrip(req *http.Request, h *hostState, name string) (*http.Response, error) {
+	base := s.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	ctx := req.Context()
+	// Only bodiless requests can safely be sent twice
+	retryable := (req.Method == http.MethodGet || req.Method == http.MethodHead) && req.Body == nil
+
+	for attempt := 1; ; attempt++ {
+		if err := h.acquire(ctx, priorityFrom(ctx)); err != nil {
+			return nil, err
+		}
+		resp, err := base.RoundTrip(req)
+
+		wait, retry := s.backoff(attempt, resp, err)
+		if resp != nil && resp.Header.Get("Retry-After") != "" {
+			h.pause(time.Now().Add(wait))
+		}
+		if !retry || !retryable || attempt >= s.maxAttempts || ctx.Err() != nil {
+			return resp, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return resp, err
+		}
+
+		reason := "error"
+		if resp != nil {
+			reason = strconv.Itoa(resp.StatusCode)
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			_ = resp.Body.Close()
+		}
+		upstreamRetries.Inc(name, reason)
+		slog.DebugContext(ctx, "Retrying upstream request", "host", name, "attempt", attempt, "wait", wait, "reason", reason)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// backoff decides whether an attempt should be retried and how long to
+// wait first: Retry-After if the host sent one, otherwise exponential
+// backoff with jitter
+func (s *upstreamScheduler) backoff(attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	switch {
+	case err != nil:
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return 0, false
+		}
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusBadGateway,
+		resp.StatusCode == http.StatusServiceUnavailable, resp.StatusCode == http.StatusGatewayTimeout:
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return min(d, s.maxBackoff), true
+		}
+	default:
+		return 0, false
+	}
+	d := min(s.baseBackoff<<(attempt-1), s.maxBackoff)
+	return d/2 + rand.N(d/2+1), true
+}
+
+// parseRetryAfter parses a Retry-After header: delay seconds or an HTTP date
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(0, t.Sub(now)), true
+	}
+	return 0, false
+}
+
+// UpstreamHostStatus is one host's entry in the health endpoint
+type UpstreamHostStatus struct {
+	Circuit     string     `json:"circuit"` // closed, open or half_open
+	Failures    int        `json:"consecutive_failures"`
+	OpenUntil   *time.Time `json:"open_until,omitempty"`
+	RateLimit   float64    `json:"rate_limit,omitempty"` // Requests per second; omitted if unlimited
+	Burst       int        `json:"burst,omitempty"`
+	Tokens      *float64   `json:"tokens,omitempty"`
+	PausedUntil *time.Time `json:"paused_until,omitempty"`
+	Waiting     struct {
+		Interactive int `json:"interactive"`
+		Background  int `json:"background"`
+	} `json:"waiting"`
+}
+
+// Status reports the state of every host seen so far
+func (s *upstreamScheduler) Status() map[string]UpstreamHostStatus {
+	s.mu.Lock()
+	hosts := make(map[string]*hostState, len(s.hosts))
+	for name, h := range s.hosts {
+		hosts[name] = h
+	}
+	s.mu.Unlock()
+
+	now := time.Now()
+	status := make(map[string]UpstreamHostStatus, len(hosts))
+	for name, h := range hosts {
+		h.mu.Lock()
+		h.refill(now)
+		st := UpstreamHostStatus{Circuit: "closed", Failures: h.failures, RateLimit: h.limit.rate, Burst: h.limit.burst}
+		switch {
+		case h.openUntil.IsZero():
+		case now.Before(h.openUntil):
+			st.Circuit = "open"
+			until := h.openUntil
+			st.OpenUntil = &until
+		default:
+			st.Circuit = "half_open"
+		}
+		if h.limit.rate > 0 {
+			tokens := float64(int(h.tokens*100)) / 100
+			st.Tokens = &tokens
+		}
+		if now.Before(h.pausedUntil) {
+			until := h.pausedUntil
+			st.PausedUntil = &until
+		}
+		st.Waiting.Interactive = len(h.queues[priorityInteractive])
+		st.Waiting.Background = len(h.queues[priorityBackground])
+		h.mu.Unlock()
+		status[name] = st
+	}
+	return status
+}
+
+// openCircuits lists the hosts whose circuit is currently open. Half-open
+// hosts are already letting a trial request through, so they aren't listed.
+func (s *upstreamScheduler) openCircuits() []string {
+	var open []string
+	for name, st := range s.Status() {
+		if st.Circuit == "open" {
+			open = append(open, name)
+		}
+	}
+	sort.Strings(open)
+	return open
+}
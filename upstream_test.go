@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testScheduler returns a scheduler with short waits for tests
+func testScheduler() *upstreamScheduler {
+	s := newUpstreamScheduler(nil)
+	s.baseBackoff = time.Millisecond
+	s.maxBackoff = 50 * time.Millisecond
+	s.cooldown = 50 * time.Millisecond
+	return s
+}
+
+// statusServer responds with each status in turn, then 200s
+func statusServer(t *testing.T, header http.Header, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := int(calls.Add(1)); n <= len(statuses) {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(statuses[n-1])
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestLoadRateLimits(t *testing.T) {
+	t.Setenv("UPSTREAM_RATE_LIMITS", "www.macrotrends.net:0.5:2; stooq.com:5 ;query1.finance.yahoo.com:0;bad;x:y")
+	limits := loadRateLimits()
+	if got := limits["www.macrotrends.net"]; got != (rateLimit{rate: 0.5, burst: 2}) {
+		t.Errorf("macrotrends = %+v", got)
+	}
+	if got := limits["stooq.com"]; got != (rateLimit{rate: 5, burst: 5}) {
+		t.Errorf("stooq = %+v", got)
+	}
+	if _, ok := limits["query1.finance.yahoo.com"]; ok {
+		t.Error("A rate of 0 should remove the limit")
+	}
+	if len(limits) != 2 {
+		t.Errorf("Invalid entries should be skipped: %v", limits)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+	tests := map[string]time.Duration{
+		"3":                             3 * time.Second,
+		"Tue, 02 Jan 2024 15:00:10 GMT": 10 * time.Second,
+		"Tue, 02 Jan 2024 14:00:00 GMT": 0,
+	}
+	for v, want := range tests {
+		if got, ok := parseRetryAfter(v, now); !ok || got != want {
+			t.Errorf("parseRetryAfter(%q) = %s, %v; want %s", v, got, ok, want)
+		}
+	}
+	for _, v := range []string{"", "soon", "-1"} {
+		if _, ok := parseRetryAfter(v, now); ok {
+			t.Errorf("parseRetryAfter(%q) should fail", v)
+		}
+	}
+}
+
+func TestUpstreamRetries(t *testing.T) {
+	srv, calls := statusServer(t, nil, http.StatusServiceUnavailable, http.StatusBadGateway)
+	client := &http.Client{Transport: testScheduler()}
+	retries := upstreamRetries.Value("127.0.0.1", "503")
+
+	resp, err := client.Get(srv.URL)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected success after retries: %v %v", resp, err)
+	}
+	_ = resp.Body.Close()
+	if calls.Load() != 3 {
+		t.Errorf("Server called %d times, want 3", calls.Load())
+	}
+	if upstreamRetries.Value("127.0.0.1", "503") != retries+1 {
+		t.Error("Expected a 503 retry to be counted")
+	}
+
+	// Client errors are final
+	srv, calls = statusServer(t, nil, http.StatusNotFound)
+	resp, err = client.Get(srv.URL)
+	if err != nil || resp.StatusCode != http.StatusNotFound || calls.Load() != 1 {
+		t.Errorf("404 should not be retried: %v %v after %d calls", resp, err, calls.Load())
+	}
+}
+
+func TestUpstreamRetryAfter(t *testing.T) {
+	srv, calls := statusServer(t, http.Header{"Retry-After": {"1"}}, http.StatusTooManyRequests)
+	s := testScheduler()
+	client := &http.Client{Transport: s}
+
+	start := time.Now()
+	resp, err := client.Get(srv.URL)
+	if err != nil || resp.StatusCode != http.StatusOK || calls.Load() != 2 {
+		t.Fatalf("Expected success on the second attempt: %v %v", resp, err)
+	}
+	_ = resp.Body.Close()
+	// Retry-After is honoured up to maxBackoff
+	if elapsed := time.Since(start); elapsed < s.maxBackoff || elapsed > time.Second {
+		t.Errorf("Retried after %s, want about %s", elapsed, s.maxBackoff)
+	}
+
+	// A retry that can't happen within the caller's deadline isn't attempted
+	srv, calls = statusServer(t, http.Header{"Retry-After": {"1"}}, http.StatusTooManyRequests)
+	s.maxBackoff = time.Minute
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	resp, err = client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests || calls.Load() != 1 {
+		t.Errorf("Expected the 429 back immediately: %v %v after %d calls", resp, err, calls.Load())
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var healthy atomic.Bool
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	s := testScheduler()
+	s.maxAttempts = 1
+	s.failureThreshold = 2
+	client := &http.Client{Transport: s}
+	get := func() (*http.Response, error) {
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return resp, err
+	}
+
+	for range 2 {
+		if _, err := get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := get(); !errors.Is(err, errCircuitOpen) || calls.Load() != 2 {
+		t.Fatalf("Expected an open circuit without a request, got %v after %d calls", err, calls.Load())
+	}
+	if st := s.Status()[srv.Listener.Addr().String()]; st.Circuit != "open" || st.Failures != 2 {
+		t.Errorf("Unexpected status %+v", st)
+	}
+
+	// After the cool-down a trial request goes through and closes the circuit
+	time.Sleep(s.cooldown)
+	if st := s.Status()[srv.Listener.Addr().String()]; st.Circuit != "half_open" || len(s.openCircuits()) != 0 {
+		t.Errorf("Expected a half-open circuit that health doesn't report as open: %+v", st)
+	}
+	healthy.Store(true)
+	if resp, err := get(); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Trial request failed: %v", err)
+	}
+	if _, err := get(); err != nil || calls.Load() != 4 {
+		t.Errorf("Circuit should be closed again: %v", err)
+	}
+}
+
+func TestCircuitIgnoresCallerDeadline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	s := testScheduler()
+	s.failureThreshold = 1
+	client := &http.Client{Transport: s}
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	if _, err := client.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the caller's deadline, got %v", err)
+	}
+	if st := s.Status()[srv.Listener.Addr().String()]; st.Circuit != "closed" || st.Failures != 0 {
+		t.Errorf("A caller's deadline shouldn't count against the host: %+v", st)
+	}
+	if open := s.openCircuits(); len(open) != 0 {
+		t.Errorf("openCircuits = %v", open)
+	}
+}
+
+func TestUpstreamPriority(t *testing.T) {
+	h := newHostState(rateLimit{rate: 20, burst: 1})
+	if err := h.acquire(t.Context(), priorityInteractive); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both wait for the next token; the interactive one arrives second but
+	// is served first
+	order := make(chan priority, 2)
+	queued := func(p priority, n int) {
+		for {
+			h.mu.Lock()
+			l := len(h.queues[p])
+			h.mu.Unlock()
+			if l == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for _, p := range []priority{priorityBackground, priorityInteractive} {
+		go func() {
+			if err := h.acquire(t.Context(), p); err == nil {
+				order <- p
+			}
+		}()
+		queued(p, 1)
+	}
+	if first, second := <-order, <-order; first != priorityInteractive || second != priorityBackground {
+		t.Errorf("Served %v then %v, want interactive first", first, second)
+	}
+}
+
+func TestUpstreamRateLimit(t *testing.T) {
+	h := newHostState(rateLimit{rate: 50, burst: 1})
+	start := time.Now()
+	for range 3 {
+		if err := h.acquire(t.Context(), priorityInteractive); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The burst is spent at once, then one token every 20ms
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Errorf("Three requests took %s, want at least 40ms", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Millisecond)
+	defer cancel()
+	h = newHostState(rateLimit{rate: 0.001, burst: 1})
+	h.tokens = 0
+	if err := h.acquire(ctx, priorityInteractive); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Waiting should end with the context: %v", err)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.queues[priorityInteractive]) != 0 {
+		t.Error("Abandoned waiter left in the queue")
+	}
+}
+
+func TestHealthUpstreamStatus(t *testing.T) {
+	server := NewServer("0", nil)
+	w := doJSON(t, server, "GET", "/api/health", "")
+
+	var resp struct {
+		Data struct {
+			Status   string                        `json:"status"`
+			Upstream map[string]UpstreamHostStatus `json:"upstream"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	yahoo, ok := resp.Data.Upstream["query1.finance.yahoo.com"]
+	if !ok || yahoo.RateLimit != 2 || yahoo.Tokens == nil {
+		t.Errorf("Expected Yahoo's limiter in health: %+v", resp.Data.Upstream)
+	}
+	if resp.Data.Status != "ok" && resp.Data.Status != "degraded" {
+		t.Errorf("status = %q", resp.Data.Status)
+	}
+}
+
+func TestUpstreamNetworkError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	s := testScheduler()
+	s.maxAttempts = 1
+	_, err := (&http.Client{Transport: s}).Get(url)
+	if !errors.Is(err, ErrUpstreamUnavailable) {
+		t.Errorf("Connection errors should match ErrUpstreamUnavailable: %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Index-wide requests on a cold cache need far more upstream requests than
+// fit in one request's budget: a cold S&P 500 snapshot is about 2000
+// macrotrends requests at 1 request/s. Batch fetches stop early enough to
+// answer with what they have, and the symbols they didn't get to are
+// fetched in the background so a later request finds them cached.
+
+// batchResponseReserve is the part of a request's remaining budget that a
+// batch fetch leaves for building and writing the response. Short budgets
+// keep a quarter of the time instead.
+const batchResponseReserve = 10 * time.Second
+
+// warmQueueSize bounds the symbols waiting for a background fetch
+const warmQueueSize = 2000
+
+// warmJob is one symbol to fetch into a cache
+type warmJob struct {
+	cache  *Cache
+	symbol string
+	days   int
+}
+
+// cacheWarmer fetches symbols that a batch request ran out of time for
+type cacheWarmer struct {
+	enabled bool
+	start   sync.Once
+	queue   chan warmJob
+
+	mu      sync.Mutex
+	pending map[string]int // Upper-case symbol -> days queued or in progress
+}
+
+// warmer is disabled on Lambda, where the sandbox may freeze as soon as
+// the response is sent
+var warmer = &cacheWarmer{
+	enabled: os.Getenv("AWS_LAMBDA_FUNCTION_NAME") == "",
+	queue:   make(chan warmJob, warmQueueSize),
+	pending: make(map[string]int),
+}
+
+// enqueue queues a background fetch of days of symbol into cache and
+// reports whether it is queued, counting a fetch of at least as many days
+// that is already waiting
+func (w *cacheWarmer) enqueue(cache *Cache, symbol string, days int) bool {
+	if !w.enabled || cache == nil {
+		return false
+	}
+	w.start.Do(func() {
+		for range batchFetchWorkers {
+			go w.work()
+		}
+	})
+
+	key := strings.ToUpper(symbol)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.pending[key] >= days {
+		return true
+	}
+	select {
+	case w.queue <- warmJob{cache: cache, symbol: symbol, days: days}:
+		w.pending[key] = days
+		return true
+	default:
+		return false
+	}
+}
+
+// work runs queued fetches at background priority
+func (w *cacheWarmer) work() {
+	for job := range w.queue {
+		ctx := withPriority(withRequestID(context.Background(), newRequestID()), priorityBackground)
+		if _, err := fetchStockData(ctx, job.cache, job.symbol, job.days); err != nil {
+			slog.WarnContext(ctx, "Background cache fill failed", "symbol", job.symbol, "error", err)
+		}
+		key := strings.ToUpper(job.symbol)
+		w.mu.Lock()
+		if w.pending[key] <= job.days {
+			delete(w.pending, key)
+		}
+		w.mu.Unlock()
+	}
+}
+
+// batchFetchContext returns the context for a batch fetch, ending early
+// enough to leave part of ctx's deadline for the response
+func batchFetchContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	reserve := min(batchResponseReserve, time.Until(deadline)/4)
+	return context.WithDeadline(ctx, deadline.Add(-reserve))
+}
+
+// warmSkipped queues a background fetch for each symbol whose fetch ran
+// out of time because fetchCtx's deadline passed, noting it in the symbol's
+// error. Coalesced fetches share that deadline and may report it before
+// fetchCtx itself does, so the deadline is checked rather than fetchCtx.Err.
+func warmSkipped(fetchCtx context.Context, cache *Cache, symbols []string, days int, errs []error) {
+	if deadline, ok := fetchCtx.Deadline(); !ok || time.Now().Before(deadline) {
+		return
+	}
+	for i, err := range errs {
+		if errors.Is(err, context.DeadlineExceeded) && warmer.enqueue(cache, symbols[i], days) {
+			errs[i] = fmt.Errorf("not fetched within the request budget, fetching in the background: %w", err)
+		}
+	}
+}
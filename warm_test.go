@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchFetchContext(t *testing.T) {
+	tests := []struct {
+		budget, want time.Duration
+	}{
+		{55 * time.Second, 45 * time.Second},
+		{8 * time.Second, 6 * time.Second},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(t.Context(), tt.budget)
+		fetchCtx, fetchCancel := batchFetchContext(ctx)
+		deadline, _ := fetchCtx.Deadline()
+		if left := time.Until(deadline); left > tt.want || left < tt.want-time.Second {
+			t.Errorf("Budget %s leaves %s for fetching, want %s", tt.budget, left, tt.want)
+		}
+		fetchCancel()
+		cancel()
+	}
+
+	fetchCtx, cancel := batchFetchContext(t.Context())
+	defer cancel()
+	if _, ok := fetchCtx.Deadline(); ok {
+		t.Error("No deadline should be added without a budget")
+	}
+}
+
+func TestBatchWarmsSkippedSymbols(t *testing.T) {
+	p := &gatedProvider{name: "gated", data: cliSeries(), release: make(chan struct{})}
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"gated"}}}, p)
+	withProviderTimeout(t, time.Minute)
+	cache := newTestCache(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 200*time.Millisecond)
+	defer cancel()
+	_, errs := fetchStockDataBatch(ctx, cache, []string{"AAPL"}, 30)
+	if !errors.Is(errs[0], context.DeadlineExceeded) || !strings.Contains(errs[0].Error(), "background") {
+		t.Fatalf("Expected the symbol to be left for a background fetch, got %v", errs[0])
+	}
+	if ctx.Err() != nil {
+		t.Error("The batch should stop before the request's own deadline")
+	}
+
+	// The background fetch fills the cache once the provider answers
+	close(p.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if meta, _ := cache.GetFetchMeta(t.Context(), "AAPL"); meta != nil {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("Skipped symbol was not fetched in the background")
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrWatchlistExists is returned when a watchlist name is already taken
+var ErrWatchlistExists = errors.New("watchlist name already exists")
+
+// Watchlist is a named, ordered list of symbols stored in the cache database
+type Watchlist struct {
+	ID          int64    `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Symbols     []string `json:"symbols"`
+	CreatedAt   string   `json:"created_at"`
+	UpdatedAt   string   `json:"updated_at"`
+}
+
+// normalizeSymbols upper-cases, trims and de-duplicates symbols, keeping order
+func normalizeSymbols(symbols []string) []string {
+	result := []string{}
+	seen := map[string]bool{}
+	for _, sym := range symbols {
+		sym = strings.ToUpper(strings.TrimSpace(sym))
+		if sym != "" && !seen[sym] {
+			seen[sym] = true
+			result = append(result, sym)
+		}
+	}
+	return result
+}
+
+// isUniqueViolation reports whether err is a SQLite UNIQUE constraint failure
+func isUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// ListWatchlists returns all watchlists ordered by name
+func (c *Cache) ListWatchlists(ctx context.Context) ([]Watchlist, error) {
+	rows, err := c.db.QueryContext(ctx,
+		`SELECT id, name, description, created_at, updated_at FROM watchlists ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	lists := []Watchlist{}
+	for rows.Next() {
+		var wl Watchlist
+		if err := rows.Scan(&wl.ID, &wl.Name, &wl.Description, &wl.CreatedAt, &wl.UpdatedAt); err != nil {
+			return nil, err
+		}
+		lists = append(lists, wl)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	_ = rows.Close()
+
+	for i := range lists {
+		if lists[i].Symbols, err = c.watchlistSymbols(ctx, lists[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return lists, nil
+}
+
+// GetWatchlist returns a watchlist by ID, or nil if it does not exist
+func (c *Cache) GetWatchlist(ctx context.Context, id int64) (*Watchlist, error) {
+	var wl Watchlist
+	err := c.db.QueryRowContext(ctx,
+		`SELECT id, name, description, created_at, updated_at FROM watchlists WHERE id = ?`, id).
+		Scan(&wl.ID, &wl.Name, &wl.Description, &wl.CreatedAt, &wl.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if wl.Symbols, err = c.watchlistSymbols(ctx, id); err != nil {
+		return nil, err
+	}
+	return &wl, nil
+}
+
+// watchlistSymbols returns a watchlist's symbols in the order they were added
+func (c *Cache) watchlistSymbols(ctx context.Context, id int64) ([]string, error) {
+	rows, err := c.db.QueryContext(ctx,
+		`SELECT symbol FROM watchlist_items WHERE watchlist_id = ? ORDER BY position, symbol`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	symbols := []string{}
+	for rows.Next() {
+		var sym string
+		if err := rows.Scan(&sym); err != nil {
+			return nil, err
+		}
+		symbols = append(symbols, sym)
+	}
+	return symbols, rows.Err()
+}
+
+// CreateWatchlist creates a watchlist with the given symbols
+func (c *Cache) CreateWatchlist(ctx context.Context, name, description string, symbols []string) (*Watchlist, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO watchlists (name, description, created_at, updated_at) VALUES (?, ?, ?, ?)`,
+		name, description, now, now)
+	if isUniqueViolation(err) {
+		return nil, ErrWatchlistExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := insertWatchlistItems(ctx, tx, id, 0, normalizeSymbols(symbols)); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return c.GetWatchlist(ctx, id)
+}
+
+// UpdateWatchlist replaces a watchlist's name, description and symbols.
+// Returns nil if the watchlist does not exist.
+func (c *Cache) UpdateWatchlist(ctx context.Context, id int64, name, description string, symbols []string) (*Watchlist, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE watchlists SET name = ?, description = ?, updated_at = ? WHERE id = ?`,
+		name, description, time.Now().UTC().Format(time.RFC3339), id)
+	if isUniqueViolation(err) {
+		return nil, ErrWatchlistExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM watchlist_items WHERE watchlist_id = ?`, id); err != nil {
+		return nil, err
+	}
+	if err := insertWatchlistItems(ctx, tx, id, 0, normalizeSymbols(symbols)); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return c.GetWatchlist(ctx, id)
+}
+
+// AddWatchlistSymbols appends symbols to a watchlist, ignoring ones already
+// present. Returns nil if the watchlist does not exist.
+func (c *Cache) AddWatchlistSymbols(ctx context.Context, id int64, symbols []string) (*Watchlist, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var next int
+	err = tx.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(i.position) + 1, 0) FROM watchlists w
+		 LEFT JOIN watchlist_items i ON i.watchlist_id = w.id WHERE w.id = ? GROUP BY w.id`, id).Scan(&next)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := insertWatchlistItems(ctx, tx, id, next, normalizeSymbols(symbols)); err != nil {
+		return nil, err
+	}
+	if err := touchWatchlist(ctx, tx, id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return c.GetWatchlist(ctx, id)
+}
+
+// RemoveWatchlistSymbol removes one symbol from a watchlist.
+// Returns false if the watchlist or symbol was not found.
+func (c *Cache) RemoveWatchlistSymbol(ctx context.Context, id int64, symbol string) (bool, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM watchlist_items WHERE watchlist_id = ? AND symbol = ?`,
+		id, strings.ToUpper(symbol))
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if err := touchWatchlist(ctx, tx, id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// DeleteWatchlist deletes a watchlist and its items.
+// Returns false if the watchlist does not exist.
+func (c *Cache) DeleteWatchlist(ctx context.Context, id int64) (bool, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM watchlist_items WHERE watchlist_id = ?`, id); err != nil {
+		return false, err
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM watchlists WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	return true, tx.Commit()
+}
+
+// insertWatchlistItems adds symbols to a watchlist starting at position start
+func insertWatchlistItems(ctx context.Context, tx *sql.Tx, id int64, start int, symbols []string) error {
+	stmt, err := tx.PrepareContext(ctx,
+		`INSERT OR IGNORE INTO watchlist_items (watchlist_id, symbol, position, added_at) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = stmt.Close() }()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for i, sym := range symbols {
+		if _, err := stmt.ExecContext(ctx, id, sym, start+i, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// touchWatchlist bumps a watchlist's updated_at
+func touchWatchlist(ctx context.Context, tx *sql.Tx, id int64) error {
+	_, err := tx.ExecContext(ctx, `UPDATE watchlists SET updated_at = ? WHERE id = ?`,
+		time.Now().UTC().Format(time.RFC3339), id)
+	return err
+}
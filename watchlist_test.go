@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCache(t *testing.T) *Cache {
+	t.Helper()
+	cache, err := NewCache(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+	t.Cleanup(func() { _ = cache.Close() })
+	return cache
+}
+
+func TestWatchlistCRUD(t *testing.T) {
+	cache := newTestCache(t)
+
+	wl, err := cache.CreateWatchlist(t.Context(), "Tech", "big tech", []string{"aapl", " MSFT ", "AAPL"})
+	if err != nil {
+		t.Fatalf("CreateWatchlist: %v", err)
+	}
+	if wl.ID == 0 || len(wl.Symbols) != 2 || wl.Symbols[0] != "AAPL" || wl.Symbols[1] != "MSFT" {
+		t.Fatalf("Unexpected watchlist: %+v", wl)
+	}
+
+	if _, err := cache.CreateWatchlist(t.Context(), "Tech", "", nil); err != ErrWatchlistExists {
+		t.Errorf("Expected ErrWatchlistExists, got %v", err)
+	}
+
+	wl, err = cache.AddWatchlistSymbols(t.Context(), wl.ID, []string{"0700.HK", "MSFT"})
+	if err != nil || len(wl.Symbols) != 3 || wl.Symbols[2] != "0700.HK" {
+		t.Fatalf("AddWatchlistSymbols: %+v, %v", wl, err)
+	}
+
+	if found, err := cache.RemoveWatchlistSymbol(t.Context(), wl.ID, "aapl"); !found || err != nil {
+		t.Errorf("RemoveWatchlistSymbol: %v, %v", found, err)
+	}
+	if found, _ := cache.RemoveWatchlistSymbol(t.Context(), wl.ID, "AAPL"); found {
+		t.Error("Expected second removal to report not found")
+	}
+
+	wl, err = cache.UpdateWatchlist(t.Context(), wl.ID, "Tech 2", "renamed", []string{"NVDA"})
+	if err != nil || wl.Name != "Tech 2" || len(wl.Symbols) != 1 || wl.Symbols[0] != "NVDA" {
+		t.Fatalf("UpdateWatchlist: %+v, %v", wl, err)
+	}
+
+	lists, err := cache.ListWatchlists(t.Context())
+	if err != nil || len(lists) != 1 {
+		t.Fatalf("ListWatchlists: %+v, %v", lists, err)
+	}
+
+	if found, err := cache.DeleteWatchlist(t.Context(), wl.ID); !found || err != nil {
+		t.Errorf("DeleteWatchlist: %v, %v", found, err)
+	}
+	if got, _ := cache.GetWatchlist(t.Context(), wl.ID); got != nil {
+		t.Errorf("Expected deleted watchlist to be gone, got %+v", got)
+	}
+	if got, _ := cache.AddWatchlistSymbols(t.Context(), wl.ID, []string{"AAPL"}); got != nil {
+		t.Errorf("Expected nil when adding to a missing watchlist, got %+v", got)
+	}
+}
+
+// doJSON sends a request with an optional JSON body and returns the recorder
+func doJSON(t *testing.T, server *Server, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	return w
+}
+
+func TestWatchlistEndpoints(t *testing.T) {
+	start := time.Now().AddDate(0, 0, -40).Format("2006-01-02")
+	values := make([]float64, 41)
+	for i := range values {
+		values[i] = 100
+	}
+	values[40] = 110
+	data := reverseData(closes(start, values...))
+	data[0].PE = "25.00"
+
+	withProviders(t, ProviderChains{Markets: map[string][]string{"US": {"fake"}}},
+		&symbolProvider{name: "fake", data: map[string][]StockData{"AAPL": data}})
+	server := NewServer("0", newTestCache(t))
+
+	w := doJSON(t, server, "POST", "/api/watchlists", `{"name": "Mine", "symbols": ["aapl", "missing"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Create: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Data Watchlist `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &created)
+	base := fmt.Sprintf("/api/watchlists/%d", created.Data.ID)
+
+	if w := doJSON(t, server, "POST", "/api/watchlists", `{"name": "Mine"}`); w.Code != http.StatusConflict {
+		t.Errorf("Duplicate: expected 409, got %d", w.Code)
+	}
+	if w := doJSON(t, server, "POST", "/api/watchlists", `{"symbols": []}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Missing name: expected 400, got %d", w.Code)
+	}
+
+	w = doJSON(t, server, "GET", base+"/snapshot", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Snapshot: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var snap struct {
+		Data Snapshot `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &snap); err != nil {
+		t.Fatalf("Failed to parse snapshot: %v", err)
+	}
+	if snap.Data.Count != 2 || snap.Data.Period != "monthly" {
+		t.Fatalf("Unexpected snapshot: %+v", snap.Data)
+	}
+	item := snap.Data.Items[0]
+	if item.Symbol != "AAPL" || *item.Close != 110 || *item.DayChangePct != 10 || *item.PeriodChangePct != 10 || *item.PE != 25 {
+		t.Errorf("Unexpected AAPL item: %+v", item)
+	}
+	if snap.Data.Items[1].Error == "" {
+		t.Errorf("Expected error for MISSING, got %+v", snap.Data.Items[1])
+	}
+
+	w = doJSON(t, server, "GET", base+"/excel?period=daily", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Disposition"), "watchlist_1_daily.xlsx") {
+		t.Errorf("Excel: %d %q", w.Code, w.Header().Get("Content-Disposition"))
+	}
+	if w := doJSON(t, server, "GET", "/api/watchlists/999/excel", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Excel for missing watchlist: expected 404, got %d", w.Code)
+	}
+
+	if w := doJSON(t, server, "DELETE", base+"/symbols/MISSING", ""); w.Code != http.StatusOK {
+		t.Errorf("Remove symbol: expected 200, got %d", w.Code)
+	}
+	if w := doJSON(t, server, "POST", base+"/symbols", `{"symbols": ["MSFT"]}`); w.Code != http.StatusOK ||
+		!strings.Contains(w.Body.String(), `"symbols":["AAPL","MSFT"]`) {
+		t.Errorf("Add symbols: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, server, "PUT", base, `{"name": "Renamed", "symbols": ["KO"]}`); w.Code != http.StatusOK ||
+		!strings.Contains(w.Body.String(), `"name":"Renamed"`) {
+		t.Errorf("Replace: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, server, "GET", "/api/watchlists", ""); !strings.Contains(w.Body.String(), `"Renamed"`) {
+		t.Errorf("List: %s", w.Body.String())
+	}
+	if w := doJSON(t, server, "DELETE", base, ""); w.Code != http.StatusOK {
+		t.Errorf("Delete: expected 200, got %d", w.Code)
+	}
+	if w := doJSON(t, server, "GET", base, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Get deleted: expected 404, got %d", w.Code)
+	}
+	if w := doJSON(t, server, "GET", "/api/watchlists/abc", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Bad ID: expected 400, got %d", w.Code)
+	}
+}
+
+func TestWatchlistsWithoutCache(t *testing.T) {
+	server := NewServer("0", nil)
+	if w := doJSON(t, server, "GET", "/api/watchlists", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without cache, got %d", w.Code)
+	}
+}
@@ -8,6 +8,7 @@ const API_BASE = '';
 // Initialize on page load
 document.addEventListener('DOMContentLoaded', () => {
     loadIndices();
+    loadWatchlists();
     
     // Enter key to fetch
     document.getElementById('symbol').addEventListener('keypress', (e) => {
@@ -356,6 +357,7 @@ async function showIndexSymbols(indexKey) {
         if (!result.success) return;
 
         const section = document.getElementById('indexSymbolsSection');
+        section.dataset.key = indexKey;
         const title = document.getElementById('indexSymbolsTitle');
         const desc = document.getElementById('indexSymbolsDesc');
         const tbody = document.getElementById('indexSymbolsBody');
@@ -378,6 +380,7 @@ async function showIndexSymbols(indexKey) {
                     <span onclick="fetchSymbol('${symbol}')" class="font-mono text-blue-400 hover:text-blue-300 cursor-pointer hover:underline">${symbol}</span>
                 </td>
                 <td class="py-2 px-2 text-gray-400">${companyName}</td>
+                <td class="py-2 px-2 text-gray-500" colspan="4">-</td>
             `;
             tbody.appendChild(tr);
         });
@@ -389,6 +392,163 @@ async function showIndexSymbols(indexKey) {
     }
 }
 
+// Load the latest close, month change, P/E and drop counts for the shown index
+async function showIndexSnapshot() {
+    const key = document.getElementById('indexSymbolsSection').dataset.key;
+    const btn = document.getElementById('indexSnapshotBtn');
+    if (!key) return;
+    btn.disabled = true;
+    btn.textContent = 'Loading...';
+
+    try {
+        const response = await fetch(`${API_BASE}/api/indices/${key}/snapshot?period=monthly`);
+        const result = await response.json();
+        if (!result.success) return;
+
+        const pct = v => v == null ? '-' : `<span class="${v < 0 ? 'text-red-400' : 'text-green-400'}">${v.toFixed(2)}%</span>`;
+        const tbody = document.getElementById('indexSymbolsBody');
+        tbody.innerHTML = '';
+        result.data.items.forEach((item, idx) => {
+            const tr = document.createElement('tr');
+            tr.className = 'border-b border-gray-700 hover:bg-gray-700';
+            const cells = item.error
+                ? `<td class="py-2 px-2 text-red-400" colspan="4">${escapeHTML(item.error)}</td>`
+                : `<td class="py-2 px-2">${item.close.toFixed(2)}</td>
+                   <td class="py-2 px-2">${pct(item.period_change_pct)}</td>
+                   <td class="py-2 px-2">${item.pe != null ? item.pe.toFixed(2) : '-'}</td>
+                   <td class="py-2 px-2">${item.drop_5pct ? `${item.drop_5pct.close}/${item.drop_5pct.low}` : '-'}</td>`;
+            tr.innerHTML = `
+                <td class="py-2 px-2 text-gray-500">${idx + 1}</td>
+                <td class="py-2 px-2">
+                    <span onclick="fetchSymbol('${item.symbol}')" class="font-mono text-blue-400 hover:text-blue-300 cursor-pointer hover:underline">${item.symbol}</span>
+                </td>
+                <td class="py-2 px-2 text-gray-400">${escapeHTML(item.company_name) || '-'}</td>
+                ${cells}
+            `;
+            tbody.appendChild(tr);
+        });
+    } catch (error) {
+        console.error('Failed to load index snapshot:', error);
+    } finally {
+        btn.disabled = false;
+        btn.textContent = 'Load prices';
+    }
+}
+
+// Load saved watchlists (requires the server-side cache)
+async function loadWatchlists() {
+    try {
+        const response = await fetch(`${API_BASE}/api/watchlists`);
+        const result = await response.json();
+
+        const section = document.getElementById('watchlistsSection');
+        if (!result.success) {
+            section.classList.add('hidden');
+            return;
+        }
+        section.classList.remove('hidden');
+
+        const container = document.getElementById('watchlists');
+        container.innerHTML = '';
+
+        result.data.forEach(wl => {
+            const card = document.createElement('div');
+            card.className = 'bg-gray-800 rounded-lg p-4 hover:bg-gray-700 cursor-pointer transition-colors';
+            card.onclick = () => showWatchlistSnapshot(wl.id);
+            card.innerHTML = `
+                <h4 class="font-bold text-green-400"></h4>
+                <p class="text-sm text-gray-400"></p>
+                <p class="text-xs text-gray-500 mt-2">${wl.symbols.length} symbols</p>
+            `;
+            card.querySelector('h4').textContent = wl.name;
+            card.querySelector('p').textContent = wl.description || wl.symbols.slice(0, 6).join(', ');
+            container.appendChild(card);
+        });
+    } catch (error) {
+        console.error('Failed to load watchlists:', error);
+    }
+}
+
+// Create a watchlist from a comma-separated symbol list
+async function createWatchlist() {
+    const name = prompt('Watchlist name:');
+    if (!name) return;
+    const symbols = (prompt('Symbols (comma-separated):') || '').split(',').map(s => s.trim()).filter(Boolean);
+
+    const response = await fetch(`${API_BASE}/api/watchlists`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ name, symbols }),
+    });
+    const result = await response.json();
+    if (!result.success) {
+        alert(result.error || 'Failed to create watchlist');
+        return;
+    }
+    loadWatchlists();
+}
+
+// Delete the watchlist currently shown
+async function deleteWatchlist() {
+    const id = document.getElementById('watchlistSnapshotSection').dataset.id;
+    if (!id || !confirm('Delete this watchlist?')) return;
+    await fetch(`${API_BASE}/api/watchlists/${id}`, { method: 'DELETE' });
+    hideWatchlistSnapshot();
+    loadWatchlists();
+}
+
+// Show the latest close, day/period change and P/E for a watchlist
+async function showWatchlistSnapshot(id) {
+    const section = document.getElementById('watchlistSnapshotSection');
+    const tbody = document.getElementById('watchlistSnapshotBody');
+    section.dataset.id = id;
+    tbody.innerHTML = '<tr><td colspan="6" class="py-2 px-2 text-gray-500">Loading...</td></tr>';
+    section.classList.remove('hidden');
+
+    try {
+        const response = await fetch(`${API_BASE}/api/watchlists/${id}/snapshot?period=monthly`);
+        const result = await response.json();
+        if (!result.success) {
+            tbody.innerHTML = '';
+            return;
+        }
+
+        document.getElementById('watchlistSnapshotTitle').textContent = `${result.data.name} (${result.data.count} symbols)`;
+        const pct = v => v == null ? '-' : `<span class="${v < 0 ? 'text-red-400' : 'text-green-400'}">${v.toFixed(2)}%</span>`;
+
+        tbody.innerHTML = '';
+        result.data.items.forEach(item => {
+            const tr = document.createElement('tr');
+            tr.className = 'border-b border-gray-700 hover:bg-gray-700';
+            tr.innerHTML = `
+                <td class="py-2 px-2">
+                    <span onclick="fetchSymbol('${item.symbol}')" class="font-mono text-blue-400 hover:text-blue-300 cursor-pointer hover:underline">${item.symbol}</span>
+                </td>
+                <td class="py-2 px-2 text-gray-400">${escapeHTML(item.company_name) || '-'}</td>
+                <td class="py-2 px-2">${item.error ? `<span class="text-red-400">${escapeHTML(item.error)}</span>` : item.close.toFixed(2)}</td>
+                <td class="py-2 px-2">${pct(item.day_change_pct)}</td>
+                <td class="py-2 px-2">${pct(item.period_change_pct)}</td>
+                <td class="py-2 px-2">${item.pe != null ? item.pe.toFixed(2) : '-'}</td>
+            `;
+            tbody.appendChild(tr);
+        });
+    } catch (error) {
+        console.error('Failed to load watchlist snapshot:', error);
+    }
+}
+
+// Escape text for safe insertion into innerHTML
+function escapeHTML(text) {
+    const div = document.createElement('div');
+    div.textContent = text || '';
+    return div.innerHTML;
+}
+
+// Hide watchlist snapshot table
+function hideWatchlistSnapshot() {
+    document.getElementById('watchlistSnapshotSection').classList.add('hidden');
+}
+
 // Hide index symbols table
 function hideIndexSymbols() {
     document.getElementById('indexSymbolsSection').classList.add('hidden');
@@ -448,6 +608,13 @@ function exportExcel() {
     window.location.href = url;
 }
 
+// Download one workbook for an index or watchlist, one sheet per symbol
+function downloadGroupExcel(kind, key) {
+    if (!key) return;
+    const days = document.getElementById('days').value || 1825;
+    window.location.href = `${API_BASE}/api/${kind}/${key}/excel?days=${days}&period=monthly`;
+}
+
 // Format drop count object as C/L string
 function formatDropCount(drop) {
     if (!drop) return '0/0';
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -18,20 +20,18 @@ type YahooFetcher struct {
 // NewYahooFetcher creates a new Yahoo Finance fetcher
 func NewYahooFetcher() *YahooFetcher {
 	return &YahooFetcher{
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		client: newUpstreamClient(),
 	}
 }
 
 // FetchCompanyName fetches just the company name from Yahoo Finance
-func (f *YahooFetcher) FetchCompanyName(symbol string) (string, error) {
+func (f *YahooFetcher) FetchCompanyName(ctx context.Context, symbol string) (string, error) {
 	url := fmt.Sprintf(
 		"https://query1.finance.yahoo.com/v8/finance/chart/%s?interval=1d&range=1d",
 		strings.ToUpper(symbol),
 	)
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return "", err
 	}
@@ -91,23 +91,44 @@ type YahooChartResponse struct {
 	} `json:"chart"`
 }
 
+// YahooEventsResponse holds the dividend and split events from the chart API,
+// present when the request includes events=div,splits
+type YahooEventsResponse struct {
+	Chart struct {
+		Result []struct {
+			Events struct {
+				Dividends map[string]struct {
+					Amount float64 `json:"amount"`
+					Date   int64   `json:"date"`
+				} `json:"dividends"`
+				Splits map[string]struct {
+					Date        int64   `json:"date"`
+					Numerator   float64 `json:"numerator"`
+					Denominator float64 `json:"denominator"`
+					SplitRatio  string  `json:"splitRatio"`
+				} `json:"splits"`
+			} `json:"events"`
+		} `json:"result"`
+	} `json:"chart"`
+}
+
 // FetchHistoricalData fetches historical data from Yahoo Finance using the chart API
-// Returns: data, companyName, error
-func (f *YahooFetcher) FetchHistoricalData(symbol string, startDate, endDate time.Time) ([]StockData, string, error) {
+// Returns: data, companyName, dividend/split events, error
+func (f *YahooFetcher) FetchHistoricalData(ctx context.Context, symbol string, startDate, endDate time.Time) ([]StockData, string, []CorporateEvent, error) {
 	period1 := startDate.Unix()
 	period2 := endDate.Unix()
 
 	// Use the chart API which doesn't require authentication
 	url := fmt.Sprintf(
-		"https://query1.finance.yahoo.com/v8/finance/chart/%s?period1=%d&period2=%d&interval=1d&includePrePost=false",
+		"https://query1.finance.yahoo.com/v8/finance/chart/%s?period1=%d&period2=%d&interval=1d&includePrePost=false&events=div,splits",
 		strings.ToUpper(symbol),
 		period1,
 		period2,
 	)
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return nil, "", err
+		return nil, "", nil, err
 	}
 
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
@@ -115,30 +136,33 @@ func (f *YahooFetcher) FetchHistoricalData(symbol string, startDate, endDate tim
 
 	resp, err := f.client.Do(req)
 	if err != nil {
-		return nil, "", fmt.Errorf("request failed: %w", err)
+		return nil, "", nil, fmt.Errorf("request failed: %w", err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, "", err
+		return nil, "", nil, err
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body[:min(500, len(body))]))
+		return nil, "", nil, newStatusError("API", resp, body)
 	}
 
 	var chartResp YahooChartResponse
 	if err := json.Unmarshal(body, &chartResp); err != nil {
-		return nil, "", fmt.Errorf("failed to parse response: %w", err)
+		return nil, "", nil, fmt.Errorf("%w: %w", ErrParse, err)
 	}
 
 	if chartResp.Chart.Error != nil {
-		return nil, "", fmt.Errorf("API error: %s - %s", chartResp.Chart.Error.Code, chartResp.Chart.Error.Description)
+		if chartResp.Chart.Error.Code == "Not Found" {
+			return nil, "", nil, fmt.Errorf("%w %s: %s", ErrUnknownSymbol, symbol, chartResp.Chart.Error.Description)
+		}
+		return nil, "", nil, fmt.Errorf("API error: %s - %s", chartResp.Chart.Error.Code, chartResp.Chart.Error.Description)
 	}
 
 	if len(chartResp.Chart.Result) == 0 {
-		return nil, "", fmt.Errorf("no data returned for symbol %s", symbol)
+		return nil, "", nil, fmt.Errorf("%w returned for symbol %s", ErrNoData, symbol)
 	}
 
 	// Get company name from meta
@@ -148,7 +172,47 @@ func (f *YahooFetcher) FetchHistoricalData(symbol string, startDate, endDate tim
 	}
 
 	data, err := parseYahooChartData(chartResp)
-	return data, companyName, err
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return data, companyName, parseYahooEvents(body), nil
+}
+
+// parseYahooEvents extracts dividends and splits from a chart response body
+func parseYahooEvents(body []byte) []CorporateEvent {
+	var eventsResp YahooEventsResponse
+	if err := json.Unmarshal(body, &eventsResp); err != nil || len(eventsResp.Chart.Result) == 0 {
+		return nil
+	}
+
+	events := eventsResp.Chart.Result[0].Events
+	var result []CorporateEvent
+	for _, d := range events.Dividends {
+		result = append(result, CorporateEvent{
+			Date:   time.Unix(d.Date, 0).Format("2006-01-02"),
+			Type:   EventDividend,
+			Amount: d.Amount,
+		})
+	}
+	for _, s := range events.Splits {
+		if s.Denominator == 0 {
+			continue
+		}
+		result = append(result, CorporateEvent{
+			Date:   time.Unix(s.Date, 0).Format("2006-01-02"),
+			Type:   EventSplit,
+			Ratio:  s.SplitRatio,
+			Factor: s.Numerator / s.Denominator,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Date != result[j].Date {
+			return result[i].Date < result[j].Date
+		}
+		return result[i].Type < result[j].Type
+	})
+	return result
 }
 
 // parseYahooChartData converts Yahoo chart response to StockData
@@ -157,11 +221,16 @@ func parseYahooChartData(resp YahooChartResponse) ([]StockData, error) {
 	timestamps := result.Timestamp
 
 	if len(result.Indicators.Quote) == 0 {
-		return nil, fmt.Errorf("no quote data in response")
+		return nil, fmt.Errorf("%w: no quote data in response", ErrNoData)
 	}
 
 	quote := result.Indicators.Quote[0]
 
+	var adjClose []float64
+	if len(result.Indicators.AdjClose) > 0 {
+		adjClose = result.Indicators.AdjClose[0].AdjClose
+	}
+
 	var data []StockData
 	var prevClose, prevHigh float64
 
@@ -218,6 +287,9 @@ func parseYahooChartData(resp YahooChartResponse) ([]StockData, error) {
 			Change:  change,
 			HChange: hchange,
 		}
+		if i < len(adjClose) && adjClose[i] > 0 {
+			sd.AdjClose = strconv.FormatFloat(adjClose[i], 'f', 4, 64)
+		}
 
 		data = append(data, sd)
 		prevClose = quote.Close[i]
@@ -243,3 +315,30 @@ func formatVolume(v int64) string {
 	}
 	return strconv.FormatInt(v, 10)
 }
+
+// Name implements Provider
+func (f *YahooFetcher) Name() string {
+	return "yahoo"
+}
+
+// QuoteURL implements Provider
+func (f *YahooFetcher) QuoteURL(symbol, companyName string) string {
+	return fmt.Sprintf("https://finance.yahoo.com/quote/%s", strings.ToUpper(symbol))
+}
+
+// Fetch implements Provider, returning daily prices (no P/E)
+func (f *YahooFetcher) Fetch(ctx context.Context, symbol string, days int) (*FetchResult, error) {
+	endDate := time.Now()
+	startDate := endDate.AddDate(0, 0, -days)
+
+	data, companyName, events, err := f.FetchHistoricalData(ctx, symbol, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FetchResult{
+		Data:        reverseData(data),
+		CompanyName: companyName,
+		Events:      events,
+	}, nil
+}
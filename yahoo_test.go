@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"testing"
 )
 
@@ -167,3 +168,55 @@ func TestParseYahooChartData_EmptyQuote(t *testing.T) {
 		t.Error("Expected error for empty quote data")
 	}
 }
+
+func TestParseYahooEvents(t *testing.T) {
+	body := []byte(`{"chart":{"result":[{"events":{
+		"dividends":{"1699540200":{"amount":0.24,"date":1699540200}},
+		"splits":{"1598880600":{"date":1598880600,"numerator":4,"denominator":1,"splitRatio":"4:1"}}
+	}}]}}`)
+
+	events := parseYahooEvents(body)
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+
+	split := events[0]
+	if split.Type != EventSplit || split.Ratio != "4:1" || split.Factor != 4 {
+		t.Errorf("Unexpected split: %+v", split)
+	}
+	div := events[1]
+	if div.Type != EventDividend || div.Amount != 0.24 {
+		t.Errorf("Unexpected dividend: %+v", div)
+	}
+	if split.Date >= div.Date {
+		t.Error("Expected events sorted by date")
+	}
+}
+
+func TestParseYahooEvents_None(t *testing.T) {
+	if events := parseYahooEvents([]byte(`{"chart":{"result":[{}]}}`)); len(events) != 0 {
+		t.Errorf("Expected no events, got %+v", events)
+	}
+}
+
+func TestParseYahooChartData_AdjClose(t *testing.T) {
+	var resp YahooChartResponse
+	err := json.Unmarshal([]byte(`{"chart":{"result":[{
+		"timestamp":[1704067200,1704153600],
+		"indicators":{
+			"quote":[{"open":[100,102],"high":[105,108],"low":[99,101],"close":[104,107],"volume":[1000000,2000000]}],
+			"adjclose":[{"adjclose":[52.0,107.0]}]
+		}
+	}]}}`), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := parseYahooChartData(resp)
+	if err != nil {
+		t.Fatalf("parseYahooChartData() error = %v", err)
+	}
+	if data[0].AdjClose != "52.0000" || data[1].AdjClose != "107.0000" {
+		t.Errorf("AdjClose = %q, %q", data[0].AdjClose, data[1].AdjClose)
+	}
+}